@@ -282,19 +282,37 @@ func createDefaultUser(db *gorm.DB) {
 	}
 }
 
-// Health check handler
-func healthCheckHandler() gin.HandlerFunc {
+// @Summary Health check
+// @Description Public liveness check: reports only whether the database answers, without contacting external services
+// @Tags Health
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 503 {object} map[string]interface{}
+// @Router /health [get]
+func healthCheckHandler(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		health := map[string]interface{}{
-			"status":            "healthy",
-			"timestamp":         time.Now().Format(time.RFC3339),
-			"external_services": checkExternalServicesHealth(),
-			"notification_system": map[string]interface{}{
-				"scheduler_running": true,
-				"last_check":        "< 30m ago", // Placeholder
-			},
+		status, code := "healthy", http.StatusOK
+		if sqlDB, err := db.DB(); err != nil || sqlDB.PingContext(c.Request.Context()) != nil {
+			status, code = "unhealthy", http.StatusServiceUnavailable
 		}
 
-		c.JSON(http.StatusOK, health)
+		c.JSON(code, gin.H{
+			"status":    status,
+			"timestamp": time.Now().Format(time.RFC3339),
+		})
+	}
+}
+
+// @Summary External services health
+// @Description Status of the email transport and webhooks. The result is cached for five minutes so the SMTP server is not contacted on every request
+// @Tags Health
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} ExternalServicesHealth
+// @Failure 401 {object} map[string]string
+// @Router /health/services [get]
+func servicesHealthHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, cachedExternalServicesHealth(c.Request.Context(), db))
 	}
 }
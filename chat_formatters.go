@@ -0,0 +1,250 @@
+// chat_formatters.go - Adattatori dei webhook per piattaforme di chat
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// chatFormatter trasforma una notifica nel messaggio atteso da una piattaforma
+type chatFormatter struct {
+	// render restituisce il corpo JSON del messaggio
+	render func(subscription WebhookSubscription, notification Notification) (interface{}, error)
+	// newRequest prepara la richiesta; se nil si usa una POST all'URL della sottoscrizione
+	newRequest func(subscription WebhookSubscription, delivery WebhookDelivery, body []byte) (*http.Request, error)
+	// validate controlla i campi richiesti dalla piattaforma
+	validate func(subscription WebhookSubscription) error
+}
+
+var chatFormatters = map[string]chatFormatter{
+	"slack":    {render: renderSlackMessage},
+	"discord":  {render: renderDiscordMessage},
+	"telegram": {render: renderTelegramMessage, validate: requireChatID},
+	"matrix":   {render: renderMatrixMessage, newRequest: newMatrixRequest, validate: validateMatrixSubscription},
+}
+
+// Colori e icone per priorità, condivisi dai formati
+var priorityColors = map[NotificationPriority]int{
+	PriorityLow:      0x6c757d,
+	PriorityMedium:   0x0d6efd,
+	PriorityHigh:     0xfd7e14,
+	PriorityCritical: 0xdc3545,
+}
+
+var priorityEmoji = map[NotificationPriority]string{
+	PriorityLow:      "ℹ️",
+	PriorityMedium:   "🔔",
+	PriorityHigh:     "⚠️",
+	PriorityCritical: "🚨",
+}
+
+// chatActionURL restituisce il link assoluto all'azione della notifica
+func chatActionURL(notification Notification) string {
+	if notification.ActionURL == "" {
+		return ""
+	}
+	if strings.HasPrefix(notification.ActionURL, "http://") || strings.HasPrefix(notification.ActionURL, "https://") {
+		return notification.ActionURL
+	}
+	return strings.TrimRight(getEnv("BASE_URL", "http://localhost:8080"), "/") + notification.ActionURL
+}
+
+func chatActionLabel(notification Notification) string {
+	if notification.ActionLabel != "" {
+		return notification.ActionLabel
+	}
+	return "Apri"
+}
+
+// chatContext riassume priorità, tipo e riccio in una riga
+func chatContext(notification Notification) string {
+	parts := []string{"Priorità: " + string(notification.Priority), "Tipo: " + string(notification.Type)}
+	if notification.Hedgehog != nil {
+		parts = append(parts, "Riccio: "+notification.Hedgehog.Name)
+	}
+	return strings.Join(parts, " · ")
+}
+
+func chatTitle(notification Notification) string {
+	return strings.TrimSpace(priorityEmoji[notification.Priority] + " " + notification.Title)
+}
+
+// Lunghezze massime dei titoli accettate dalle piattaforme
+const (
+	slackHeaderMaxLength  = 150
+	discordTitleMaxLength = 256
+)
+
+// truncateText accorcia il testo a max caratteri, terminandolo con un'ellissi
+func truncateText(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max-1]) + "…"
+}
+
+// slackEscape protegge i caratteri di controllo del mrkdwn di Slack
+func slackEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// renderSlackMessage produce un messaggio Block Kit per gli Incoming Webhook di Slack
+func renderSlackMessage(_ WebhookSubscription, notification Notification) (interface{}, error) {
+	blocks := []map[string]interface{}{
+		{
+			"type": "header",
+			"text": map[string]interface{}{"type": "plain_text", "text": truncateText(chatTitle(notification), slackHeaderMaxLength), "emoji": true},
+		},
+		{
+			"type": "section",
+			"text": map[string]interface{}{"type": "mrkdwn", "text": slackEscape(notification.Message)},
+		},
+		{
+			"type":     "context",
+			"elements": []map[string]interface{}{{"type": "mrkdwn", "text": slackEscape(chatContext(notification))}},
+		},
+	}
+
+	if link := chatActionURL(notification); link != "" {
+		blocks = append(blocks, map[string]interface{}{
+			"type": "actions",
+			"elements": []map[string]interface{}{{
+				"type": "button",
+				"text": map[string]interface{}{"type": "plain_text", "text": chatActionLabel(notification)},
+				"url":  link,
+			}},
+		})
+	}
+
+	return map[string]interface{}{
+		"text":   fmt.Sprintf("%s: %s", notification.Title, notification.Message),
+		"blocks": blocks,
+	}, nil
+}
+
+// renderDiscordMessage produce un embed per i webhook di Discord
+func renderDiscordMessage(_ WebhookSubscription, notification Notification) (interface{}, error) {
+	embed := map[string]interface{}{
+		"title":       truncateText(chatTitle(notification), discordTitleMaxLength),
+		"description": notification.Message,
+		"color":       priorityColors[notification.Priority],
+		"timestamp":   notification.CreatedAt.UTC().Format(time.RFC3339),
+		"footer":      map[string]interface{}{"text": "La Ninna"},
+		"fields": []map[string]interface{}{
+			{"name": "Priorità", "value": string(notification.Priority), "inline": true},
+			{"name": "Tipo", "value": string(notification.Type), "inline": true},
+		},
+	}
+	if notification.Hedgehog != nil {
+		embed["fields"] = append(embed["fields"].([]map[string]interface{}),
+			map[string]interface{}{"name": "Riccio", "value": notification.Hedgehog.Name, "inline": true})
+	}
+	if link := chatActionURL(notification); link != "" {
+		embed["url"] = link
+	}
+
+	return map[string]interface{}{
+		"username": "La Ninna",
+		"embeds":   []map[string]interface{}{embed},
+	}, nil
+}
+
+// chatHTML produce il testo HTML usato da Telegram e Matrix
+func chatHTML(notification Notification) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<b>%s</b>\n%s\n<i>%s</i>",
+		html.EscapeString(chatTitle(notification)),
+		html.EscapeString(notification.Message),
+		html.EscapeString(chatContext(notification)))
+	if link := chatActionURL(notification); link != "" {
+		fmt.Fprintf(&b, "\n<a href=\"%s\">%s</a>", html.EscapeString(link), html.EscapeString(chatActionLabel(notification)))
+	}
+	return b.String()
+}
+
+// renderTelegramMessage produce la richiesta sendMessage della Bot API di Telegram
+func renderTelegramMessage(subscription WebhookSubscription, notification Notification) (interface{}, error) {
+	return map[string]interface{}{
+		"chat_id":                  subscription.ChatID,
+		"text":                     chatHTML(notification),
+		"parse_mode":               "HTML",
+		"disable_web_page_preview": true,
+	}, nil
+}
+
+// renderMatrixMessage produce un evento m.room.message con corpo HTML
+func renderMatrixMessage(_ WebhookSubscription, notification Notification) (interface{}, error) {
+	plain := fmt.Sprintf("%s\n%s\n%s", chatTitle(notification), notification.Message, chatContext(notification))
+	if link := chatActionURL(notification); link != "" {
+		plain += "\n" + link
+	}
+	return map[string]interface{}{
+		"msgtype":        "m.notice",
+		"body":           plain,
+		"format":         "org.matrix.custom.html",
+		"formatted_body": strings.ReplaceAll(chatHTML(notification), "\n", "<br>"),
+	}, nil
+}
+
+// matrixTxnID identifica la consegna presso il server Matrix, che scarta gli eventi con un ID già visto:
+// i nuovi tentativi della coda riusano lo stesso ID, un reinvio manuale ne usa uno nuovo
+func matrixTxnID(subscription WebhookSubscription, delivery WebhookDelivery, body []byte) string {
+	attempt := fmt.Sprintf("%d/%s", subscription.ID, delivery.Event)
+	if delivery.NotificationID != nil {
+		attempt += fmt.Sprintf("/%d", *delivery.NotificationID)
+	}
+	if delivery.RedeliveryOf != nil {
+		attempt += fmt.Sprintf("/redelivery-%d-%d", *delivery.RedeliveryOf, time.Now().UnixNano())
+	}
+	sum := sha256.Sum256(append([]byte(attempt+"/"), body...))
+	return "laninna-" + hex.EncodeToString(sum[:16])
+}
+
+// newMatrixRequest invia l'evento nella stanza tramite la Client-Server API
+func newMatrixRequest(subscription WebhookSubscription, delivery WebhookDelivery, body []byte) (*http.Request, error) {
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		strings.TrimRight(subscription.URL, "/"), url.PathEscape(subscription.ChatID), matrixTxnID(subscription, delivery, body))
+
+	req, err := http.NewRequest("PUT", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+subscription.AccessToken)
+	return req, nil
+}
+
+func requireChatID(subscription WebhookSubscription) error {
+	if subscription.ChatID == "" {
+		return fmt.Errorf("chat_id is required for %s subscriptions", subscription.Format)
+	}
+	return nil
+}
+
+func validateMatrixSubscription(subscription WebhookSubscription) error {
+	if err := requireChatID(subscription); err != nil {
+		return err
+	}
+	if subscription.AccessToken == "" {
+		return errors.New("access_token is required for matrix subscriptions")
+	}
+	return nil
+}
+
+// renderChatMessage serializza la notifica nel formato di chat della sottoscrizione
+func renderChatMessage(formatter chatFormatter, subscription WebhookSubscription, notification Notification) ([]byte, error) {
+	message, err := formatter.render(subscription, notification)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(message)
+}
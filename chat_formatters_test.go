@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type stubRequest struct {
+	Method        string
+	Path          string
+	Authorization string
+	Body          map[string]interface{}
+}
+
+// chatStubServer registra le richieste ricevute da una finta piattaforma di chat
+func chatStubServer(t *testing.T, requests *[]stubRequest) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		var body map[string]interface{}
+		if err := json.Unmarshal(raw, &body); err != nil {
+			t.Errorf("body is not JSON: %s", raw)
+		}
+		*requests = append(*requests, stubRequest{Method: r.Method, Path: r.URL.EscapedPath(), Authorization: r.Header.Get("Authorization"), Body: body})
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestChatFormattersAgainstStubServers(t *testing.T) {
+	db := newTestDB(t)
+	hedgehog := Hedgehog{Name: "Spillo", Status: "in_care"}
+	db.Create(&hedgehog)
+	notification := Notification{Type: NotificationWeightDrop, Priority: PriorityCritical, Title: "Calo peso", Message: "Spillo <ha perso> il 12%",
+		HedgehogID: &hedgehog.ID, ActionURL: "/hedgehogs/1", ActionLabel: "Vedi riccio"}
+	db.Create(&notification)
+	db.Preload("Hedgehog").First(&notification)
+
+	cases := []struct {
+		format string
+		check  func(t *testing.T, r stubRequest)
+	}{
+		{"slack", func(t *testing.T, r stubRequest) {
+			blocks, _ := r.Body["blocks"].([]interface{})
+			if r.Method != "POST" || len(blocks) != 4 || r.Body["text"] == "" {
+				t.Errorf("slack request = %+v", r)
+			}
+			section := blocks[1].(map[string]interface{})["text"].(map[string]interface{})
+			if !strings.Contains(section["text"].(string), "&lt;ha perso&gt;") {
+				t.Errorf("slack mrkdwn not escaped: %v", section["text"])
+			}
+		}},
+		{"discord", func(t *testing.T, r stubRequest) {
+			embeds, _ := r.Body["embeds"].([]interface{})
+			if len(embeds) != 1 {
+				t.Fatalf("discord request = %+v", r)
+			}
+			embed := embeds[0].(map[string]interface{})
+			if int(embed["color"].(float64)) != priorityColors[PriorityCritical] || len(embed["fields"].([]interface{})) != 3 {
+				t.Errorf("discord embed = %+v", embed)
+			}
+		}},
+		{"telegram", func(t *testing.T, r stubRequest) {
+			text, _ := r.Body["text"].(string)
+			if r.Body["chat_id"] != "-100123" || r.Body["parse_mode"] != "HTML" || !strings.Contains(text, "&lt;ha perso&gt;") {
+				t.Errorf("telegram request = %+v", r)
+			}
+		}},
+		{"matrix", func(t *testing.T, r stubRequest) {
+			if r.Method != "PUT" || !strings.HasPrefix(r.Path, "/_matrix/client/v3/rooms/%21room:example.org/send/m.room.message/") {
+				t.Errorf("matrix request = %s %s", r.Method, r.Path)
+			}
+			if r.Authorization != "Bearer syt_token" || r.Body["format"] != "org.matrix.custom.html" {
+				t.Errorf("matrix request = %+v", r)
+			}
+		}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.format, func(t *testing.T) {
+			var requests []stubRequest
+			server := chatStubServer(t, &requests)
+
+			subscription := WebhookSubscription{Name: tc.format, URL: server.URL, Format: tc.format, ChatID: "-100123", Enabled: true}
+			if tc.format == "matrix" {
+				subscription.ChatID = "!room:example.org"
+				subscription.AccessToken = "syt_token"
+			}
+			if err := validateWebhookSubscription(&subscription); err != nil {
+				t.Fatal(err)
+			}
+			db.Create(&subscription)
+
+			body, err := buildWebhookBody(subscription, notification)
+			if err != nil {
+				t.Fatal(err)
+			}
+			delivery, err := NewWebhookService().Deliver(db, subscription, WebhookDelivery{Event: WebhookEventNotificationCreated, RequestBody: string(body)})
+			if err != nil || !delivery.Success || len(requests) != 1 {
+				t.Fatalf("delivery = %+v, err %v, requests %d", delivery, err, len(requests))
+			}
+			tc.check(t, requests[0])
+		})
+	}
+}
+
+func TestChatFormatValidation(t *testing.T) {
+	telegram := WebhookSubscription{Name: "Bot", URL: "https://api.telegram.org/botX/sendMessage", Format: "telegram"}
+	if err := validateWebhookSubscription(&telegram); err == nil {
+		t.Error("telegram subscription without chat_id accepted")
+	}
+
+	matrix := WebhookSubscription{Name: "Stanza", URL: "https://matrix.example.org", Format: "matrix", ChatID: "!room:example.org"}
+	if err := validateWebhookSubscription(&matrix); err == nil {
+		t.Error("matrix subscription without access_token accepted")
+	}
+}
+
+func TestMatrixTxnIDAndTitleLimits(t *testing.T) {
+	subscription := WebhookSubscription{ID: 1, Format: "matrix"}
+	notificationID := uint(7)
+	delivery := WebhookDelivery{Event: WebhookEventNotificationCreated, NotificationID: &notificationID}
+	body := []byte(`{"body":"x"}`)
+
+	// I nuovi tentativi della stessa consegna non duplicano il messaggio nella stanza
+	first := matrixTxnID(subscription, delivery, body)
+	if retry := matrixTxnID(subscription, delivery, body); retry != first {
+		t.Errorf("retry txnId = %s, want %s", retry, first)
+	}
+	otherID := uint(8)
+	if other := matrixTxnID(subscription, WebhookDelivery{Event: WebhookEventNotificationCreated, NotificationID: &otherID}, body); other == first {
+		t.Error("different notifications share a txnId")
+	}
+	original := uint(3)
+	delivery.RedeliveryOf = &original
+	if manual := matrixTxnID(subscription, delivery, body); manual == first {
+		t.Error("manual redelivery reused the txnId")
+	}
+
+	notification := Notification{Priority: PriorityLow, Title: strings.Repeat("à", 300), Message: "m"}
+	slack, _ := renderSlackMessage(subscription, notification)
+	header := slack.(map[string]interface{})["blocks"].([]map[string]interface{})[0]["text"].(map[string]interface{})["text"].(string)
+	if n := len([]rune(header)); n != slackHeaderMaxLength {
+		t.Errorf("slack header length = %d", n)
+	}
+	discord, _ := renderDiscordMessage(subscription, notification)
+	title := discord.(map[string]interface{})["embeds"].([]map[string]interface{})[0]["title"].(string)
+	if n := len([]rune(title)); n != discordTitleMaxLength {
+		t.Errorf("discord title length = %d", n)
+	}
+}
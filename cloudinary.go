@@ -82,6 +82,27 @@ func (s *CloudinaryService) UploadImage(ctx context.Context, file io.Reader, fil
 	return result.SecureURL, nil
 }
 
+// UploadAttachment uploads a generic file (PDF or image) to Cloudinary
+func (s *CloudinaryService) UploadAttachment(ctx context.Context, file interface{}, folder, filename string) (string, error) {
+	uploadCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	result, err := s.cld.Upload.Upload(uploadCtx, file, uploader.UploadParams{
+		Folder:       folder,
+		PublicID:     filename,
+		ResourceType: "auto",
+		Overwrite:    boolPtr(true),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if result.SecureURL == "" {
+		return result.URL, nil
+	}
+	return result.SecureURL, nil
+}
+
 // UploadHedgehogImageHandler handles the upload of a hedgehog image
 // @Summary Upload hedgehog image
 // @Description Upload an image for a hedgehog to Cloudinary
@@ -0,0 +1,180 @@
+// cron.go - Espressioni cron a cinque campi per le attività pianificate
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // fusi orari disponibili anche senza zoneinfo di sistema
+)
+
+// cronAliases sono le abbreviazioni accettate al posto dei cinque campi
+var cronAliases = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// CronSchedule è un'espressione cron analizzata: minuto, ora, giorno del mese, mese, giorno della settimana
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool
+}
+
+// parseCron analizza un'espressione a cinque campi (con liste, intervalli e passi) o un alias
+func parseCron(expr string) (*CronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if alias, ok := cronAliases[expr]; ok {
+		expr = alias
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression must have 5 fields, got %d", len(fields))
+	}
+
+	var schedule CronSchedule
+	var err error
+	if schedule.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("minute: %v", err)
+	}
+	if schedule.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("hour: %v", err)
+	}
+	if schedule.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("day of month: %v", err)
+	}
+	if schedule.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("month: %v", err)
+	}
+	if schedule.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("day of week: %v", err)
+	}
+	// 7 è un sinonimo di domenica
+	if schedule.dow&(1<<7) != 0 {
+		schedule.dow |= 1
+	}
+	schedule.domAny = fields[2] == "*"
+	schedule.dowAny = fields[4] == "*"
+	return &schedule, nil
+}
+
+// parseCronField converte un campo in una maschera di bit dei valori ammessi
+func parseCronField(field string, min, max int) (uint64, error) {
+	var mask uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			step = n
+			part = part[:i]
+		}
+
+		low, high := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err1, err2 error
+			low, err1 = strconv.Atoi(bounds[0])
+			high, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			low = n
+			if step > 1 {
+				high = max
+			} else {
+				high = n
+			}
+		}
+
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+		for v := low; v <= high; v += step {
+			mask |= 1 << uint(v)
+		}
+	}
+	return mask, nil
+}
+
+// matchesDay applica la regola cron classica: se entrambi i campi del giorno
+// sono vincolati basta che uno dei due corrisponda
+func (s *CronSchedule) matchesDay(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Next restituisce il primo istante successivo a after che soddisfa l'espressione,
+// nel fuso orario di after. Restituisce l'istante zero se non esiste entro cinque anni.
+func (s *CronSchedule) Next(after time.Time) time.Time {
+	loc := after.Location()
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := after.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// validateCron controlla che l'espressione sia valida e scatti almeno una volta
+func validateCron(expr string) error {
+	schedule, err := parseCron(expr)
+	if err != nil {
+		return err
+	}
+	if schedule.Next(time.Now()).IsZero() {
+		return errors.New("cron expression never fires")
+	}
+	return nil
+}
+
+// nextCronRun calcola la prossima esecuzione nel fuso orario indicato
+func nextCronRun(expr, timezone string, after time.Time) (time.Time, error) {
+	schedule, err := parseCron(expr)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unknown timezone %q", timezone)
+	}
+	next := schedule.Next(after.In(loc))
+	if next.IsZero() {
+		return next, errors.New("cron expression never fires")
+	}
+	return next, nil
+}
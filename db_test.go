@@ -0,0 +1,30 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// newTestDB apre un database SQLite temporaneo con lo schema dell'applicazione
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: gormlogger.Default.LogMode(gormlogger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&User{}, &Room{}, &Area{}, &Hedgehog{}, &Therapy{}, &TherapyDose{}, &WeightRecord{}, &GrowthCurvePoint{}, &LabTest{},
+		&FeedingPlan{}, &DailyTask{}, &FoodIntake{}, &HibernationWake{}, &ReleaseCriteria{}, &NotificationRuleConfig{},
+		&Notification{}, &NotificationReceipt{}, &NotificationSubscription{}, &NotificationEvent{}, &NotificationHistory{}, &StreamTicket{}, &EscalationPolicy{},
+		&NotificationEscalation{}, &Job{}, &DeadJob{}, &WebhookSubscription{}, &WebhookDelivery{}, &NotificationSettings{},
+		&EmailTransportSettings{}, &NotificationTemplate{}, &DigestSchedule{}, &ScheduledJob{}, &ScheduledJobRun{},
+		&QuietHours{}, &OnCallShift{}, &SeedMarker{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
@@ -0,0 +1,659 @@
+// digest.go - Riassunti periodici delle notifiche per destinatario
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/laninna/hedgehog-app/logger"
+	"gorm.io/gorm"
+)
+
+// Frequenze dei riassunti
+const (
+	DigestHourly = "hourly"
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// JobKindDigest invia il riassunto di una pianificazione
+const JobKindDigest = "notifications.digest"
+
+const digestDefaultTimezone = "Europe/Rome"
+
+// digestFrequencies associa a ogni frequenza il periodo coperto e l'espressione cron predefinita
+var digestFrequencies = map[string]struct {
+	Period time.Duration
+	Cron   string
+}{
+	DigestHourly: {time.Hour, "0 * * * *"},
+	DigestDaily:  {24 * time.Hour, "0 8 * * *"},
+	DigestWeekly: {7 * 24 * time.Hour, "0 8 * * 1"},
+}
+
+// DigestSectionInfo descrive una sezione del riassunto e i tipi di notifica che raccoglie
+type DigestSectionInfo struct {
+	Key    string             `json:"key" example:"overdue_therapies"`
+	Types  []NotificationType `json:"types"`
+	Titles map[string]string  `json:"titles"`
+} // @DigestSectionInfo
+
+// digestSectionOther raccoglie i tipi non assegnati ad altre sezioni
+const digestSectionOther = "other"
+
+// digestSections sono le sezioni del riassunto, nell'ordine di stampa
+var digestSections = []DigestSectionInfo{
+	{Key: "overdue_therapies", Types: []NotificationType{NotificationTherapyExpired}, Titles: map[string]string{"it": "Terapie scadute", "en": "Overdue therapies"}},
+	{Key: "expiring_therapies", Types: []NotificationType{NotificationTherapyExpiring}, Titles: map[string]string{"it": "Terapie in scadenza", "en": "Expiring therapies"}},
+	{Key: "weight_alerts", Types: []NotificationType{NotificationWeightDrop, NotificationWeightStagnation, NotificationGrowthBehind}, Titles: map[string]string{"it": "Allarmi peso", "en": "Weight alerts"}},
+	{Key: "missing_weighings", Types: []NotificationType{NotificationNoWeighing}, Titles: map[string]string{"it": "Pesature mancanti", "en": "Missing weighings"}},
+	{Key: "feeding", Types: []NotificationType{NotificationLowFoodIntake}, Titles: map[string]string{"it": "Alimentazione", "en": "Feeding"}},
+	{Key: "hibernation", Types: []NotificationType{NotificationHibernationLoss, NotificationHibernationWaking}, Titles: map[string]string{"it": "Letargo", "en": "Hibernation"}},
+	{Key: "lab_tests", Types: []NotificationType{NotificationLabTestDue}, Titles: map[string]string{"it": "Esami di controllo", "en": "Lab tests"}},
+	{Key: "releases", Types: []NotificationType{NotificationHedgehogRecovered}, Titles: map[string]string{"it": "Pronti per il rilascio", "en": "Ready for release"}},
+	{Key: digestSectionOther, Titles: map[string]string{"it": "Altre notifiche", "en": "Other notifications"}},
+}
+
+// digestLabels sono i testi fissi del riassunto per lingua
+var digestLabels = map[string]map[string]string{
+	"it": {
+		"subject":  "[La Ninna] %s - %d notifiche",
+		"total":    "Totali",
+		"critical": "Critiche",
+		"high":     "Importanti",
+		"empty":    "Nessuna notifica nel periodo.",
+		"hedgehog": "Riccio",
+		"view_all": "Visualizza tutte le notifiche",
+		"settings": "Modifica il riassunto",
+	},
+	"en": {
+		"subject":  "[La Ninna] %s - %d notifications",
+		"total":    "Total",
+		"critical": "Critical",
+		"high":     "Important",
+		"empty":    "No notifications in this period.",
+		"hedgehog": "Hedgehog",
+		"view_all": "View all notifications",
+		"settings": "Edit this digest",
+	},
+}
+
+const digestTemplate = `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="UTF-8">
+    <style>
+        body { font-family: Arial, sans-serif; line-height: 1.6; color: #333; }
+        .header { background: linear-gradient(135deg, #667eea 0%, #764ba2 100%); color: white; padding: 20px; text-align: center; }
+        .summary { background: #f8f9fa; padding: 20px; margin: 20px 0; border-radius: 8px; }
+        .stat { display: inline-block; margin: 10px; padding: 15px; background: white; border-radius: 8px; text-align: center; min-width: 100px; }
+        .notification-item { background: white; margin: 10px 0; padding: 15px; border-radius: 8px; border-left: 4px solid #dee2e6; }
+        .critical { border-left-color: #dc3545; }
+        .high { border-left-color: #fd7e14; }
+        .medium { border-left-color: #0d6efd; }
+        .footer { background: #f8f9fa; padding: 15px; text-align: center; font-size: 0.9em; color: #666; }
+    </style>
+</head>
+<body>
+    <div class="header">
+        <div style="font-size: 2em; margin-bottom: 10px;">🦔</div>
+        <h1>{{.Name}}</h1>
+        <p>{{.Period}}</p>
+    </div>
+
+    <div style="padding: 20px;">
+        <div class="summary">
+            <div class="stat"><div style="font-size: 1.5em; font-weight: bold;">{{.Count}}</div><div>{{index .Labels "total"}}</div></div>
+            <div class="stat critical"><div style="font-size: 1.5em; font-weight: bold;">{{.Critical}}</div><div>{{index .Labels "critical"}}</div></div>
+            <div class="stat high"><div style="font-size: 1.5em; font-weight: bold;">{{.High}}</div><div>{{index .Labels "high"}}</div></div>
+        </div>
+
+        {{range .Sections}}
+        <h3>{{.Title}} ({{len .Notifications}})</h3>
+        {{range .Notifications}}
+        <div class="notification-item {{.Priority}}">
+            <h4>{{.Title}}</h4>
+            <p>{{.Message}}</p>
+            {{if .Hedgehog}}<p><strong>{{index $.Labels "hedgehog"}}:</strong> {{.Hedgehog.Name}}</p>{{end}}
+        </div>
+        {{end}}
+        {{else}}
+        <p>{{index .Labels "empty"}}</p>
+        {{end}}
+    </div>
+
+    <div class="footer">
+        <p>Centro Recupero Ricci "La Ninna" - Novello (CN)</p>
+        <p><a href="{{.BaseURL}}/notifications">{{index .Labels "view_all"}}</a> | <a href="{{.BaseURL}}/notifications#digests">{{index .Labels "settings"}}</a></p>
+    </div>
+</body>
+</html>`
+
+// DigestPayload indica la pianificazione e il periodo da riassumere
+type DigestPayload struct {
+	ScheduleID uint      `json:"schedule_id"`
+	Since      time.Time `json:"since"`
+	Until      time.Time `json:"until"`
+}
+
+// DigestSection è una sezione compilata del riassunto
+type DigestSection struct {
+	Key           string         `json:"key" example:"weight_alerts"`
+	Title         string         `json:"title" example:"Allarmi peso"`
+	Notifications []Notification `json:"notifications"`
+} // @DigestSection
+
+// DigestContent è il riassunto compilato per un periodo
+type DigestContent struct {
+	Recipient string          `json:"recipient" example:"volontario@laninna.it"`
+	Subject   string          `json:"subject" example:"[La Ninna] Riassunto mattutino - 3 notifiche"`
+	Since     time.Time       `json:"since" format:"date-time"`
+	Until     time.Time       `json:"until" format:"date-time"`
+	Count     int             `json:"count" example:"3"`
+	Sections  []DigestSection `json:"sections"`
+	HTML      string          `json:"html"`
+	Sent      bool            `json:"sent" example:"true"`
+} // @DigestContent
+
+func init() {
+	registerJobHandler(JobKindDigest, runDigestJob)
+}
+
+// validateDigestSchedule completa i valori predefiniti e controlla la pianificazione
+func validateDigestSchedule(db *gorm.DB, schedule *DigestSchedule) error {
+	if strings.TrimSpace(schedule.Name) == "" {
+		return errors.New("name is required")
+	}
+	if schedule.Frequency == "" {
+		schedule.Frequency = DigestDaily
+	}
+	frequency, ok := digestFrequencies[schedule.Frequency]
+	if !ok {
+		return errors.New("frequency must be one of hourly, daily, weekly")
+	}
+	if strings.TrimSpace(schedule.Cron) == "" {
+		schedule.Cron = frequency.Cron
+	}
+	if err := validateCron(schedule.Cron); err != nil {
+		return err
+	}
+	if schedule.Timezone == "" {
+		schedule.Timezone = digestDefaultTimezone
+	}
+	if _, err := time.LoadLocation(schedule.Timezone); err != nil {
+		return fmt.Errorf("unknown timezone %q", schedule.Timezone)
+	}
+	if schedule.MinPriority == "" {
+		schedule.MinPriority = PriorityLow
+	}
+	if !validNotificationPriority(schedule.MinPriority) {
+		return errors.New("min_priority must be one of low, medium, high, critical")
+	}
+	for _, section := range splitList(schedule.Sections) {
+		if digestSectionFor(section) == nil {
+			return fmt.Errorf("unknown section: %s", section)
+		}
+	}
+
+	if schedule.Email == "" && schedule.UserID != nil {
+		var user User
+		if err := db.First(&user, *schedule.UserID).Error; err != nil {
+			return errors.New("user not found")
+		}
+		schedule.Email = user.Email
+	}
+	if schedule.Email == "" {
+		return errors.New("email is required when the user has no email address")
+	}
+	return nil
+}
+
+func digestSectionFor(key string) *DigestSectionInfo {
+	for i := range digestSections {
+		if digestSections[i].Key == key {
+			return &digestSections[i]
+		}
+	}
+	return nil
+}
+
+// digestSectionOf restituisce la sezione a cui appartiene un tipo di notifica
+func digestSectionOf(notifType NotificationType) string {
+	for _, section := range digestSections {
+		for _, t := range section.Types {
+			if t == notifType {
+				return section.Key
+			}
+		}
+	}
+	return digestSectionOther
+}
+
+// scheduleNextRun calcola e imposta la prossima esecuzione della pianificazione
+func scheduleNextRun(schedule *DigestSchedule, after time.Time) error {
+	next, err := nextCronRun(schedule.Cron, schedule.Timezone, after)
+	if err != nil {
+		return err
+	}
+	next = next.UTC()
+	schedule.NextRunAt = &next
+	return nil
+}
+
+// digestLocale restituisce la lingua del destinatario del riassunto
+func digestLocale(db *gorm.DB, schedule DigestSchedule) string {
+	if schedule.UserID != nil {
+		return userLocale(db, *schedule.UserID)
+	}
+	return recipientLocale(db, schedule.Email)
+}
+
+// BuildDigest raccoglie le notifiche del periodo secondo i filtri della pianificazione e compone l'email
+func (bns *BatchNotificationService) BuildDigest(schedule DigestSchedule, since, until time.Time) (DigestContent, error) {
+	var query *gorm.DB
+	if schedule.UserID != nil {
+		query = inboxQuery(bns.db, *schedule.UserID, loadSubscription(bns.db, *schedule.UserID))
+	} else {
+		// Senza utente non c'è uno stato personale di lettura: si includono tutte le notifiche del periodo
+		query = bns.db.Model(&Notification{})
+	}
+	query = query.Where("notifications.created_at >= ? AND notifications.created_at < ?", since, until)
+	if schedule.MinPriority != "" && schedule.MinPriority != PriorityLow {
+		query = query.Where("notifications.priority IN ?", prioritiesAtLeast(schedule.MinPriority))
+	}
+
+	var notifications []Notification
+	if err := query.Preload("Hedgehog").
+		Order("notifications.priority DESC, notifications.created_at DESC").
+		Find(&notifications).Error; err != nil {
+		return DigestContent{}, err
+	}
+
+	locale := digestLocale(bns.db, schedule)
+	localizeNotifications(bns.db, notifications, locale)
+
+	// Raggruppa per sezione, nell'ordine delle sezioni
+	selected := splitList(schedule.Sections)
+	bySection := make(map[string][]Notification)
+	for _, notification := range notifications {
+		key := digestSectionOf(notification.Type)
+		if len(selected) > 0 && !containsString(selected, key) {
+			continue
+		}
+		bySection[key] = append(bySection[key], notification)
+	}
+
+	content := DigestContent{Recipient: schedule.Email, Since: since, Until: until}
+	counts := make(map[NotificationPriority]int)
+	for _, info := range digestSections {
+		items := bySection[info.Key]
+		if len(items) == 0 {
+			continue
+		}
+		content.Sections = append(content.Sections, DigestSection{Key: info.Key, Title: info.Titles[locale], Notifications: items})
+		content.Count += len(items)
+		for _, item := range items {
+			counts[item.Priority]++
+		}
+	}
+
+	labels := digestLabels[locale]
+	if labels == nil {
+		labels = digestLabels["it"]
+	}
+	content.Subject = fmt.Sprintf(labels["subject"], schedule.Name, content.Count)
+
+	loc, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		loc = time.Local
+	}
+	data := struct {
+		Name     string
+		Period   string
+		Count    int
+		Critical int
+		High     int
+		Sections []DigestSection
+		Labels   map[string]string
+		BaseURL  string
+	}{
+		Name:     schedule.Name,
+		Period:   formatLocaleDateTime(since.In(loc), locale) + " – " + formatLocaleDateTime(until.In(loc), locale),
+		Count:    content.Count,
+		Critical: counts[PriorityCritical],
+		High:     counts[PriorityHigh],
+		Sections: content.Sections,
+		Labels:   labels,
+		BaseURL:  getEnv("BASE_URL", "http://localhost:8080"),
+	}
+
+	tmpl, err := template.New("digest").Parse(digestTemplate)
+	if err != nil {
+		return content, err
+	}
+	var html bytes.Buffer
+	if err := tmpl.Execute(&html, data); err != nil {
+		return content, err
+	}
+	content.HTML = html.String()
+	return content, nil
+}
+
+// SendDigest compone e invia il riassunto; senza force un riassunto vuoto non viene inviato
+func (bns *BatchNotificationService) SendDigest(schedule DigestSchedule, since, until time.Time, force bool) (DigestContent, error) {
+	content, err := bns.BuildDigest(schedule, since, until)
+	if err != nil {
+		return content, err
+	}
+	if content.Count == 0 && !force {
+		return content, nil
+	}
+	if bns.emailService == nil {
+		return content, errors.New("email service not configured")
+	}
+	if err := bns.emailService.send(schedule.Email, content.Subject, content.HTML); err != nil {
+		return content, err
+	}
+	content.Sent = true
+	return content, nil
+}
+
+// digestWindow restituisce l'inizio del periodo da riassumere: l'ultima esecuzione
+// o, per la prima, il periodo della frequenza
+func digestWindow(schedule DigestSchedule, until time.Time) time.Time {
+	if schedule.LastRunAt != nil {
+		return *schedule.LastRunAt
+	}
+	period := digestFrequencies[schedule.Frequency].Period
+	if period == 0 {
+		period = 24 * time.Hour
+	}
+	return until.Add(-period)
+}
+
+// RunDueDigests accoda i riassunti scaduti. La prenotazione è un aggiornamento
+// condizionato, quindi con più istanze ogni riassunto parte una sola volta.
+func RunDueDigests(db *gorm.DB, now time.Time) int {
+	var schedules []DigestSchedule
+	db.Where("enabled = ? AND next_run_at <= ?", true, now).Find(&schedules)
+
+	started := 0
+	for _, schedule := range schedules {
+		since := digestWindow(schedule, now)
+		if err := scheduleNextRun(&schedule, now); err != nil {
+			logger.Error("Pianificazione riassunto non valida", err,
+				logger.Str("component", "digest"),
+				logger.Uint("schedule_id", schedule.ID))
+			db.Model(&schedule).Updates(map[string]interface{}{"enabled": false, "last_error": err.Error()})
+			continue
+		}
+
+		result := db.Model(&DigestSchedule{}).
+			Where("id = ? AND next_run_at <= ?", schedule.ID, now).
+			Updates(map[string]interface{}{"next_run_at": schedule.NextRunAt, "last_run_at": now})
+		if result.Error != nil || result.RowsAffected != 1 {
+			continue
+		}
+
+		if _, err := enqueueJob(db, JobKindDigest, DigestPayload{ScheduleID: schedule.ID, Since: since, Until: now}); err == nil {
+			started++
+		}
+	}
+	return started
+}
+
+func runDigestJob(db *gorm.DB, payload []byte) error {
+	var request DigestPayload
+	if err := json.Unmarshal(payload, &request); err != nil {
+		return err
+	}
+
+	var schedule DigestSchedule
+	if err := db.First(&schedule, request.ScheduleID).Error; err != nil {
+		// Pianificazione eliminata nel frattempo
+		return nil
+	}
+
+	content, err := NewBatchNotificationService(db).SendDigest(schedule, request.Since, request.Until, false)
+	updates := map[string]interface{}{"last_count": content.Count, "last_error": ""}
+	if err != nil {
+		updates["last_error"] = err.Error()
+	}
+	db.Model(&schedule).Updates(updates)
+	return err
+}
+
+// seedLegacyDigestSchedule converte una sola volta il vecchio riassunto giornaliero delle 08:00
+// in una pianificazione: se l'utente la elimina non viene ricreata
+func seedLegacyDigestSchedule(db *gorm.DB) {
+	seedOnce(db, "legacy_digest_schedule", &DigestSchedule{}, func(tx *gorm.DB) error {
+		var settings NotificationSettings
+		if err := tx.First(&settings).Error; err != nil || !settings.EmailNotificationsEnabled || settings.EmailAddress == "" {
+			return nil
+		}
+
+		schedule := DigestSchedule{Name: "Riassunto giornaliero", Email: settings.EmailAddress, Frequency: DigestDaily, Enabled: true}
+		if err := validateDigestSchedule(tx, &schedule); err != nil {
+			return nil
+		}
+		if err := scheduleNextRun(&schedule, time.Now()); err != nil {
+			return nil
+		}
+		return tx.Create(&schedule).Error
+	})
+}
+
+// @Summary List digest sections
+// @Description List the sections a digest can include and the notification types they collect
+// @Tags Digests
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} DigestSectionInfo
+// @Failure 401 {object} map[string]string
+// @Router /digest-sections [get]
+func getDigestSectionsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, digestSections)
+	}
+}
+
+// @Summary List digest schedules
+// @Description List the periodic notification digests
+// @Tags Digests
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param user_id query int false "Filter by user ID"
+// @Success 200 {array} DigestSchedule
+// @Failure 401 {object} map[string]string
+// @Router /digest-schedules [get]
+func getDigestSchedulesHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := db.Model(&DigestSchedule{})
+		if userID := c.Query("user_id"); userID != "" {
+			query = query.Where("user_id = ?", userID)
+		}
+
+		var schedules []DigestSchedule
+		query.Order("id ASC").Find(&schedules)
+		c.JSON(http.StatusOK, schedules)
+	}
+}
+
+// @Summary Create digest schedule
+// @Description Create a periodic digest. Without user_id and email the digest is sent to the authenticated user
+// @Tags Digests
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param schedule body DigestSchedule true "Digest schedule"
+// @Success 201 {object} DigestSchedule
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /digest-schedules [post]
+func createDigestScheduleHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		schedule := DigestSchedule{Enabled: true}
+		if err := c.ShouldBindJSON(&schedule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		schedule.ID = 0
+		schedule.LastRunAt = nil
+		schedule.LastCount = 0
+		schedule.LastError = ""
+		if schedule.UserID == nil && schedule.Email == "" {
+			schedule.UserID = currentUserID(c)
+		}
+
+		if err := validateDigestSchedule(db, &schedule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := scheduleNextRun(&schedule, time.Now()); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := db.Create(&schedule).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, schedule)
+	}
+}
+
+// @Summary Update digest schedule
+// @Description Update a periodic digest; the next run is recomputed
+// @Tags Digests
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Schedule ID"
+// @Param schedule body DigestSchedule true "Digest schedule"
+// @Success 200 {object} DigestSchedule
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /digest-schedules/{id} [put]
+func updateDigestScheduleHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var schedule DigestSchedule
+		if err := db.First(&schedule, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Digest schedule not found"})
+			return
+		}
+
+		existing := schedule
+		if err := c.ShouldBindJSON(&schedule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		schedule.ID = existing.ID
+		schedule.LastRunAt = existing.LastRunAt
+		schedule.LastCount = existing.LastCount
+		schedule.LastError = existing.LastError
+		schedule.CreatedAt = existing.CreatedAt
+
+		if err := validateDigestSchedule(db, &schedule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := scheduleNextRun(&schedule, time.Now()); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := db.Save(&schedule).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, schedule)
+	}
+}
+
+// @Summary Delete digest schedule
+// @Description Delete a periodic digest
+// @Tags Digests
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Schedule ID"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /digest-schedules/{id} [delete]
+func deleteDigestScheduleHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var schedule DigestSchedule
+		if err := db.First(&schedule, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Digest schedule not found"})
+			return
+		}
+
+		if err := db.Delete(&schedule).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Digest schedule deleted"})
+	}
+}
+
+// @Summary Send digest preview
+// @Description Build the digest for the period it would cover if it ran now and send it immediately, even if empty. The schedule's last and next run are not changed. With send=false the digest is only returned
+// @Tags Digests
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Schedule ID"
+// @Param send query bool false "Send the email" default(true)
+// @Success 200 {object} DigestContent
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 502 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /digest-schedules/{id}/preview [post]
+func previewDigestScheduleHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var schedule DigestSchedule
+		if err := db.First(&schedule, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Digest schedule not found"})
+			return
+		}
+
+		bns := NewBatchNotificationService(db)
+		now := time.Now()
+		since := digestWindow(schedule, now)
+
+		if c.Query("send") == "false" {
+			content, err := bns.BuildDigest(schedule, since, now)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, content)
+			return
+		}
+
+		content, err := bns.SendDigest(schedule, since, now, true)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Errore invio riassunto: " + err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, content)
+	}
+}
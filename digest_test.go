@@ -0,0 +1,180 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCronNext(t *testing.T) {
+	rome, _ := time.LoadLocation("Europe/Rome")
+	cases := []struct {
+		expr  string
+		after time.Time
+		want  time.Time
+	}{
+		{"0 8 * * *", time.Date(2024, 1, 15, 9, 0, 0, 0, rome), time.Date(2024, 1, 16, 8, 0, 0, 0, rome)},
+		{"*/15 * * * *", time.Date(2024, 1, 15, 9, 7, 30, 0, rome), time.Date(2024, 1, 15, 9, 15, 0, 0, rome)},
+		{"0 8 * * 1-5", time.Date(2024, 1, 19, 8, 0, 0, 0, rome), time.Date(2024, 1, 22, 8, 0, 0, 0, rome)},
+		{"30 18 1 * *", time.Date(2024, 1, 31, 12, 0, 0, 0, rome), time.Date(2024, 2, 1, 18, 30, 0, 0, rome)},
+		{"@weekly", time.Date(2024, 1, 15, 0, 0, 0, 0, rome), time.Date(2024, 1, 21, 0, 0, 0, 0, rome)},
+	}
+	for _, tc := range cases {
+		schedule, err := parseCron(tc.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.expr, err)
+		}
+		if got := schedule.Next(tc.after); !got.Equal(tc.want) {
+			t.Errorf("%s after %v = %v, want %v", tc.expr, tc.after, got, tc.want)
+		}
+	}
+
+	for _, expr := range []string{"0 8 * *", "61 * * * *", "0 8 30 2 *", "a b c d e"} {
+		if err := validateCron(expr); err == nil {
+			t.Errorf("%q should be rejected", expr)
+		}
+	}
+
+	// Il fuso orario sposta l'istante assoluto
+	next, _ := nextCronRun("0 8 * * *", "America/New_York", time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC))
+	if want := time.Date(2024, 1, 15, 13, 0, 0, 0, time.UTC); !next.Equal(want) {
+		t.Errorf("new york = %v, want %v", next.UTC(), want)
+	}
+}
+
+func TestBuildDigestSectionsAndPriorityFloor(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now()
+	hedgehog := Hedgehog{Name: "Spillo", Status: "in_care", ArrivalDate: now.AddDate(0, 0, -30)}
+	db.Create(&hedgehog)
+	db.Create(&Notification{Type: NotificationTherapyExpired, Priority: PriorityHigh, Title: "Terapia scaduta", Message: "x", HedgehogID: &hedgehog.ID})
+	db.Create(&Notification{Type: NotificationWeightDrop, Priority: PriorityCritical, Title: "Calo peso", Message: "x", HedgehogID: &hedgehog.ID})
+	db.Create(&Notification{Type: NotificationNoWeighing, Priority: PriorityLow, Title: "Pesatura", Message: "x"})
+	// La dismissione di un utente non toglie la notifica dal riassunto di un indirizzo condiviso
+	updateReceipt(db, 2, 1, false, true)
+
+	schedule := DigestSchedule{Name: "Mattina", Email: "volontari@laninna.it", MinPriority: PriorityHigh, Sections: "weight_alerts,overdue_therapies"}
+	if err := validateDigestSchedule(db, &schedule); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := NewBatchNotificationService(db).BuildDigest(schedule, now.Add(-time.Hour), now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content.Count != 2 || len(content.Sections) != 2 || content.Sections[0].Key != "overdue_therapies" {
+		t.Fatalf("content = %+v", content.Sections)
+	}
+	if content.Subject != "[La Ninna] Mattina - 2 notifiche" || !strings.Contains(content.HTML, "Allarmi peso") {
+		t.Errorf("subject = %q", content.Subject)
+	}
+}
+
+func TestRunDueDigestsSendsOnce(t *testing.T) {
+	db := newTestDB(t)
+	memoryMailbox.Reset()
+	db.Create(&EmailTransportSettings{Transport: EmailTransportMemory, From: "notifiche@laninna.it", Enabled: true})
+	db.Create(&Notification{Type: NotificationSystemAlert, Priority: PriorityMedium, Title: "Prova", Message: "x"})
+
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	schedule := DigestSchedule{Name: "Orario", Email: "volontari@laninna.it", Frequency: DigestHourly, Enabled: true, NextRunAt: &past}
+	validateDigestSchedule(db, &schedule)
+	db.Create(&schedule)
+
+	// Una seconda istanza che arriva dopo non trova più nulla da eseguire
+	if started := RunDueDigests(db, now.Add(time.Second)); started != 1 {
+		t.Fatalf("started = %d, want 1", started)
+	}
+	if started := RunDueDigests(db, now.Add(time.Second)); started != 0 {
+		t.Fatalf("second run started %d", started)
+	}
+
+	queue := NewJobQueue(db)
+	for queue.ProcessNext() {
+	}
+
+	messages := memoryMailbox.Messages()
+	if len(messages) != 1 || !strings.HasPrefix(messages[0].Subject, "[La Ninna] Orario - 1") {
+		t.Fatalf("mailbox = %+v", messages)
+	}
+
+	db.First(&schedule, schedule.ID)
+	if schedule.LastCount != 1 || schedule.LastRunAt == nil || !schedule.NextRunAt.After(now) {
+		t.Errorf("schedule = %+v", schedule)
+	}
+}
+
+func TestPreviewDigestSendsEmptyDigest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := newTestDB(t)
+	memoryMailbox.Reset()
+	db.Create(&EmailTransportSettings{Transport: EmailTransportMemory, From: "notifiche@laninna.it", Enabled: true})
+	user := User{Username: "jane", Email: "jane@laninna.it", Password: "x"}
+	db.Create(&user)
+	db.Create(&NotificationSubscription{UserID: user.ID, Locale: "en"})
+
+	schedule := DigestSchedule{Name: "Weekly", UserID: &user.ID, Frequency: DigestWeekly, Enabled: true}
+	validateDigestSchedule(db, &schedule)
+	db.Create(&schedule)
+
+	router := gin.New()
+	router.POST("/digest-schedules/:id/preview", previewDigestScheduleHandler(db))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/digest-schedules/1/preview", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+
+	messages := memoryMailbox.Messages()
+	if len(messages) != 1 || messages[0].To[0] != "jane@laninna.it" || messages[0].Subject != "[La Ninna] Weekly - 0 notifications" {
+		t.Fatalf("mailbox = %+v", messages)
+	}
+	if !strings.Contains(messages[0].HTMLBody, "No notifications in this period.") {
+		t.Error("empty digest should say so")
+	}
+}
+
+func TestLegacyDigestScheduleSeededOnlyOnce(t *testing.T) {
+	db := newTestDB(t)
+	db.Create(&NotificationSettings{EmailNotificationsEnabled: true, EmailAddress: "volontari@laninna.it"})
+
+	seedLegacyDigestSchedule(db)
+	var count int64
+	db.Model(&DigestSchedule{}).Count(&count)
+	if count != 1 {
+		t.Fatalf("schedules = %d, want the converted daily summary", count)
+	}
+
+	// Eliminata dall'utente, la pianificazione non torna al riavvio
+	db.Where("1 = 1").Delete(&DigestSchedule{})
+	seedLegacyDigestSchedule(db)
+	db.Model(&DigestSchedule{}).Count(&count)
+	if count != 0 {
+		t.Errorf("schedules = %d after restart, want 0", count)
+	}
+}
+
+func TestCreateDisabledDigestSchedule(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := newTestDB(t)
+	router := gin.New()
+	router.POST("/digests", createDigestScheduleHandler(db))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/digests",
+		strings.NewReader(`{"name":"Sospeso","email":"volontari@laninna.it","frequency":"daily","enabled":false}`)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+
+	var schedule DigestSchedule
+	db.First(&schedule)
+	if schedule.Enabled {
+		t.Error("schedule created with enabled=false was stored as enabled")
+	}
+}
@@ -0,0 +1,194 @@
+// domain_events.go - Eventi di dominio inviati ai webhook esterni
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/laninna/hedgehog-app/logger"
+	"gorm.io/gorm"
+)
+
+// Eventi di dominio pubblicati dagli handler
+const (
+	DomainEventHedgehogCreated       = "hedgehog.created"
+	DomainEventHedgehogStatusChanged = "hedgehog.status_changed"
+	DomainEventHedgehogMoved         = "hedgehog.moved"
+	DomainEventHedgehogDeleted       = "hedgehog.deleted"
+	DomainEventWeightRecorded        = "weight.recorded"
+	DomainEventTherapyStarted        = "therapy.started"
+	DomainEventTherapyCompleted      = "therapy.completed"
+)
+
+// DomainEventInfo descrive un evento e la versione corrente del suo schema
+type DomainEventInfo struct {
+	Type        string `json:"type" example:"hedgehog.status_changed"`
+	Version     int    `json:"version" example:"1"`
+	Description string `json:"description" example:"A hedgehog changed status"`
+} // @DomainEventInfo
+
+// domainEvents elenca gli eventi disponibili; la versione cresce solo con modifiche incompatibili dello schema
+var domainEvents = []DomainEventInfo{
+	{Type: WebhookEventNotificationCreated, Version: 1, Description: "A notification was created"},
+	{Type: DomainEventHedgehogCreated, Version: 1, Description: "A hedgehog was admitted"},
+	{Type: DomainEventHedgehogStatusChanged, Version: 1, Description: "A hedgehog changed status (recovered, deceased, ...)"},
+	{Type: DomainEventHedgehogMoved, Version: 1, Description: "A hedgehog was moved to another area"},
+	{Type: DomainEventHedgehogDeleted, Version: 1, Description: "A hedgehog record was deleted"},
+	{Type: DomainEventWeightRecorded, Version: 1, Description: "A weight was recorded"},
+	{Type: DomainEventTherapyStarted, Version: 1, Description: "A therapy was created"},
+	{Type: DomainEventTherapyCompleted, Version: 1, Description: "A therapy was marked as completed"},
+}
+
+func domainEventVersion(eventType string) int {
+	for _, info := range domainEvents {
+		if info.Type == eventType {
+			return info.Version
+		}
+	}
+	return 0
+}
+
+// DomainEventEnvelope è il corpo JSON di ogni evento di dominio
+type DomainEventEnvelope struct {
+	ID         string      `json:"id"`
+	Type       string      `json:"type"`
+	Version    int         `json:"version"`
+	Source     string      `json:"source"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	Data       interface{} `json:"data"`
+}
+
+// HedgehogEventV1 è lo schema v1 degli eventi hedgehog.*
+type HedgehogEventV1 struct {
+	ID             uint       `json:"id"`
+	Name           string     `json:"name"`
+	Status         string     `json:"status"`
+	PreviousStatus string     `json:"previous_status,omitempty"`
+	Sex            string     `json:"sex"`
+	ArrivalDate    time.Time  `json:"arrival_date"`
+	ReleaseDate    *time.Time `json:"release_date,omitempty"`
+	AreaID         *uint      `json:"area_id"`
+	PreviousAreaID *uint      `json:"previous_area_id,omitempty"`
+}
+
+// WeightEventV1 è lo schema v1 dell'evento weight.recorded
+type WeightEventV1 struct {
+	ID         uint      `json:"id"`
+	HedgehogID uint      `json:"hedgehog_id"`
+	Weight     float64   `json:"weight"`
+	Date       time.Time `json:"date"`
+	Flagged    bool      `json:"flagged"`
+	Excluded   bool      `json:"excluded"`
+}
+
+// TherapyEventV1 è lo schema v1 degli eventi therapy.*
+type TherapyEventV1 struct {
+	ID         uint       `json:"id"`
+	HedgehogID uint       `json:"hedgehog_id"`
+	Name       string     `json:"name"`
+	Category   string     `json:"category"`
+	Status     string     `json:"status"`
+	StartDate  time.Time  `json:"start_date"`
+	EndDate    *time.Time `json:"end_date,omitempty"`
+}
+
+func newHedgehogEvent(hedgehog Hedgehog) HedgehogEventV1 {
+	return HedgehogEventV1{
+		ID:          hedgehog.ID,
+		Name:        hedgehog.Name,
+		Status:      hedgehog.Status,
+		Sex:         hedgehog.Sex,
+		ArrivalDate: hedgehog.ArrivalDate,
+		ReleaseDate: hedgehog.ReleaseDate,
+		AreaID:      hedgehog.AreaID,
+	}
+}
+
+func newWeightEvent(record WeightRecord) WeightEventV1 {
+	return WeightEventV1{
+		ID:         record.ID,
+		HedgehogID: record.HedgehogID,
+		Weight:     record.Weight,
+		Date:       record.Date,
+		Flagged:    record.Flagged,
+		Excluded:   record.Excluded,
+	}
+}
+
+func newTherapyEvent(therapy Therapy) TherapyEventV1 {
+	return TherapyEventV1{
+		ID:         therapy.ID,
+		HedgehogID: therapy.HedgehogID,
+		Name:       therapy.Name,
+		Category:   therapy.Category,
+		Status:     therapy.Status,
+		StartDate:  therapy.StartDate,
+		EndDate:    therapy.EndDate,
+	}
+}
+
+func newDomainEventID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return "evt_" + hex.EncodeToString(buf)
+}
+
+// publishDomainEvent accoda l'evento per le sottoscrizioni interessate.
+// Dentro una transazione va passata la tx, così l'evento parte solo se il dato viene salvato.
+func publishDomainEvent(db *gorm.DB, eventType string, data interface{}) {
+	var subscriptions []WebhookSubscription
+	db.Where("enabled = ?", true).Find(&subscriptions)
+
+	var body []byte
+	for _, subscription := range subscriptions {
+		if !subscribedToEvent(subscription, eventType) {
+			continue
+		}
+
+		// Lo stesso corpo (e ID) per tutte le sottoscrizioni, così i destinatari possono deduplicare
+		if body == nil {
+			var err error
+			body, err = json.Marshal(DomainEventEnvelope{
+				ID:         newDomainEventID(),
+				Type:       eventType,
+				Version:    domainEventVersion(eventType),
+				Source:     "laninna-hedgehog-app",
+				OccurredAt: time.Now().UTC(),
+				Data:       data,
+			})
+			if err != nil {
+				logger.Error("Errore serializzazione evento di dominio", err, logger.Str("event", eventType))
+				return
+			}
+		}
+
+		if _, err := enqueueJob(db, JobKindWebhookDelivery, WebhookDeliveryPayload{
+			SubscriptionID: subscription.ID,
+			Event:          eventType,
+			Body:           body,
+		}); err != nil {
+			logger.Error("Errore accodamento evento di dominio", err,
+				logger.Str("event", eventType),
+				logger.Uint("subscription_id", subscription.ID))
+		}
+	}
+}
+
+// @Summary List webhook events
+// @Description List the events webhook subscriptions can receive, with the current payload schema version
+// @Tags Webhooks
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} DomainEventInfo
+// @Failure 401 {object} map[string]string
+// @Router /webhook-events [get]
+func getWebhookEventsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, domainEvents)
+	}
+}
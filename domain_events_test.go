@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDomainEventDeliveredToSubscribers(t *testing.T) {
+	db := newTestDB(t)
+
+	var received []DomainEventEnvelope
+	var eventHeader string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		var envelope DomainEventEnvelope
+		json.Unmarshal(raw, &envelope)
+		received = append(received, envelope)
+		eventHeader = r.Header.Get("X-La-Ninna-Event")
+	}))
+	defer server.Close()
+
+	db.Create(&WebhookSubscription{Name: "Adozioni", URL: server.URL, Format: "json", Events: "hedgehog.status_changed", Enabled: true})
+	db.Create(&WebhookSubscription{Name: "Solo notifiche", URL: server.URL, Format: "json", Enabled: true})
+
+	hedgehog := Hedgehog{Name: "Spillo", Status: "recovered"}
+	db.Create(&hedgehog)
+	event := newHedgehogEvent(hedgehog)
+	event.PreviousStatus = "in_care"
+	publishDomainEvent(db, DomainEventHedgehogStatusChanged, event)
+	publishDomainEvent(db, DomainEventWeightRecorded, newWeightEvent(WeightRecord{HedgehogID: hedgehog.ID, Weight: 600}))
+
+	var jobs []Job
+	db.Find(&jobs)
+	if len(jobs) != 1 {
+		t.Fatalf("jobs = %d, want 1 for the only matching subscription", len(jobs))
+	}
+
+	queue := NewJobQueue(db)
+	for queue.ProcessNext() {
+	}
+
+	if len(received) != 1 || eventHeader != DomainEventHedgehogStatusChanged {
+		t.Fatalf("received = %+v, header %q", received, eventHeader)
+	}
+	envelope := received[0]
+	data, _ := envelope.Data.(map[string]interface{})
+	if envelope.Version != 1 || envelope.ID == "" || data["previous_status"] != "in_care" || data["status"] != "recovered" {
+		t.Errorf("envelope = %+v", envelope)
+	}
+
+	var delivery WebhookDelivery
+	db.First(&delivery)
+	if !delivery.Success || delivery.Event != DomainEventHedgehogStatusChanged || delivery.NotificationID != nil {
+		t.Errorf("delivery = %+v", delivery)
+	}
+}
+
+func TestDomainEventSubscriptionValidation(t *testing.T) {
+	unknown := WebhookSubscription{Name: "X", URL: "https://example.org", Format: "json", Events: "hedgehog.adopted"}
+	if err := validateWebhookSubscription(&unknown); err == nil {
+		t.Error("unknown event accepted")
+	}
+
+	slack := WebhookSubscription{Name: "X", URL: "https://hooks.slack.com/x", Format: "slack", Events: "weight.recorded"}
+	if err := validateWebhookSubscription(&slack); err == nil {
+		t.Error("domain event accepted for a chat format")
+	}
+}
@@ -3,27 +3,31 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
-	"html/template"
 	"log"
 	"net/http"
-	"net/smtp"
 	"os"
-	"strings"
+	"sync"
 	"time"
 )
 
 // Configurazione email
 type EmailConfig struct {
-	SMTPHost string
-	SMTPPort string
-	From     string
-	Username string
-	Password string
-	Enabled  bool
+	Transport    string
+	SMTPHost     string
+	SMTPPort     string
+	Security     string
+	AuthMode     string
+	From         string
+	Username     string
+	Password     string
+	SendmailPath string
+	MailboxDir   string
+	Enabled      bool
 }
 
 // Template email per notifiche
@@ -102,6 +106,8 @@ type EmailData struct {
 	ActionLabel    string
 	BaseURL        string
 	AdditionalData string
+	Locale         string
+	Vars           map[string]interface{}
 }
 
 // Struttura webhook payload
@@ -126,29 +132,25 @@ type NotificationWebhook struct {
 
 // EmailService - Servizio email
 type EmailService struct {
-	config   EmailConfig
-	template *template.Template
+	db        *gorm.DB
+	config    EmailConfig
+	transport EmailTransport
 }
 
-func NewEmailService() *EmailService {
-	config := EmailConfig{
-		SMTPHost: getEnv("EMAIL_SMTP_HOST", "smtp.gmail.com"),
-		SMTPPort: getEnv("EMAIL_SMTP_PORT", "587"),
-		From:     getEnv("EMAIL_FROM", "notifications@laninna.it"),
-		Username: getEnv("EMAIL_USERNAME", ""),
-		Password: getEnv("EMAIL_PASSWORD", ""),
-		Enabled:  getEnv("EMAIL_USERNAME", "") != "",
-	}
+// NewEmailService usa la configurazione salvata nel database, o le variabili EMAIL_* se assente
+func NewEmailService(db *gorm.DB) *EmailService {
+	config := loadEmailConfig(db)
 
-	tmpl, err := template.New("email").Parse(emailTemplate)
+	transport, err := newEmailTransport(config)
 	if err != nil {
-		log.Printf("Errore parsing template email: %v", err)
+		log.Printf("Errore configurazione trasporto email: %v", err)
 		return nil
 	}
 
 	return &EmailService{
-		config:   config,
-		template: tmpl,
+		db:        db,
+		config:    config,
+		transport: transport,
 	}
 }
 
@@ -158,16 +160,23 @@ func (es *EmailService) SendNotificationEmail(notification Notification, toEmail
 		return nil
 	}
 
+	// Titolo e messaggio nella lingua del destinatario
+	locale := recipientLocale(es.db, toEmail)
+	localizeNotification(es.db, &notification, locale)
+
 	// Prepara dati per template
 	data := EmailData{
-		Subject:       fmt.Sprintf("[La Ninna] %s", notification.Title),
 		Title:         notification.Title,
 		Message:       notification.Message,
 		PriorityClass: getPriorityClass(string(notification.Priority)),
-		CreatedAt:     notification.CreatedAt.Format("02/01/2006 15:04"),
+		CreatedAt:     formatLocaleDateTime(notification.CreatedAt, locale),
 		ActionURL:     notification.ActionURL,
 		ActionLabel:   notification.ActionLabel,
 		BaseURL:       getEnv("BASE_URL", "http://localhost:8080"),
+		Locale:        locale,
+	}
+	if notification.TemplateData != "" {
+		json.Unmarshal([]byte(notification.TemplateData), &data.Vars)
 	}
 
 	if notification.Hedgehog != nil {
@@ -182,32 +191,54 @@ func (es *EmailService) SendNotificationEmail(notification Notification, toEmail
 		data.AdditionalData = notification.Data
 	}
 
-	// Genera HTML da template
-	var htmlBuffer bytes.Buffer
-	if err := es.template.Execute(&htmlBuffer, data); err != nil {
+	// Oggetto e HTML dal modello del tipo o generico, nella lingua del destinatario
+	subject, body, err := es.renderEmail(string(notification.Type), locale, data)
+	if err != nil {
 		log.Printf("Errore generazione template email: %v", err)
 		return err
 	}
 
 	// Invia email
-	return es.sendSMTP(toEmail, data.Subject, htmlBuffer.String())
+	return es.send(toEmail, subject, body)
 }
 
-func (es *EmailService) sendSMTP(to, subject, htmlBody string) error {
-	// Messaggio email con headers
-	message := fmt.Sprintf(`To: %s
-Subject: %s
-MIME-Version: 1.0
-Content-Type: text/html; charset=UTF-8
+// renderEmail esegue il modello email; se quello personalizzato fallisce usa il predefinito
+func (es *EmailService) renderEmail(notifType, locale string, data EmailData) (string, string, error) {
+	tmpl, ok := resolveTemplate(es.db, notifType, TemplateChannelEmail, locale)
+	if !ok {
+		return "", "", fmt.Errorf("nessun modello email per %s/%s", notifType, locale)
+	}
 
-%s`, to, subject, htmlBody)
+	subject, err := renderText(tmpl.Title, tmpl.Locale, data, false)
+	if err == nil {
+		data.Subject = subject
+		var body string
+		if body, err = renderHTML(tmpl.Body, tmpl.Locale, data, false); err == nil {
+			return subject, body, nil
+		}
+	}
 
-	// Autenticazione SMTP
-	auth := smtp.PlainAuth("", es.config.Username, es.config.Password, es.config.SMTPHost)
+	builtin, found := builtinFor(tmpl)
+	if !tmpl.Custom || !found {
+		return "", "", err
+	}
+	log.Printf("Modello email %s non valido, uso il predefinito: %v", templateKey(tmpl.Type, tmpl.Channel, tmpl.Locale), err)
+	if subject, err = renderText(builtin.Title, tmpl.Locale, data, false); err != nil {
+		return "", "", err
+	}
+	data.Subject = subject
+	body, err := renderHTML(builtin.Body, tmpl.Locale, data, false)
+	return subject, body, err
+}
 
-	// Invia email
-	addr := fmt.Sprintf("%s:%s", es.config.SMTPHost, es.config.SMTPPort)
-	err := smtp.SendMail(addr, auth, es.config.From, []string{to}, []byte(message))
+func (es *EmailService) send(to, subject, htmlBody string) error {
+	err := es.transport.Send(EmailMessage{
+		From:     es.config.From,
+		To:       []string{to},
+		Subject:  subject,
+		HTMLBody: htmlBody,
+		Date:     time.Now(),
+	})
 
 	if err != nil {
 		log.Printf("❌ Errore invio email: %v", err)
@@ -258,13 +289,9 @@ func NewWebhookService() *WebhookService {
 	}
 }
 
-func (ws *WebhookService) SendWebhook(notification Notification, webhookURL string) error {
-	if webhookURL == "" {
-		return nil
-	}
-
-	// Prepara payload
-	payload := WebhookPayload{
+// newWebhookPayload prepara il payload JSON standard di una notifica
+func newWebhookPayload(notification Notification) WebhookPayload {
+	return WebhookPayload{
 		Source:    "laninna-hedgehog-app",
 		Timestamp: time.Now().Format(time.RFC3339),
 		Notification: NotificationWebhook{
@@ -283,9 +310,15 @@ func (ws *WebhookService) SendWebhook(notification Notification, webhookURL stri
 			"environment": getEnv("GIN_MODE", "development"),
 		},
 	}
+}
+
+func (ws *WebhookService) SendWebhook(notification Notification, webhookURL string) error {
+	if webhookURL == "" {
+		return nil
+	}
 
 	// Serializza JSON
-	jsonData, err := json.Marshal(payload)
+	jsonData, err := json.Marshal(newWebhookPayload(notification))
 	if err != nil {
 		log.Printf("Errore serializzazione webhook: %v", err)
 		return err
@@ -331,25 +364,39 @@ func (ws *WebhookService) sendWebhookAttempt(url string, data []byte) error {
 	return nil
 }
 
-// Aggiorna NotificationService per usare email e webhook
+// Accoda l'invio di email e webhook: i worker della coda gestiscono i tentativi.
+// Le notifiche critiche partono subito e per email vanno ai reperibili del giorno;
+// le altre restano in coda fino alla fine delle ore di silenzio del destinatario.
 func (ns *NotificationService) sendExternalNotifications(notification Notification) {
+	now := time.Now()
+
 	// Invia email se abilitato
-	if ns.settings.EmailNotificationsEnabled && ns.settings.EmailAddress != "" {
-		emailService := NewEmailService()
-		if emailService != nil {
-			if err := emailService.SendNotificationEmail(notification, ns.settings.EmailAddress); err != nil {
-				log.Printf("❌ Errore invio email: %v", err)
-			}
+	if ns.settings.EmailNotificationsEnabled {
+		var recipients []string
+		if notification.Priority == PriorityCritical {
+			recipients = onCallEmails(ns.db, now)
+		}
+		if len(recipients) == 0 && ns.settings.EmailAddress != "" {
+			recipients = []string{ns.settings.EmailAddress}
+		}
+		for _, recipient := range recipients {
+			enqueueJobAt(ns.db, JobKindNotificationEmail, ExternalNotificationPayload{
+				NotificationID: notification.ID,
+				Recipient:      recipient,
+			}, holdUntil(ns.db, notification, QuietChannelEmail, recipient, now))
 		}
 	}
 
 	// Invia webhook se configurato
 	if ns.settings.WebhookURL != "" {
-		webhookService := NewWebhookService()
-		if err := webhookService.SendWebhook(notification, ns.settings.WebhookURL); err != nil {
-			log.Printf("❌ Errore invio webhook: %v", err)
-		}
+		enqueueJobAt(ns.db, JobKindNotificationWebhook, ExternalNotificationPayload{
+			NotificationID: notification.ID,
+			Recipient:      ns.settings.WebhookURL,
+		}, holdUntil(ns.db, notification, QuietChannelWebhook, ns.settings.WebhookURL, now))
 	}
+
+	// Invia alle sottoscrizioni webhook firmate
+	ns.enqueueWebhookDeliveries(notification)
 }
 
 // Utility function per variabili ambiente
@@ -383,7 +430,7 @@ func testEmailHandler(db *gorm.DB) gin.HandlerFunc {
 			CreatedAt:   time.Now(),
 		}
 
-		emailService := NewEmailService()
+		emailService := NewEmailService(db)
 		if emailService == nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Servizio email non configurato"})
 			return
@@ -430,7 +477,7 @@ func testWebhookHandler(db *gorm.DB) gin.HandlerFunc {
 	}
 }
 
-// Batch notification sender per riassunti periodici
+// Batch notification sender per i riassunti periodici (vedi digest.go)
 type BatchNotificationService struct {
 	db           *gorm.DB
 	emailService *EmailService
@@ -439,387 +486,134 @@ type BatchNotificationService struct {
 func NewBatchNotificationService(db *gorm.DB) *BatchNotificationService {
 	return &BatchNotificationService{
 		db:           db,
-		emailService: NewEmailService(),
+		emailService: NewEmailService(db),
 	}
 }
 
-func (bns *BatchNotificationService) SendDailySummary() error {
-	log.Println("📊 Sending daily notification summary...")
-
-	// Ottieni impostazioni
-	var settings NotificationSettings
-	if err := bns.db.First(&settings).Error; err != nil {
-		return err
-	}
-
-	if !settings.EmailNotificationsEnabled || settings.EmailAddress == "" {
-		return nil
-	}
-
-	// Raccogli notifiche ultime 24 ore
-	yesterday := time.Now().AddDate(0, 0, -1)
-	var notifications []Notification
-	bns.db.Preload("Hedgehog").Preload("Therapy").
-		Where("created_at >= ? AND dismissed = false", yesterday).
-		Order("priority DESC, created_at DESC").
-		Find(&notifications)
+// Health check per servizi esterni
+type ExternalServicesHealth struct {
+	Email          bool      `json:"email"`
+	Webhook        bool      `json:"webhook"`
+	EmailTransport string    `json:"email_transport"`
+	SMTP           string    `json:"smtp_status"`
+	CheckedAt      time.Time `json:"checked_at"`
+}
 
-	if len(notifications) == 0 {
-		log.Println("📧 No notifications to summarize")
-		return nil
-	}
+// servicesHealthTTL limita le verifiche reali del trasporto: ognuna apre una connessione SMTP autenticata
+const servicesHealthTTL = 5 * time.Minute
 
-	// Genera email riassunto
-	return bns.sendSummaryEmail(notifications, settings.EmailAddress)
+var servicesHealthCache struct {
+	sync.Mutex
+	health ExternalServicesHealth
 }
 
-func (bns *BatchNotificationService) sendSummaryEmail(notifications []Notification, toEmail string) error {
-	if bns.emailService == nil {
-		return fmt.Errorf("email service not configured")
-	}
-
-	// Conta per priorità
-	counts := map[string]int{
-		"critical": 0,
-		"high":     0,
-		"medium":   0,
-		"low":      0,
-	}
-
-	for _, n := range notifications {
-		counts[string(n.Priority)]++
-	}
-
-	// Template HTML per riassunto
-	summaryTemplate := `
-    <!DOCTYPE html>
-    <html>
-    <head>
-        <meta charset="UTF-8">
-        <style>
-            body { font-family: Arial, sans-serif; line-height: 1.6; color: #333; }
-            .header { background: linear-gradient(135deg, #667eea 0%, #764ba2 100%); color: white; padding: 20px; text-align: center; }
-            .summary { background: #f8f9fa; padding: 20px; margin: 20px 0; border-radius: 8px; }
-            .stat { display: inline-block; margin: 10px; padding: 15px; background: white; border-radius: 8px; text-align: center; min-width: 100px; }
-            .critical { border-left: 4px solid #dc3545; }
-            .high { border-left: 4px solid #fd7e14; }
-            .medium { border-left: 4px solid #0d6efd; }
-            .notification-item { background: white; margin: 10px 0; padding: 15px; border-radius: 8px; border-left: 4px solid #dee2e6; }
-            .footer { background: #f8f9fa; padding: 15px; text-align: center; font-size: 0.9em; color: #666; }
-        </style>
-    </head>
-    <body>
-        <div class="header">
-            <div style="font-size: 2em; margin-bottom: 10px;">🦔</div>
-            <h1>Riassunto Giornaliero - La Ninna</h1>
-            <p>{{.Date}}</p>
-        </div>
-        
-        <div style="padding: 20px;">
-            <div class="summary">
-                <h2>Statistiche Notifiche</h2>
-                <div>
-                    <div class="stat critical">
-                        <div style="font-size: 1.5em; font-weight: bold;">{{.Critical}}</div>
-                        <div>Critiche</div>
-                    </div>
-                    <div class="stat high">
-                        <div style="font-size: 1.5em; font-weight: bold;">{{.High}}</div>
-                        <div>Importanti</div>
-                    </div>
-                    <div class="stat medium">
-                        <div style="font-size: 1.5em; font-weight: bold;">{{.Medium}}</div>
-                        <div>Normali</div>
-                    </div>
-                    <div class="stat">
-                        <div style="font-size: 1.5em; font-weight: bold;">{{.Total}}</div>
-                        <div>Totali</div>
-                    </div>
-                </div>
-            </div>
-            
-            {{if .HasCritical}}
-            <h3 style="color: #dc3545;">🚨 Notifiche Critiche</h3>
-            {{range .CriticalNotifications}}
-            <div class="notification-item critical">
-                <h4>{{.Title}}</h4>
-                <p>{{.Message}}</p>
-                {{if .Hedgehog}}<p><strong>Riccio:</strong> {{.Hedgehog.Name}}</p>{{end}}
-                <p style="font-size: 0.9em; color: #666;">{{.CreatedAt.Format "15:04"}}</p>
-            </div>
-            {{end}}
-            {{end}}
-            
-            {{if .HasHigh}}
-            <h3 style="color: #fd7e14;">⚠️ Notifiche Importanti</h3>
-            {{range .HighNotifications}}
-            <div class="notification-item high">
-                <h4>{{.Title}}</h4>
-                <p>{{.Message}}</p>
-                {{if .Hedgehog}}<p><strong>Riccio:</strong> {{.Hedgehog.Name}}</p>{{end}}
-                <p style="font-size: 0.9em; color: #666;">{{.CreatedAt.Format "15:04"}}</p>
-            </div>
-            {{end}}
-            {{end}}
-        </div>
-        
-        <div class="footer">
-            <p>Centro Recupero Ricci "La Ninna" - Novello (CN)</p>
-            <p><a href="{{.BaseURL}}/notifications">Visualizza tutte le notifiche</a></p>
-        </div>
-    </body>
-    </html>
-    `
-
-	// Prepara dati template
-	criticalNotifications := make([]Notification, 0)
-	highNotifications := make([]Notification, 0)
-
-	for _, n := range notifications {
-		if n.Priority == PriorityCritical {
-			criticalNotifications = append(criticalNotifications, n)
-		} else if n.Priority == PriorityHigh {
-			highNotifications = append(highNotifications, n)
-		}
+func checkExternalServicesHealth(ctx context.Context, db *gorm.DB) ExternalServicesHealth {
+	health := ExternalServicesHealth{
+		Webhook:        true, // Webhook è sempre disponibile se configurato
+		EmailTransport: loadEmailConfig(db).Transport,
+		CheckedAt:      time.Now(),
 	}
 
-	data := struct {
-		Date                  string
-		Critical              int
-		High                  int
-		Medium                int
-		Total                 int
-		HasCritical           bool
-		HasHigh               bool
-		CriticalNotifications []Notification
-		HighNotifications     []Notification
-		BaseURL               string
-	}{
-		Date:                  time.Now().Format("02/01/2006"),
-		Critical:              counts["critical"],
-		High:                  counts["high"],
-		Medium:                counts["medium"] + counts["low"],
-		Total:                 len(notifications),
-		HasCritical:           len(criticalNotifications) > 0,
-		HasHigh:               len(highNotifications) > 0,
-		CriticalNotifications: criticalNotifications,
-		HighNotifications:     highNotifications,
-		BaseURL:               getEnv("BASE_URL", "http://localhost:8080"),
-	}
-
-	// Genera HTML
-	tmpl, err := template.New("summary").Parse(summaryTemplate)
+	// Verifica reale del trasporto: per SMTP connessione, TLS e autenticazione.
+	// Il dettaglio dell'errore va solo nei log, non nella risposta
+	status, err := checkEmailTransport(ctx, db)
+	health.SMTP = status
+	health.Email = status == "ready"
 	if err != nil {
-		return err
+		log.Printf("❌ Verifica trasporto email fallita: %v", err)
 	}
 
-	var htmlBuffer bytes.Buffer
-	if err := tmpl.Execute(&htmlBuffer, data); err != nil {
-		return err
-	}
-
-	// Invia email
-	subject := fmt.Sprintf("[La Ninna] Riassunto Giornaliero - %d notifiche", len(notifications))
-	return bns.emailService.sendSMTP(toEmail, subject, htmlBuffer.String())
-}
-
-// Scheduler per riassunti periodici
-func StartBatchNotificationScheduler(db *gorm.DB) {
-	batchService := NewBatchNotificationService(db)
-
-	// Invia riassunto giornaliero alle 08:00
-	go func() {
-		for {
-			now := time.Now()
-			next := time.Date(now.Year(), now.Month(), now.Day(), 8, 0, 0, 0, now.Location())
-			if next.Before(now) {
-				next = next.Add(24 * time.Hour)
-			}
-
-			time.Sleep(time.Until(next))
-
-			if err := batchService.SendDailySummary(); err != nil {
-				log.Printf("❌ Errore invio riassunto giornaliero: %v", err)
-			}
-		}
-	}()
-
-	log.Println("📅 Batch notification scheduler started (daily summary at 08:00)")
+	return health
 }
 
-// Health check per servizi esterni
-type ExternalServicesHealth struct {
-	Email   bool   `json:"email"`
-	Webhook bool   `json:"webhook"`
-	SMTP    string `json:"smtp_status"`
-}
+// cachedExternalServicesHealth riusa l'ultima verifica finché non è più vecchia di servicesHealthTTL
+func cachedExternalServicesHealth(ctx context.Context, db *gorm.DB) ExternalServicesHealth {
+	servicesHealthCache.Lock()
+	defer servicesHealthCache.Unlock()
 
-func checkExternalServicesHealth() ExternalServicesHealth {
-	health := ExternalServicesHealth{
-		Email:   false,
-		Webhook: true, // Webhook è sempre disponibile se configurato
-		SMTP:    "not_configured",
+	if time.Since(servicesHealthCache.health.CheckedAt) < servicesHealthTTL {
+		return servicesHealthCache.health
 	}
+	servicesHealthCache.health = checkExternalServicesHealth(ctx, db)
+	return servicesHealthCache.health
+}
 
-	// Controlla configurazione email
-	if getEnv("EMAIL_USERNAME", "") != "" {
-		health.Email = true
-		health.SMTP = "configured"
-
-		// Test connessione SMTP (opzionale)
-		emailService := NewEmailService()
-		if emailService != nil {
-			// Qui potresti aggiungere un ping SMTP
-			health.SMTP = "ready"
+// @Summary Get notification analytics
+// @Description Get notification counts of the last 24 hours and week, by type and priority, and the daily trend of the last 7 days
+// @Tags Notifications
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} object
+// @Failure 401 {object} map[string]string
+// @Router /notifications/analytics [get]
+func getNotificationAnalyticsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var stats struct {
+			Last24Hours int64                    `json:"last_24_hours"`
+			LastWeek    int64                    `json:"last_week"`
+			ByType      map[string]int64         `json:"by_type"`
+			ByPriority  map[string]int64         `json:"by_priority"`
+			TrendData   []map[string]interface{} `json:"trend_data"`
 		}
-	}
 
-	return health
-}
+		now := time.Now()
+		yesterday := now.AddDate(0, 0, -1)
+		lastWeek := now.AddDate(0, 0, -7)
 
-// Aggiungi nuove route al router
-func addNotificationSystemRoutes(r *gin.Engine, db *gorm.DB) {
-	api := r.Group("/api")
-
-	// Health check pubblico
-	r.GET("/health", healthCheckHandler())
-
-	protected := api.Group("/")
-	protected.Use(authMiddleware())
-	{
-		// Test servizi esterni
-		protected.POST("/test/email", testEmailHandler(db))
-		protected.POST("/test/webhook", testWebhookHandler(db))
-
-		// Trigger riassunto manuale
-		protected.POST("/notifications/summary", func(c *gin.Context) {
-			batchService := NewBatchNotificationService(db)
-			go func() {
-				if err := batchService.SendDailySummary(); err != nil {
-					log.Printf("Errore riassunto manuale: %v", err)
-				}
-			}()
-			c.JSON(http.StatusOK, gin.H{"message": "Daily summary triggered"})
-		})
-
-		// Statistiche avanzate notifiche
-		protected.GET("/notifications/analytics", func(c *gin.Context) {
-			var stats struct {
-				Last24Hours int64                    `json:"last_24_hours"`
-				LastWeek    int64                    `json:"last_week"`
-				ByType      map[string]int64         `json:"by_type"`
-				ByPriority  map[string]int64         `json:"by_priority"`
-				TrendData   []map[string]interface{} `json:"trend_data"`
-			}
+		// Conteggi base
+		db.Model(&Notification{}).Where("created_at >= ?", yesterday).Count(&stats.Last24Hours)
+		db.Model(&Notification{}).Where("created_at >= ?", lastWeek).Count(&stats.LastWeek)
 
-			now := time.Now()
-			yesterday := now.AddDate(0, 0, -1)
-			lastWeek := now.AddDate(0, 0, -7)
+		// Per tipo
+		var typeCounts []struct {
+			Type  string `json:"type"`
+			Count int64  `json:"count"`
+		}
+		db.Model(&Notification{}).
+			Select("type, count(*) as count").
+			Where("created_at >= ?", lastWeek).
+			Group("type").
+			Find(&typeCounts)
+
+		stats.ByType = make(map[string]int64)
+		for _, tc := range typeCounts {
+			stats.ByType[tc.Type] = tc.Count
+		}
 
-			// Conteggi base
-			db.Model(&Notification{}).Where("created_at >= ?", yesterday).Count(&stats.Last24Hours)
-			db.Model(&Notification{}).Where("created_at >= ?", lastWeek).Count(&stats.LastWeek)
+		// Per priorità
+		var priorityCounts []struct {
+			Priority string `json:"priority"`
+			Count    int64  `json:"count"`
+		}
+		db.Model(&Notification{}).
+			Select("priority, count(*) as count").
+			Where("created_at >= ?", lastWeek).
+			Group("priority").
+			Find(&priorityCounts)
+
+		stats.ByPriority = make(map[string]int64)
+		for _, pc := range priorityCounts {
+			stats.ByPriority[pc.Priority] = pc.Count
+		}
 
-			// Per tipo
-			var typeCounts []struct {
-				Type  string `json:"type"`
-				Count int64  `json:"count"`
-			}
-			db.Model(&Notification{}).
-				Select("type, count(*) as count").
-				Where("created_at >= ?", lastWeek).
-				Group("type").
-				Find(&typeCounts)
-
-			stats.ByType = make(map[string]int64)
-			for _, tc := range typeCounts {
-				stats.ByType[tc.Type] = tc.Count
-			}
+		// Trend ultimi 7 giorni
+		for i := 6; i >= 0; i-- {
+			day := now.AddDate(0, 0, -i)
+			dayStart := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+			dayEnd := dayStart.Add(24 * time.Hour)
 
-			// Per priorità
-			var priorityCounts []struct {
-				Priority string `json:"priority"`
-				Count    int64  `json:"count"`
-			}
+			var count int64
 			db.Model(&Notification{}).
-				Select("priority, count(*) as count").
-				Where("created_at >= ?", lastWeek).
-				Group("priority").
-				Find(&priorityCounts)
-
-			stats.ByPriority = make(map[string]int64)
-			for _, pc := range priorityCounts {
-				stats.ByPriority[pc.Priority] = pc.Count
-			}
+				Where("created_at >= ? AND created_at < ?", dayStart, dayEnd).
+				Count(&count)
 
-			// Trend ultimi 7 giorni
-			for i := 6; i >= 0; i-- {
-				day := now.AddDate(0, 0, -i)
-				dayStart := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
-				dayEnd := dayStart.Add(24 * time.Hour)
-
-				var count int64
-				db.Model(&Notification{}).
-					Where("created_at >= ? AND created_at < ?", dayStart, dayEnd).
-					Count(&count)
-
-				stats.TrendData = append(stats.TrendData, map[string]interface{}{
-					"date":  day.Format("2006-01-02"),
-					"count": count,
-				})
-			}
-
-			c.JSON(http.StatusOK, stats)
-		})
-	}
-}
-
-// Notification middleware per intercettare eventi CRUD
-func NotificationMiddleware(db *gorm.DB) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Esegui handler
-		c.Next()
-
-		// Solo per operazioni POST/PUT/DELETE con successo
-		if c.Writer.Status() < 200 || c.Writer.Status() >= 300 {
-			return
+			stats.TrendData = append(stats.TrendData, map[string]interface{}{
+				"date":  day.Format("2006-01-02"),
+				"count": count,
+			})
 		}
 
-		method := c.Request.Method
-		path := c.Request.URL.Path
-
-		// Trigger controllo notifiche per operazioni critiche
-		go func() {
-			ns := NewNotificationService(db)
-
-			// Controlli specifici per endpoint
-			if strings.Contains(path, "/weight-records") && method == "POST" {
-				// Nuova pesatura - controlla trend peso
-				ns.checkWeightNotifications()
-			} else if strings.Contains(path, "/therapies") && (method == "POST" || method == "PUT") {
-				// Terapia modificata - controlla scadenze
-				ns.checkTherapyNotifications()
-			} else if strings.Contains(path, "/hedgehogs") && method == "PUT" {
-				// Riccio modificato - controlli generali
-				ns.checkMissingWeighings()
-			}
-		}()
+		c.JSON(http.StatusOK, stats)
 	}
 }
-
-// Funzione di inizializzazione completa sistema notifiche
-func InitNotificationSystem(r *gin.Engine, db *gorm.DB) {
-	log.Println("🔔 Initializing notification system...")
-
-	// Aggiungi middleware
-	r.Use(NotificationMiddleware(db))
-
-	// Avvia schedulers
-	StartNotificationScheduler(db)
-	StartBatchNotificationScheduler(db)
-
-	// Aggiungi route
-	addNotificationSystemRoutes(r, db)
-
-	log.Println("✅ Notification system initialized successfully")
-}
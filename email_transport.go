@@ -0,0 +1,673 @@
+// email_transport.go - Trasporti email (SMTP, sendmail, casella su file, memoria)
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/laninna/hedgehog-app/logger"
+	"gorm.io/gorm"
+)
+
+// Trasporti disponibili
+const (
+	EmailTransportSMTP     = "smtp"
+	EmailTransportSendmail = "sendmail"
+	EmailTransportFile     = "file"
+	EmailTransportMemory   = "memory"
+)
+
+var emailTransports = []string{EmailTransportSMTP, EmailTransportSendmail, EmailTransportFile, EmailTransportMemory}
+var smtpSecurityModes = []string{"starttls", "tls", "none"}
+var smtpAuthModes = []string{"plain", "login", "cram-md5", "none"}
+
+// Timeout di connessione e dialogo con il server SMTP
+const smtpTimeout = 15 * time.Second
+
+// EmailMessage è un messaggio HTML pronto per l'invio
+type EmailMessage struct {
+	From     string
+	To       []string
+	Subject  string
+	HTMLBody string
+	Date     time.Time
+}
+
+// Bytes serializza il messaggio in formato RFC 5322, con corpo quoted-printable
+func (m EmailMessage) Bytes() []byte {
+	date := m.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", m.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", randomHex(12), messageIDHost(m.From))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/html; charset=UTF-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	qp := quotedprintable.NewWriter(&buf)
+	qp.Write([]byte(m.HTMLBody))
+	qp.Close()
+	return buf.Bytes()
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func messageIDHost(from string) string {
+	if addr, err := mail.ParseAddress(from); err == nil {
+		if _, host, ok := strings.Cut(addr.Address, "@"); ok {
+			return host
+		}
+	}
+	return "laninna.local"
+}
+
+// EmailTransport invia i messaggi e verifica di poterlo fare
+type EmailTransport interface {
+	Send(msg EmailMessage) error
+	Check(ctx context.Context) error
+}
+
+// newEmailTransport crea il trasporto indicato dalla configurazione
+func newEmailTransport(config EmailConfig) (EmailTransport, error) {
+	switch config.Transport {
+	case "", EmailTransportSMTP:
+		return &smtpTransport{config: config}, nil
+	case EmailTransportSendmail:
+		return &sendmailTransport{path: config.SendmailPath}, nil
+	case EmailTransportFile:
+		return &fileTransport{dir: config.MailboxDir}, nil
+	case EmailTransportMemory:
+		return memoryMailbox, nil
+	default:
+		return nil, fmt.Errorf("trasporto email non supportato: %s", config.Transport)
+	}
+}
+
+// smtpTransport invia tramite server SMTP con STARTTLS, TLS implicito o in chiaro
+type smtpTransport struct {
+	config EmailConfig
+}
+
+func (t *smtpTransport) connect(ctx context.Context) (*smtp.Client, error) {
+	host := t.config.SMTPHost
+	addr := net.JoinHostPort(host, t.config.SMTPPort)
+	tlsConfig := &tls.Config{ServerName: host}
+
+	ctx, cancel := context.WithTimeout(ctx, smtpTimeout)
+	defer cancel()
+
+	var conn net.Conn
+	var err error
+	if t.config.Security == "tls" {
+		conn, err = (&tls.Dialer{Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if t.config.Security == "starttls" {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, errors.New("il server SMTP non supporta STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	if auth := t.auth(); auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			client.Close()
+			return nil, errors.New("il server SMTP non supporta l'autenticazione")
+		}
+		if err := client.Auth(auth); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+func (t *smtpTransport) auth() smtp.Auth {
+	if t.config.Username == "" {
+		return nil
+	}
+	switch t.config.AuthMode {
+	case "none":
+		return nil
+	case "login":
+		return &loginAuth{username: t.config.Username, password: t.config.Password}
+	case "cram-md5":
+		return smtp.CRAMMD5Auth(t.config.Username, t.config.Password)
+	default:
+		return smtp.PlainAuth("", t.config.Username, t.config.Password, t.config.SMTPHost)
+	}
+}
+
+func (t *smtpTransport) Send(msg EmailMessage) error {
+	client, err := t.connect(context.Background())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := client.Mail(msg.From); err != nil {
+		return err
+	}
+	for _, to := range msg.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// Check apre una sessione reale (connessione, TLS e autenticazione) senza inviare nulla
+func (t *smtpTransport) Check(ctx context.Context) error {
+	client, err := t.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := client.Noop(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// loginAuth implementa il meccanismo AUTH LOGIN, richiesto da alcuni provider
+type loginAuth struct {
+	username, password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS {
+		return "", nil, errors.New("AUTH LOGIN richiede una connessione cifrata")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("richiesta AUTH LOGIN inattesa: %s", fromServer)
+}
+
+// sendmailTransport consegna il messaggio al binario sendmail locale
+type sendmailTransport struct {
+	path string
+}
+
+func (t *sendmailTransport) Send(msg EmailMessage) error {
+	args := append([]string{"-i", "-f", msg.From, "--"}, msg.To...)
+	cmd := exec.Command(t.path, args...)
+	cmd.Stdin = bytes.NewReader(msg.Bytes())
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("sendmail: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func (t *sendmailTransport) Check(ctx context.Context) error {
+	_, err := exec.LookPath(t.path)
+	return err
+}
+
+// fileTransport scrive ogni messaggio come file .eml: la casella di sviluppo
+type fileTransport struct {
+	dir string
+}
+
+func (t *fileTransport) Send(msg EmailMessage) error {
+	if err := os.MkdirAll(t.dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405.000"), randomHex(4))
+	return os.WriteFile(filepath.Join(t.dir, name), msg.Bytes(), 0o644)
+}
+
+func (t *fileTransport) Check(ctx context.Context) error {
+	if err := os.MkdirAll(t.dir, 0o755); err != nil {
+		return err
+	}
+	probe, err := os.CreateTemp(t.dir, ".check-*")
+	if err != nil {
+		return err
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+// memoryTransport conserva i messaggi in memoria, per i test e le prove locali
+type memoryTransport struct {
+	mu       sync.Mutex
+	messages []EmailMessage
+}
+
+// Numero massimo di messaggi conservati dal trasporto in memoria
+const memoryMailboxSize = 200
+
+var memoryMailbox = &memoryTransport{}
+
+func (t *memoryTransport) Send(msg EmailMessage) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.messages = append(t.messages, msg)
+	if len(t.messages) > memoryMailboxSize {
+		t.messages = t.messages[len(t.messages)-memoryMailboxSize:]
+	}
+	return nil
+}
+
+func (t *memoryTransport) Check(ctx context.Context) error {
+	return nil
+}
+
+// Messages restituisce una copia dei messaggi ricevuti
+func (t *memoryTransport) Messages() []EmailMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]EmailMessage(nil), t.messages...)
+}
+
+func (t *memoryTransport) Reset() {
+	t.mu.Lock()
+	t.messages = nil
+	t.mu.Unlock()
+}
+
+// envEmailConfig legge la configurazione dalle variabili EMAIL_*
+func envEmailConfig() EmailConfig {
+	port := getEnv("EMAIL_SMTP_PORT", "587")
+	security := "starttls"
+	if port == "465" {
+		security = "tls"
+	}
+
+	config := EmailConfig{
+		Transport:    getEnv("EMAIL_TRANSPORT", EmailTransportSMTP),
+		SMTPHost:     getEnv("EMAIL_SMTP_HOST", "smtp.gmail.com"),
+		SMTPPort:     port,
+		Security:     getEnv("EMAIL_SMTP_SECURITY", security),
+		AuthMode:     getEnv("EMAIL_SMTP_AUTH", "plain"),
+		From:         getEnv("EMAIL_FROM", "notifications@laninna.it"),
+		Username:     getEnv("EMAIL_USERNAME", ""),
+		Password:     getEnv("EMAIL_PASSWORD", ""),
+		SendmailPath: getEnv("EMAIL_SENDMAIL_PATH", "/usr/sbin/sendmail"),
+		MailboxDir:   getEnv("EMAIL_MAILBOX_DIR", "./mailbox"),
+	}
+	// Per SMTP servono le credenziali, gli altri trasporti funzionano subito
+	config.Enabled = config.Transport != EmailTransportSMTP || config.Username != ""
+	return config
+}
+
+// applyEnvEmailSettings imposta dall'ambiente percorso di sendmail, casella e password SMTP:
+// i percorsi portano a eseguire comandi e scrivere file sul server, la password non va salvata in chiaro
+func applyEnvEmailSettings(settings *EmailTransportSettings) {
+	env := envEmailConfig()
+	settings.SendmailPath = env.SendmailPath
+	settings.MailboxDir = env.MailboxDir
+	settings.Password = env.Password
+}
+
+// dropStoredEmailPassword elimina la colonna della password salvata in chiaro dalle versioni precedenti
+func dropStoredEmailPassword(db *gorm.DB) {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&EmailTransportSettings{}) || !migrator.HasColumn(&EmailTransportSettings{}, "password") {
+		return
+	}
+	if err := migrator.DropColumn(&EmailTransportSettings{}, "password"); err != nil {
+		logger.Error("Errore rimozione password email salvata", err, logger.Str("component", "email"))
+	}
+}
+
+// loadEmailConfig usa le impostazioni salvate dall'amministrazione, altrimenti l'ambiente
+func loadEmailConfig(db *gorm.DB) EmailConfig {
+	if db == nil {
+		return envEmailConfig()
+	}
+
+	var settings EmailTransportSettings
+	if err := db.First(&settings).Error; err != nil {
+		return envEmailConfig()
+	}
+	applyEnvEmailSettings(&settings)
+
+	return EmailConfig{
+		Transport:    settings.Transport,
+		SMTPHost:     settings.SMTPHost,
+		SMTPPort:     settings.SMTPPort,
+		Security:     settings.Security,
+		AuthMode:     settings.AuthMode,
+		From:         settings.From,
+		Username:     settings.Username,
+		Password:     settings.Password,
+		SendmailPath: settings.SendmailPath,
+		MailboxDir:   settings.MailboxDir,
+		Enabled:      settings.Enabled,
+	}
+}
+
+func emailSettingsFromConfig(config EmailConfig) EmailTransportSettings {
+	return EmailTransportSettings{
+		Transport:    config.Transport,
+		SMTPHost:     config.SMTPHost,
+		SMTPPort:     config.SMTPPort,
+		Security:     config.Security,
+		AuthMode:     config.AuthMode,
+		Username:     config.Username,
+		From:         config.From,
+		SendmailPath: config.SendmailPath,
+		MailboxDir:   config.MailboxDir,
+		Enabled:      config.Enabled,
+	}
+}
+
+func validateEmailTransportSettings(settings *EmailTransportSettings) error {
+	if settings.Transport == "" {
+		settings.Transport = EmailTransportSMTP
+	}
+	if !containsString(emailTransports, settings.Transport) {
+		return fmt.Errorf("transport must be one of %s", strings.Join(emailTransports, ", "))
+	}
+	if _, err := mail.ParseAddress(settings.From); err != nil {
+		return errors.New("from must be a valid email address")
+	}
+
+	switch settings.Transport {
+	case EmailTransportSMTP:
+		if settings.SMTPHost == "" || settings.SMTPPort == "" {
+			return errors.New("smtp_host and smtp_port are required")
+		}
+		if settings.Security == "" {
+			settings.Security = "starttls"
+		}
+		if settings.AuthMode == "" {
+			settings.AuthMode = "plain"
+		}
+		if !containsString(smtpSecurityModes, settings.Security) {
+			return fmt.Errorf("security must be one of %s", strings.Join(smtpSecurityModes, ", "))
+		}
+		if !containsString(smtpAuthModes, settings.AuthMode) {
+			return fmt.Errorf("auth_mode must be one of %s", strings.Join(smtpAuthModes, ", "))
+		}
+	case EmailTransportSendmail:
+		if settings.SendmailPath == "" {
+			return errors.New("sendmail_path is required")
+		}
+	case EmailTransportFile:
+		if settings.MailboxDir == "" {
+			return errors.New("mailbox_dir is required")
+		}
+	}
+	return nil
+}
+
+// checkEmailTransport verifica il trasporto configurato, con una connessione reale per SMTP
+func checkEmailTransport(ctx context.Context, db *gorm.DB) (string, error) {
+	config := loadEmailConfig(db)
+	if !config.Enabled {
+		return "not_configured", nil
+	}
+
+	transport, err := newEmailTransport(config)
+	if err != nil {
+		return "error", err
+	}
+	if err := transport.Check(ctx); err != nil {
+		return "error", err
+	}
+	return "ready", nil
+}
+
+// MailboxMessage riassume un messaggio della casella di sviluppo
+type MailboxMessage struct {
+	File    string    `json:"file,omitempty" example:"20240115-103000.000-a1b2c3d4.eml"`
+	From    string    `json:"from" example:"notifications@laninna.it"`
+	To      string    `json:"to" example:"volontari@laninna.it"`
+	Subject string    `json:"subject" example:"[La Ninna] Calo di peso"`
+	Date    time.Time `json:"date" example:"2024-01-15T10:30:00Z" format:"date-time"`
+} // @MailboxMessage
+
+// readMailboxDir legge le intestazioni dei file .eml, i più recenti per primi
+func readMailboxDir(dir string, limit int) ([]MailboxMessage, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+	if len(files) > limit {
+		files = files[:limit]
+	}
+
+	decoder := new(mime.WordDecoder)
+	messages := make([]MailboxMessage, 0, len(files))
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			continue
+		}
+		parsed, err := mail.ReadMessage(f)
+		if err == nil {
+			subject, _ := decoder.DecodeHeader(parsed.Header.Get("Subject"))
+			date, _ := parsed.Header.Date()
+			messages = append(messages, MailboxMessage{
+				File:    filepath.Base(file),
+				From:    parsed.Header.Get("From"),
+				To:      parsed.Header.Get("To"),
+				Subject: subject,
+				Date:    date,
+			})
+		}
+		f.Close()
+	}
+	return messages, nil
+}
+
+// @Summary Get email transport settings
+// @Description Get the effective outgoing email configuration (from the database, or from the environment when never saved). The password is not returned
+// @Tags Email
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} EmailTransportSettings
+// @Failure 401 {object} map[string]string
+// @Router /admin/email-transport [get]
+func getEmailTransportHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var settings EmailTransportSettings
+		if err := db.First(&settings).Error; err != nil {
+			settings = emailSettingsFromConfig(envEmailConfig())
+		}
+		settings.SendmailPath, settings.MailboxDir = envEmailConfig().SendmailPath, envEmailConfig().MailboxDir
+		settings.Password = ""
+		c.JSON(http.StatusOK, settings)
+	}
+}
+
+// @Summary Update email transport settings
+// @Description Save the outgoing email configuration. password, sendmail_path and mailbox_dir come from the environment (EMAIL_PASSWORD, EMAIL_SENDMAIL_PATH, EMAIL_MAILBOX_DIR) and cannot be changed here
+// @Tags Email
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param settings body EmailTransportSettings true "Email transport settings"
+// @Success 200 {object} EmailTransportSettings
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /admin/email-transport [put]
+func updateEmailTransportHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var settings EmailTransportSettings
+		exists := db.First(&settings).Error == nil
+		if !exists {
+			settings = emailSettingsFromConfig(envEmailConfig())
+		}
+
+		id := settings.ID
+		settings.Password = ""
+		settings.SendmailPath, settings.MailboxDir = "", ""
+		if err := c.ShouldBindJSON(&settings); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		env := envEmailConfig()
+		if (settings.SendmailPath != "" && settings.SendmailPath != env.SendmailPath) ||
+			(settings.MailboxDir != "" && settings.MailboxDir != env.MailboxDir) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "sendmail_path and mailbox_dir can only be set with EMAIL_SENDMAIL_PATH and EMAIL_MAILBOX_DIR"})
+			return
+		}
+		if settings.Password != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "password can only be set with EMAIL_PASSWORD"})
+			return
+		}
+		applyEnvEmailSettings(&settings)
+		settings.ID = id
+
+		if err := validateEmailTransportSettings(&settings); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		enabled := settings.Enabled
+		if err := db.Save(&settings).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !enabled {
+			db.Model(&settings).Update("enabled", false)
+		}
+
+		settings.Password = ""
+		c.JSON(http.StatusOK, settings)
+	}
+}
+
+// @Summary Check email transport
+// @Description Verify the configured transport: for SMTP a real connection with TLS and authentication is opened, without sending mail
+// @Tags Email
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]string
+// @Failure 502 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /admin/email-transport/check [post]
+func checkEmailTransportHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status, err := checkEmailTransport(c.Request.Context(), db)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"status": status, "error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": status})
+	}
+}
+
+// @Summary List development mailbox
+// @Description List the messages captured by the file or memory transport, most recent first
+// @Tags Email
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param limit query int false "Limit results" default(50)
+// @Success 200 {array} MailboxMessage
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /admin/email-transport/mailbox [get]
+func getMailboxHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := 50
+		fmt.Sscanf(c.Query("limit"), "%d", &limit)
+		if limit <= 0 {
+			limit = 50
+		}
+
+		config := loadEmailConfig(db)
+		switch config.Transport {
+		case EmailTransportFile:
+			messages, err := readMailboxDir(config.MailboxDir, limit)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, messages)
+
+		case EmailTransportMemory:
+			stored := memoryMailbox.Messages()
+			messages := make([]MailboxMessage, 0, limit)
+			for i := len(stored) - 1; i >= 0 && len(messages) < limit; i-- {
+				messages = append(messages, MailboxMessage{
+					From:    stored[i].From,
+					To:      strings.Join(stored[i].To, ", "),
+					Subject: stored[i].Subject,
+					Date:    stored[i].Date,
+				})
+			}
+			c.JSON(http.StatusOK, messages)
+
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "The mailbox is only available with the file or memory transport"})
+		}
+	}
+}
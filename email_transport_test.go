@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeSMTPServer risponde a una sessione SMTP in chiaro e registra i messaggi ricevuti
+type fakeSMTPServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	commands []string
+	data     []string
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeSMTPServer{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 fake ESMTP")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.Fields(line + " x")[0])
+		s.mu.Lock()
+		s.commands = append(s.commands, command)
+		s.mu.Unlock()
+
+		switch command {
+		case "EHLO":
+			reply("250-fake")
+			reply("250 8BITMIME")
+		case "DATA":
+			reply("354 go ahead")
+			var body strings.Builder
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil || dataLine == ".\r\n" {
+					break
+				}
+				body.WriteString(dataLine)
+			}
+			s.mu.Lock()
+			s.data = append(s.data, body.String())
+			s.mu.Unlock()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func (s *fakeSMTPServer) config() EmailConfig {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return EmailConfig{Transport: EmailTransportSMTP, SMTPHost: host, SMTPPort: port, Security: "none", AuthMode: "none", From: "notifiche@laninna.it", Enabled: true}
+}
+
+func TestSMTPTransportSendAndCheck(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	transport, _ := newEmailTransport(server.config())
+
+	if err := transport.Check(context.Background()); err != nil {
+		t.Fatalf("check against fake server: %v", err)
+	}
+
+	err := transport.Send(EmailMessage{From: "notifiche@laninna.it", To: []string{"volontari@laninna.it"}, Subject: "Calo di peso è critico", HTMLBody: "<p>Spillo</p>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if len(server.data) != 1 {
+		t.Fatalf("messages = %d, want 1 (commands %v)", len(server.data), server.commands)
+	}
+	if !strings.Contains(server.data[0], "Subject: =?utf-8?q?") || !strings.Contains(server.data[0], "<p>Spillo</p>") {
+		t.Errorf("unexpected message:\n%s", server.data[0])
+	}
+}
+
+func TestSMTPTransportStartTLSRequired(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	config := server.config()
+	config.Security = "starttls"
+	transport, _ := newEmailTransport(config)
+
+	if err := transport.Check(context.Background()); err == nil {
+		t.Error("check should fail when the server does not offer STARTTLS")
+	}
+}
+
+func TestFileTransportMailbox(t *testing.T) {
+	db := newTestDB(t)
+	dir := t.TempDir()
+	t.Setenv("EMAIL_MAILBOX_DIR", dir)
+	db.Create(&EmailTransportSettings{Transport: EmailTransportFile, From: "notifiche@laninna.it", Enabled: true})
+
+	emailService := NewEmailService(db)
+	notification := Notification{Type: NotificationWeightDrop, Priority: PriorityHigh, Title: "Calo peso", Message: "Spillo ha perso il 12%"}
+	if err := emailService.SendNotificationEmail(notification, "volontari@laninna.it"); err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := readMailboxDir(dir, 10)
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("mailbox = %+v, err %v", messages, err)
+	}
+	if messages[0].Subject != "[La Ninna] Calo peso" || messages[0].To != "volontari@laninna.it" {
+		t.Errorf("message = %+v", messages[0])
+	}
+
+	if status, err := checkEmailTransport(context.Background(), db); status != "ready" || err != nil {
+		t.Errorf("check = %s, %v", status, err)
+	}
+}
+
+func TestMemoryTransportAndHealth(t *testing.T) {
+	db := newTestDB(t)
+	memoryMailbox.Reset()
+	db.Create(&EmailTransportSettings{Transport: EmailTransportMemory, From: "notifiche@laninna.it", Enabled: true})
+
+	notification := Notification{Type: NotificationSystemAlert, Priority: PriorityMedium, Title: "Prova", Message: "Messaggio"}
+	if err := NewEmailService(db).SendNotificationEmail(notification, "admin@laninna.it"); err != nil {
+		t.Fatal(err)
+	}
+	if messages := memoryMailbox.Messages(); len(messages) != 1 || messages[0].To[0] != "admin@laninna.it" {
+		t.Fatalf("memory mailbox = %+v", messages)
+	}
+
+	health := checkExternalServicesHealth(context.Background(), db)
+	if !health.Email || health.EmailTransport != EmailTransportMemory || health.SMTP != "ready" {
+		t.Errorf("health = %+v", health)
+	}
+}
+
+func TestUpdateEmailTransportRejectsPaths(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := newTestDB(t)
+	t.Setenv("EMAIL_SENDMAIL_PATH", "/usr/sbin/sendmail")
+
+	router := gin.New()
+	router.PUT("/admin/email-transport", updateEmailTransportHandler(db))
+	put := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/admin/email-transport", strings.NewReader(body)))
+		return w
+	}
+
+	if w := put(`{"transport":"sendmail","from":"notifiche@laninna.it","sendmail_path":"/tmp/evil.sh"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("custom sendmail_path: status = %d", w.Code)
+	}
+	if w := put(`{"transport":"file","from":"notifiche@laninna.it","mailbox_dir":"/etc"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("custom mailbox_dir: status = %d", w.Code)
+	}
+	if w := put(`{"transport":"smtp","from":"notifiche@laninna.it","smtp_host":"smtp.example.com","password":"segreta"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("password in the body: status = %d", w.Code)
+	}
+	if w := put(`{"transport":"sendmail","from":"notifiche@laninna.it"}`); w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	if config := loadEmailConfig(db); config.Transport != EmailTransportSendmail || config.SendmailPath != "/usr/sbin/sendmail" {
+		t.Errorf("config = %+v", config)
+	}
+	if db.Migrator().HasColumn(&EmailTransportSettings{}, "password") {
+		t.Error("password column stored in the database")
+	}
+}
+
+func TestServicesHealthIsCached(t *testing.T) {
+	db := newTestDB(t)
+	db.Create(&EmailTransportSettings{Transport: EmailTransportMemory, From: "notifiche@laninna.it", Enabled: true})
+	servicesHealthCache.Lock()
+	servicesHealthCache.health = ExternalServicesHealth{}
+	servicesHealthCache.Unlock()
+
+	first := cachedExternalServicesHealth(context.Background(), db)
+	db.Model(&EmailTransportSettings{}).Where("1 = 1").Update("transport", EmailTransportSMTP)
+	if second := cachedExternalServicesHealth(context.Background(), db); second != first {
+		t.Errorf("health checked again within the TTL: %+v", second)
+	}
+
+	servicesHealthCache.Lock()
+	servicesHealthCache.health.CheckedAt = time.Now().Add(-2 * servicesHealthTTL)
+	servicesHealthCache.Unlock()
+	if third := cachedExternalServicesHealth(context.Background(), db); third.EmailTransport != EmailTransportSMTP || third.Email {
+		t.Errorf("expired health not refreshed: %+v", third)
+	}
+}
@@ -0,0 +1,547 @@
+// escalation.go - Presa in carico ed escalation delle notifiche
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/laninna/hedgehog-app/logger"
+	"gorm.io/gorm"
+)
+
+// Canali di escalation, nell'ordine predefinito
+const (
+	EscalationChannelInApp   = "in_app"
+	EscalationChannelEmail   = "email"
+	EscalationChannelWebhook = "webhook"
+)
+
+// Esito di un passo di escalation
+const (
+	EscalationStatusSent    = "sent"
+	EscalationStatusQueued  = "queued"
+	EscalationStatusFailed  = "failed"
+	EscalationStatusSkipped = "skipped"
+)
+
+// OnCallUpdate è il corpo della richiesta di modifica dei contatti di un utente
+type OnCallUpdate struct {
+	Email       *string `json:"email" example:"volontario@laninna.it"`
+	OnCallOrder *int    `json:"on_call_order" example:"1" minimum:"0"`
+}
+
+func defaultEscalationPolicies() []EscalationPolicy {
+	return []EscalationPolicy{
+		{Name: "Critici", Priority: PriorityCritical, AckTimeoutMinutes: 30, Channels: "in_app,email,webhook", Enabled: true},
+		{Name: "Alta priorità", Priority: PriorityHigh, AckTimeoutMinutes: 120, Channels: "in_app,email", Enabled: true},
+	}
+}
+
+// loadEscalationPolicies restituisce le policy abilitate, creando quelle predefinite al primo avvio.
+// Se l'utente le elimina tutte non vengono ricreate: l'escalation resta disattivata.
+func loadEscalationPolicies(db *gorm.DB) []EscalationPolicy {
+	seedOnce(db, "escalation_policies", &EscalationPolicy{}, func(tx *gorm.DB) error {
+		defaults := defaultEscalationPolicies()
+		return tx.Create(&defaults).Error
+	})
+
+	var policies []EscalationPolicy
+	db.Where("enabled = ?", true).Order("id ASC").Find(&policies)
+	return policies
+}
+
+// policyFor restituisce la prima policy applicabile alla notifica
+func policyFor(policies []EscalationPolicy, notification Notification) *EscalationPolicy {
+	for i, policy := range policies {
+		if policy.Priority != notification.Priority {
+			continue
+		}
+		if types := splitList(policy.Types); len(types) > 0 && !containsString(types, string(notification.Type)) {
+			continue
+		}
+		return &policies[i]
+	}
+	return nil
+}
+
+// onCallUsers restituisce gli utenti reperibili nell'ordine di escalation
+func onCallUsers(db *gorm.DB) []User {
+	var users []User
+	db.Where("on_call_order > 0").Order("on_call_order ASC, id ASC").Find(&users)
+	return users
+}
+
+// onCallUsersAt restituisce i reperibili: prima quelli di turno nel giorno, poi
+// gli altri nell'ordine di escalation
+func onCallUsersAt(db *gorm.DB, now time.Time) []User {
+	users := onCallToday(db, now)
+	for _, user := range onCallUsers(db) {
+		duplicate := false
+		for _, existing := range users {
+			if existing.ID == user.ID {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			users = append(users, user)
+		}
+	}
+	return users
+}
+
+// EscalatePending esegue il passo successivo per le notifiche non prese in carico
+// entro il tempo previsto dalla loro policy. Restituisce il numero di passi eseguiti.
+func (ns *NotificationService) EscalatePending(now time.Time) int {
+	policies := loadEscalationPolicies(ns.db)
+	if len(policies) == 0 {
+		return 0
+	}
+
+	priorities := make([]NotificationPriority, 0, len(policies))
+	for _, policy := range policies {
+		priorities = append(priorities, policy.Priority)
+	}
+
+	var notifications []Notification
+	ns.db.Preload("Hedgehog").Preload("Therapy").
+		Where("acknowledged_at IS NULL AND priority IN ?", priorities).
+		Where("expires_at IS NULL OR expires_at > ?", now).
+		Where("resolved_at IS NULL AND (snoozed_until IS NULL OR snoozed_until <= ?)", now).
+		Order("created_at ASC").
+		Find(&notifications)
+
+	users := onCallUsersAt(ns.db, now)
+	escalated := 0
+	for _, notification := range notifications {
+		policy := policyFor(policies, notification)
+		if policy == nil {
+			continue
+		}
+
+		channels := splitList(policy.Channels)
+		step := notification.EscalationLevel + 1
+		if step >= len(channels) {
+			continue
+		}
+
+		last := notification.CreatedAt
+		if notification.EscalatedAt != nil {
+			last = *notification.EscalatedAt
+		}
+		if now.Sub(last) < time.Duration(policy.AckTimeoutMinutes)*time.Minute {
+			continue
+		}
+
+		var user *User
+		if len(users) > 0 {
+			user = &users[(step-1)%len(users)]
+		}
+
+		ns.escalate(notification, *policy, step, channels[step], user, now)
+		escalated++
+	}
+
+	return escalated
+}
+
+// escalate esegue un passo di escalation e lo registra sulla notifica; email e
+// webhook passano dalla coda lavori e, se non critici, rispettano le ore di silenzio
+func (ns *NotificationService) escalate(notification Notification, policy EscalationPolicy, step int, channel string, user *User, now time.Time) {
+	record := NotificationEscalation{
+		NotificationID: notification.ID,
+		PolicyID:       policy.ID,
+		Step:           step,
+		Channel:        channel,
+		Status:         EscalationStatusSent,
+	}
+	if user != nil {
+		record.UserID = &user.ID
+	}
+
+	var err error
+	switch channel {
+	case EscalationChannelInApp:
+		// Riporta la notifica tra le non lette dell'utente reperibile (o di tutti)
+		query := ns.db.Where("notification_id = ?", notification.ID)
+		if user != nil {
+			query = query.Where("user_id = ?", user.ID)
+		}
+		err = query.Delete(&NotificationReceipt{}).Error
+
+	case EscalationChannelEmail:
+		record.Recipient = ns.settings.EmailAddress
+		if user != nil && user.Email != "" {
+			record.Recipient = user.Email
+		}
+		emailService := NewEmailService(ns.db)
+		if record.Recipient == "" || emailService == nil || !emailService.config.Enabled {
+			record.Status = EscalationStatusSkipped
+			record.Error = "email non configurata"
+			break
+		}
+		record.Status = EscalationStatusQueued
+		_, err = enqueueJobAt(ns.db, JobKindNotificationEmail, ExternalNotificationPayload{
+			NotificationID: notification.ID,
+			Recipient:      record.Recipient,
+		}, holdUntil(ns.db, notification, QuietChannelEmail, record.Recipient, now))
+
+	case EscalationChannelWebhook:
+		// Consegne firmate alle sottoscrizioni interessate, registrate nel log consegne
+		if ns.enqueueWebhookDeliveries(notification) == 0 {
+			record.Status = EscalationStatusSkipped
+			record.Error = "nessuna sottoscrizione webhook"
+			break
+		}
+		record.Status = EscalationStatusQueued
+
+	default:
+		record.Status = EscalationStatusSkipped
+		record.Error = "canale sconosciuto: " + channel
+	}
+
+	if err != nil {
+		record.Status = EscalationStatusFailed
+		record.Error = err.Error()
+	}
+
+	if err := ns.db.Create(&record).Error; err != nil {
+		logger.Error("Errore registrazione escalation", err,
+			logger.Str("component", "escalation"),
+			logger.Uint("notification_id", notification.ID))
+	}
+
+	ns.db.Model(&Notification{}).Where("id = ?", notification.ID).Updates(map[string]interface{}{
+		"escalation_level": step,
+		"escalated_at":     now,
+	})
+
+	logger.Info("⏫ Notifica escalata",
+		logger.Str("component", "escalation"),
+		logger.Uint("notification_id", notification.ID),
+		logger.Int("step", step),
+		logger.Str("channel", channel),
+		logger.Str("status", record.Status))
+}
+
+func validEscalationChannel(channel string) bool {
+	switch channel {
+	case EscalationChannelInApp, EscalationChannelEmail, EscalationChannelWebhook:
+		return true
+	}
+	return false
+}
+
+func validateEscalationPolicy(policy *EscalationPolicy) error {
+	if policy.Name == "" {
+		return errors.New("name is required")
+	}
+	if !validNotificationPriority(policy.Priority) {
+		return errors.New("priority must be one of low, medium, high, critical")
+	}
+	if policy.AckTimeoutMinutes < 1 {
+		return errors.New("ack_timeout_minutes must be at least 1")
+	}
+	if policy.Channels == "" {
+		policy.Channels = "in_app,email,webhook"
+	}
+	channels := splitList(policy.Channels)
+	if len(channels) < 2 {
+		return errors.New("channels must list the initial channel and at least one escalation channel")
+	}
+	for _, channel := range channels {
+		if !validEscalationChannel(channel) {
+			return errors.New("channels must be in_app, email or webhook")
+		}
+	}
+	return nil
+}
+
+// @Summary Acknowledge notification
+// @Description Acknowledge a notification, stopping its escalation
+// @Tags Notifications
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Notification ID"
+// @Success 200 {object} Notification
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /notifications/{id}/acknowledge [put]
+func acknowledgeNotificationHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := requireUserID(c)
+		if !ok {
+			return
+		}
+
+		var notification Notification
+		if err := db.First(&notification, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+			return
+		}
+
+		if notification.AcknowledgedAt == nil {
+			now := time.Now()
+			notification.AcknowledgedAt = &now
+			notification.AcknowledgedBy = &userID
+			if err := db.Model(&notification).Updates(map[string]interface{}{
+				"acknowledged_at": now,
+				"acknowledged_by": userID,
+			}).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			publishNotificationEvent(db, NotificationEventAcknowledged, notification.ID, nil, gin.H{
+				"notification_id": notification.ID,
+				"acknowledged_at": now,
+				"acknowledged_by": userID,
+			})
+		}
+
+		// Prendere in carico implica averla letta
+		receipt, err := updateReceipt(db, notification.ID, userID, true, false)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		notification.Read = receipt.Read
+		notification.Dismissed = receipt.Dismissed
+
+		c.JSON(http.StatusOK, notification)
+	}
+}
+
+// @Summary Get notification escalations
+// @Description Get the escalation steps performed for a notification
+// @Tags Notifications
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Notification ID"
+// @Success 200 {array} NotificationEscalation
+// @Failure 404 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /notifications/{id}/escalations [get]
+func getNotificationEscalationsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var notification Notification
+		if err := db.First(&notification, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+			return
+		}
+
+		var escalations []NotificationEscalation
+		db.Where("notification_id = ?", notification.ID).Order("step ASC").Find(&escalations)
+
+		c.JSON(http.StatusOK, escalations)
+	}
+}
+
+// @Summary List escalation policies
+// @Description List the escalation policies for unacknowledged notifications
+// @Tags Escalation
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} EscalationPolicy
+// @Failure 401 {object} map[string]string
+// @Router /escalation-policies [get]
+func getEscalationPoliciesHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		loadEscalationPolicies(db)
+
+		var policies []EscalationPolicy
+		db.Order("id ASC").Find(&policies)
+		c.JSON(http.StatusOK, policies)
+	}
+}
+
+// @Summary Create escalation policy
+// @Description Create a new escalation policy
+// @Tags Escalation
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param policy body EscalationPolicy true "Escalation policy"
+// @Success 201 {object} EscalationPolicy
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /escalation-policies [post]
+func createEscalationPolicyHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		policy := EscalationPolicy{Enabled: true}
+		if err := c.ShouldBindJSON(&policy); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		policy.ID = 0
+
+		if err := validateEscalationPolicy(&policy); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := db.Create(&policy).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, policy)
+	}
+}
+
+// @Summary Update escalation policy
+// @Description Update an existing escalation policy
+// @Tags Escalation
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Policy ID"
+// @Param policy body EscalationPolicy true "Escalation policy"
+// @Success 200 {object} EscalationPolicy
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /escalation-policies/{id} [put]
+func updateEscalationPolicyHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var policy EscalationPolicy
+		if err := db.First(&policy, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Escalation policy not found"})
+			return
+		}
+
+		id := policy.ID
+		if err := c.ShouldBindJSON(&policy); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		policy.ID = id
+
+		if err := validateEscalationPolicy(&policy); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := db.Save(&policy).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, policy)
+	}
+}
+
+// @Summary Delete escalation policy
+// @Description Delete an escalation policy
+// @Tags Escalation
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Policy ID"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /escalation-policies/{id} [delete]
+func deleteEscalationPolicyHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var policy EscalationPolicy
+		if err := db.First(&policy, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Escalation policy not found"})
+			return
+		}
+
+		if err := db.Delete(&policy).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Escalation policy deleted"})
+	}
+}
+
+// @Summary List users
+// @Description List users with their contact details and on-call order
+// @Tags Escalation
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} User
+// @Failure 401 {object} map[string]string
+// @Router /users [get]
+func getUsersHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var users []User
+		db.Order("username ASC").Find(&users)
+		c.JSON(http.StatusOK, users)
+	}
+}
+
+// @Summary Update user on-call details
+// @Description Update the email address and on-call escalation order of a user. An empty email removes it
+// @Tags Escalation
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "User ID"
+// @Param contact body OnCallUpdate true "Contact details"
+// @Success 200 {object} User
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /users/{id}/on-call [put]
+func updateUserOnCallHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var user User
+		if err := db.First(&user, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		var request OnCallUpdate
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		updates := map[string]interface{}{}
+		if request.Email != nil {
+			email := strings.TrimSpace(*request.Email)
+			if email != "" {
+				addr, err := mail.ParseAddress(email)
+				if err != nil || addr.Address != email {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "email must be a valid email address"})
+					return
+				}
+			}
+			updates["email"] = email
+		}
+		if request.OnCallOrder != nil {
+			if *request.OnCallOrder < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "on_call_order must not be negative"})
+				return
+			}
+			updates["on_call_order"] = *request.OnCallOrder
+		}
+
+		if len(updates) > 0 {
+			if err := db.Model(&user).Updates(updates).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		db.First(&user, user.ID)
+		c.JSON(http.StatusOK, user)
+	}
+}
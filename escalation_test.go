@@ -0,0 +1,173 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestEscalationFollowsChannelsAndOnCallUsers(t *testing.T) {
+	db := newTestDB(t)
+	db.Create(&User{Username: "primo", Password: "x", Email: "primo@laninna.it", OnCallOrder: 1})
+	db.Create(&User{Username: "secondo", Password: "x", OnCallOrder: 2})
+	db.Create(&User{Username: "riposo", Password: "x"})
+
+	hedgehog := Hedgehog{Name: "Spillo"}
+	db.Create(&hedgehog)
+	notification := Notification{Type: NotificationWeightDrop, Priority: PriorityCritical, Title: "Calo", Message: "Calo", HedgehogID: &hedgehog.ID}
+	db.Create(&notification)
+
+	ns := NewNotificationService(db)
+	start := time.Now()
+	steps := []struct {
+		after time.Duration
+		want  int
+	}{
+		{0, 0},                 // Entro il tempo di presa in carico
+		{31 * time.Minute, 1},  // Email al primo reperibile
+		{45 * time.Minute, 0},  // Attende un nuovo timeout
+		{62 * time.Minute, 1},  // Webhook, secondo reperibile
+		{200 * time.Minute, 0}, // Canali esauriti
+	}
+	for _, step := range steps {
+		if got := ns.EscalatePending(start.Add(step.after)); got != step.want {
+			t.Fatalf("after %v escalated %d, want %d", step.after, got, step.want)
+		}
+	}
+
+	var escalations []NotificationEscalation
+	db.Order("step ASC").Find(&escalations)
+	if len(escalations) != 2 {
+		t.Fatalf("escalations = %d, want 2", len(escalations))
+	}
+	if escalations[0].Channel != EscalationChannelEmail || escalations[0].Recipient != "primo@laninna.it" {
+		t.Errorf("first step = %+v, want email to the first on-call user", escalations[0])
+	}
+	if escalations[1].Channel != EscalationChannelWebhook || escalations[1].UserID == nil || *escalations[1].UserID != 2 {
+		t.Errorf("second step = %+v, want webhook for the second on-call user", escalations[1])
+	}
+
+	db.First(&notification, notification.ID)
+	if notification.EscalationLevel != 2 {
+		t.Errorf("escalation level = %d, want 2", notification.EscalationLevel)
+	}
+}
+
+func TestEscalationQueuesEmailAndWebhookSteps(t *testing.T) {
+	db := newTestDB(t)
+	db.Create(&EmailTransportSettings{Transport: EmailTransportMemory, From: "notifiche@laninna.it", Enabled: true})
+	db.Create(&NotificationSettings{WebhookURL: "https://example.org/legacy"})
+	db.Create(&WebhookSubscription{Name: "Reperibili", URL: "https://example.org/hooks/laninna", Format: "json", Enabled: true})
+	db.Create(&User{Username: "primo", Password: "x", Email: "primo@laninna.it", OnCallOrder: 1})
+
+	notification := Notification{Type: NotificationWeightDrop, Priority: PriorityCritical, Title: "Calo", Message: "Calo"}
+	db.Create(&notification)
+
+	ns := NewNotificationService(db)
+	start := time.Now()
+	ns.EscalatePending(start.Add(31 * time.Minute))
+	ns.EscalatePending(start.Add(62 * time.Minute))
+
+	var escalations []NotificationEscalation
+	db.Order("step ASC").Find(&escalations)
+	if len(escalations) != 2 {
+		t.Fatalf("escalations = %d, want 2", len(escalations))
+	}
+	for _, escalation := range escalations {
+		if escalation.Status != EscalationStatusQueued {
+			t.Errorf("step %d status = %q, want queued", escalation.Step, escalation.Status)
+		}
+	}
+
+	var jobs []Job
+	db.Order("id ASC").Find(&jobs)
+	if len(jobs) != 2 || jobs[0].Kind != JobKindNotificationEmail || !strings.Contains(jobs[0].Payload, "primo@laninna.it") {
+		t.Fatalf("jobs = %+v, want the email step queued first", jobs)
+	}
+	// Il webhook passa dalle sottoscrizioni firmate, non dall'URL legacy
+	if jobs[1].Kind != JobKindWebhookDelivery || !strings.Contains(jobs[1].Payload, `"subscription_id":1`) {
+		t.Errorf("second job = %+v, want a signed subscription delivery", jobs[1])
+	}
+}
+
+func TestAcknowledgedNotificationsAreNotEscalated(t *testing.T) {
+	db := newTestDB(t)
+	hedgehog := Hedgehog{Name: "Spillo"}
+	db.Create(&hedgehog)
+	now := time.Now()
+	userID := uint(1)
+	db.Create(&Notification{Type: NotificationWeightDrop, Priority: PriorityCritical, Title: "Calo", Message: "Calo",
+		HedgehogID: &hedgehog.ID, AcknowledgedAt: &now, AcknowledgedBy: &userID})
+
+	if got := NewNotificationService(db).EscalatePending(now.Add(time.Hour)); got != 0 {
+		t.Errorf("escalated %d acknowledged notifications", got)
+	}
+}
+
+func TestEscalationPoliciesSeededOnlyOnce(t *testing.T) {
+	db := newTestDB(t)
+	if policies := loadEscalationPolicies(db); len(policies) != 2 {
+		t.Fatalf("default policies = %d, want 2", len(policies))
+	}
+
+	// Eliminate tutte, l'escalation resta disattivata
+	db.Where("1 = 1").Delete(&EscalationPolicy{})
+	if policies := loadEscalationPolicies(db); len(policies) != 0 {
+		t.Errorf("policies re-seeded: %+v", policies)
+	}
+}
+
+func TestUpdateUserOnCallValidatesEmail(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := newTestDB(t)
+	user := User{Username: "mario", Password: "x", Email: "mario@laninna.it"}
+	db.Create(&user)
+
+	router := gin.New()
+	router.PUT("/users/:id/on-call", updateUserOnCallHandler(db))
+	put := func(body string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/users/1/on-call", strings.NewReader(body)))
+		return w.Code
+	}
+
+	for _, email := range []string{"mario", "mario@", "Mario <mario@laninna.it>"} {
+		if code := put(`{"email":"` + strings.ReplaceAll(email, `"`, `\"`) + `"}`); code != http.StatusBadRequest {
+			t.Errorf("email %q: status = %d", email, code)
+		}
+	}
+	if code := put(`{"email":" volontario@laninna.it "}`); code != http.StatusOK {
+		t.Fatalf("valid email: status = %d", code)
+	}
+	db.First(&user, user.ID)
+	if user.Email != "volontario@laninna.it" {
+		t.Errorf("email = %q", user.Email)
+	}
+	if code := put(`{"email":""}`); code != http.StatusOK {
+		t.Errorf("clearing email: status = %d", code)
+	}
+}
+
+func TestCreateDisabledEscalationPolicy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := newTestDB(t)
+	router := gin.New()
+	router.POST("/escalation-policies", createEscalationPolicyHandler(db))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/escalation-policies",
+		strings.NewReader(`{"name":"Bozza","priority":"high","ack_timeout_minutes":60,"channels":"in_app,email","enabled":false}`)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+
+	var policy EscalationPolicy
+	db.First(&policy)
+	if policy.Enabled {
+		t.Error("policy created with enabled=false was stored as enabled")
+	}
+}
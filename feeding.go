@@ -0,0 +1,521 @@
+// feeding.go - Piani alimentari e registro giornaliero del cibo
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Riepilogo alimentazione di un riccio su un periodo
+type IntakeSummary struct {
+	PlannedDailyIntake float64 `json:"planned_daily_intake"`
+	AvgDailyIntake     float64 `json:"avg_daily_intake"`
+	IntakeRatio        float64 `json:"intake_ratio"`
+	LoggedDays         int     `json:"logged_days"`
+	LowDays            int     `json:"low_days"`
+}
+
+// startOfDay tronca un orario all'inizio della giornata
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// plannedDailyIntake calcola i grammi giornalieri previsti dai piani attivi in un giorno
+func plannedDailyIntake(plans []FeedingPlan, day time.Time) float64 {
+	total := 0.0
+	for _, plan := range plans {
+		if !plan.Active || startOfDay(plan.StartDate).After(day) {
+			continue
+		}
+		if plan.EndDate != nil && plan.EndDate.Before(day) {
+			continue
+		}
+		total += plan.Grams * float64(plan.TimesPerDay)
+	}
+	return total
+}
+
+// summarizeIntake analizza gli ultimi giorni completi (escluso oggi) di alimentazione
+func (ns *NotificationService) summarizeIntake(hedgehogID uint, days int) IntakeSummary {
+	var summary IntakeSummary
+
+	today := startOfDay(time.Now())
+	since := today.AddDate(0, 0, -days)
+
+	var plans []FeedingPlan
+	ns.db.Where("hedgehog_id = ? AND active = ?", hedgehogID, true).Find(&plans)
+
+	var intakes []FoodIntake
+	// Un giorno di margine per parte: una data salvata con un altro fuso (SQLite confronta il testo)
+	// può cadere fuori dai limiti; il giorno locale di ogni pasto si decide qui sotto
+	ns.db.Where("hedgehog_id = ? AND date >= ? AND date < ?", hedgehogID, since.AddDate(0, 0, -1), today.AddDate(0, 0, 1)).
+		Find(&intakes)
+
+	// Giorni locali, come plannedDailyIntake: un pasto salvato in UTC vicino alla mezzanotte
+	// finirebbe altrimenti nel giorno sbagliato. I giorni fuori dal periodo sono ignorati dal ciclo.
+	eatenByDay := make(map[time.Time]float64)
+	offeredByDay := make(map[time.Time]float64)
+	for _, intake := range intakes {
+		key := startOfDay(intake.Date.In(time.Local))
+		eatenByDay[key] += intake.EatenGrams
+		offeredByDay[key] += intake.OfferedGrams
+	}
+
+	threshold := ns.settings.LowIntakeThreshold
+	if threshold <= 0 {
+		threshold = 50
+	}
+
+	totalPlanned, totalEaten := 0.0, 0.0
+	for day := since; day.Before(today); day = day.AddDate(0, 0, 1) {
+		eaten, logged := eatenByDay[day]
+		if !logged {
+			continue
+		}
+
+		// Senza piano si confronta con il cibo offerto
+		planned := plannedDailyIntake(plans, day)
+		if planned == 0 {
+			planned = offeredByDay[day]
+		}
+
+		summary.LoggedDays++
+		totalEaten += eaten
+		totalPlanned += planned
+		if planned > 0 && eaten/planned*100 < threshold {
+			summary.LowDays++
+		}
+	}
+
+	summary.PlannedDailyIntake = plannedDailyIntake(plans, today)
+	if summary.LoggedDays > 0 {
+		summary.AvgDailyIntake = totalEaten / float64(summary.LoggedDays)
+	}
+	if totalPlanned > 0 {
+		summary.IntakeRatio = totalEaten / totalPlanned * 100
+	}
+
+	return summary
+}
+
+// calculateIntakeCorrelation calcola la correlazione di Pearson tra cibo mangiato
+// e variazione di peso giornaliera negli intervalli tra pesature consecutive
+func calculateIntakeCorrelation(weights []WeightRecord, intakes []FoodIntake) *float64 {
+	var xs, ys []float64
+
+	// weights è ordinato per data decrescente
+	for i := 0; i < len(weights)-1; i++ {
+		from, to := weights[i+1].Date, weights[i].Date
+		days := to.Sub(from).Hours() / 24
+		if days <= 0 {
+			continue
+		}
+
+		eaten, found := 0.0, false
+		for _, intake := range intakes {
+			if !intake.Date.Before(from) && intake.Date.Before(to) {
+				eaten += intake.EatenGrams
+				found = true
+			}
+		}
+		if !found {
+			continue
+		}
+
+		xs = append(xs, eaten/days)
+		ys = append(ys, (weights[i].Weight-weights[i+1].Weight)/days)
+	}
+
+	if len(xs) < 3 {
+		return nil
+	}
+
+	n := float64(len(xs))
+	var sumX, sumY float64
+	for i := range xs {
+		sumX += xs[i]
+		sumY += ys[i]
+	}
+	meanX, meanY := sumX/n, sumY/n
+
+	var cov, varX, varY float64
+	for i := range xs {
+		dx, dy := xs[i]-meanX, ys[i]-meanY
+		cov += dx * dy
+		varX += dx * dx
+		varY += dy * dy
+	}
+	if varX == 0 || varY == 0 {
+		return nil
+	}
+
+	r := cov / math.Sqrt(varX*varY)
+	return &r
+}
+
+// applyIntakeAnalysis arricchisce l'analisi peso con i dati di alimentazione
+func (ns *NotificationService) applyIntakeAnalysis(analysis *WeightAnalysis, weights []WeightRecord) {
+	days := ns.lowIntakeDays()
+	summary := ns.summarizeIntake(analysis.HedgehogID, days)
+
+	analysis.PlannedDailyIntake = summary.PlannedDailyIntake
+	analysis.AvgDailyIntake = summary.AvgDailyIntake
+	analysis.IntakeRatio = summary.IntakeRatio
+
+	if len(weights) >= 2 {
+		var intakes []FoodIntake
+		ns.db.Where("hedgehog_id = ? AND date >= ? AND date < ?",
+			analysis.HedgehogID, weights[len(weights)-1].Date, weights[0].Date).
+			Find(&intakes)
+		analysis.IntakeCorrelation = calculateIntakeCorrelation(weights, intakes)
+	}
+
+	if summary.LoggedDays >= days && summary.LowDays >= days {
+		analysis.IntakeAlert = true
+		analysis.IntakeAlertReason = fmt.Sprintf("Mangia poco da %d giorni (%.0f%% del previsto)", summary.LowDays, summary.IntakeRatio)
+	}
+}
+
+func (ns *NotificationService) lowIntakeDays() int {
+	if ns.settings.LowIntakeDays <= 0 {
+		return 2
+	}
+	return ns.settings.LowIntakeDays
+}
+
+func (ns *NotificationService) foodIntakeCandidates() ([]RuleCandidate, error) {
+	var candidates []RuleCandidate
+	var hedgehogs []Hedgehog
+	ns.scoped(ns.db.Where("status = 'in_care'"), "id").Find(&hedgehogs)
+
+	days := ns.lowIntakeDays()
+
+	for _, hedgehog := range hedgehogs {
+		summary := ns.summarizeIntake(hedgehog.ID, days)
+		if summary.LoggedDays < days || summary.LowDays < days {
+			continue
+		}
+
+		data, _ := json.Marshal(summary)
+		candidates = append(candidates, RuleCandidate{RepeatAfter: 24 * time.Hour, Notification: Notification{
+			Type:         NotificationLowFoodIntake,
+			Priority:     PriorityHigh,
+			TemplateData: templateVars(map[string]interface{}{"Hedgehog": hedgehog.Name, "IntakeRatio": summary.IntakeRatio, "Days": days}),
+			HedgehogID:   &hedgehog.ID,
+			ActionURL:    fmt.Sprintf("/hedgehogs/%d", hedgehog.ID),
+			ActionLabel:  "Controlla Alimentazione",
+			Data:         string(data),
+		}})
+	}
+
+	return candidates, nil
+}
+
+// Feeding plan handlers
+// @Summary Get feeding plans
+// @Description Get feeding plans with optional filters
+// @Tags Feeding
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param hedgehog_id query int false "Filter by hedgehog ID"
+// @Param active query boolean false "Filter by active status"
+// @Success 200 {array} FeedingPlan
+// @Failure 401 {object} map[string]string
+// @Router /feeding-plans [get]
+func getFeedingPlans(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var plans []FeedingPlan
+		query := db.Order("start_date DESC")
+
+		if hedgehogID := c.Query("hedgehog_id"); hedgehogID != "" {
+			query = query.Where("hedgehog_id = ?", hedgehogID)
+		}
+
+		if active := c.Query("active"); active != "" {
+			query = query.Where("active = ?", active == "true")
+		}
+
+		query.Find(&plans)
+		c.JSON(http.StatusOK, plans)
+	}
+}
+
+// @Summary Create feeding plan
+// @Description Create a new feeding plan for a hedgehog
+// @Tags Feeding
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param feeding_plan body FeedingPlan true "Feeding plan data"
+// @Success 201 {object} FeedingPlan
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /feeding-plans [post]
+func createFeedingPlan(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		plan := FeedingPlan{Active: true}
+		if err := c.ShouldBindJSON(&plan); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if plan.HedgehogID == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "hedgehog_id is required"})
+			return
+		}
+
+		if plan.TimesPerDay <= 0 {
+			plan.TimesPerDay = 1
+		}
+
+		if plan.StartDate.IsZero() {
+			plan.StartDate = time.Now()
+		}
+
+		if err := db.Create(&plan).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, plan)
+	}
+}
+
+// @Summary Update feeding plan
+// @Description Update an existing feeding plan
+// @Tags Feeding
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Feeding plan ID"
+// @Param feeding_plan body FeedingPlan true "Updated feeding plan data"
+// @Success 200 {object} FeedingPlan
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /feeding-plans/{id} [put]
+func updateFeedingPlan(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		var plan FeedingPlan
+
+		if err := db.First(&plan, id).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Feeding plan not found"})
+			return
+		}
+
+		previousHedgehogID := plan.HedgehogID
+		if err := c.ShouldBindJSON(&plan); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := db.Save(&plan).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		enqueueAutoResolve(db, plan.HedgehogID)
+		if previousHedgehogID != plan.HedgehogID {
+			enqueueAutoResolve(db, previousHedgehogID)
+		}
+
+		c.JSON(http.StatusOK, plan)
+	}
+}
+
+// @Summary Delete feeding plan
+// @Description Delete a feeding plan by its ID
+// @Tags Feeding
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Feeding plan ID"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /feeding-plans/{id} [delete]
+func deleteFeedingPlan(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		var plan FeedingPlan
+		if err := db.First(&plan, id).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Feeding plan not found"})
+			return
+		}
+		if err := db.Delete(&plan).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		enqueueAutoResolve(db, plan.HedgehogID)
+		c.JSON(http.StatusOK, gin.H{"message": "Feeding plan deleted"})
+	}
+}
+
+// Food intake handlers
+// @Summary Get food intake records
+// @Description Get daily food intake records with optional filters
+// @Tags Feeding
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param hedgehog_id query int false "Filter by hedgehog ID"
+// @Param start_date query string false "Start date filter (YYYY-MM-DD)"
+// @Param end_date query string false "End date filter (YYYY-MM-DD)"
+// @Success 200 {array} FoodIntake
+// @Failure 401 {object} map[string]string
+// @Router /food-intakes [get]
+func getFoodIntakes(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var intakes []FoodIntake
+		query := db.Order("date DESC")
+
+		if hedgehogID := c.Query("hedgehog_id"); hedgehogID != "" {
+			query = query.Where("hedgehog_id = ?", hedgehogID)
+		}
+
+		if startDate := c.Query("start_date"); startDate != "" {
+			if date, err := time.Parse("2006-01-02", startDate); err == nil {
+				query = query.Where("date >= ?", date)
+			}
+		}
+
+		if endDate := c.Query("end_date"); endDate != "" {
+			if date, err := time.Parse("2006-01-02", endDate); err == nil {
+				query = query.Where("date < ?", date.AddDate(0, 0, 1))
+			}
+		}
+
+		query.Find(&intakes)
+		c.JSON(http.StatusOK, intakes)
+	}
+}
+
+// @Summary Create food intake record
+// @Description Log food offered to and eaten by a hedgehog
+// @Tags Feeding
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param food_intake body FoodIntake true "Food intake data"
+// @Success 201 {object} FoodIntake
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /food-intakes [post]
+func createFoodIntake(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var intake FoodIntake
+		if err := c.ShouldBindJSON(&intake); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if intake.HedgehogID == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "hedgehog_id is required"})
+			return
+		}
+
+		if intake.EatenGrams < 0 || intake.OfferedGrams < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "grams cannot be negative"})
+			return
+		}
+
+		if intake.Date.IsZero() {
+			intake.Date = time.Now()
+		}
+
+		// Eredita il tipo di cibo dal piano
+		if intake.FeedingPlanID != nil && intake.FoodType == "" {
+			var plan FeedingPlan
+			if err := db.First(&plan, *intake.FeedingPlanID).Error; err == nil {
+				intake.FoodType = plan.FoodType
+			}
+		}
+
+		if err := db.Create(&intake).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		enqueueAutoResolve(db, intake.HedgehogID)
+
+		c.JSON(http.StatusCreated, intake)
+	}
+}
+
+// @Summary Update food intake record
+// @Description Update an existing food intake record
+// @Tags Feeding
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Food intake ID"
+// @Param food_intake body FoodIntake true "Updated food intake data"
+// @Success 200 {object} FoodIntake
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /food-intakes/{id} [put]
+func updateFoodIntake(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		var intake FoodIntake
+
+		if err := db.First(&intake, id).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Food intake not found"})
+			return
+		}
+
+		if err := c.ShouldBindJSON(&intake); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := db.Save(&intake).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		enqueueAutoResolve(db, intake.HedgehogID)
+
+		c.JSON(http.StatusOK, intake)
+	}
+}
+
+// @Summary Delete food intake record
+// @Description Delete a food intake record by its ID
+// @Tags Feeding
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Food intake ID"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /food-intakes/{id} [delete]
+func deleteFoodIntake(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		var intake FoodIntake
+		if err := db.First(&intake, id).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Food intake not found"})
+			return
+		}
+		if err := db.Delete(&intake).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		enqueueAutoResolve(db, intake.HedgehogID)
+		c.JSON(http.StatusOK, gin.H{"message": "Food intake deleted"})
+	}
+}
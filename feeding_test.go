@@ -0,0 +1,130 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCreateInactiveFeedingPlan(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := newTestDB(t)
+	hedgehog := Hedgehog{Name: "Spillo"}
+	db.Create(&hedgehog)
+
+	router := gin.New()
+	router.POST("/feeding-plans", createFeedingPlan(db))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/feeding-plans",
+		strings.NewReader(`{"hedgehog_id":1,"food_type":"Crocchette","grams":30,"active":false}`)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+
+	var plan FeedingPlan
+	db.First(&plan)
+	if plan.Active {
+		t.Error("plan created with active=false was stored as active")
+	}
+}
+
+func TestPlannedDailyIntake(t *testing.T) {
+	day := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	ended := day.AddDate(0, 0, -1)
+	plans := []FeedingPlan{
+		{Grams: 30, TimesPerDay: 2, Active: true, StartDate: day.Add(9 * time.Hour)}, // Iniziato in giornata
+		{Grams: 10, TimesPerDay: 1, Active: false, StartDate: day.AddDate(0, 0, -5)},
+		{Grams: 20, TimesPerDay: 1, Active: true, StartDate: day.AddDate(0, 0, 1)},
+		{Grams: 40, TimesPerDay: 1, Active: true, StartDate: day.AddDate(0, 0, -5), EndDate: &ended},
+	}
+	if got := plannedDailyIntake(plans, day); got != 60 {
+		t.Errorf("planned = %.0f, want 60 from the only plan in force", got)
+	}
+}
+
+func TestSummarizeIntakeCountsLowDays(t *testing.T) {
+	db := newTestDB(t)
+	hedgehog := Hedgehog{Name: "Spillo"}
+	db.Create(&hedgehog)
+	today := startOfDay(time.Now())
+	db.Create(&FeedingPlan{HedgehogID: hedgehog.ID, FoodType: "Crocchette", Grams: 30, TimesPerDay: 2, Active: true, StartDate: today.AddDate(0, 0, -10)})
+
+	// 60g previsti al giorno, soglia predefinita 50%
+	for daysAgo, eaten := range map[int]float64{3: 20, 2: 50, 1: 25, 0: 0} {
+		db.Create(&FoodIntake{HedgehogID: hedgehog.ID, Date: today.AddDate(0, 0, -daysAgo).Add(8 * time.Hour), OfferedGrams: 60, EatenGrams: eaten})
+	}
+
+	summary := NewNotificationService(db).summarizeIntake(hedgehog.ID, 3)
+	if summary.LoggedDays != 3 || summary.LowDays != 2 {
+		t.Errorf("logged = %d, low = %d, want 3 logged days (today excluded) and 2 low", summary.LoggedDays, summary.LowDays)
+	}
+	if summary.PlannedDailyIntake != 60 || math.Abs(summary.AvgDailyIntake-95.0/3) > 0.01 || math.Abs(summary.IntakeRatio-95.0/180*100) > 0.01 {
+		t.Errorf("summary = %+v", summary)
+	}
+}
+
+func TestSummarizeIntakeWithoutPlanUsesOfferedFood(t *testing.T) {
+	db := newTestDB(t)
+	hedgehog := Hedgehog{Name: "Spillo"}
+	db.Create(&hedgehog)
+	yesterday := startOfDay(time.Now()).AddDate(0, 0, -1).Add(8 * time.Hour)
+	db.Create(&FoodIntake{HedgehogID: hedgehog.ID, Date: yesterday, OfferedGrams: 40, EatenGrams: 10})
+
+	summary := NewNotificationService(db).summarizeIntake(hedgehog.ID, 2)
+	if summary.LowDays != 1 || summary.IntakeRatio != 25 {
+		t.Errorf("summary = %+v, want the day compared with the 40g offered", summary)
+	}
+}
+
+func TestCalculateIntakeCorrelation(t *testing.T) {
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	// Pesate in ordine decrescente, come arrivano dal database
+	weights := []WeightRecord{
+		{Weight: 530, Date: start.AddDate(0, 0, 4)},
+		{Weight: 520, Date: start.AddDate(0, 0, 3)},
+		{Weight: 515, Date: start.AddDate(0, 0, 2)},
+		{Weight: 505, Date: start.AddDate(0, 0, 1)},
+		{Weight: 500, Date: start},
+	}
+	intakes := []FoodIntake{
+		{Date: start.Add(12 * time.Hour), EatenGrams: 40},
+		{Date: start.AddDate(0, 0, 1).Add(12 * time.Hour), EatenGrams: 60},
+		{Date: start.AddDate(0, 0, 2).Add(12 * time.Hour), EatenGrams: 45},
+		{Date: start.AddDate(0, 0, 3).Add(12 * time.Hour), EatenGrams: 55},
+	}
+
+	r := calculateIntakeCorrelation(weights, intakes)
+	if r == nil || *r < 0.9 {
+		t.Fatalf("correlation = %v, want strongly positive", r)
+	}
+
+	// Servono almeno tre intervalli con cibo registrato
+	if r := calculateIntakeCorrelation(weights, intakes[:2]); r != nil {
+		t.Errorf("correlation with two intervals = %.2f, want nil", *r)
+	}
+}
+
+func TestSummarizeIntakeUsesLocalDays(t *testing.T) {
+	local := time.Local
+	time.Local = time.FixedZone("UTC+2", 2*60*60)
+	t.Cleanup(func() { time.Local = local })
+
+	db := newTestDB(t)
+	hedgehog := Hedgehog{Name: "Spillo"}
+	db.Create(&hedgehog)
+
+	// Pasto di ieri all'1:00 ora locale, salvato in UTC alle 23:00 del giorno prima
+	meal := startOfDay(time.Now()).AddDate(0, 0, -1).Add(time.Hour).UTC()
+	db.Create(&FoodIntake{HedgehogID: hedgehog.ID, Date: meal, OfferedGrams: 40, EatenGrams: 40})
+
+	summary := NewNotificationService(db).summarizeIntake(hedgehog.ID, 1)
+	if summary.LoggedDays != 1 || summary.LowDays != 0 {
+		t.Errorf("summary = %+v, want the meal counted on yesterday's local day", summary)
+	}
+}
@@ -0,0 +1,369 @@
+// growth.go - Curve di crescita attese per classe d'età e sesso
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	AgeClassHoglet   = "hoglet"
+	AgeClassJuvenile = "juvenile"
+	AgeClassAdult    = "adult"
+)
+
+// Confronto tra il peso attuale e la curva di crescita attesa
+type GrowthComparison struct {
+	AgeDays            int      `json:"age_days"`
+	AgeClass           string   `json:"age_class"`
+	ExpectedWeight     float64  `json:"expected_weight"`
+	ZScore             float64  `json:"z_score"`
+	Percentile         float64  `json:"percentile"`
+	ExpectedGainPerDay float64  `json:"expected_gain_per_day"`
+	ActualGainPerDay   float64  `json:"actual_gain_per_day"`
+	GainRatio          *float64 `json:"gain_ratio,omitempty"` // Crescita reale / crescita attesa
+	BehindCurve        bool     `json:"behind_curve"`
+}
+
+// ageClassFor restituisce la classe d'età in base ai giorni di vita
+func ageClassFor(ageDays int) string {
+	switch {
+	case ageDays < 56:
+		return AgeClassHoglet
+	case ageDays < 365:
+		return AgeClassJuvenile
+	default:
+		return AgeClassAdult
+	}
+}
+
+func validAgeClass(class string) bool {
+	return class == AgeClassHoglet || class == AgeClassJuvenile || class == AgeClassAdult
+}
+
+// Curva di riferimento di default per il riccio europeo (valida per entrambi i sessi)
+func defaultGrowthCurve() []GrowthCurvePoint {
+	points := []struct {
+		age      int
+		expected float64
+		stdDev   float64
+	}{
+		{0, 20, 4},
+		{14, 80, 15},
+		{28, 150, 25},
+		{42, 230, 35},
+		{56, 300, 45},
+		{90, 420, 60},
+		{120, 520, 75},
+		{180, 650, 90},
+		{270, 750, 100},
+		{365, 850, 110},
+		{730, 950, 120},
+	}
+
+	curve := make([]GrowthCurvePoint, 0, len(points))
+	for _, p := range points {
+		curve = append(curve, GrowthCurvePoint{
+			Sex:            "any",
+			AgeClass:       ageClassFor(p.age),
+			AgeDays:        p.age,
+			ExpectedWeight: p.expected,
+			StdDev:         p.stdDev,
+		})
+	}
+	return curve
+}
+
+// loadGrowthCurve legge la curva per il sesso indicato, con fallback su quella generica.
+// La curva predefinita si crea solo al primo utilizzo.
+func loadGrowthCurve(db *gorm.DB, sex string) []GrowthCurvePoint {
+	seedOnce(db, "growth_curve", &GrowthCurvePoint{}, func(tx *gorm.DB) error {
+		defaults := defaultGrowthCurve()
+		return tx.Create(&defaults).Error
+	})
+
+	var curve []GrowthCurvePoint
+	if sex == "male" || sex == "female" {
+		db.Where("sex = ?", sex).Order("age_days ASC").Find(&curve)
+	}
+	if len(curve) == 0 {
+		db.Where("sex = 'any'").Order("age_days ASC").Find(&curve)
+	}
+	return curve
+}
+
+// interpolateGrowthCurve calcola peso atteso e deviazione standard a una certa età
+func interpolateGrowthCurve(curve []GrowthCurvePoint, ageDays float64) (expected, stdDev float64, ok bool) {
+	if len(curve) == 0 {
+		return 0, 0, false
+	}
+	if ageDays <= float64(curve[0].AgeDays) {
+		return curve[0].ExpectedWeight, curve[0].StdDev, true
+	}
+
+	for i := 1; i < len(curve); i++ {
+		prev, next := curve[i-1], curve[i]
+		if ageDays <= float64(next.AgeDays) {
+			span := float64(next.AgeDays - prev.AgeDays)
+			if span <= 0 {
+				return next.ExpectedWeight, next.StdDev, true
+			}
+			f := (ageDays - float64(prev.AgeDays)) / span
+			return prev.ExpectedWeight + f*(next.ExpectedWeight-prev.ExpectedWeight),
+				prev.StdDev + f*(next.StdDev-prev.StdDev), true
+		}
+	}
+
+	// Oltre l'ultimo punto la curva resta piatta
+	last := curve[len(curve)-1]
+	return last.ExpectedWeight, last.StdDev, true
+}
+
+// normalPercentile converte uno z-score nel percentile della distribuzione normale
+func normalPercentile(z float64) float64 {
+	return 0.5 * (1 + math.Erf(z/math.Sqrt2)) * 100
+}
+
+// compareWithGrowthCurve confronta un peso con la curva all'età raggiunta nella data indicata
+func compareWithGrowthCurve(curve []GrowthCurvePoint, birthDate time.Time, weight float64, at time.Time) (GrowthComparison, bool) {
+	ageDays := at.Sub(birthDate).Hours() / 24
+	if ageDays < 0 {
+		return GrowthComparison{}, false
+	}
+
+	expected, stdDev, ok := interpolateGrowthCurve(curve, ageDays)
+	if !ok || stdDev <= 0 {
+		return GrowthComparison{}, false
+	}
+
+	z := (weight - expected) / stdDev
+	return GrowthComparison{
+		AgeDays:        int(ageDays),
+		AgeClass:       ageClassFor(int(ageDays)),
+		ExpectedWeight: math.Round(expected*10) / 10,
+		ZScore:         math.Round(z*100) / 100,
+		Percentile:     math.Round(normalPercentile(z)*10) / 10,
+	}, true
+}
+
+// annotateWeightRecord valorizza i campi della curva di crescita di una pesata
+func annotateWeightRecord(db *gorm.DB, record *WeightRecord) {
+	record.AgeClass = ""
+	record.ExpectedWeight = nil
+	record.ZScore = nil
+	record.Percentile = nil
+
+	var hedgehog Hedgehog
+	if err := db.First(&hedgehog, record.HedgehogID).Error; err != nil || hedgehog.BirthDate == nil {
+		return
+	}
+
+	comparison, ok := compareWithGrowthCurve(loadGrowthCurve(db, hedgehog.Sex), *hedgehog.BirthDate, record.Weight, record.Date)
+	if !ok {
+		return
+	}
+
+	record.AgeClass = comparison.AgeClass
+	record.ExpectedWeight = &comparison.ExpectedWeight
+	record.ZScore = &comparison.ZScore
+	record.Percentile = &comparison.Percentile
+}
+
+// analyzeGrowth confronta peso e crescita recente con quelli attesi dalla curva
+func (ns *NotificationService) analyzeGrowth(hedgehog Hedgehog, latest WeightRecord, trend WeightTrend) *GrowthComparison {
+	if hedgehog.BirthDate == nil {
+		return nil
+	}
+
+	curve := loadGrowthCurve(ns.db, hedgehog.Sex)
+	comparison, ok := compareWithGrowthCurve(curve, *hedgehog.BirthDate, latest.Weight, latest.Date)
+	if !ok {
+		return nil
+	}
+
+	// Crescita attesa nella finestra del trend
+	window := float64(trend.WindowDays)
+	if window <= 0 {
+		window = float64(ns.trendConfig().WindowDays)
+	}
+	ageDays := latest.Date.Sub(*hedgehog.BirthDate).Hours() / 24
+	expectedNow, _, _ := interpolateGrowthCurve(curve, ageDays)
+	expectedBefore, _, _ := interpolateGrowthCurve(curve, math.Max(0, ageDays-window))
+	comparison.ExpectedGainPerDay = math.Round((expectedNow-expectedBefore)/window*10) / 10
+
+	if comparison.ZScore < ns.settings.GrowthMinZScore {
+		comparison.BehindCurve = true
+	}
+
+	if trend.Direction != "insufficient_data" {
+		comparison.ActualGainPerDay = math.Round(trend.SlopePerDay*10) / 10
+		// Il confronto della crescita ha senso solo finché l'animale deve ancora crescere
+		if comparison.ExpectedGainPerDay >= 1 {
+			ratio := math.Round(trend.SlopePerDay/comparison.ExpectedGainPerDay*100) / 100
+			comparison.GainRatio = &ratio
+			if ratio < ns.settings.GrowthMinGainRatio {
+				comparison.BehindCurve = true
+			}
+		}
+	}
+
+	return &comparison
+}
+
+// growthDropThreshold scala la soglia di calo sul peso atteso (un giovane da 300g non è un adulto da 900g)
+func (ns *NotificationService) growthDropThreshold(threshold float64, growth *GrowthComparison) float64 {
+	if growth == nil || ns.settings.GrowthDropPercent <= 0 {
+		return threshold
+	}
+	scaled := growth.ExpectedWeight * ns.settings.GrowthDropPercent / 100
+	if scaled > 0 && scaled < threshold {
+		return math.Round(scaled*10) / 10
+	}
+	return threshold
+}
+
+// @Summary Get growth curves
+// @Description Get the reference growth curve points, optionally filtered by sex
+// @Tags Growth Curves
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param sex query string false "Filter by sex (male, female, any)"
+// @Success 200 {array} GrowthCurvePoint
+// @Failure 401 {object} map[string]string
+// @Router /growth-curves [get]
+func getGrowthCurves(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Garantisce la presenza della curva di default
+		loadGrowthCurve(db, "any")
+
+		query := db.Order("sex ASC, age_days ASC")
+		if sex := c.Query("sex"); sex != "" {
+			query = query.Where("sex = ?", sex)
+		}
+
+		var points []GrowthCurvePoint
+		query.Find(&points)
+		c.JSON(http.StatusOK, points)
+	}
+}
+
+// @Summary Create growth curve point
+// @Description Add a reference point to a growth curve
+// @Tags Growth Curves
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param point body GrowthCurvePoint true "Growth curve point"
+// @Success 201 {object} GrowthCurvePoint
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /growth-curves [post]
+func createGrowthCurvePoint(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var point GrowthCurvePoint
+		if err := c.ShouldBindJSON(&point); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := validateGrowthCurvePoint(&point); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := db.Create(&point).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, point)
+	}
+}
+
+// @Summary Update growth curve point
+// @Description Update a reference point of a growth curve
+// @Tags Growth Curves
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Growth curve point ID"
+// @Param point body GrowthCurvePoint true "Growth curve point"
+// @Success 200 {object} GrowthCurvePoint
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /growth-curves/{id} [put]
+func updateGrowthCurvePoint(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var point GrowthCurvePoint
+		if err := db.First(&point, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Growth curve point not found"})
+			return
+		}
+
+		if err := c.ShouldBindJSON(&point); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := validateGrowthCurvePoint(&point); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := db.Save(&point).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, point)
+	}
+}
+
+// @Summary Delete growth curve point
+// @Description Delete a reference point of a growth curve
+// @Tags Growth Curves
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Growth curve point ID"
+// @Success 200 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /growth-curves/{id} [delete]
+func deleteGrowthCurvePoint(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := db.Delete(&GrowthCurvePoint{}, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Growth curve point deleted"})
+	}
+}
+
+func validateGrowthCurvePoint(point *GrowthCurvePoint) error {
+	if point.Sex == "" {
+		point.Sex = "any"
+	}
+	if point.Sex != "male" && point.Sex != "female" && point.Sex != "any" {
+		return errors.New("sex must be one of male, female, any")
+	}
+	if point.AgeClass == "" {
+		point.AgeClass = ageClassFor(point.AgeDays)
+	}
+	if !validAgeClass(point.AgeClass) {
+		return errors.New("age_class must be one of hoglet, juvenile, adult")
+	}
+	if point.AgeDays < 0 || point.ExpectedWeight <= 0 || point.StdDev <= 0 {
+		return errors.New("age_days must be >= 0, expected_weight and std_dev must be positive")
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestInterpolateGrowthCurve(t *testing.T) {
+	curve := []GrowthCurvePoint{
+		{AgeDays: 0, ExpectedWeight: 20, StdDev: 4},
+		{AgeDays: 14, ExpectedWeight: 80, StdDev: 15},
+		{AgeDays: 28, ExpectedWeight: 150, StdDev: 25},
+	}
+
+	tests := []struct {
+		name             string
+		age              float64
+		expected, stdDev float64
+	}{
+		{"first point", 0, 20, 4},
+		{"midway", 7, 50, 9.5},
+		{"on a point", 14, 80, 15},
+		{"quarter of the segment", 17.5, 97.5, 17.5},
+		{"flat after the last point", 400, 150, 25},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expected, stdDev, ok := interpolateGrowthCurve(curve, tt.age)
+			if !ok || math.Abs(expected-tt.expected) > 1e-9 || math.Abs(stdDev-tt.stdDev) > 1e-9 {
+				t.Errorf("age %.1f = %.2f ± %.2f (%v), want %.2f ± %.2f", tt.age, expected, stdDev, ok, tt.expected, tt.stdDev)
+			}
+		})
+	}
+
+	if _, _, ok := interpolateGrowthCurve(nil, 10); ok {
+		t.Error("empty curve should not interpolate")
+	}
+}
+
+func TestCompareWithGrowthCurve(t *testing.T) {
+	curve := defaultGrowthCurve()
+	birth := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	// A 90 giorni il peso atteso è 420g ± 60g
+	comparison, ok := compareWithGrowthCurve(curve, birth, 360, birth.AddDate(0, 0, 90))
+	if !ok {
+		t.Fatal("comparison not available")
+	}
+	if comparison.AgeClass != AgeClassJuvenile || comparison.ExpectedWeight != 420 || comparison.ZScore != -1 || comparison.Percentile != 15.9 {
+		t.Errorf("comparison = %+v, want juvenile at z -1 (15.9th percentile)", comparison)
+	}
+
+	if _, ok := compareWithGrowthCurve(curve, birth, 360, birth.AddDate(0, 0, -1)); ok {
+		t.Error("weight before birth compared with the curve")
+	}
+}
+
+func TestAgeClassFor(t *testing.T) {
+	for age, want := range map[int]string{0: AgeClassHoglet, 55: AgeClassHoglet, 56: AgeClassJuvenile, 364: AgeClassJuvenile, 365: AgeClassAdult} {
+		if got := ageClassFor(age); got != want {
+			t.Errorf("ageClassFor(%d) = %q, want %q", age, got, want)
+		}
+	}
+}
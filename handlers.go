@@ -15,13 +15,20 @@ import (
 // @Accept json
 // @Produce json
 // @Security BearerAuth
+// @Param lab_result query string false "Filter by result of the latest lab test" Enums(pending,negative,positive,inconclusive)
 // @Success 200 {array} Hedgehog
 // @Failure 401 {object} map[string]string
 // @Router /hedgehogs [get]
 func getHedgehogs(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var hedgehogs []Hedgehog
-		db.Preload("Area").Preload("Area.Room").Preload("Therapies").Preload("WeightRecords").Find(&hedgehogs)
+		query := db.Preload("Area").Preload("Area.Room").Preload("Therapies").Preload("WeightRecords").Preload("LabTests")
+
+		if labResult := c.Query("lab_result"); labResult != "" {
+			query = query.Where("id IN (?)", latestLabResultQuery(db, labResult))
+		}
+
+		query.Find(&hedgehogs)
 		c.JSON(http.StatusOK, hedgehogs)
 	}
 }
@@ -63,6 +70,7 @@ func createHedgehog(db *gorm.DB) gin.HandlerFunc {
 		}
 
 		db.Preload("Area").Preload("Area.Room").First(&hedgehog, hedgehog.ID)
+		publishDomainEvent(db, DomainEventHedgehogCreated, newHedgehogEvent(hedgehog))
 
 		log.Info().
 			Uint("id", hedgehog.ID).
@@ -91,7 +99,7 @@ func getHedgehog(db *gorm.DB) gin.HandlerFunc {
 		id := c.Param("id")
 		var hedgehog Hedgehog
 
-		if err := db.Preload("Area").Preload("Area.Room").Preload("Therapies").Preload("WeightRecords").First(&hedgehog, id).Error; err != nil {
+		if err := db.Preload("Area").Preload("Area.Room").Preload("Therapies").Preload("WeightRecords").Preload("LabTests").Preload("FeedingPlans").First(&hedgehog, id).Error; err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Hedgehog not found"})
 			return
 		}
@@ -123,6 +131,7 @@ func updateHedgehog(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		previousAreaID, previousStatus := hedgehog.AreaID, hedgehog.Status
 		if err := c.ShouldBindJSON(&hedgehog); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
@@ -133,6 +142,19 @@ func updateHedgehog(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		if !sameAreaID(previousAreaID, hedgehog.AreaID) {
+			recordAreaMove(db, hedgehog.ID, previousAreaID, hedgehog.AreaID, currentUserID(c))
+			event := newHedgehogEvent(hedgehog)
+			event.PreviousAreaID = previousAreaID
+			publishDomainEvent(db, DomainEventHedgehogMoved, event)
+		}
+		if previousStatus != hedgehog.Status {
+			event := newHedgehogEvent(hedgehog)
+			event.PreviousStatus = previousStatus
+			publishDomainEvent(db, DomainEventHedgehogStatusChanged, event)
+		}
+		enqueueAutoResolve(db, hedgehog.ID)
+
 		db.Preload("Area").Preload("Area.Room").First(&hedgehog, hedgehog.ID)
 		c.JSON(http.StatusOK, hedgehog)
 	}
@@ -165,6 +187,8 @@ func deleteHedgehog(db *gorm.DB) gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		publishDomainEvent(db, DomainEventHedgehogDeleted, newHedgehogEvent(hedgehog))
+		enqueueAutoResolve(db, hedgehog.ID)
 
 		c.JSON(http.StatusOK, gin.H{"message": "Hedgehog deleted"})
 	}
@@ -468,6 +492,7 @@ func createTherapy(db *gorm.DB) gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		publishDomainEvent(db, DomainEventTherapyStarted, newTherapyEvent(therapy))
 
 		c.JSON(http.StatusCreated, therapy)
 	}
@@ -497,6 +522,7 @@ func updateTherapy(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		previousStatus := therapy.Status
 		if err := c.ShouldBindJSON(&therapy); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
@@ -506,6 +532,10 @@ func updateTherapy(db *gorm.DB) gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		if therapy.Status == "completed" && previousStatus != "completed" {
+			publishDomainEvent(db, DomainEventTherapyCompleted, newTherapyEvent(therapy))
+		}
+		enqueueAutoResolve(db, therapy.HedgehogID)
 
 		c.JSON(http.StatusOK, therapy)
 	}
@@ -519,16 +549,23 @@ func updateTherapy(db *gorm.DB) gin.HandlerFunc {
 // @Security BearerAuth
 // @Param id path int true "Therapy ID"
 // @Success 200 {object} map[string]string
+// @Failure 404 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Failure 401 {object} map[string]string
 // @Router /therapies/{id} [delete]
 func deleteTherapy(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
-		if err := db.Delete(&Therapy{}, id).Error; err != nil {
+		var therapy Therapy
+		if err := db.First(&therapy, id).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Therapy not found"})
+			return
+		}
+		if err := db.Delete(&therapy).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		enqueueAutoResolve(db, therapy.HedgehogID)
 		c.JSON(http.StatusOK, gin.H{"message": "Therapy deleted"})
 	}
 }
@@ -540,6 +577,7 @@ func deleteTherapy(db *gorm.DB) gin.HandlerFunc {
 // @Produce json
 // @Security BearerAuth
 // @Param hedgehog_id query int false "Filter by hedgehog ID"
+// @Param flagged query boolean false "Filter by flagged status"
 // @Param limit query int false "Limit results" default(100)
 // @Success 200 {array} WeightRecord
 // @Failure 401 {object} map[string]string
@@ -553,6 +591,9 @@ func getWeightRecords(db *gorm.DB) gin.HandlerFunc {
 		if hedgehogID != "" {
 			query = query.Where("hedgehog_id = ?", hedgehogID)
 		}
+		if flagged := c.Query("flagged"); flagged != "" {
+			query = query.Where("flagged = ?", flagged == "true")
+		}
 
 		// Limit per performance
 		limit := 100
@@ -574,9 +615,12 @@ func getWeightRecords(db *gorm.DB) gin.HandlerFunc {
 // @Accept json
 // @Produce json
 // @Security BearerAuth
+// @Description Implausible jumps against recent history are rejected with 409 unless confirm=true is passed
 // @Param weight_record body WeightRecord true "Weight record data"
+// @Param confirm query boolean false "Confirm a weight flagged as implausible"
 // @Success 201 {object} WeightRecord
 // @Failure 400 {object} map[string]string
+// @Failure 409 {object} map[string]interface{}
 // @Failure 401 {object} map[string]string
 // @Router /weight-records [post]
 func createWeightRecord(db *gorm.DB) gin.HandlerFunc {
@@ -596,10 +640,25 @@ func createWeightRecord(db *gorm.DB) gin.HandlerFunc {
 			record.Date = time.Now()
 		}
 
+		// Controllo plausibilità rispetto alle pesate recenti
+		check := NewNotificationService(db).detectWeightOutlier(record)
+		if check.Flagged && !confirmRequested(c) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":                 "Weight looks implausible, confirm to save it",
+				"requires_confirmation": true,
+				"outlier":               check,
+			})
+			return
+		}
+		applyOutlierCheck(&record, check)
+		annotateWeightRecord(db, &record)
+
 		if err := db.Create(&record).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		publishDomainEvent(db, DomainEventWeightRecorded, newWeightEvent(record))
+		enqueueAutoResolve(db, record.HedgehogID)
 
 		c.JSON(http.StatusCreated, record)
 	}
@@ -629,15 +688,30 @@ func updateWeightRecord(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
+		previousWeight := record.Weight
 		if err := c.ShouldBindJSON(&record); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
+		// Una correzione del peso viene ricontrollata
+		check := NewNotificationService(db).detectWeightOutlier(record)
+		if check.Flagged && record.Weight != previousWeight && !confirmRequested(c) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":                 "Weight looks implausible, confirm to save it",
+				"requires_confirmation": true,
+				"outlier":               check,
+			})
+			return
+		}
+		applyOutlierCheck(&record, check)
+		annotateWeightRecord(db, &record)
+
 		if err := db.Save(&record).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		enqueueAutoResolve(db, record.HedgehogID)
 
 		c.JSON(http.StatusOK, record)
 	}
@@ -651,16 +725,23 @@ func updateWeightRecord(db *gorm.DB) gin.HandlerFunc {
 // @Security BearerAuth
 // @Param id path int true "Weight Record ID"
 // @Success 200 {object} map[string]string
+// @Failure 404 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Failure 401 {object} map[string]string
 // @Router /weight-records/{id} [delete]
 func deleteWeightRecord(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
-		if err := db.Delete(&WeightRecord{}, id).Error; err != nil {
+		var record WeightRecord
+		if err := db.First(&record, id).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Weight record not found"})
+			return
+		}
+		if err := db.Delete(&record).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		enqueueAutoResolve(db, record.HedgehogID)
 		c.JSON(http.StatusOK, gin.H{"message": "Weight record deleted"})
 	}
 }
@@ -0,0 +1,386 @@
+// hibernation.go - Svernamento e letargo dei ricci
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/laninna/hedgehog-app/logger"
+	"gorm.io/gorm"
+)
+
+// Stato del letargo di un riccio
+type HibernationStatus struct {
+	HedgehogID     uint       `json:"hedgehog_id"`
+	HedgehogName   string     `json:"hedgehog_name"`
+	Hibernating    bool       `json:"hibernating"`
+	Start          *time.Time `json:"start,omitempty"`
+	End            *time.Time `json:"end,omitempty"`
+	DaysHibernated int        `json:"days_hibernated"`
+	StartWeight    float64    `json:"start_weight"`
+	CurrentWeight  float64    `json:"current_weight"`
+	LossPercent    float64    `json:"loss_percent"`
+	RecentWakes    int        `json:"recent_wakes"`
+	TotalWakes     int        `json:"total_wakes"`
+}
+
+// isHibernating indica se il riccio è attualmente in letargo
+func isHibernating(h Hedgehog) bool {
+	if h.HibernationStart == nil || h.HibernationStart.After(time.Now()) {
+		return false
+	}
+	return h.HibernationEnd == nil || h.HibernationEnd.Before(*h.HibernationStart)
+}
+
+func (ns *NotificationService) noWeighingDays(hibernating bool) int {
+	if hibernating && ns.settings.HibernationNoWeighingDays > 0 {
+		return ns.settings.HibernationNoWeighingDays
+	}
+	return ns.settings.NoWeighingDays
+}
+
+func (ns *NotificationService) weightDropThreshold(hibernating bool) float64 {
+	if hibernating && ns.settings.HibernationDropThreshold > 0 {
+		return ns.settings.HibernationDropThreshold
+	}
+	return ns.settings.WeightDropThreshold
+}
+
+// hibernationStatus calcola peso perso e risvegli dall'inizio del letargo
+func hibernationStatus(db *gorm.DB, h Hedgehog, wakeWindowDays int) HibernationStatus {
+	status := HibernationStatus{
+		HedgehogID:   h.ID,
+		HedgehogName: h.Name,
+		Hibernating:  isHibernating(h),
+		Start:        h.HibernationStart,
+		End:          h.HibernationEnd,
+	}
+
+	if h.HibernationStart == nil {
+		return status
+	}
+
+	start := *h.HibernationStart
+	end := time.Now()
+	if !status.Hibernating && h.HibernationEnd != nil {
+		end = *h.HibernationEnd
+	}
+	status.DaysHibernated = int(end.Sub(start).Hours() / 24)
+
+	// Peso di riferimento: ultima pesata prima dell'inizio del letargo
+	var startRecord WeightRecord
+	if err := db.Where("hedgehog_id = ? AND excluded = ? AND date <= ?", h.ID, false, start).
+		Order("date DESC").First(&startRecord).Error; err != nil {
+		db.Where("hedgehog_id = ? AND excluded = ? AND date >= ?", h.ID, false, start).
+			Order("date ASC").First(&startRecord)
+	}
+
+	var lastRecord WeightRecord
+	db.Where("hedgehog_id = ? AND excluded = ? AND date <= ?", h.ID, false, end).
+		Order("date DESC").First(&lastRecord)
+
+	status.StartWeight = startRecord.Weight
+	status.CurrentWeight = lastRecord.Weight
+	if startRecord.Weight > 0 && lastRecord.Weight > 0 {
+		status.LossPercent = (startRecord.Weight - lastRecord.Weight) / startRecord.Weight * 100
+	}
+
+	var total, recent int64
+	db.Model(&HibernationWake{}).
+		Where("hedgehog_id = ? AND date >= ? AND date <= ?", h.ID, start, end).
+		Count(&total)
+	db.Model(&HibernationWake{}).
+		Where("hedgehog_id = ? AND date >= ? AND date <= ?", h.ID, end.AddDate(0, 0, -wakeWindowDays), end).
+		Count(&recent)
+	status.TotalWakes = int(total)
+	status.RecentWakes = int(recent)
+
+	return status
+}
+
+func (ns *NotificationService) wakeWindowDays() int {
+	if ns.settings.HibernationWakeWindowDays <= 0 {
+		return 7
+	}
+	return ns.settings.HibernationWakeWindowDays
+}
+
+// hibernatingStatuses restituisce lo stato di tutti i ricci attualmente in letargo
+func (ns *NotificationService) hibernatingStatuses() []HibernationStatus {
+	var hedgehogs []Hedgehog
+	ns.scoped(ns.db.Where("status = 'in_care' AND hibernation_start IS NOT NULL"), "id").Find(&hedgehogs)
+
+	var statuses []HibernationStatus
+	for _, hedgehog := range hedgehogs {
+		if isHibernating(hedgehog) {
+			statuses = append(statuses, hibernationStatus(ns.db, hedgehog, ns.wakeWindowDays()))
+		}
+	}
+	return statuses
+}
+
+// Perdita di peso eccessiva durante il letargo
+func (ns *NotificationService) hibernationLossCandidates() ([]RuleCandidate, error) {
+	if ns.settings.HibernationMaxLossPercent <= 0 {
+		return nil, nil
+	}
+
+	var candidates []RuleCandidate
+	for _, status := range ns.hibernatingStatuses() {
+		if status.LossPercent < ns.settings.HibernationMaxLossPercent {
+			continue
+		}
+
+		hedgehogID := status.HedgehogID
+		data, _ := json.Marshal(status)
+		candidates = append(candidates, RuleCandidate{RepeatAfter: 48 * time.Hour, Notification: Notification{
+			Type:     NotificationHibernationLoss,
+			Priority: PriorityCritical,
+			TemplateData: templateVars(map[string]interface{}{
+				"Hedgehog": status.HedgehogName, "LossPercent": status.LossPercent,
+				"StartWeight": status.StartWeight, "CurrentWeight": status.CurrentWeight,
+			}),
+			HedgehogID:  &hedgehogID,
+			ActionURL:   fmt.Sprintf("/hedgehogs/%d", hedgehogID),
+			ActionLabel: "Valuta Risveglio",
+			Data:        string(data),
+		}})
+	}
+
+	return candidates, nil
+}
+
+// Risvegli troppo frequenti durante il letargo
+func (ns *NotificationService) hibernationWakingCandidates() ([]RuleCandidate, error) {
+	if ns.settings.HibernationMaxWakes <= 0 {
+		return nil, nil
+	}
+
+	var candidates []RuleCandidate
+	for _, status := range ns.hibernatingStatuses() {
+		if status.RecentWakes < ns.settings.HibernationMaxWakes {
+			continue
+		}
+
+		hedgehogID := status.HedgehogID
+		data, _ := json.Marshal(status)
+		candidates = append(candidates, RuleCandidate{RepeatAfter: 48 * time.Hour, Notification: Notification{
+			Type:     NotificationHibernationWaking,
+			Priority: PriorityHigh,
+			TemplateData: templateVars(map[string]interface{}{
+				"Hedgehog": status.HedgehogName, "Wakes": status.RecentWakes, "Days": ns.wakeWindowDays(),
+			}),
+			HedgehogID:  &hedgehogID,
+			ActionURL:   fmt.Sprintf("/hedgehogs/%d", hedgehogID),
+			ActionLabel: "Controlla Riccio",
+			Data:        string(data),
+		}})
+	}
+
+	return candidates, nil
+}
+
+// @Summary Get hibernation status
+// @Description Get the hibernation state, weight loss and wakings of a hedgehog
+// @Tags Hibernation
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Hedgehog ID"
+// @Success 200 {object} HibernationStatus
+// @Failure 404 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /hedgehogs/{id}/hibernation [get]
+func getHibernationStatusHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var hedgehog Hedgehog
+		if err := db.First(&hedgehog, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Hedgehog not found"})
+			return
+		}
+
+		ns := NewNotificationService(db)
+		c.JSON(http.StatusOK, hibernationStatus(db, hedgehog, ns.wakeWindowDays()))
+	}
+}
+
+// @Summary Start hibernation
+// @Description Mark a hedgehog as hibernating from the given date (defaults to now)
+// @Tags Hibernation
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Hedgehog ID"
+// @Param hibernation body object false "Start date, e.g. {\"date\": \"2024-11-20T00:00:00Z\"}"
+// @Success 200 {object} Hedgehog
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /hedgehogs/{id}/hibernation/start [post]
+func startHibernationHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		log := logger.GetLoggerFromContext(c)
+
+		var request struct {
+			Date *time.Time `json:"date"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil && c.Request.ContentLength > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var hedgehog Hedgehog
+		if err := db.First(&hedgehog, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Hedgehog not found"})
+			return
+		}
+
+		if isHibernating(hedgehog) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Hedgehog is already hibernating"})
+			return
+		}
+
+		start := time.Now()
+		if request.Date != nil {
+			start = *request.Date
+		}
+
+		hedgehog.HibernationStart = &start
+		hedgehog.HibernationEnd = nil
+		if err := db.Save(&hedgehog).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		enqueueAutoResolve(db, hedgehog.ID)
+
+		log.Info().Uint("hedgehog_id", hedgehog.ID).Time("start", start).Msg("Hibernation started")
+		c.JSON(http.StatusOK, hedgehog)
+	}
+}
+
+// @Summary End hibernation
+// @Description Mark the end of a hedgehog's hibernation at the given date (defaults to now)
+// @Tags Hibernation
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Hedgehog ID"
+// @Param hibernation body object false "End date, e.g. {\"date\": \"2025-03-10T00:00:00Z\"}"
+// @Success 200 {object} Hedgehog
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /hedgehogs/{id}/hibernation/end [post]
+func endHibernationHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		log := logger.GetLoggerFromContext(c)
+
+		var request struct {
+			Date *time.Time `json:"date"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil && c.Request.ContentLength > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var hedgehog Hedgehog
+		if err := db.First(&hedgehog, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Hedgehog not found"})
+			return
+		}
+
+		if !isHibernating(hedgehog) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Hedgehog is not hibernating"})
+			return
+		}
+
+		end := time.Now()
+		if request.Date != nil {
+			end = *request.Date
+		}
+		if end.Before(*hedgehog.HibernationStart) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "End date is before the start of hibernation"})
+			return
+		}
+
+		hedgehog.HibernationEnd = &end
+		if err := db.Save(&hedgehog).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		enqueueAutoResolve(db, hedgehog.ID)
+
+		log.Info().Uint("hedgehog_id", hedgehog.ID).Time("end", end).Msg("Hibernation ended")
+		c.JSON(http.StatusOK, hedgehog)
+	}
+}
+
+// @Summary Get hibernation wakings
+// @Description Get the recorded wakings of a hedgehog
+// @Tags Hibernation
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Hedgehog ID"
+// @Success 200 {array} HibernationWake
+// @Failure 401 {object} map[string]string
+// @Router /hedgehogs/{id}/hibernation/wakes [get]
+func getHibernationWakesHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var wakes []HibernationWake
+		db.Where("hedgehog_id = ?", c.Param("id")).Order("date DESC").Find(&wakes)
+		c.JSON(http.StatusOK, wakes)
+	}
+}
+
+// @Summary Record hibernation waking
+// @Description Record that a hibernating hedgehog woke up
+// @Tags Hibernation
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Hedgehog ID"
+// @Param wake body HibernationWake true "Waking data"
+// @Success 201 {object} HibernationWake
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /hedgehogs/{id}/hibernation/wakes [post]
+func createHibernationWakeHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var wake HibernationWake
+		if err := c.ShouldBindJSON(&wake); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var hedgehog Hedgehog
+		if err := db.First(&hedgehog, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Hedgehog not found"})
+			return
+		}
+
+		if !isHibernating(hedgehog) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Hedgehog is not hibernating"})
+			return
+		}
+
+		wake.HedgehogID = hedgehog.ID
+		if wake.Date.IsZero() {
+			wake.Date = time.Now()
+		}
+
+		if err := db.Create(&wake).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, wake)
+	}
+}
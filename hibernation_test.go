@@ -0,0 +1,78 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestIsHibernating(t *testing.T) {
+	now := time.Now()
+	past := now.AddDate(0, 0, -20)
+	earlier := now.AddDate(0, 0, -40)
+	ended := now.AddDate(0, 0, -5)
+	future := now.AddDate(0, 0, 3)
+
+	tests := []struct {
+		name       string
+		start, end *time.Time
+		want       bool
+	}{
+		{"never", nil, nil, false},
+		{"started", &past, nil, true},
+		{"starts in the future", &future, nil, false},
+		{"ended", &past, &ended, false},
+		{"restarted after a previous end", &past, &earlier, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isHibernating(Hedgehog{HibernationStart: tt.start, HibernationEnd: tt.end}); got != tt.want {
+				t.Errorf("isHibernating = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHibernationStatusLossAndWakes(t *testing.T) {
+	db := newTestDB(t)
+	start := time.Now().AddDate(0, 0, -30)
+	hedgehog := Hedgehog{Name: "Dormiglione", Status: "in_care", HibernationStart: &start}
+	db.Create(&hedgehog)
+
+	// Riferimento: l'ultima pesata valida prima del letargo
+	db.Create(&WeightRecord{HedgehogID: hedgehog.ID, Weight: 650, Date: start.AddDate(0, 0, -10)})
+	db.Create(&WeightRecord{HedgehogID: hedgehog.ID, Weight: 700, Date: start.AddDate(0, 0, -2)})
+	db.Create(&WeightRecord{HedgehogID: hedgehog.ID, Weight: 800, Date: start.AddDate(0, 0, -1), Excluded: true})
+	db.Create(&WeightRecord{HedgehogID: hedgehog.ID, Weight: 595, Date: time.Now().AddDate(0, 0, -1)})
+
+	db.Create(&HibernationWake{HedgehogID: hedgehog.ID, Date: start.AddDate(0, 0, 5)})
+	db.Create(&HibernationWake{HedgehogID: hedgehog.ID, Date: time.Now().AddDate(0, 0, -2)})
+
+	status := hibernationStatus(db, hedgehog, 7)
+	if !status.Hibernating || status.DaysHibernated != 30 {
+		t.Errorf("status = %+v, want hibernating for 30 days", status)
+	}
+	if status.StartWeight != 700 || status.CurrentWeight != 595 || math.Abs(status.LossPercent-15) > 0.01 {
+		t.Errorf("weights = %.0f -> %.0f (%.2f%%), want 700 -> 595 (15%%)", status.StartWeight, status.CurrentWeight, status.LossPercent)
+	}
+	if status.TotalWakes != 2 || status.RecentWakes != 1 {
+		t.Errorf("wakes = %d total, %d recent, want 2 and 1", status.TotalWakes, status.RecentWakes)
+	}
+}
+
+func TestHibernationStatusWithoutPriorWeight(t *testing.T) {
+	db := newTestDB(t)
+	start := time.Now().AddDate(0, 0, -10)
+	hedgehog := Hedgehog{Name: "Nuovo", HibernationStart: &start}
+	db.Create(&hedgehog)
+
+	// Senza pesate precedenti si usa la prima pesata durante il letargo
+	db.Create(&WeightRecord{HedgehogID: hedgehog.ID, Weight: 600, Date: start.AddDate(0, 0, 1)})
+	db.Create(&WeightRecord{HedgehogID: hedgehog.ID, Weight: 570, Date: time.Now().AddDate(0, 0, -1)})
+
+	status := hibernationStatus(db, hedgehog, 7)
+	if status.StartWeight != 600 || math.Abs(status.LossPercent-5) > 0.01 {
+		t.Errorf("status = %+v, want start weight 600 and 5%% loss", status)
+	}
+}
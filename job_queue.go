@@ -0,0 +1,577 @@
+// job_queue.go - Coda lavori persistente su database
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/laninna/hedgehog-app/logger"
+	"gorm.io/gorm"
+)
+
+// Stati di un lavoro
+const (
+	JobStatusPending = "pending"
+	JobStatusRunning = "running"
+	JobStatusDone    = "done"
+	JobStatusFailed  = "failed"
+)
+
+// Tipi di lavoro predefiniti
+const (
+	JobKindNotificationCheck   = "notifications.check"
+	JobKindNotificationEmail   = "notifications.email"
+	JobKindNotificationWebhook = "notifications.webhook"
+)
+
+const (
+	jobRetryBase     = 30 * time.Second
+	jobRetryMax      = time.Hour
+	jobStaleAfter    = 10 * time.Minute
+	jobLeaseRenewal  = jobStaleAfter / 4 // Il worker rinnova il lock ben prima che maintain lo consideri scaduto
+	jobRetentionDays = 7
+)
+
+// JobHandler esegue un lavoro; un errore provoca un nuovo tentativo
+type JobHandler func(db *gorm.DB, payload []byte) error
+
+var jobHandlers = map[string]JobHandler{}
+
+// registerJobHandler associa un tipo di lavoro al suo handler
+func registerJobHandler(kind string, handler JobHandler) {
+	jobHandlers[kind] = handler
+}
+
+// NotificationCheckPayload indica le regole da valutare (tutte se vuoto)
+type NotificationCheckPayload struct {
+	Rules []string `json:"rules,omitempty"`
+}
+
+// ExternalNotificationPayload indica la notifica da inviare e il destinatario
+type ExternalNotificationPayload struct {
+	NotificationID uint   `json:"notification_id"`
+	Recipient      string `json:"recipient"`
+}
+
+// JobQueueStats riassume lo stato della coda
+type JobQueueStats struct {
+	ByStatus map[string]int64 `json:"by_status"`
+	Dead     int64            `json:"dead"`
+}
+
+func init() {
+	registerJobHandler(JobKindNotificationCheck, runNotificationCheckJob)
+	registerJobHandler(JobKindNotificationEmail, runNotificationEmailJob)
+	registerJobHandler(JobKindNotificationWebhook, runNotificationWebhookJob)
+}
+
+// enqueueJob accoda un lavoro da eseguire appena possibile
+func enqueueJob(db *gorm.DB, kind string, payload interface{}) (*Job, error) {
+	return enqueueJobAt(db, kind, payload, time.Now())
+}
+
+// enqueueJobAt accoda un lavoro da eseguire non prima di runAt
+func enqueueJobAt(db *gorm.DB, kind string, payload interface{}, runAt time.Time) (*Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	job := Job{
+		Kind:        kind,
+		Payload:     string(data),
+		Status:      JobStatusPending,
+		MaxAttempts: 5,
+		RunAt:       runAt,
+	}
+	if err := db.Create(&job).Error; err != nil {
+		logger.Error("Errore accodamento lavoro", err,
+			logger.Str("component", "jobs"),
+			logger.Str("kind", kind))
+		return nil, err
+	}
+	return &job, nil
+}
+
+// jobBackoff restituisce l'attesa prima del tentativo successivo (esponenziale)
+func jobBackoff(attempts int) time.Duration {
+	delay := jobRetryBase
+	for i := 1; i < attempts && delay < jobRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > jobRetryMax {
+		delay = jobRetryMax
+	}
+	return delay
+}
+
+// JobQueue esegue i lavori accodati con un pool di worker
+type JobQueue struct {
+	db           *gorm.DB
+	workerID     string
+	workers      int
+	pollInterval time.Duration
+}
+
+func NewJobQueue(db *gorm.DB) *JobQueue {
+	workers := 4
+	if n, err := strconv.Atoi(os.Getenv("JOB_WORKERS")); err == nil && n > 0 {
+		workers = n
+	}
+
+	pollInterval := 2 * time.Second
+	if seconds, err := strconv.Atoi(os.Getenv("JOB_POLL_SECONDS")); err == nil && seconds > 0 {
+		pollInterval = time.Duration(seconds) * time.Second
+	}
+
+	hostname, _ := os.Hostname()
+	return &JobQueue{
+		db:           db,
+		workerID:     fmt.Sprintf("%s:%d", hostname, os.Getpid()),
+		workers:      workers,
+		pollInterval: pollInterval,
+	}
+}
+
+// claim prenota il prossimo lavoro pronto. Il secondo valore è false se non ce ne sono.
+func (q *JobQueue) claim(now time.Time) (*Job, bool) {
+	var job Job
+	if err := q.db.Where("status = ? AND run_at <= ?", JobStatusPending, now).
+		Order("run_at ASC, id ASC").
+		First(&job).Error; err != nil {
+		return nil, false
+	}
+
+	// Aggiornamento condizionato: un solo worker (anche su altre istanze) vince
+	result := q.db.Model(&Job{}).
+		Where("id = ? AND status = ?", job.ID, JobStatusPending).
+		Updates(map[string]interface{}{
+			"status":    JobStatusRunning,
+			"attempts":  gorm.Expr("attempts + 1"),
+			"locked_at": now,
+			"locked_by": q.workerID,
+		})
+	if result.Error != nil || result.RowsAffected != 1 {
+		return nil, true
+	}
+
+	job.Status = JobStatusRunning
+	job.Attempts++
+	return &job, true
+}
+
+// ProcessNext esegue un lavoro, se disponibile. Restituisce false se la coda è vuota.
+func (q *JobQueue) ProcessNext() bool {
+	job, found := q.claim(time.Now())
+	if job == nil {
+		return found
+	}
+
+	release := q.holdLease(job)
+	err := q.execute(job)
+	release()
+
+	if err != nil {
+		q.fail(job, err)
+	} else {
+		q.complete(job)
+	}
+	return true
+}
+
+// owned seleziona il lavoro solo se è ancora prenotato da questo worker per questo tentativo:
+// se maintain l'ha rimesso in coda e un altro worker l'ha preso, l'esito non va più scritto
+func (q *JobQueue) owned(db *gorm.DB, job *Job) *gorm.DB {
+	return db.Model(&Job{}).Where("id = ? AND status = ? AND locked_by = ? AND attempts = ?",
+		job.ID, JobStatusRunning, q.workerID, job.Attempts)
+}
+
+// renewLease aggiorna il lock del lavoro; restituisce false se il lavoro non è più di questo worker
+func (q *JobQueue) renewLease(job *Job, now time.Time) bool {
+	result := q.owned(q.db, job).Update("locked_at", now)
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+// holdLease rinnova il lock finché il lavoro è in esecuzione, così maintain non lo rimette in coda
+func (q *JobQueue) holdLease(job *Job) (release func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(jobLeaseRenewal)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if !q.renewLease(job, now) {
+					logger.Warn("Lock del lavoro perso durante l'esecuzione",
+						logger.Str("component", "jobs"),
+						logger.Uint("job_id", job.ID),
+						logger.Str("kind", job.Kind))
+					return
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// leaseLost registra un esito scartato perché il lavoro è passato a un altro worker
+func (q *JobQueue) leaseLost(job *Job) {
+	logger.Warn("Esito del lavoro scartato, lock passato a un altro worker",
+		logger.Str("component", "jobs"),
+		logger.Uint("job_id", job.ID),
+		logger.Str("kind", job.Kind),
+		logger.Int("attempt", job.Attempts))
+}
+
+func (q *JobQueue) execute(job *Job) (err error) {
+	handler, ok := jobHandlers[job.Kind]
+	if !ok {
+		return fmt.Errorf("nessun handler per il lavoro %q", job.Kind)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return handler(q.db, []byte(job.Payload))
+}
+
+func (q *JobQueue) complete(job *Job) {
+	now := time.Now()
+	result := q.owned(q.db, job).Updates(map[string]interface{}{
+		"status":       JobStatusDone,
+		"completed_at": now,
+		"last_error":   "",
+	})
+	if result.Error == nil && result.RowsAffected == 0 {
+		q.leaseLost(job)
+	}
+}
+
+// fail riprogramma il lavoro con backoff o lo sposta tra i lavori falliti
+func (q *JobQueue) fail(job *Job, jobErr error) {
+	log := []logger.Field{
+		logger.Str("component", "jobs"),
+		logger.Uint("job_id", job.ID),
+		logger.Str("kind", job.Kind),
+		logger.Int("attempt", job.Attempts),
+	}
+
+	if job.Attempts < job.MaxAttempts {
+		result := q.owned(q.db, job).Updates(map[string]interface{}{
+			"status":     JobStatusPending,
+			"run_at":     time.Now().Add(jobBackoff(job.Attempts)),
+			"locked_at":  nil,
+			"locked_by":  "",
+			"last_error": jobErr.Error(),
+		})
+		if result.Error == nil && result.RowsAffected == 0 {
+			q.leaseLost(job)
+			return
+		}
+		logger.Warn("Lavoro fallito, nuovo tentativo programmato", append(log, logger.Err(jobErr))...)
+		return
+	}
+
+	lost := false
+	err := q.db.Transaction(func(tx *gorm.DB) error {
+		result := q.owned(tx, job).Updates(map[string]interface{}{
+			"status":     JobStatusFailed,
+			"last_error": jobErr.Error(),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			lost = true
+			return nil
+		}
+		return tx.Create(&DeadJob{
+			JobID:     job.ID,
+			Kind:      job.Kind,
+			Payload:   job.Payload,
+			Attempts:  job.Attempts,
+			LastError: jobErr.Error(),
+		}).Error
+	})
+	if err != nil {
+		logger.Error("Errore spostamento lavoro tra i falliti", err, log...)
+		return
+	}
+	if lost {
+		q.leaseLost(job)
+		return
+	}
+	logger.Error("Lavoro spostato tra i falliti", jobErr, log...)
+}
+
+// maintain rimette in coda i lavori il cui worker non rinnova più il lock (terminato o bloccato)
+// e pulisce quelli completati
+func (q *JobQueue) maintain(now time.Time) {
+	q.db.Model(&Job{}).
+		Where("status = ? AND locked_at < ?", JobStatusRunning, now.Add(-jobStaleAfter)).
+		Updates(map[string]interface{}{"status": JobStatusPending, "locked_at": nil, "locked_by": ""})
+
+	q.db.Where("status = ? AND completed_at < ?", JobStatusDone, now.AddDate(0, 0, -jobRetentionDays)).
+		Delete(&Job{})
+}
+
+// Start avvia il pool di worker fino alla cancellazione del contesto.
+// La manutenzione della coda è un'attività dello scheduler.
+func (q *JobQueue) Start(ctx context.Context) {
+	for i := 0; i < q.workers; i++ {
+		go func() {
+			for {
+				if ctx.Err() != nil {
+					return
+				}
+				if q.ProcessNext() {
+					continue
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(q.pollInterval):
+				}
+			}
+		}()
+	}
+
+	logger.Info("⚙️ Job workers started",
+		logger.Str("component", "jobs"),
+		logger.Int("workers", q.workers))
+}
+
+// StartJobWorkers avvia i worker della coda lavori, che si fermano alla cancellazione di ctx
+func StartJobWorkers(ctx context.Context, db *gorm.DB) {
+	NewJobQueue(db).Start(ctx)
+}
+
+func runNotificationCheckJob(db *gorm.DB, payload []byte) error {
+	var request NotificationCheckPayload
+	if err := json.Unmarshal(payload, &request); err != nil {
+		return err
+	}
+
+	ns := NewNotificationService(db)
+	if len(request.Rules) == 0 {
+		return ns.CheckAllNotifications()
+	}
+
+	for _, evaluation := range ns.RunRules(false, request.Rules...) {
+		if evaluation.Error != "" {
+			return fmt.Errorf("%s: %s", evaluation.Rule, evaluation.Error)
+		}
+	}
+	return nil
+}
+
+// loadJobNotification legge la notifica indicata nel payload con i dati collegati
+func loadJobNotification(db *gorm.DB, payload []byte) (Notification, ExternalNotificationPayload, error) {
+	var request ExternalNotificationPayload
+	if err := json.Unmarshal(payload, &request); err != nil {
+		return Notification{}, request, err
+	}
+
+	var notification Notification
+	err := db.Preload("Hedgehog").Preload("Therapy").First(&notification, request.NotificationID).Error
+	return notification, request, err
+}
+
+func runNotificationEmailJob(db *gorm.DB, payload []byte) error {
+	notification, request, err := loadJobNotification(db, payload)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Notifica eliminata nel frattempo
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !sendStillNeeded(notification, QuietChannelEmail, time.Now()) {
+		return nil
+	}
+
+	emailService := NewEmailService(db)
+	if emailService == nil {
+		return errors.New("servizio email non disponibile")
+	}
+	return emailService.SendNotificationEmail(notification, request.Recipient)
+}
+
+func runNotificationWebhookJob(db *gorm.DB, payload []byte) error {
+	notification, request, err := loadJobNotification(db, payload)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Notifica eliminata nel frattempo
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !sendStillNeeded(notification, QuietChannelWebhook, time.Now()) {
+		return nil
+	}
+
+	// I nuovi tentativi sono gestiti dalla coda
+	webhookService := NewWebhookService()
+	webhookService.retries = 1
+	return webhookService.SendWebhook(notification, request.Recipient)
+}
+
+// @Summary List queued jobs
+// @Description List background jobs, most recent first
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param status query string false "Filter by status (pending, running, done, failed)"
+// @Param kind query string false "Filter by job kind"
+// @Param limit query int false "Limit results" default(100)
+// @Success 200 {array} Job
+// @Failure 401 {object} map[string]string
+// @Router /admin/queue [get]
+func getQueuedJobsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := db.Model(&Job{})
+		if status := c.Query("status"); status != "" {
+			query = query.Where("status = ?", status)
+		}
+		if kind := c.Query("kind"); kind != "" {
+			query = query.Where("kind = ?", kind)
+		}
+
+		limit := 100
+		if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 {
+			limit = l
+		}
+
+		var jobs []Job
+		query.Order("id DESC").Limit(limit).Find(&jobs)
+		c.JSON(http.StatusOK, jobs)
+	}
+}
+
+// @Summary Get job queue statistics
+// @Description Count jobs by status and dead-lettered jobs
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} JobQueueStats
+// @Failure 401 {object} map[string]string
+// @Router /admin/queue/stats [get]
+func getJobQueueStatsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var counts []struct {
+			Status string
+			Count  int64
+		}
+		db.Model(&Job{}).Select("status, count(*) as count").Group("status").Find(&counts)
+
+		stats := JobQueueStats{ByStatus: make(map[string]int64)}
+		for _, count := range counts {
+			stats.ByStatus[count.Status] = count.Count
+		}
+		db.Model(&DeadJob{}).Count(&stats.Dead)
+
+		c.JSON(http.StatusOK, stats)
+	}
+}
+
+// @Summary List dead-lettered jobs
+// @Description List jobs that exhausted their attempts
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} DeadJob
+// @Failure 401 {object} map[string]string
+// @Router /admin/queue/dead [get]
+func getDeadJobsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var jobs []DeadJob
+		db.Order("id DESC").Find(&jobs)
+		c.JSON(http.StatusOK, jobs)
+	}
+}
+
+// @Summary Retry dead-lettered job
+// @Description Enqueue a dead-lettered job again and remove it from the dead-letter table
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Dead job ID"
+// @Success 200 {object} Job
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /admin/queue/dead/{id}/retry [post]
+func retryDeadJobHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var dead DeadJob
+		if err := db.First(&dead, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Dead job not found"})
+			return
+		}
+
+		job := Job{
+			Kind:        dead.Kind,
+			Payload:     dead.Payload,
+			Status:      JobStatusPending,
+			MaxAttempts: 5,
+			RunAt:       time.Now(),
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&job).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&dead).Error
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, job)
+	}
+}
+
+// @Summary Delete dead-lettered job
+// @Description Discard a dead-lettered job
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Dead job ID"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /admin/queue/dead/{id} [delete]
+func deleteDeadJobHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var dead DeadJob
+		if err := db.First(&dead, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Dead job not found"})
+			return
+		}
+
+		if err := db.Delete(&dead).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Dead job deleted"})
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestJobQueueRetriesThenDeadLetters(t *testing.T) {
+	db := newTestDB(t)
+
+	calls := 0
+	registerJobHandler("test.failing", func(db *gorm.DB, payload []byte) error {
+		calls++
+		return errors.New("destinatario non raggiungibile")
+	})
+
+	job, err := enqueueJob(db, "test.failing", map[string]int{"value": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Model(job).Update("max_attempts", 2)
+
+	queue := &JobQueue{db: db, workerID: "test", workers: 1}
+	if !queue.ProcessNext() {
+		t.Fatal("expected a job to run")
+	}
+
+	db.First(job, job.ID)
+	if job.Status != JobStatusPending || job.Attempts != 1 || !job.RunAt.After(time.Now()) {
+		t.Fatalf("after first failure job = %+v, want pending with backoff", job)
+	}
+
+	// Il lavoro non è ancora pronto
+	if queue.ProcessNext() {
+		t.Fatal("job ran before its backoff expired")
+	}
+
+	db.Model(job).Update("run_at", time.Now().Add(-time.Second))
+	queue.ProcessNext()
+
+	db.First(job, job.ID)
+	if job.Status != JobStatusFailed || calls != 2 {
+		t.Fatalf("job = %+v after %d calls, want failed after 2", job, calls)
+	}
+
+	var dead []DeadJob
+	db.Find(&dead)
+	if len(dead) != 1 || dead[0].JobID != job.ID || dead[0].LastError == "" {
+		t.Fatalf("dead jobs = %+v", dead)
+	}
+}
+
+func TestJobQueueRunsHandlerWithPayload(t *testing.T) {
+	db := newTestDB(t)
+
+	var received NotificationCheckPayload
+	registerJobHandler("test.capture", func(db *gorm.DB, payload []byte) error {
+		return json.Unmarshal(payload, &received)
+	})
+
+	job, _ := enqueueJob(db, "test.capture", NotificationCheckPayload{Rules: []string{RuleWeightAlert}})
+	queue := &JobQueue{db: db, workerID: "test", workers: 1}
+	queue.ProcessNext()
+
+	db.First(job, job.ID)
+	if job.Status != JobStatusDone || job.CompletedAt == nil {
+		t.Errorf("job = %+v, want done", job)
+	}
+	if len(received.Rules) != 1 || received.Rules[0] != RuleWeightAlert {
+		t.Errorf("payload = %+v", received)
+	}
+}
+
+func TestJobQueueLeaseOwnership(t *testing.T) {
+	db := newTestDB(t)
+	queue := &JobQueue{db: db, workerID: "test", workers: 1}
+
+	// Durante l'esecuzione maintain rimette in coda il lavoro e un altro worker lo prende
+	registerJobHandler("test.stolen", func(db *gorm.DB, payload []byte) error {
+		var job Job
+		db.Where("kind = ?", "test.stolen").First(&job)
+		if !queue.renewLease(&job, time.Now()) {
+			t.Error("lease not renewed by its owner")
+		}
+		queue.maintain(time.Now().Add(2 * jobStaleAfter))
+		other := &JobQueue{db: db, workerID: "other", workers: 1}
+		if claimed, _ := other.claim(time.Now()); claimed == nil {
+			t.Error("stale job was not requeued")
+		}
+		if queue.renewLease(&job, time.Now()) {
+			t.Error("lease renewed after another worker claimed the job")
+		}
+		return nil
+	})
+
+	job, _ := enqueueJob(db, "test.stolen", struct{}{})
+	queue.ProcessNext()
+
+	db.First(job, job.ID)
+	if job.Status != JobStatusRunning || job.LockedBy != "other" || job.Attempts != 2 {
+		t.Errorf("job = %+v, want still running for the new owner", job)
+	}
+}
+
+func TestJobBackoffIsExponentialAndCapped(t *testing.T) {
+	if jobBackoff(1) != 30*time.Second || jobBackoff(3) != 2*time.Minute {
+		t.Errorf("backoff = %v, %v", jobBackoff(1), jobBackoff(3))
+	}
+	if jobBackoff(20) != time.Hour {
+		t.Errorf("backoff(20) = %v, want capped at 1h", jobBackoff(20))
+	}
+}
+
+func TestJobsForDeletedRecordsAreDone(t *testing.T) {
+	db := newTestDB(t)
+	subscription := WebhookSubscription{Name: "Canale", URL: "https://example.org/hook", Enabled: true}
+	db.Create(&subscription)
+
+	enqueueJob(db, JobKindNotificationEmail, ExternalNotificationPayload{NotificationID: 99, Recipient: "volontari@laninna.it"})
+	enqueueJob(db, JobKindNotificationWebhook, ExternalNotificationPayload{NotificationID: 99, Recipient: "https://example.org/legacy"})
+	enqueueJob(db, JobKindWebhookDelivery, WebhookDeliveryPayload{SubscriptionID: subscription.ID, NotificationID: 99})
+	enqueueJob(db, JobKindWebhookDelivery, WebhookDeliveryPayload{SubscriptionID: 42, Event: "hedgehog.created", Body: json.RawMessage(`{}`)})
+
+	queue := NewJobQueue(db)
+	for queue.ProcessNext() {
+	}
+
+	// Notifica o sottoscrizione eliminate non sono errori da ritentare
+	var done, dead int64
+	db.Model(&Job{}).Where("status = ?", JobStatusDone).Count(&done)
+	db.Model(&DeadJob{}).Count(&dead)
+	if done != 4 || dead != 0 {
+		t.Errorf("done = %d, dead = %d, want every job done", done, dead)
+	}
+}
@@ -0,0 +1,276 @@
+// labtests.go - Esami di laboratorio e controlli parassitologici
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/laninna/hedgehog-app/logger"
+	"gorm.io/gorm"
+)
+
+// latestLabResultQuery restituisce gli ID dei ricci il cui esame più recente ha l'esito indicato
+func latestLabResultQuery(db *gorm.DB, result string) *gorm.DB {
+	return db.Model(&LabTest{}).
+		Select("lab_tests.hedgehog_id").
+		Where("lab_tests.result = ?", result).
+		Where("lab_tests.sample_date = (SELECT MAX(lt.sample_date) FROM lab_tests lt WHERE lt.hedgehog_id = lab_tests.hedgehog_id AND lt.deleted_at IS NULL)")
+}
+
+// labTestFollowUpCandidates segnala i ricci che necessitano di un esame di controllo
+// dopo la fine di una terapia di sverminazione
+func (ns *NotificationService) labTestFollowUpCandidates() ([]RuleCandidate, error) {
+	followUpDays := ns.settings.LabTestFollowUpDays
+	if followUpDays <= 0 {
+		followUpDays = 14
+	}
+
+	var therapies []Therapy
+	if err := ns.scoped(ns.db.Where("category = ? AND end_date IS NOT NULL", "deworming"), "hedgehog_id").
+		Where("status IN ?", []string{"active", "completed"}).
+		Find(&therapies).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var candidates []RuleCandidate
+
+	for _, therapy := range therapies {
+		dueDate := therapy.EndDate.AddDate(0, 0, followUpDays)
+		if dueDate.After(now) {
+			continue
+		}
+
+		// Esame già eseguito dopo la fine della terapia
+		var count int64
+		ns.db.Model(&LabTest{}).
+			Where("hedgehog_id = ? AND sample_date >= ?", therapy.HedgehogID, *therapy.EndDate).
+			Count(&count)
+		if count > 0 {
+			continue
+		}
+
+		var hedgehog Hedgehog
+		if err := ns.db.First(&hedgehog, therapy.HedgehogID).Error; err != nil || hedgehog.Status != "in_care" {
+			continue
+		}
+
+		daysOverdue := int(now.Sub(dueDate).Hours() / 24)
+		candidates = append(candidates, RuleCandidate{RepeatAfter: 72 * time.Hour, Notification: Notification{
+			Type:         NotificationLabTestDue,
+			Priority:     PriorityMedium,
+			TemplateData: templateVars(map[string]interface{}{"Hedgehog": hedgehog.Name, "Therapy": therapy.Name, "EndDate": *therapy.EndDate}),
+			HedgehogID:   &therapy.HedgehogID,
+			TherapyID:    &therapy.ID,
+			ActionURL:    fmt.Sprintf("/hedgehogs/%d", therapy.HedgehogID),
+			ActionLabel:  "Registra Esame",
+			Data:         fmt.Sprintf(`{"days_overdue": %d, "therapy_end": %q}`, daysOverdue, therapy.EndDate.Format("2006-01-02")),
+		}})
+	}
+
+	return candidates, nil
+}
+
+// @Summary Get lab tests
+// @Description Get laboratory test records with optional filters
+// @Tags Lab Tests
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param hedgehog_id query int false "Filter by hedgehog ID"
+// @Param result query string false "Filter by result"
+// @Param test_type query string false "Filter by test type"
+// @Success 200 {array} LabTest
+// @Failure 401 {object} map[string]string
+// @Router /lab-tests [get]
+func getLabTests(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var tests []LabTest
+		query := db.Order("sample_date DESC")
+
+		if hedgehogID := c.Query("hedgehog_id"); hedgehogID != "" {
+			query = query.Where("hedgehog_id = ?", hedgehogID)
+		}
+
+		if result := c.Query("result"); result != "" {
+			query = query.Where("result = ?", result)
+		}
+
+		if testType := c.Query("test_type"); testType != "" {
+			query = query.Where("test_type = ?", testType)
+		}
+
+		query.Find(&tests)
+		c.JSON(http.StatusOK, tests)
+	}
+}
+
+// @Summary Create lab test
+// @Description Add a new laboratory test record for a hedgehog
+// @Tags Lab Tests
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param lab_test body LabTest true "Lab test data"
+// @Success 201 {object} LabTest
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /lab-tests [post]
+func createLabTest(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var test LabTest
+		if err := c.ShouldBindJSON(&test); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if test.HedgehogID == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "hedgehog_id is required"})
+			return
+		}
+
+		if test.TestType == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "test_type is required"})
+			return
+		}
+
+		if test.SampleDate.IsZero() {
+			test.SampleDate = time.Now()
+		}
+
+		if err := db.Create(&test).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		enqueueAutoResolve(db, test.HedgehogID)
+
+		c.JSON(http.StatusCreated, test)
+	}
+}
+
+// @Summary Update lab test
+// @Description Update an existing laboratory test record
+// @Tags Lab Tests
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Lab test ID"
+// @Param lab_test body LabTest true "Updated lab test data"
+// @Success 200 {object} LabTest
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /lab-tests/{id} [put]
+func updateLabTest(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		var test LabTest
+
+		if err := db.First(&test, id).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Lab test not found"})
+			return
+		}
+
+		if err := c.ShouldBindJSON(&test); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := db.Save(&test).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		enqueueAutoResolve(db, test.HedgehogID)
+
+		c.JSON(http.StatusOK, test)
+	}
+}
+
+// @Summary Delete lab test
+// @Description Delete a laboratory test record by its ID
+// @Tags Lab Tests
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Lab test ID"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /lab-tests/{id} [delete]
+func deleteLabTest(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		var test LabTest
+		if err := db.First(&test, id).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Lab test not found"})
+			return
+		}
+		if err := db.Delete(&test).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		enqueueAutoResolve(db, test.HedgehogID)
+		c.JSON(http.StatusOK, gin.H{"message": "Lab test deleted"})
+	}
+}
+
+// @Summary Upload lab test attachment
+// @Description Upload a lab report (PDF or image) for a laboratory test
+// @Tags Lab Tests
+// @Accept multipart/form-data
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Lab test ID"
+// @Param file formData file true "Lab report to upload"
+// @Success 200 {object} LabTest
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /lab-tests/{id}/attachment [post]
+func uploadLabTestAttachmentHandler(db *gorm.DB, cloudinaryService *CloudinaryService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		log := logger.GetLoggerFromContext(c)
+
+		id := c.Param("id")
+		var test LabTest
+		if err := db.First(&test, id).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Lab test not found"})
+			return
+		}
+
+		file, header, err := c.Request.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No file provided or invalid file"})
+			return
+		}
+		defer file.Close()
+
+		if header.Header.Get("Content-Type") != "application/pdf" && !isValidImageType(header) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file type. Only PDF, JPEG, PNG, and GIF are allowed"})
+			return
+		}
+
+		filename := fmt.Sprintf("labtest_%d_%s", test.ID, time.Now().Format("20060102150405"))
+		url, err := cloudinaryService.UploadAttachment(c, file, "lab-tests", filename)
+		if err != nil {
+			log.Error().Err(err).Uint("lab_test_id", test.ID).Msg("Failed to upload lab test attachment")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload attachment: " + err.Error()})
+			return
+		}
+
+		test.AttachmentURL = url
+		if err := db.Save(&test).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		log.Info().Uint("lab_test_id", test.ID).Str("attachment_url", url).Msg("Lab test attachment uploaded")
+		c.JSON(http.StatusOK, test)
+	}
+}
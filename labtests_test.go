@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatestLabResultUsesMostRecentTest(t *testing.T) {
+	db := newTestDB(t)
+	treated := Hedgehog{Name: "Curato"}
+	positive := Hedgehog{Name: "Positivo"}
+	db.Create(&treated)
+	db.Create(&positive)
+
+	now := time.Now()
+	db.Create(&LabTest{HedgehogID: treated.ID, TestType: "fecal", SampleDate: now.AddDate(0, 0, -10), Result: "positive"})
+	db.Create(&LabTest{HedgehogID: treated.ID, TestType: "fecal", SampleDate: now, Result: "negative"})
+	db.Create(&LabTest{HedgehogID: positive.ID, TestType: "fecal", SampleDate: now, Result: "positive"})
+
+	var hedgehogs []Hedgehog
+	if err := db.Where("id IN (?)", latestLabResultQuery(db, "positive")).Find(&hedgehogs).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(hedgehogs) != 1 || hedgehogs[0].ID != positive.ID {
+		t.Errorf("hedgehogs = %+v, want only the one whose latest test is positive", hedgehogs)
+	}
+}
+
+func TestLabTestFollowUpAfterDeworming(t *testing.T) {
+	db := newTestDB(t)
+	hedgehog := Hedgehog{Name: "Spillo", Status: "in_care"}
+	db.Create(&hedgehog)
+	end := time.Now().AddDate(0, 0, -20)
+	db.Create(&Therapy{HedgehogID: hedgehog.ID, Name: "Panacur", Category: "deworming", Status: "completed", StartDate: end.AddDate(0, 0, -3), EndDate: &end})
+
+	// L'esame precedente alla fine della terapia non conta come controllo
+	db.Create(&LabTest{HedgehogID: hedgehog.ID, TestType: "fecal", SampleDate: end.AddDate(0, 0, -5), Result: "positive"})
+
+	ns := NewNotificationService(db)
+	candidates, err := ns.labTestFollowUpCandidates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 1 || candidates[0].Notification.Type != NotificationLabTestDue {
+		t.Fatalf("candidates = %+v, want one follow-up", candidates)
+	}
+
+	db.Create(&LabTest{HedgehogID: hedgehog.ID, TestType: "fecal", SampleDate: end.AddDate(0, 0, 14), Result: "negative"})
+	if candidates, _ := ns.labTestFollowUpCandidates(); len(candidates) != 0 {
+		t.Errorf("follow-up still requested after the control test: %+v", candidates)
+	}
+}
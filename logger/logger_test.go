@@ -7,6 +7,7 @@ import (
 	"github.com/rs/zerolog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -193,4 +194,12 @@ func checkLogLevel(t *testing.T, log map[string]interface{}, expectedLevel strin
 	if log["level"] != expectedLevel {
 		t.Errorf("Expected log level %s, got %s", expectedLevel, log["level"])
 	}
-}
\ No newline at end of file
+}
+func TestMaskQuery(t *testing.T) {
+	if got := maskQuery("token=eyJhbGciOi.abc&last_event_id=5"); strings.Contains(got, "eyJ") || !strings.Contains(got, "last_event_id=5") {
+		t.Errorf("maskQuery = %q", got)
+	}
+	if got := maskQuery("limit=10"); got != "limit=10" {
+		t.Errorf("maskQuery changed a harmless query: %q", got)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 	"math/rand"
 	"fmt"
+	"net/url"
 	"strings"
 )
 
@@ -69,7 +70,7 @@ func RequestLogger() gin.HandlerFunc {
 
 		// Add query if present
 		if raw != "" {
-			event = event.Str("query", raw)
+			event = event.Str("query", maskQuery(raw))
 		}
 
 		// Log request completion
@@ -140,6 +141,28 @@ func UserContextMiddleware() gin.HandlerFunc {
 	}
 }
 
+// sensitiveQueryParams are query parameters whose values must never reach the logs
+var sensitiveQueryParams = []string{"token", "ticket", "access_token", "secret", "password"}
+
+// maskQuery hides the values of sensitive query parameters
+func maskQuery(raw string) string {
+	values, err := url.ParseQuery(raw)
+	if err != nil {
+		return "[unparseable query]"
+	}
+	masked := false
+	for _, name := range sensitiveQueryParams {
+		if _, ok := values[name]; ok {
+			values.Set(name, "***")
+			masked = true
+		}
+	}
+	if !masked {
+		return raw
+	}
+	return values.Encode()
+}
+
 // generateRequestID generates a random request ID
 func generateRequestID() string {
 	// Simple implementation - in production, consider using UUID
@@ -21,6 +21,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -31,6 +33,9 @@ import (
 	"gorm.io/gorm"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	_ "github.com/laninna/hedgehog-app/docs"
 )
@@ -73,8 +78,17 @@ func main() {
 	// Inizializza router
 	r := setupRouter(db, cloudinaryService)
 
-	// Avvia sistema notifiche
-	StartNotificationScheduler(db)
+	// Avvia lettura cartella CSV delle bilance (se configurata)
+	StartScaleDropFolder(db)
+
+	// Worker e scheduler si fermano con SIGINT/SIGTERM, senza prendere nuovi lavori
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Avvia worker della coda lavori e scheduler delle attività periodiche
+	StartJobWorkers(ctx, db)
+	seedLegacyDigestSchedule(db)
+	StartScheduler(ctx, db)
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -84,7 +98,16 @@ func main() {
 	logger.Info("🦔 La Ninna server starting", logger.Str("port", port))
 	
 	// Start HTTP server
-	if err := http.ListenAndServe(":"+port, r); err != nil {
+	server := &http.Server{Addr: ":" + port, Handler: r}
+	go func() {
+		<-ctx.Done()
+		logger.Info("🛑 Shutting down server")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		logger.Fatal("Server failed to start", err)
 	}
 }
@@ -117,13 +140,44 @@ func initDB() (*gorm.DB, error) {
 			&Area{},
 			&Therapy{},
 			&WeightRecord{},
+			&GrowthCurvePoint{},
+			&AreaMove{},
+			&ScaleReading{},
+			&LabTest{},
+			&FeedingPlan{},
+			&FoodIntake{},
+			&TherapyDose{},
+			&DailyTask{},
+			&HibernationWake{},
+			&ReleaseCriteria{},
+			&NotificationRuleConfig{},
 			&Notification{},         // ← Nuovo
+			&NotificationReceipt{},
+			&NotificationSubscription{},
+			&NotificationEvent{},
+			&NotificationHistory{},
+			&StreamTicket{},
+			&EscalationPolicy{},
+			&NotificationEscalation{},
+			&Job{},
+			&DeadJob{},
+			&WebhookSubscription{},
+			&WebhookDelivery{},
 			&NotificationSettings{}, // ← Nuovo
+			&EmailTransportSettings{},
+			&NotificationTemplate{},
+			&DigestSchedule{},
+			&ScheduledJob{},
+			&ScheduledJobRun{},
+			&QuietHours{},
+			&OnCallShift{},
+			&SeedMarker{},
 		)
 		if err != nil {
 			logger.Error("Database migration failed", err)
 			return nil, err
 		}
+		dropStoredEmailPassword(db)
 		logger.Info("Database migrations completed successfully")
 	} else {
 		logger.Info("Skipping database migrations (AUTO_MIGRATE not set to 'true')")
@@ -168,6 +222,17 @@ func setupRouter(db *gorm.DB, cloudinaryService *CloudinaryService) *gin.Engine
 		api.POST("/login", loginHandler(db))
 		api.POST("/refresh", refreshTokenHandler(db))
 
+		// Scale ingestion (bridge token or JWT)
+		scale := api.Group("/scale")
+		scale.Use(scaleAuthMiddleware())
+		{
+			scale.POST("/readings", ingestScaleReadingsHandler(db))
+			scale.POST("/readings/csv", ingestScaleCSVHandler(db))
+		}
+
+		// Stream notifiche (EventSource non può inviare l'header Authorization)
+		api.GET("/notifications/stream", streamAuthMiddleware(db), streamNotificationsHandler(db))
+
 		// Protected routes
 		protected := api.Group("/")
 		protected.Use(authMiddleware())
@@ -175,6 +240,7 @@ func setupRouter(db *gorm.DB, cloudinaryService *CloudinaryService) *gin.Engine
  		// Hedgehogs CRUD
 			protected.GET("/hedgehogs", getHedgehogs(db))
 			protected.POST("/hedgehogs", createHedgehog(db))
+			protected.GET("/hedgehogs/release-candidates", getReleaseCandidatesHandler(db))
 			protected.GET("/hedgehogs/:id", getHedgehog(db))
 			protected.PUT("/hedgehogs/:id", updateHedgehog(db))
 			protected.DELETE("/hedgehogs/:id", deleteHedgehog(db))
@@ -184,6 +250,14 @@ func setupRouter(db *gorm.DB, cloudinaryService *CloudinaryService) *gin.Engine
 				protected.POST("/hedgehogs/:id/image", UploadHedgehogImageHandler(db, cloudinaryService))
 			}
 
+			// Hibernation
+			protected.GET("/hedgehogs/:id/weights/series", getWeightSeriesHandler(db))
+			protected.GET("/hedgehogs/:id/hibernation", getHibernationStatusHandler(db))
+			protected.POST("/hedgehogs/:id/hibernation/start", startHibernationHandler(db))
+			protected.POST("/hedgehogs/:id/hibernation/end", endHibernationHandler(db))
+			protected.GET("/hedgehogs/:id/hibernation/wakes", getHibernationWakesHandler(db))
+			protected.POST("/hedgehogs/:id/hibernation/wakes", createHibernationWakeHandler(db))
+
 			// Rooms CRUD
 			protected.GET("/rooms", getRooms(db))
 			protected.POST("/rooms", createRoom(db))
@@ -208,6 +282,44 @@ func setupRouter(db *gorm.DB, cloudinaryService *CloudinaryService) *gin.Engine
 			protected.POST("/weight-records", createWeightRecord(db))
 			protected.PUT("/weight-records/:id", updateWeightRecord(db))
 			protected.DELETE("/weight-records/:id", deleteWeightRecord(db))
+			protected.PUT("/weight-records/:id/exclusion", setWeightRecordExclusion(db))
+
+			// Scale readings review
+			protected.GET("/scale/readings", getScaleReadingsHandler(db))
+			protected.PUT("/scale/readings/:id/assign", assignScaleReadingHandler(db))
+
+			// Growth Curves CRUD
+			protected.GET("/growth-curves", getGrowthCurves(db))
+			protected.POST("/growth-curves", createGrowthCurvePoint(db))
+			protected.PUT("/growth-curves/:id", updateGrowthCurvePoint(db))
+			protected.DELETE("/growth-curves/:id", deleteGrowthCurvePoint(db))
+
+			// Lab Tests CRUD
+			protected.GET("/lab-tests", getLabTests(db))
+			protected.POST("/lab-tests", createLabTest(db))
+			protected.PUT("/lab-tests/:id", updateLabTest(db))
+			protected.DELETE("/lab-tests/:id", deleteLabTest(db))
+			if cloudinaryService != nil {
+				protected.POST("/lab-tests/:id/attachment", uploadLabTestAttachmentHandler(db, cloudinaryService))
+			}
+
+			// Feeding plans and daily intake
+			protected.GET("/feeding-plans", getFeedingPlans(db))
+			protected.POST("/feeding-plans", createFeedingPlan(db))
+			protected.PUT("/feeding-plans/:id", updateFeedingPlan(db))
+			protected.DELETE("/feeding-plans/:id", deleteFeedingPlan(db))
+			protected.GET("/food-intakes", getFoodIntakes(db))
+			protected.POST("/food-intakes", createFoodIntake(db))
+			protected.PUT("/food-intakes/:id", updateFoodIntake(db))
+			protected.DELETE("/food-intakes/:id", deleteFoodIntake(db))
+
+			// Daily task rota
+			protected.GET("/tasks", getDailyTasksHandler(db))
+			protected.POST("/tasks/generate", generateDailyTasksHandler(db))
+			protected.PUT("/tasks/:id/assign", assignDailyTaskHandler(db))
+			protected.POST("/tasks/:id/complete", completeDailyTaskHandler(db))
+			protected.POST("/tasks/:id/reopen", reopenDailyTaskHandler(db))
+			protected.GET("/therapy-doses", getTherapyDoses(db))
 
 			// Export routes
 			protected.POST("/export", exportDataHandler(db))
@@ -226,28 +338,121 @@ func setupRouter(db *gorm.DB, cloudinaryService *CloudinaryService) *gin.Engine
 
 			// Notification routes  ← NUOVO
 			protected.GET("/notifications", getNotificationsHandler(db))
+			protected.POST("/notifications/stream-ticket", createStreamTicketHandler(db))
 			protected.PUT("/notifications/:id/read", markNotificationReadHandler(db))
 			protected.DELETE("/notifications/:id", dismissNotificationHandler(db))
 			protected.GET("/notifications/stats", getNotificationStatsHandler(db))
+			protected.GET("/notifications/analytics", getNotificationAnalyticsHandler(db))
+			protected.PUT("/notifications/:id/acknowledge", acknowledgeNotificationHandler(db))
+			protected.PUT("/notifications/:id/assign", assignNotificationHandler(db))
+			protected.PUT("/notifications/:id/snooze", snoozeNotificationHandler(db))
+			protected.PUT("/notifications/:id/resolve", resolveNotificationHandler(db))
+			protected.PUT("/notifications/:id/reopen", reopenNotificationHandler(db))
+			protected.GET("/notifications/:id/history", getNotificationHistoryHandler(db))
+			protected.GET("/notifications/:id/escalations", getNotificationEscalationsHandler(db))
 			protected.POST("/notifications/check", func(c *gin.Context) {
-				ns := NewNotificationService(db)
-				go ns.CheckAllNotifications()
-				c.JSON(http.StatusOK, gin.H{"message": "Check triggered"})
+				job, err := enqueueJob(db, JobKindNotificationCheck, NotificationCheckPayload{})
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+					return
+				}
+				c.JSON(http.StatusOK, gin.H{"message": "Check triggered", "job_id": job.ID})
 			})
 
 			// Analysis routes  ← NUOVO
 			protected.GET("/analysis/weight", getWeightAnalysisHandler(db))
 			protected.GET("/analysis/therapy", getTherapyAnalysisHandler(db))
 
+			// Release criteria
+			protected.GET("/release-criteria", getReleaseCriteriaHandler(db))
+			protected.PUT("/release-criteria", updateReleaseCriteriaHandler(db))
+			protected.PUT("/release-criteria/temperature", reportTemperatureHandler(db))
+
 			// Settings routes  ← NUOVO
 			protected.GET("/notification-settings", getNotificationSettingsHandler(db))
 			protected.PUT("/notification-settings", updateNotificationSettingsHandler(db))
+
+			// Preferenze notifiche dell'utente
+			protected.GET("/notification-preferences", getNotificationPreferencesHandler(db))
+			protected.PUT("/notification-preferences", updateNotificationPreferencesHandler(db))
+
+			// Escalation e reperibilità
+			protected.GET("/escalation-policies", getEscalationPoliciesHandler(db))
+			protected.POST("/escalation-policies", createEscalationPolicyHandler(db))
+			protected.PUT("/escalation-policies/:id", updateEscalationPolicyHandler(db))
+			protected.DELETE("/escalation-policies/:id", deleteEscalationPolicyHandler(db))
+			protected.GET("/users", getUsersHandler(db))
+			protected.PUT("/users/:id/on-call", updateUserOnCallHandler(db))
+
+			// Ore di silenzio e turni di reperibilità
+			protected.GET("/quiet-hours", getQuietHoursHandler(db))
+			protected.POST("/quiet-hours", createQuietHoursHandler(db))
+			protected.PUT("/quiet-hours/:id", updateQuietHoursHandler(db))
+			protected.DELETE("/quiet-hours/:id", deleteQuietHoursHandler(db))
+			protected.GET("/on-call-rota", getOnCallRotaHandler(db))
+			protected.GET("/on-call-rota/current", getCurrentOnCallHandler(db))
+			protected.POST("/on-call-rota", createOnCallShiftHandler(db))
+			protected.DELETE("/on-call-rota/:id", deleteOnCallShiftHandler(db))
+
+			// Webhook firmati
+			protected.GET("/webhooks", getWebhookSubscriptionsHandler(db))
+			protected.POST("/webhooks", createWebhookSubscriptionHandler(db))
+			protected.PUT("/webhooks/:id", updateWebhookSubscriptionHandler(db))
+			protected.DELETE("/webhooks/:id", deleteWebhookSubscriptionHandler(db))
+			protected.GET("/webhooks/:id/deliveries", getWebhookDeliveriesHandler(db))
+			protected.POST("/webhook-deliveries/:id/redeliver", redeliverWebhookHandler(db))
+			protected.GET("/webhook-events", getWebhookEventsHandler())
+
+			// Trasporto email
+			protected.GET("/admin/email-transport", getEmailTransportHandler(db))
+			protected.PUT("/admin/email-transport", updateEmailTransportHandler(db))
+			protected.POST("/admin/email-transport/check", checkEmailTransportHandler(db))
+			protected.GET("/health/services", servicesHealthHandler(db))
+			protected.POST("/test/email", testEmailHandler(db))
+			protected.POST("/test/webhook", testWebhookHandler(db))
+			protected.GET("/admin/email-transport/mailbox", getMailboxHandler(db))
+
+			// Coda lavori
+			protected.GET("/admin/queue", getQueuedJobsHandler(db))
+			protected.GET("/admin/queue/stats", getJobQueueStatsHandler(db))
+			protected.GET("/admin/queue/dead", getDeadJobsHandler(db))
+			protected.POST("/admin/queue/dead/:id/retry", retryDeadJobHandler(db))
+			protected.DELETE("/admin/queue/dead/:id", deleteDeadJobHandler(db))
+
+			// Attività pianificate
+			protected.GET("/admin/jobs", getScheduledJobsHandler(db))
+			protected.GET("/admin/jobs/:name/runs", getScheduledJobRunsHandler(db))
+			protected.POST("/admin/jobs/:name/pause", pauseScheduledJobHandler(db))
+			protected.POST("/admin/jobs/:name/resume", resumeScheduledJobHandler(db))
+			protected.POST("/admin/jobs/:name/trigger", triggerScheduledJobHandler(db))
+
+			// Modelli di notifiche ed email
+			protected.GET("/notification-templates", getNotificationTemplatesHandler(db))
+			protected.POST("/notification-templates/preview", previewNotificationTemplateHandler(db))
+			protected.PUT("/notification-templates/:type/:channel/:locale", updateNotificationTemplateHandler(db))
+			protected.DELETE("/notification-templates/:type/:channel/:locale", deleteNotificationTemplateHandler(db))
+
+			// Riassunti periodici
+			protected.GET("/digest-sections", getDigestSectionsHandler())
+			protected.GET("/digest-schedules", getDigestSchedulesHandler(db))
+			protected.POST("/digest-schedules", createDigestScheduleHandler(db))
+			protected.PUT("/digest-schedules/:id", updateDigestScheduleHandler(db))
+			protected.DELETE("/digest-schedules/:id", deleteDigestScheduleHandler(db))
+			protected.POST("/digest-schedules/:id/preview", previewDigestScheduleHandler(db))
+
+			// Regole di notifica
+			protected.GET("/notification-rules", getNotificationRulesHandler(db))
+			protected.PUT("/notification-rules/:name", updateNotificationRuleHandler(db))
+			protected.POST("/notification-rules/dry-run", dryRunNotificationRulesHandler(db))
 		}
 	}
 
 	// Swagger documentation
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
+	// Health check pubblico, senza verifiche dei servizi esterni
+	r.GET("/health", healthCheckHandler(db))
+
 	// Frontend routes
 	r.GET("/", indexHandler)
 	r.GET("/login", loginPageHandler)
@@ -255,6 +460,7 @@ func setupRouter(db *gorm.DB, cloudinaryService *CloudinaryService) *gin.Engine
 	r.GET("/rooms", roomsPageHandler)
 	r.GET("/room-builder", roomBuilderPageHandler)
 	r.GET("/notifications", notificationsPageHandler) // ← NUOVO
+	r.GET("/tasks", tasksPageHandler)
 	r.GET("/tutorial", docsPageHandler)
 
 	return r
@@ -8,31 +8,40 @@ import (
 // User model
 // @Description User account information for authentication and authorization
 type User struct {
-	ID        uint           `json:"id" gorm:"primaryKey" example:"1" description:"Unique identifier"`
-	Username  string         `json:"username" gorm:"unique;not null" example:"admin" description:"Unique username for login"`
-	Password  string         `json:"-" gorm:"not null" description:"Hashed password (not exposed in API)"`
-	CreatedAt time.Time      `json:"created_at" example:"2024-01-01T00:00:00Z" description:"When the user was created"`
-	UpdatedAt time.Time      `json:"updated_at" example:"2024-01-01T00:00:00Z" description:"When the user was last updated"`
-	DeletedAt gorm.DeletedAt `json:"-" gorm:"index" description:"Soft delete timestamp (not exposed in API)"`
+	ID          uint           `json:"id" gorm:"primaryKey" example:"1" description:"Unique identifier"`
+	Username    string         `json:"username" gorm:"unique;not null" example:"admin" description:"Unique username for login"`
+	Password    string         `json:"-" gorm:"not null" description:"Hashed password (not exposed in API)"`
+	Email       string         `json:"email" example:"volontario@laninna.it" description:"Email address used for escalated notifications"`
+	OnCallOrder int            `json:"on_call_order" gorm:"default:0" example:"1" description:"Position in the on-call escalation order, 0 if not on call" minimum:"0"`
+	CreatedAt   time.Time      `json:"created_at" example:"2024-01-01T00:00:00Z" description:"When the user was created"`
+	UpdatedAt   time.Time      `json:"updated_at" example:"2024-01-01T00:00:00Z" description:"When the user was last updated"`
+	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index" description:"Soft delete timestamp (not exposed in API)"`
 } // @User
 
 // Hedgehog model
 // @Description Information about a hedgehog in the rescue center
 type Hedgehog struct {
-	ID            uint           `json:"id" gorm:"primaryKey" example:"1" description:"Unique identifier"`
-	Name          string         `json:"name" gorm:"not null" example:"Spillo" description:"Name of the hedgehog"`
-	Description   string         `json:"description" example:"Riccio trovato nel giardino" description:"Additional information about the hedgehog"`
-	Picture       string         `json:"picture" gorm:"default:'https://res.cloudinary.com/dbzxfdul3/image/upload/v1753739516/cute-hedgehog-cartoon-porcupine-illustration_1058532-11530_kxu4lt.jpg'" example:"https://res.cloudinary.com/demo/image/upload/v1312461204/sample.jpg" description:"URL to the hedgehog's picture"`
-	ArrivalDate   time.Time      `json:"arrival_date" example:"2024-01-15T10:30:00Z" description:"When the hedgehog arrived at the center" format:"date-time"`
-	Status        string         `json:"status" gorm:"default:'in_care'" example:"in_care" enums:"in_care,recovered,deceased" description:"Current status of the hedgehog"`
-	ReleaseDate   *time.Time     `json:"release_date,omitempty" example:"2024-07-28T10:30:00Z" description:"When the hedgehog was or will be released" format:"date-time"`
-	AreaID        *uint          `json:"area_id" example:"1" description:"ID of the area where the hedgehog is located"`
-	Area          *Area          `json:"area,omitempty" gorm:"foreignKey:AreaID" description:"Area where the hedgehog is located"`
-	Therapies     []Therapy      `json:"therapies,omitempty" description:"Treatments and therapies for the hedgehog"`
-	WeightRecords []WeightRecord `json:"weight_records,omitempty" description:"Weight history records"`
-	CreatedAt     time.Time      `json:"created_at" example:"2024-01-15T10:30:00Z" description:"When the record was created" format:"date-time"`
-	UpdatedAt     time.Time      `json:"updated_at" example:"2024-01-15T10:30:00Z" description:"When the record was last updated" format:"date-time"`
-	DeletedAt     gorm.DeletedAt `json:"-" gorm:"index" description:"Soft delete timestamp (not exposed in API)"`
+	ID               uint           `json:"id" gorm:"primaryKey" example:"1" description:"Unique identifier"`
+	Name             string         `json:"name" gorm:"not null" example:"Spillo" description:"Name of the hedgehog"`
+	Description      string         `json:"description" example:"Riccio trovato nel giardino" description:"Additional information about the hedgehog"`
+	Picture          string         `json:"picture" gorm:"default:'https://res.cloudinary.com/dbzxfdul3/image/upload/v1753739516/cute-hedgehog-cartoon-porcupine-illustration_1058532-11530_kxu4lt.jpg'" example:"https://res.cloudinary.com/demo/image/upload/v1312461204/sample.jpg" description:"URL to the hedgehog's picture"`
+	ArrivalDate      time.Time      `json:"arrival_date" example:"2024-01-15T10:30:00Z" description:"When the hedgehog arrived at the center" format:"date-time"`
+	Microchip        string         `json:"microchip,omitempty" gorm:"index" example:"380260000123456" description:"RFID microchip or tag code used by connected scales"`
+	Sex              string         `json:"sex" gorm:"default:'unknown'" example:"female" enums:"male,female,unknown" description:"Sex of the hedgehog"`
+	BirthDate        *time.Time     `json:"birth_date,omitempty" example:"2024-08-01T00:00:00Z" description:"Estimated date of birth, used to compare weights with the growth curve" format:"date-time"`
+	Status           string         `json:"status" gorm:"default:'in_care'" example:"in_care" enums:"in_care,recovered,deceased" description:"Current status of the hedgehog"`
+	ReleaseDate      *time.Time     `json:"release_date,omitempty" example:"2024-07-28T10:30:00Z" description:"When the hedgehog was or will be released" format:"date-time"`
+	HibernationStart *time.Time     `json:"hibernation_start,omitempty" example:"2024-11-20T00:00:00Z" description:"When the current or last hibernation started" format:"date-time"`
+	HibernationEnd   *time.Time     `json:"hibernation_end,omitempty" example:"2025-03-10T00:00:00Z" description:"When the last hibernation ended" format:"date-time"`
+	AreaID           *uint          `json:"area_id" example:"1" description:"ID of the area where the hedgehog is located"`
+	Area             *Area          `json:"area,omitempty" gorm:"foreignKey:AreaID" description:"Area where the hedgehog is located"`
+	Therapies        []Therapy      `json:"therapies,omitempty" description:"Treatments and therapies for the hedgehog"`
+	WeightRecords    []WeightRecord `json:"weight_records,omitempty" description:"Weight history records"`
+	LabTests         []LabTest      `json:"lab_tests,omitempty" description:"Laboratory and parasite test results"`
+	FeedingPlans     []FeedingPlan  `json:"feeding_plans,omitempty" description:"Feeding plans assigned to the hedgehog"`
+	CreatedAt        time.Time      `json:"created_at" example:"2024-01-15T10:30:00Z" description:"When the record was created" format:"date-time"`
+	UpdatedAt        time.Time      `json:"updated_at" example:"2024-01-15T10:30:00Z" description:"When the record was last updated" format:"date-time"`
+	DeletedAt        gorm.DeletedAt `json:"-" gorm:"index" description:"Soft delete timestamp (not exposed in API)"`
 } // @Hedgehog
 
 // Room model
@@ -61,6 +70,7 @@ type Area struct {
 	Width       float64        `json:"width" example:"100" description:"Width of the area in cm" minimum:"1"`
 	Height      float64        `json:"height" example:"80" description:"Height of the area in cm" minimum:"1"`
 	MaxCapacity int            `json:"max_capacity" gorm:"default:1" example:"2" description:"Maximum number of hedgehogs this area can house" minimum:"1"`
+	QRCode      string         `json:"qr_code,omitempty" gorm:"index" example:"CAGE-001" description:"QR code printed on the cage, used by connected scales"`
 	Hedgehogs   []Hedgehog     `json:"hedgehogs,omitempty" description:"Hedgehogs currently housed in this area"`
 	CreatedAt   time.Time      `json:"created_at" example:"2024-01-15T10:30:00Z" description:"When the record was created" format:"date-time"`
 	UpdatedAt   time.Time      `json:"updated_at" example:"2024-01-15T10:30:00Z" description:"When the record was last updated" format:"date-time"`
@@ -77,6 +87,9 @@ type Therapy struct {
 	StartDate   time.Time      `json:"start_date" example:"2024-01-15T10:30:00Z" description:"When the therapy started" format:"date-time"`
 	EndDate     *time.Time     `json:"end_date" example:"2024-01-30T10:30:00Z" description:"When the therapy is scheduled to end" format:"date-time"`
 	Status      string         `json:"status" gorm:"default:'active'" example:"active" enums:"active,completed,suspended" description:"Current status of the therapy"`
+	Category    string         `json:"category" gorm:"default:'general'" example:"deworming" enums:"general,deworming,antibiotic" description:"Category of the therapy, used to schedule follow-up tests"`
+	Dosage      string         `json:"dosage" example:"0.1 ml" description:"Amount administered for each dose"`
+	DosesPerDay int            `json:"doses_per_day" gorm:"default:1" example:"2" description:"Number of doses to administer each day" minimum:"0"`
 	CreatedAt   time.Time      `json:"created_at" example:"2024-01-15T10:30:00Z" description:"When the record was created" format:"date-time"`
 	UpdatedAt   time.Time      `json:"updated_at" example:"2024-01-15T10:30:00Z" description:"When the record was last updated" format:"date-time"`
 	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index" description:"Soft delete timestamp (not exposed in API)"`
@@ -85,29 +98,217 @@ type Therapy struct {
 // WeightRecord model
 // @Description A record of a hedgehog's weight measurement
 type WeightRecord struct {
+	ID             uint           `json:"id" gorm:"primaryKey" example:"1" description:"Unique identifier"`
+	HedgehogID     uint           `json:"hedgehog_id" example:"1" description:"ID of the hedgehog this weight record belongs to"`
+	Weight         float64        `json:"weight" example:"450.5" description:"Weight in grams" minimum:"1"`
+	Date           time.Time      `json:"date" example:"2024-01-15T10:30:00Z" description:"When the weight was measured" format:"date-time"`
+	Notes          string         `json:"notes" example:"Peso stabile" description:"Additional notes about the weight measurement"`
+	AgeClass       string         `json:"age_class,omitempty" example:"juvenile" enums:"hoglet,juvenile,adult" description:"Age class at the time of weighing (read-only)"`
+	ExpectedWeight *float64       `json:"expected_weight,omitempty" example:"420" description:"Expected weight from the growth curve (read-only)"`
+	ZScore         *float64       `json:"z_score,omitempty" example:"-1.2" description:"Standard deviations from the expected weight (read-only)"`
+	Percentile     *float64       `json:"percentile,omitempty" example:"11.5" description:"Percentile on the growth curve (read-only)"`
+	Flagged        bool           `json:"flagged" gorm:"default:false;index" example:"false" description:"Whether the weight was flagged as an implausible jump (read-only)"`
+	FlagReason     string         `json:"flag_reason,omitempty" example:"Peso 4500g molto diverso dal riferimento 450g" description:"Why the weight was flagged (read-only)"`
+	Excluded       bool           `json:"excluded" gorm:"default:false;index" example:"false" description:"Whether the weight is excluded from trend analysis"`
+	CreatedAt      time.Time      `json:"created_at" example:"2024-01-15T10:30:00Z" description:"When the record was created" format:"date-time"`
+	UpdatedAt      time.Time      `json:"updated_at" example:"2024-01-15T10:30:00Z" description:"When the record was last updated" format:"date-time"`
+	DeletedAt      gorm.DeletedAt `json:"-" gorm:"index" description:"Soft delete timestamp (not exposed in API)"`
+} // @WeightRecord
+
+// GrowthCurvePoint model
+// @Description A reference point of the expected growth curve for an age class and sex
+type GrowthCurvePoint struct {
+	ID             uint      `json:"id" gorm:"primaryKey" example:"1" description:"Unique identifier"`
+	Sex            string    `json:"sex" gorm:"default:'any';index" example:"any" enums:"male,female,any" description:"Sex the point applies to"`
+	AgeClass       string    `json:"age_class" gorm:"not null" example:"juvenile" enums:"hoglet,juvenile,adult" description:"Age class the point belongs to"`
+	AgeDays        int       `json:"age_days" gorm:"not null" example:"90" description:"Age in days" minimum:"0"`
+	ExpectedWeight float64   `json:"expected_weight" gorm:"not null" example:"450" description:"Expected (median) weight in grams at this age" minimum:"1"`
+	StdDev         float64   `json:"std_dev" gorm:"not null" example:"65" description:"Standard deviation of the weight in grams at this age" minimum:"1"`
+	CreatedAt      time.Time `json:"created_at" example:"2024-01-15T10:30:00Z" description:"When the record was created" format:"date-time"`
+	UpdatedAt      time.Time `json:"updated_at" example:"2024-01-15T10:30:00Z" description:"When the record was last updated" format:"date-time"`
+} // @GrowthCurvePoint
+
+// AreaMove model
+// @Description A move of a hedgehog from one area to another
+type AreaMove struct {
+	ID           uint      `json:"id" gorm:"primaryKey" example:"1" description:"Unique identifier"`
+	HedgehogID   uint      `json:"hedgehog_id" gorm:"not null;index" example:"1" description:"ID of the hedgehog that was moved"`
+	FromAreaID   *uint     `json:"from_area_id" example:"1" description:"Area the hedgehog was moved from"`
+	ToAreaID     *uint     `json:"to_area_id" example:"2" description:"Area the hedgehog was moved to"`
+	FromAreaName string    `json:"from_area_name" example:"Gabbia 1" description:"Name of the previous area at the time of the move"`
+	ToAreaName   string    `json:"to_area_name" example:"Recinto esterno" description:"Name of the new area at the time of the move"`
+	MovedAt      time.Time `json:"moved_at" example:"2024-01-20T10:30:00Z" description:"When the hedgehog was moved" format:"date-time"`
+	UserID       *uint     `json:"user_id,omitempty" example:"1" description:"User who moved the hedgehog"`
+	CreatedAt    time.Time `json:"created_at" example:"2024-01-20T10:30:00Z" description:"When the record was created" format:"date-time"`
+} // @AreaMove
+
+// ScaleReading model
+// @Description A raw reading received from a connected scale
+type ScaleReading struct {
+	ID             uint      `json:"id" gorm:"primaryKey" example:"1" description:"Unique identifier"`
+	DeviceID       string    `json:"device_id" gorm:"index" example:"scale-01" description:"Identifier of the scale or bridge"`
+	Tag            string    `json:"tag" gorm:"index" example:"380260000123456" description:"Microchip/RFID code or cage QR code read with the weight"`
+	Weight         float64   `json:"weight" example:"452.5" description:"Weight in grams" minimum:"1"`
+	MeasuredAt     time.Time `json:"measured_at" gorm:"index" example:"2024-01-15T10:30:00Z" description:"When the scale measured the weight" format:"date-time"`
+	Source         string    `json:"source" gorm:"default:'bridge'" example:"bridge" enums:"bridge,csv" description:"How the reading was received"`
+	Status         string    `json:"status" gorm:"index" example:"recorded" enums:"recorded,merged,unmatched,ambiguous,rejected" description:"Outcome of the ingestion"`
+	Message        string    `json:"message,omitempty" example:"Nessun riccio con questo codice" description:"Details about the outcome"`
+	HedgehogID     *uint     `json:"hedgehog_id,omitempty" example:"1" description:"Hedgehog matched to the reading"`
+	WeightRecordID *uint     `json:"weight_record_id,omitempty" example:"1" description:"Weight record created or updated by the reading"`
+	CreatedAt      time.Time `json:"created_at" example:"2024-01-15T10:30:00Z" description:"When the reading was received" format:"date-time"`
+} // @ScaleReading
+
+// LabTest model
+// @Description A laboratory test (e.g. faecal test for lungworm or fluke) performed on a hedgehog
+type LabTest struct {
+	ID            uint           `json:"id" gorm:"primaryKey" example:"1" description:"Unique identifier"`
+	HedgehogID    uint           `json:"hedgehog_id" gorm:"index" example:"1" description:"ID of the hedgehog this test belongs to"`
+	TestType      string         `json:"test_type" gorm:"not null" example:"faecal_flotation" enums:"faecal_flotation,baermann,sedimentation,blood,other" description:"Type of laboratory test"`
+	SampleDate    time.Time      `json:"sample_date" example:"2024-01-15T10:30:00Z" description:"When the sample was collected" format:"date-time"`
+	Result        string         `json:"result" gorm:"default:'pending'" example:"positive" enums:"pending,negative,positive,inconclusive" description:"Outcome of the test"`
+	Parasites     string         `json:"parasites" example:"Crenosoma striatum" description:"Parasites identified in the sample"`
+	ParasiteLoad  string         `json:"parasite_load" example:"moderate" enums:"none,low,moderate,high" description:"Estimated parasite load"`
+	AttachmentURL string         `json:"attachment_url" example:"https://res.cloudinary.com/demo/raw/upload/lab-tests/report.pdf" description:"URL of the attached lab report"`
+	Notes         string         `json:"notes" example:"Campione raccolto al mattino" description:"Additional notes about the test"`
+	CreatedAt     time.Time      `json:"created_at" example:"2024-01-15T10:30:00Z" description:"When the record was created" format:"date-time"`
+	UpdatedAt     time.Time      `json:"updated_at" example:"2024-01-15T10:30:00Z" description:"When the record was last updated" format:"date-time"`
+	DeletedAt     gorm.DeletedAt `json:"-" gorm:"index" description:"Soft delete timestamp (not exposed in API)"`
+} // @LabTest
+
+// FeedingPlan model
+// @Description A feeding plan describing what and how often a hedgehog should eat
+type FeedingPlan struct {
+	ID          uint           `json:"id" gorm:"primaryKey" example:"1" description:"Unique identifier"`
+	HedgehogID  uint           `json:"hedgehog_id" gorm:"index" example:"1" description:"ID of the hedgehog this plan belongs to"`
+	FoodType    string         `json:"food_type" gorm:"not null" example:"Crocchette gatto" description:"Type of food"`
+	Grams       float64        `json:"grams" example:"30" description:"Grams of food per meal" minimum:"0"`
+	TimesPerDay int            `json:"times_per_day" gorm:"default:1" example:"2" description:"Number of meals per day" minimum:"1"`
+	StartDate   time.Time      `json:"start_date" example:"2024-01-15T00:00:00Z" description:"When the plan starts" format:"date-time"`
+	EndDate     *time.Time     `json:"end_date,omitempty" example:"2024-02-15T00:00:00Z" description:"When the plan ends, if limited" format:"date-time"`
+	Active      bool           `json:"active" example:"true" description:"Whether the plan is currently in use"`
+	Notes       string         `json:"notes" example:"Aggiungere acqua alle crocchette" description:"Additional feeding instructions"`
+	CreatedAt   time.Time      `json:"created_at" example:"2024-01-15T10:30:00Z" description:"When the record was created" format:"date-time"`
+	UpdatedAt   time.Time      `json:"updated_at" example:"2024-01-15T10:30:00Z" description:"When the record was last updated" format:"date-time"`
+	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index" description:"Soft delete timestamp (not exposed in API)"`
+} // @FeedingPlan
+
+// FoodIntake model
+// @Description A daily record of food offered to and eaten by a hedgehog
+type FoodIntake struct {
+	ID            uint           `json:"id" gorm:"primaryKey" example:"1" description:"Unique identifier"`
+	HedgehogID    uint           `json:"hedgehog_id" gorm:"index" example:"1" description:"ID of the hedgehog this record belongs to"`
+	FeedingPlanID *uint          `json:"feeding_plan_id,omitempty" example:"1" description:"ID of the feeding plan this intake refers to, if any"`
+	Date          time.Time      `json:"date" example:"2024-01-15T08:00:00Z" description:"When the food was checked" format:"date-time"`
+	FoodType      string         `json:"food_type" example:"Crocchette gatto" description:"Type of food"`
+	OfferedGrams  float64        `json:"offered_grams" example:"60" description:"Grams of food offered" minimum:"0"`
+	EatenGrams    float64        `json:"eaten_grams" example:"45" description:"Grams of food actually eaten" minimum:"0"`
+	Notes         string         `json:"notes" example:"Ha lasciato la carne" description:"Additional notes"`
+	CreatedAt     time.Time      `json:"created_at" example:"2024-01-15T10:30:00Z" description:"When the record was created" format:"date-time"`
+	UpdatedAt     time.Time      `json:"updated_at" example:"2024-01-15T10:30:00Z" description:"When the record was last updated" format:"date-time"`
+	DeletedAt     gorm.DeletedAt `json:"-" gorm:"index" description:"Soft delete timestamp (not exposed in API)"`
+} // @FoodIntake
+
+// TherapyDose model
+// @Description A single administered dose of a therapy
+type TherapyDose struct {
+	ID             uint           `json:"id" gorm:"primaryKey" example:"1" description:"Unique identifier"`
+	TherapyID      uint           `json:"therapy_id" gorm:"index" example:"1" description:"ID of the therapy"`
+	HedgehogID     uint           `json:"hedgehog_id" gorm:"index" example:"1" description:"ID of the hedgehog receiving the dose"`
+	AdministeredAt time.Time      `json:"administered_at" example:"2024-01-15T08:00:00Z" description:"When the dose was administered" format:"date-time"`
+	UserID         *uint          `json:"user_id,omitempty" example:"1" description:"ID of the user who administered the dose"`
+	Notes          string         `json:"notes" example:"Somministrata con il cibo" description:"Additional notes"`
+	CreatedAt      time.Time      `json:"created_at" example:"2024-01-15T10:30:00Z" description:"When the record was created" format:"date-time"`
+	UpdatedAt      time.Time      `json:"updated_at" example:"2024-01-15T10:30:00Z" description:"When the record was last updated" format:"date-time"`
+	DeletedAt      gorm.DeletedAt `json:"-" gorm:"index" description:"Soft delete timestamp (not exposed in API)"`
+} // @TherapyDose
+
+// DailyTask model
+// @Description A care task (weighing, medication, feeding) generated for a given day
+type DailyTask struct {
+	ID             uint           `json:"id" gorm:"primaryKey" example:"1" description:"Unique identifier"`
+	Key            string         `json:"key" gorm:"uniqueIndex;not null" example:"2024-01-15:medication:3:1" description:"Unique key preventing duplicate generation"`
+	Date           time.Time      `json:"date" gorm:"index" example:"2024-01-15T00:00:00Z" description:"Day the task refers to" format:"date-time"`
+	Type           string         `json:"type" gorm:"not null" example:"medication" enums:"weighing,medication,feeding" description:"Kind of task"`
+	HedgehogID     uint           `json:"hedgehog_id" gorm:"index" example:"1" description:"ID of the hedgehog the task refers to"`
+	HedgehogName   string         `json:"hedgehog_name" example:"Spillo" description:"Name of the hedgehog at generation time"`
+	RoomID         *uint          `json:"room_id" gorm:"index" example:"1" description:"ID of the room where the hedgehog is housed"`
+	AreaID         *uint          `json:"area_id" example:"1" description:"ID of the area where the hedgehog is housed"`
+	TherapyID      *uint          `json:"therapy_id,omitempty" example:"1" description:"ID of the therapy for medication tasks"`
+	FeedingPlanID  *uint          `json:"feeding_plan_id,omitempty" example:"1" description:"ID of the feeding plan for feeding tasks"`
+	Sequence       int            `json:"sequence" example:"1" description:"Progressive number of the dose or meal within the day"`
+	Title          string         `json:"title" example:"Antibiotico (dose 1/2)" description:"Short description of the task"`
+	Details        string         `json:"details" example:"0.1 ml" description:"Instructions for the task"`
+	AssignedUserID *uint          `json:"assigned_user_id" example:"1" description:"ID of the user the task is assigned to"`
+	Status         string         `json:"status" gorm:"default:'pending'" example:"pending" enums:"pending,done,skipped" description:"Current status of the task"`
+	CompletedAt    *time.Time     `json:"completed_at,omitempty" example:"2024-01-15T08:15:00Z" description:"When the task was completed" format:"date-time"`
+	CompletedByID  *uint          `json:"completed_by_id,omitempty" example:"1" description:"ID of the user who completed the task"`
+	RecordID       *uint          `json:"record_id,omitempty" example:"42" description:"ID of the weight record, food intake or therapy dose created on completion"`
+	Notes          string         `json:"notes" example:"Ha mangiato tutto" description:"Notes added on completion"`
+	CreatedAt      time.Time      `json:"created_at" example:"2024-01-15T10:30:00Z" description:"When the record was created" format:"date-time"`
+	UpdatedAt      time.Time      `json:"updated_at" example:"2024-01-15T10:30:00Z" description:"When the record was last updated" format:"date-time"`
+	DeletedAt      gorm.DeletedAt `json:"-" gorm:"index" description:"Soft delete timestamp (not exposed in API)"`
+} // @DailyTask
+
+// HibernationWake model
+// @Description A recorded waking of a hibernating hedgehog
+type HibernationWake struct {
 	ID         uint           `json:"id" gorm:"primaryKey" example:"1" description:"Unique identifier"`
-	HedgehogID uint           `json:"hedgehog_id" example:"1" description:"ID of the hedgehog this weight record belongs to"`
-	Weight     float64        `json:"weight" example:"450.5" description:"Weight in grams" minimum:"1"`
-	Date       time.Time      `json:"date" example:"2024-01-15T10:30:00Z" description:"When the weight was measured" format:"date-time"`
-	Notes      string         `json:"notes" example:"Peso stabile" description:"Additional notes about the weight measurement"`
+	HedgehogID uint           `json:"hedgehog_id" gorm:"index" example:"1" description:"ID of the hedgehog that woke up"`
+	Date       time.Time      `json:"date" example:"2024-12-05T07:30:00Z" description:"When the waking was observed" format:"date-time"`
+	Notes      string         `json:"notes" example:"Ha mangiato ed è tornato a dormire" description:"Additional notes"`
 	CreatedAt  time.Time      `json:"created_at" example:"2024-01-15T10:30:00Z" description:"When the record was created" format:"date-time"`
 	UpdatedAt  time.Time      `json:"updated_at" example:"2024-01-15T10:30:00Z" description:"When the record was last updated" format:"date-time"`
 	DeletedAt  gorm.DeletedAt `json:"-" gorm:"index" description:"Soft delete timestamp (not exposed in API)"`
-} // @WeightRecord
+} // @HibernationWake
+
+// ReleaseCriteria model
+// @Description Configurable criteria used to evaluate whether a hedgehog is ready for release
+type ReleaseCriteria struct {
+	ID                     uint       `json:"id" gorm:"primaryKey" example:"1" description:"Unique identifier"`
+	Enabled                bool       `json:"enabled" gorm:"default:true" example:"true" description:"Whether release candidates generate notifications"`
+	MinWeight              float64    `json:"min_weight" gorm:"default:600" example:"600" description:"Minimum weight in grams for release" minimum:"1"`
+	AllowedTrends          string     `json:"allowed_trends" gorm:"default:'improving,stable'" example:"improving,stable" description:"Comma separated weight trends considered acceptable"`
+	RequireNoTherapies     bool       `json:"require_no_therapies" gorm:"default:true" example:"true" description:"Whether all therapies must be completed before release"`
+	SeasonStartMonth       int        `json:"season_start_month" gorm:"default:4" example:"4" description:"First month of the release season (1-12)" minimum:"1" maximum:"12"`
+	SeasonEndMonth         int        `json:"season_end_month" gorm:"default:10" example:"10" description:"Last month of the release season (1-12)" minimum:"1" maximum:"12"`
+	MinTemperature         *float64   `json:"min_temperature" example:"8" description:"Minimum night temperature in °C for release, if checked"`
+	LastTemperature        *float64   `json:"last_temperature" example:"12.5" description:"Last reported night temperature in °C"`
+	LastTemperatureAt      *time.Time `json:"last_temperature_at" example:"2024-05-01T06:00:00Z" description:"When the last temperature was reported" format:"date-time"`
+	NotificationRepeatDays int        `json:"notification_repeat_days" gorm:"default:7" example:"7" description:"Days before a release notification is repeated for the same hedgehog" minimum:"1"`
+	CreatedAt              time.Time  `json:"created_at" example:"2024-01-15T10:30:00Z" description:"When the criteria were created" format:"date-time"`
+	UpdatedAt              time.Time  `json:"updated_at" example:"2024-01-15T10:30:00Z" description:"When the criteria were last updated" format:"date-time"`
+} // @ReleaseCriteria
+
+// NotificationRuleConfig model
+// @Description Per-rule configuration overriding the defaults of a notification rule
+type NotificationRuleConfig struct {
+	ID        uint                 `json:"id" gorm:"primaryKey" example:"1" description:"Unique identifier"`
+	Rule      string               `json:"rule" gorm:"uniqueIndex;not null" example:"weight_alert" description:"Name of the notification rule"`
+	Enabled   bool                 `json:"enabled" gorm:"default:true" example:"true" description:"Whether the rule generates notifications"`
+	Threshold *float64             `json:"threshold" example:"40" description:"Threshold overriding the default from the notification settings, in the rule's unit"`
+	Priority  NotificationPriority `json:"priority" example:"high" description:"Priority overriding the one chosen by the rule" enums:"low,medium,high,critical"`
+	CreatedAt time.Time            `json:"created_at" example:"2024-01-15T10:30:00Z" description:"When the configuration was created" format:"date-time"`
+	UpdatedAt time.Time            `json:"updated_at" example:"2024-01-15T10:30:00Z" description:"When the configuration was last updated" format:"date-time"`
+} // @NotificationRuleConfig
 
 // Notification types
 // @Description Type of notification that can be generated by the system
 type NotificationType string // @NotificationType
 
-// @enum therapy_expired therapy_expiring weight_drop weight_stagnation no_weighing hedgehog_recovered system_alert
+// @enum therapy_expired therapy_expiring weight_drop weight_stagnation no_weighing hedgehog_recovered lab_test_due low_food_intake hibernation_weight_loss hibernation_waking growth_behind system_alert
 const (
-	NotificationTherapyExpired    NotificationType = "therapy_expired"    // When a therapy has passed its end date
-	NotificationTherapyExpiring   NotificationType = "therapy_expiring"   // When a therapy is about to expire
-	NotificationWeightDrop        NotificationType = "weight_drop"        // When a hedgehog has lost significant weight
-	NotificationWeightStagnation  NotificationType = "weight_stagnation"  // When a hedgehog's weight hasn't changed for a period
-	NotificationNoWeighing        NotificationType = "no_weighing"        // When a hedgehog hasn't been weighed recently
-	NotificationHedgehogRecovered NotificationType = "hedgehog_recovered" // When a hedgehog has recovered and can be released
-	NotificationSystemAlert       NotificationType = "system_alert"       // System-level alerts
+	NotificationTherapyExpired    NotificationType = "therapy_expired"         // When a therapy has passed its end date
+	NotificationTherapyExpiring   NotificationType = "therapy_expiring"        // When a therapy is about to expire
+	NotificationWeightDrop        NotificationType = "weight_drop"             // When a hedgehog has lost significant weight
+	NotificationWeightStagnation  NotificationType = "weight_stagnation"       // When a hedgehog's weight hasn't changed for a period
+	NotificationNoWeighing        NotificationType = "no_weighing"             // When a hedgehog hasn't been weighed recently
+	NotificationHedgehogRecovered NotificationType = "hedgehog_recovered"      // When a hedgehog has recovered and can be released
+	NotificationLabTestDue        NotificationType = "lab_test_due"            // When a follow-up test is due after a deworming therapy
+	NotificationLowFoodIntake     NotificationType = "low_food_intake"         // When a hedgehog is eating much less than planned
+	NotificationHibernationLoss   NotificationType = "hibernation_weight_loss" // When a hibernating hedgehog loses too much body weight
+	NotificationHibernationWaking NotificationType = "hibernation_waking"      // When a hibernating hedgehog wakes up too often
+	NotificationGrowthBehind      NotificationType = "growth_behind"           // When a growing hedgehog falls behind its expected growth curve
+	NotificationSystemAlert       NotificationType = "system_alert"            // System-level alerts
 )
 
 // @Description Priority level for notifications
@@ -134,14 +335,121 @@ type Notification struct {
 	TherapyID   *uint                `json:"therapy_id" example:"1" description:"ID of the related therapy, if applicable"`
 	Therapy     *Therapy             `json:"therapy,omitempty" gorm:"foreignKey:TherapyID" description:"Related therapy information"`
 	Data        string               `json:"data" example:"{\"days_overdue\": 5}" description:"Additional JSON data related to the notification"`
-	Read        bool                 `json:"read" gorm:"default:false" example:"false" description:"Whether the notification has been read"`
-	Dismissed   bool                 `json:"dismissed" gorm:"default:false" example:"false" description:"Whether the notification has been dismissed"`
+	Read        bool                 `json:"read" gorm:"default:false" example:"false" description:"Whether the notification has been read by the requesting user"`
+	Dismissed   bool                 `json:"dismissed" gorm:"default:false" example:"false" description:"Whether the notification has been dismissed by the requesting user"`
 	CreatedAt   time.Time            `json:"created_at" example:"2024-01-15T10:30:00Z" description:"When the notification was created" format:"date-time"`
 	ExpiresAt   *time.Time           `json:"expires_at" example:"2024-02-15T10:30:00Z" description:"When the notification expires" format:"date-time"`
 	ActionURL   string               `json:"action_url" example:"/hedgehogs/1" description:"URL for the action button"`
 	ActionLabel string               `json:"action_label" example:"Gestisci Terapia" description:"Label for the action button"`
+
+	// Variabili del modello, per ricomporre titolo e messaggio in altre lingue
+	TemplateData string `json:"template_data,omitempty" example:"{\"Hedgehog\": \"Spillo\", \"DaysSince\": 9}" description:"JSON variables used to render title and message from the notification template"`
+	Locale       string `json:"locale,omitempty" example:"it" enums:"it,en" description:"Locale of title and message"`
+
+	// Presa in carico ed escalation
+	AcknowledgedAt  *time.Time               `json:"acknowledged_at" example:"2024-01-15T11:00:00Z" description:"When the notification was acknowledged" format:"date-time"`
+	AcknowledgedBy  *uint                    `json:"acknowledged_by" example:"1" description:"ID of the user who acknowledged the notification"`
+	EscalationLevel int                      `json:"escalation_level" gorm:"default:0" example:"1" description:"Number of escalation steps performed"`
+	EscalatedAt     *time.Time               `json:"escalated_at" example:"2024-01-15T11:00:00Z" description:"When the last escalation step was performed" format:"date-time"`
+	Escalations     []NotificationEscalation `json:"escalations,omitempty" gorm:"foreignKey:NotificationID" description:"Escalation steps performed for the notification"`
+
+	// Assegnazione, posticipo e risoluzione
+	AssignedTo         *uint      `json:"assigned_to" gorm:"index" example:"2" description:"ID of the user the notification is assigned to"`
+	AssignedAt         *time.Time `json:"assigned_at" example:"2024-01-15T11:00:00Z" description:"When the notification was assigned" format:"date-time"`
+	SnoozedUntil       *time.Time `json:"snoozed_until" example:"2024-01-16T08:00:00Z" description:"Until when the notification is hidden and not escalated or repeated" format:"date-time"`
+	SnoozedBy          *uint      `json:"snoozed_by" example:"1" description:"ID of the user who snoozed the notification"`
+	ResolvedAt         *time.Time `json:"resolved_at" gorm:"index" example:"2024-01-15T12:00:00Z" description:"When the notification was resolved" format:"date-time"`
+	ResolvedBy         *uint      `json:"resolved_by" example:"1" description:"ID of the user who resolved the notification"`
+	ResolutionNote     string     `json:"resolution_note" example:"Pesato dopo il pasto serale" description:"Note left when resolving"`
+	ResolutionAction   string     `json:"resolution_action" example:"weight_record" enums:"weight_record,therapy,therapy_dose,lab_test,food_intake,daily_task" description:"Kind of record that resolved the notification"`
+	ResolutionActionID *uint      `json:"resolution_action_id" example:"42" description:"ID of the record that resolved the notification"`
 } // @Notification
 
+// NotificationEvent model
+// @Description Change to a notification pushed on the live stream and replayed on reconnection
+type NotificationEvent struct {
+	ID             uint      `json:"id" gorm:"primaryKey" example:"42" description:"Event ID, used as SSE id and Last-Event-ID"`
+	Type           string    `json:"type" gorm:"not null;index" example:"notification.created" enums:"notification.created,notification.read,notification.dismissed,notification.acknowledged,notification.assigned,notification.snoozed,notification.resolved,notification.reopened" description:"Type of change"`
+	NotificationID uint      `json:"notification_id" gorm:"not null" example:"1" description:"ID of the notification"`
+	UserID         *uint     `json:"user_id" gorm:"index" example:"1" description:"User the event belongs to, null for events visible to everyone"`
+	Payload        string    `json:"payload" example:"{\"id\": 1}" description:"JSON payload sent to the clients"`
+	CreatedAt      time.Time `json:"created_at" example:"2024-01-15T10:30:00Z" description:"When the event was recorded" format:"date-time"`
+} // @NotificationEvent
+
+// StreamTicket model
+// @Description Single-use ticket opening the notification stream, shared by all instances
+type StreamTicket struct {
+	Ticket    string    `json:"ticket" gorm:"primaryKey;size:32" example:"3f2a9c0d8e7b6a5f4e3d2c1b0a998877" description:"Random ticket value"`
+	UserID    uint      `json:"user_id" gorm:"not null" example:"1" description:"User the ticket was issued to"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"not null;index" example:"2024-01-15T10:31:00Z" description:"When the ticket stops being valid" format:"date-time"`
+} // @StreamTicket
+
+// NotificationHistory model
+// @Description Shared change to a notification kept as its audit trail until the notification is deleted
+type NotificationHistory struct {
+	ID             uint      `json:"id" gorm:"primaryKey" example:"1" description:"History entry ID"`
+	NotificationID uint      `json:"notification_id" gorm:"not null;index" example:"1" description:"ID of the notification"`
+	Type           string    `json:"type" gorm:"not null" example:"notification.assigned" enums:"notification.created,notification.acknowledged,notification.assigned,notification.snoozed,notification.resolved,notification.reopened" description:"Type of change"`
+	Payload        string    `json:"payload" example:"{\"assigned_to\": 2}" description:"JSON details of the change"`
+	CreatedAt      time.Time `json:"created_at" example:"2024-01-15T10:30:00Z" description:"When the change happened" format:"date-time"`
+} // @NotificationHistory
+
+// EscalationPolicy model
+// @Description Policy re-notifying unacknowledged notifications of a given priority through the next channel and on-call user
+type EscalationPolicy struct {
+	ID                uint                 `json:"id" gorm:"primaryKey" example:"1" description:"Unique identifier"`
+	Name              string               `json:"name" gorm:"not null" example:"Critici notturni" description:"Name of the policy"`
+	Priority          NotificationPriority `json:"priority" gorm:"not null;index" example:"critical" enums:"low,medium,high,critical" description:"Priority of the notifications the policy applies to"`
+	Types             string               `json:"types" example:"weight_drop,hibernation_weight_loss" description:"Comma separated notification types the policy applies to, empty for all"`
+	AckTimeoutMinutes int                  `json:"ack_timeout_minutes" gorm:"default:30" example:"30" description:"Minutes without acknowledgement before the next escalation step" minimum:"1"`
+	Channels          string               `json:"channels" gorm:"default:'in_app,email,webhook'" example:"in_app,email,webhook" description:"Comma separated escalation channels, in order; the first is the initial in-app notification"`
+	Enabled           bool                 `json:"enabled" example:"true" description:"Whether the policy is active"`
+	CreatedAt         time.Time            `json:"created_at" example:"2024-01-15T10:30:00Z" description:"When the policy was created" format:"date-time"`
+	UpdatedAt         time.Time            `json:"updated_at" example:"2024-01-15T10:30:00Z" description:"When the policy was last updated" format:"date-time"`
+} // @EscalationPolicy
+
+// NotificationEscalation model
+// @Description A single escalation step performed for a notification
+type NotificationEscalation struct {
+	ID             uint      `json:"id" gorm:"primaryKey" example:"1" description:"Unique identifier"`
+	NotificationID uint      `json:"notification_id" gorm:"not null;index" example:"1" description:"ID of the escalated notification"`
+	PolicyID       uint      `json:"policy_id" example:"1" description:"ID of the escalation policy applied"`
+	Step           int       `json:"step" example:"1" description:"Escalation step number, starting from 1"`
+	Channel        string    `json:"channel" example:"email" enums:"in_app,email,webhook" description:"Channel used for this step"`
+	UserID         *uint     `json:"user_id" example:"2" description:"On-call user notified in this step"`
+	Recipient      string    `json:"recipient" example:"volontario@laninna.it" description:"Email address the step was sent to; webhook steps go to every matching webhook subscription"`
+	Status         string    `json:"status" example:"queued" enums:"sent,queued,failed,skipped" description:"Outcome of the step; email and webhook steps are queued and sent by the job queue"`
+	Error          string    `json:"error,omitempty" example:"smtp: connection refused" description:"Error message if the step failed"`
+	CreatedAt      time.Time `json:"created_at" example:"2024-01-15T11:00:00Z" description:"When the step was performed" format:"date-time"`
+} // @NotificationEscalation
+
+// NotificationReceipt model
+// @Description Read and dismissed state of a notification for a single user
+type NotificationReceipt struct {
+	ID             uint       `json:"id" gorm:"primaryKey" example:"1" description:"Unique identifier"`
+	NotificationID uint       `json:"notification_id" gorm:"not null;uniqueIndex:idx_receipt_notification_user" example:"1" description:"ID of the notification"`
+	UserID         uint       `json:"user_id" gorm:"not null;uniqueIndex:idx_receipt_notification_user;index" example:"1" description:"ID of the user"`
+	Read           bool       `json:"read" gorm:"default:false" example:"true" description:"Whether the user has read the notification"`
+	ReadAt         *time.Time `json:"read_at" example:"2024-01-15T10:30:00Z" description:"When the user read the notification" format:"date-time"`
+	Dismissed      bool       `json:"dismissed" gorm:"default:false" example:"false" description:"Whether the user has dismissed the notification"`
+	DismissedAt    *time.Time `json:"dismissed_at" example:"2024-01-15T10:30:00Z" description:"When the user dismissed the notification" format:"date-time"`
+	CreatedAt      time.Time  `json:"created_at" example:"2024-01-15T10:30:00Z" description:"When the receipt was created" format:"date-time"`
+	UpdatedAt      time.Time  `json:"updated_at" example:"2024-01-15T10:30:00Z" description:"When the receipt was last updated" format:"date-time"`
+} // @NotificationReceipt
+
+// NotificationSubscription model
+// @Description Notification preferences of a user, filtering the inbox by type, priority and room
+type NotificationSubscription struct {
+	ID          uint                 `json:"id" gorm:"primaryKey" example:"1" description:"Unique identifier"`
+	UserID      uint                 `json:"user_id" gorm:"uniqueIndex;not null" example:"1" description:"ID of the user"`
+	Types       string               `json:"types" example:"weight_drop,therapy_expired" description:"Comma separated notification types to receive, empty for all"`
+	MinPriority NotificationPriority `json:"min_priority" gorm:"default:'low'" example:"medium" enums:"low,medium,high,critical" description:"Lowest priority to receive"`
+	RoomIDs     string               `json:"room_ids" example:"1,3" description:"Comma separated room IDs whose hedgehogs generate notifications for the user, empty for all"`
+	Locale      string               `json:"locale" example:"en" enums:"it,en" description:"Language of notifications and emails, empty for the default"`
+	CreatedAt   time.Time            `json:"created_at" example:"2024-01-15T10:30:00Z" description:"When the preferences were created" format:"date-time"`
+	UpdatedAt   time.Time            `json:"updated_at" example:"2024-01-15T10:30:00Z" description:"When the preferences were last updated" format:"date-time"`
+} // @NotificationSubscription
+
 // NotificationSettings model
 // @Description Configuration settings for the notification system
 type NotificationSettings struct {
@@ -152,9 +460,217 @@ type NotificationSettings struct {
 	WeightDropDays            int       `json:"weight_drop_days" gorm:"default:7" example:"7" description:"Period in days to check for weight drops" minimum:"1"`
 	WeightStagnationDays      int       `json:"weight_stagnation_days" gorm:"default:14" example:"14" description:"Days of weight stagnation before notification" minimum:"1"`
 	NoWeighingDays            int       `json:"no_weighing_days" gorm:"default:7" example:"7" description:"Days without weighing before notification" minimum:"1"`
+	LabTestFollowUpDays       int       `json:"lab_test_follow_up_days" gorm:"default:14" example:"14" description:"Days after the end of a deworming therapy before a follow-up test is due" minimum:"1"`
+	LowIntakeThreshold        float64   `json:"low_intake_threshold" gorm:"default:50" example:"50" description:"Percentage of the planned food below which intake is considered too low" minimum:"1" maximum:"100"`
+	LowIntakeDays             int       `json:"low_intake_days" gorm:"default:2" example:"2" description:"Consecutive days of low intake before notification" minimum:"1"`
+	HibernationNoWeighingDays int       `json:"hibernation_no_weighing_days" gorm:"default:21" example:"21" description:"Days without weighing before notification while hibernating" minimum:"1"`
+	HibernationDropThreshold  float64   `json:"hibernation_drop_threshold" gorm:"default:100" example:"100" description:"Threshold in grams for weight drop notifications while hibernating" minimum:"1"`
+	HibernationMaxLossPercent float64   `json:"hibernation_max_loss_percent" gorm:"default:20" example:"20" description:"Maximum percentage of body weight lost since the start of hibernation" minimum:"1" maximum:"100"`
+	HibernationMaxWakes       int       `json:"hibernation_max_wakes" gorm:"default:3" example:"3" description:"Maximum number of wakings within the wake window before notification" minimum:"1"`
+	HibernationWakeWindowDays int       `json:"hibernation_wake_window_days" gorm:"default:7" example:"7" description:"Window in days used to count wakings" minimum:"1"`
+	TrendWindowDays           int       `json:"trend_window_days" gorm:"default:14" example:"14" description:"Days of weight history used by the trend regression" minimum:"1"`
+	TrendMethod               string    `json:"trend_method" gorm:"default:'least_squares'" example:"least_squares" enums:"least_squares,theil_sen" description:"Regression method used for the weight trend"`
+	TrendImprovingPercent     float64   `json:"trend_improving_percent" gorm:"default:0.3" example:"0.3" description:"Daily gain as percentage of body weight above which the trend is improving"`
+	TrendDecliningPercent     float64   `json:"trend_declining_percent" gorm:"default:0.3" example:"0.3" description:"Daily loss as percentage of body weight above which the trend is declining"`
+	TrendCriticalPercent      float64   `json:"trend_critical_percent" gorm:"default:1" example:"1" description:"Daily loss as percentage of body weight above which the trend is critical"`
+	TrendMinSamples           int       `json:"trend_min_samples" gorm:"default:3" example:"3" description:"Minimum number of weighings in the window to estimate a trend" minimum:"2"`
+	WeightOutlierPercent      float64   `json:"weight_outlier_percent" gorm:"default:20" example:"20" description:"Base percentage change from recent weights above which a new weight is flagged as implausible" minimum:"1"`
+	WeightOutlierDailyPercent float64   `json:"weight_outlier_daily_percent" gorm:"default:3" example:"3" description:"Additional percentage allowed for each day since the previous weighing" minimum:"0"`
+	GrowthDropPercent         float64   `json:"growth_drop_percent" gorm:"default:8" example:"8" description:"Weight drop as percentage of the expected weight that triggers a notification, capped by weight_drop_threshold" minimum:"1" maximum:"100"`
+	GrowthMinZScore           float64   `json:"growth_min_z_score" gorm:"default:-2" example:"-2" description:"Z-score on the growth curve below which the hedgehog is considered underweight"`
+	GrowthMinGainRatio        float64   `json:"growth_min_gain_ratio" gorm:"default:0.5" example:"0.5" description:"Minimum ratio between actual and expected daily gain for growing hedgehogs" minimum:"0"`
 	EmailNotificationsEnabled bool      `json:"email_notifications_enabled" gorm:"default:false" example:"false" description:"Whether to send notifications via email"`
 	EmailAddress              string    `json:"email_address" example:"admin@laninna.org" description:"Email address for notifications"`
-	WebhookURL                string    `json:"webhook_url" example:"https://hooks.slack.com/services/xxx" description:"Webhook URL for external notifications"`
+	WebhookURL                string    `json:"webhook_url" example:"https://example.org/hooks/laninna" description:"Webhook URL receiving the native JSON payload; use webhook subscriptions for chat platforms"`
 	CreatedAt                 time.Time `json:"created_at" example:"2024-01-15T10:30:00Z" description:"When the settings were created" format:"date-time"`
 	UpdatedAt                 time.Time `json:"updated_at" example:"2024-01-15T10:30:00Z" description:"When the settings were last updated" format:"date-time"`
 } // @NotificationSettings
+
+// WebhookSubscription model
+// @Description Webhook endpoint receiving signed notifications matching its filters
+type WebhookSubscription struct {
+	ID          uint      `json:"id" gorm:"primaryKey" example:"1" description:"Unique identifier"`
+	Name        string    `json:"name" gorm:"not null" example:"Gestionale veterinario" description:"Name of the subscription"`
+	URL         string    `json:"url" gorm:"not null" example:"https://example.org/hooks/laninna" description:"Endpoint receiving the webhook; responses mask path and query (https://example.org/***), send the masked value back to keep it"`
+	Secret      string    `json:"secret,omitempty" example:"whsec_3f1c..." description:"Secret used to sign deliveries; returned only when the subscription is created"`
+	Events      string    `json:"events" example:"notification.created,hedgehog.status_changed" description:"Comma separated events to deliver (see /webhook-events), empty for notification.created only"`
+	Types       string    `json:"types" example:"weight_drop,therapy_expired" description:"Comma separated notification types to deliver, empty for all"`
+	Priorities  string    `json:"priorities" example:"high,critical" description:"Comma separated priorities to deliver, empty for all"`
+	Format      string    `json:"format" gorm:"default:'json'" example:"json" enums:"json,slack,discord,telegram,matrix" description:"Payload format: native JSON or a chat platform message"`
+	ChatID      string    `json:"chat_id" example:"-1001234567890" description:"Telegram chat ID or Matrix room ID, required by those formats"`
+	AccessToken string    `json:"access_token,omitempty" example:"syt_..." description:"Matrix access token; never returned after creation"`
+	Enabled     bool      `json:"enabled" example:"true" description:"Whether deliveries are sent"`
+	CreatedAt   time.Time `json:"created_at" example:"2024-01-15T10:30:00Z" description:"When the subscription was created" format:"date-time"`
+	UpdatedAt   time.Time `json:"updated_at" example:"2024-01-15T10:30:00Z" description:"When the subscription was last updated" format:"date-time"`
+} // @WebhookSubscription
+
+// WebhookDelivery model
+// @Description Log of a single webhook delivery attempt
+type WebhookDelivery struct {
+	ID             uint      `json:"id" gorm:"primaryKey" example:"1" description:"Unique identifier"`
+	SubscriptionID uint      `json:"subscription_id" gorm:"not null;index" example:"1" description:"ID of the webhook subscription"`
+	NotificationID *uint     `json:"notification_id" gorm:"index" example:"1" description:"ID of the delivered notification, if any"`
+	Event          string    `json:"event" example:"notification.created" description:"Event delivered"`
+	URL            string    `json:"url" example:"https://example.org/***" description:"Endpoint the delivery was sent to, with path and query masked"`
+	RequestBody    string    `json:"request_body" description:"Body sent, reused for manual redelivery"`
+	StatusCode     int       `json:"status_code" example:"200" description:"HTTP status code returned by the endpoint, 0 if unreachable"`
+	ResponseBody   string    `json:"response_body" example:"ok" description:"Beginning of the response body"`
+	Error          string    `json:"error,omitempty" example:"webhook returned status 500" description:"Error of the delivery, if failed"`
+	Success        bool      `json:"success" example:"true" description:"Whether the endpoint answered with a 2xx status"`
+	DurationMs     int64     `json:"duration_ms" example:"134" description:"Duration of the request in milliseconds"`
+	RedeliveryOf   *uint     `json:"redelivery_of" example:"3" description:"ID of the delivery this one manually repeats"`
+	CreatedAt      time.Time `json:"created_at" example:"2024-01-15T10:30:00Z" description:"When the delivery was attempted" format:"date-time"`
+} // @WebhookDelivery
+
+// Job model
+// @Description Background job stored in the database and executed by the worker pool
+type Job struct {
+	ID          uint       `json:"id" gorm:"primaryKey" example:"1" description:"Unique identifier"`
+	Kind        string     `json:"kind" gorm:"not null;index" example:"notifications.email" description:"Kind of job, selects the handler"`
+	Payload     string     `json:"payload" example:"{\"notification_id\": 1}" description:"JSON payload passed to the handler"`
+	Status      string     `json:"status" gorm:"not null;default:'pending';index:idx_jobs_status_run_at" example:"pending" enums:"pending,running,done,failed" description:"Current status of the job"`
+	Attempts    int        `json:"attempts" gorm:"default:0" example:"1" description:"Number of attempts made"`
+	MaxAttempts int        `json:"max_attempts" gorm:"default:5" example:"5" description:"Attempts before the job is moved to the dead-letter table"`
+	RunAt       time.Time  `json:"run_at" gorm:"index:idx_jobs_status_run_at" example:"2024-01-15T10:30:00Z" description:"When the job becomes eligible to run" format:"date-time"`
+	LockedAt    *time.Time `json:"locked_at" example:"2024-01-15T10:30:00Z" description:"When a worker claimed the job" format:"date-time"`
+	LockedBy    string     `json:"locked_by" example:"web-1:4242" description:"Worker that claimed the job"`
+	LastError   string     `json:"last_error,omitempty" example:"webhook returned status 500" description:"Error of the last failed attempt"`
+	CompletedAt *time.Time `json:"completed_at" example:"2024-01-15T10:30:05Z" description:"When the job completed successfully" format:"date-time"`
+	CreatedAt   time.Time  `json:"created_at" example:"2024-01-15T10:30:00Z" description:"When the job was enqueued" format:"date-time"`
+	UpdatedAt   time.Time  `json:"updated_at" example:"2024-01-15T10:30:00Z" description:"When the job was last updated" format:"date-time"`
+} // @Job
+
+// DeadJob model
+// @Description Job that exhausted its attempts, kept for inspection and manual retry
+type DeadJob struct {
+	ID        uint      `json:"id" gorm:"primaryKey" example:"1" description:"Unique identifier"`
+	JobID     uint      `json:"job_id" gorm:"index" example:"1" description:"ID of the original job"`
+	Kind      string    `json:"kind" gorm:"not null;index" example:"notifications.webhook" description:"Kind of job"`
+	Payload   string    `json:"payload" example:"{\"notification_id\": 1}" description:"JSON payload of the job"`
+	Attempts  int       `json:"attempts" example:"5" description:"Number of attempts made"`
+	LastError string    `json:"last_error" example:"webhook returned status 500" description:"Error of the last attempt"`
+	CreatedAt time.Time `json:"created_at" example:"2024-01-15T12:30:00Z" description:"When the job was moved to the dead-letter table" format:"date-time"`
+} // @DeadJob
+
+// EmailTransportSettings model
+// @Description Outgoing email configuration; once saved it overrides the EMAIL_* environment variables
+type EmailTransportSettings struct {
+	ID           uint      `json:"id" gorm:"primaryKey" example:"1" description:"Unique identifier"`
+	Transport    string    `json:"transport" gorm:"default:'smtp'" example:"smtp" enums:"smtp,sendmail,file,memory" description:"How emails are sent: SMTP server, local sendmail, .eml files in a mailbox directory or kept in memory"`
+	SMTPHost     string    `json:"smtp_host" example:"smtp.gmail.com" description:"SMTP server host"`
+	SMTPPort     string    `json:"smtp_port" example:"587" description:"SMTP server port"`
+	Security     string    `json:"security" gorm:"default:'starttls'" example:"starttls" enums:"starttls,tls,none" description:"Connection security: STARTTLS upgrade, implicit TLS or plain text"`
+	AuthMode     string    `json:"auth_mode" gorm:"default:'plain'" example:"plain" enums:"plain,login,cram-md5,none" description:"SMTP authentication mechanism"`
+	Username     string    `json:"username" example:"notifiche@laninna.it" description:"SMTP username"`
+	Password     string    `json:"password,omitempty" gorm:"-" example:"app-password" description:"SMTP password; set only with EMAIL_PASSWORD, never stored in the database nor returned by the API"`
+	From         string    `json:"from" example:"notifications@laninna.it" description:"Sender address"`
+	SendmailPath string    `json:"sendmail_path" gorm:"-" example:"/usr/sbin/sendmail" description:"Path of the sendmail binary for the sendmail transport; read-only, set with EMAIL_SENDMAIL_PATH"`
+	MailboxDir   string    `json:"mailbox_dir" gorm:"-" example:"./mailbox" description:"Directory receiving .eml files for the file transport; read-only, set with EMAIL_MAILBOX_DIR"`
+	Enabled      bool      `json:"enabled" gorm:"default:true" example:"true" description:"Whether emails are sent"`
+	CreatedAt    time.Time `json:"created_at" example:"2024-01-15T10:30:00Z" description:"When the settings were created" format:"date-time"`
+	UpdatedAt    time.Time `json:"updated_at" example:"2024-01-15T10:30:00Z" description:"When the settings were last updated" format:"date-time"`
+} // @EmailTransportSettings
+
+// NotificationTemplate model
+// @Description Custom template for a notification type, channel and locale, overriding the built-in default
+type NotificationTemplate struct {
+	ID        uint      `json:"id,omitempty" gorm:"primaryKey" example:"1" description:"Unique identifier, empty for built-in templates"`
+	Type      string    `json:"type" gorm:"not null;uniqueIndex:idx_template_key" example:"weight_drop" description:"Notification type, or * for the generic email template"`
+	Channel   string    `json:"channel" gorm:"not null;uniqueIndex:idx_template_key" example:"in_app" enums:"in_app,email" description:"Channel: in-app title and message, or email subject and HTML body"`
+	Locale    string    `json:"locale" gorm:"not null;uniqueIndex:idx_template_key" example:"en" enums:"it,en" description:"Locale of the template"`
+	Title     string    `json:"title" gorm:"not null" example:"Weight Alert: {{.Hedgehog}}" description:"Template of the title (email subject for the email channel)"`
+	Body      string    `json:"body" gorm:"not null" example:"{{.Hedgehog}} now weighs {{printf \"%.0f\" .CurrentWeight}}g" description:"Template of the message (HTML body for the email channel)"`
+	Custom    bool      `json:"custom" gorm:"-" example:"true" description:"Whether the template overrides the built-in default"`
+	UpdatedBy *uint     `json:"updated_by,omitempty" example:"1" description:"ID of the user who last edited the template"`
+	CreatedAt time.Time `json:"created_at,omitempty" example:"2024-01-15T10:30:00Z" description:"When the template was created" format:"date-time"`
+	UpdatedAt time.Time `json:"updated_at,omitempty" example:"2024-01-15T10:30:00Z" description:"When the template was last updated" format:"date-time"`
+} // @NotificationTemplate
+
+// DigestSchedule model
+// @Description Periodic email digest of notifications for a recipient
+type DigestSchedule struct {
+	ID          uint                 `json:"id" gorm:"primaryKey" example:"1" description:"Unique identifier"`
+	Name        string               `json:"name" gorm:"not null" example:"Riassunto mattutino" description:"Name of the digest"`
+	UserID      *uint                `json:"user_id" gorm:"index" example:"1" description:"User receiving the digest; their preferences and dismissed notifications are applied"`
+	Email       string               `json:"email" example:"volontario@laninna.it" description:"Recipient address, defaults to the user's email"`
+	Cron        string               `json:"cron" gorm:"not null" example:"0 8 * * *" description:"Five-field cron expression (minute hour day month weekday) or @hourly, @daily, @weekly, @monthly"`
+	Timezone    string               `json:"timezone" gorm:"default:'Europe/Rome'" example:"Europe/Rome" description:"IANA timezone the cron expression is evaluated in"`
+	Frequency   string               `json:"frequency" gorm:"default:'daily'" example:"daily" enums:"hourly,daily,weekly" description:"Period covered by the first digest and default cron when none is given; later digests cover the time since the previous one"`
+	MinPriority NotificationPriority `json:"min_priority" gorm:"default:'low'" example:"medium" enums:"low,medium,high,critical" description:"Lowest priority included"`
+	Sections    string               `json:"sections" example:"overdue_therapies,weight_alerts" description:"Comma separated sections to include (see /digest-sections), empty for all"`
+	Enabled     bool                 `json:"enabled" example:"true" description:"Whether the digest is sent"`
+	LastRunAt   *time.Time           `json:"last_run_at" example:"2024-01-15T08:00:00Z" description:"When the digest last ran" format:"date-time"`
+	NextRunAt   *time.Time           `json:"next_run_at" gorm:"index" example:"2024-01-16T08:00:00Z" description:"When the digest will run next" format:"date-time"`
+	LastCount   int                  `json:"last_count" example:"5" description:"Notifications included in the last digest"`
+	LastError   string               `json:"last_error" example:"dial tcp: connection refused" description:"Error of the last run, empty if it succeeded"`
+	CreatedAt   time.Time            `json:"created_at" example:"2024-01-15T10:30:00Z" description:"When the schedule was created" format:"date-time"`
+	UpdatedAt   time.Time            `json:"updated_at" example:"2024-01-15T10:30:00Z" description:"When the schedule was last updated" format:"date-time"`
+} // @DigestSchedule
+
+// ScheduledJob model
+// @Description Periodic job run by the scheduler, with its cron expression and last run
+type ScheduledJob struct {
+	ID             uint       `json:"id" gorm:"primaryKey" example:"1" description:"Unique identifier"`
+	Name           string     `json:"name" gorm:"uniqueIndex;not null" example:"notification-check" description:"Unique name of the job"`
+	Description    string     `json:"description" example:"Evaluate all notification rules" description:"What the job does"`
+	Cron           string     `json:"cron" gorm:"not null" example:"*/30 * * * *" description:"Cron expression, evaluated in the server timezone"`
+	Paused         bool       `json:"paused" gorm:"default:false" example:"false" description:"Whether scheduled runs are suspended; manual triggers still work"`
+	Status         string     `json:"status" gorm:"default:'idle'" example:"succeeded" enums:"idle,running,succeeded,failed" description:"Status of the current or last run"`
+	NextRunAt      *time.Time `json:"next_run_at" example:"2024-01-15T11:00:00Z" description:"When the job will run next" format:"date-time"`
+	LastRunAt      *time.Time `json:"last_run_at" example:"2024-01-15T10:30:00Z" description:"When the last run started" format:"date-time"`
+	LastDurationMs int64      `json:"last_duration_ms" example:"420" description:"Duration of the last run in milliseconds"`
+	LastError      string     `json:"last_error" example:"database is locked" description:"Error of the last run, empty if it succeeded"`
+	RunCount       int        `json:"run_count" example:"48" description:"Number of completed runs"`
+	LockedBy       string     `json:"locked_by" example:"web-1:4242" description:"Instance running the job, empty when idle"`
+	LockedUntil    *time.Time `json:"locked_until" example:"2024-01-15T10:45:00Z" description:"Expiry of the run lock, after which another instance may take over" format:"date-time"`
+	CreatedAt      time.Time  `json:"created_at" example:"2024-01-15T10:30:00Z" description:"When the job was first registered" format:"date-time"`
+	UpdatedAt      time.Time  `json:"updated_at" example:"2024-01-15T10:30:00Z" description:"When the job was last updated" format:"date-time"`
+} // @ScheduledJob
+
+// ScheduledJobRun model
+// @Description A single run of a scheduled job
+type ScheduledJobRun struct {
+	ID         uint      `json:"id" gorm:"primaryKey" example:"1" description:"Unique identifier"`
+	JobName    string    `json:"job_name" gorm:"not null;index" example:"notification-check" description:"Name of the scheduled job"`
+	Trigger    string    `json:"trigger" example:"schedule" enums:"schedule,manual" description:"Whether the run was scheduled or triggered manually"`
+	Instance   string    `json:"instance" example:"web-1:4242" description:"Instance that ran the job"`
+	Status     string    `json:"status" example:"succeeded" enums:"succeeded,failed" description:"Outcome of the run"`
+	Error      string    `json:"error" example:"database is locked" description:"Error returned by the job, if any"`
+	StartedAt  time.Time `json:"started_at" example:"2024-01-15T10:30:00Z" description:"When the run started" format:"date-time"`
+	DurationMs int64     `json:"duration_ms" example:"420" description:"Duration in milliseconds"`
+} // @ScheduledJobRun
+
+// QuietHours model
+// @Description Daily window in which non-critical external notifications are held for a channel and recipient
+type QuietHours struct {
+	ID        uint      `json:"id" gorm:"primaryKey" example:"1" description:"Unique identifier"`
+	Channel   string    `json:"channel" gorm:"index" example:"email" enums:"email,webhook" description:"Channel the window applies to, empty for all external channels"`
+	Recipient string    `json:"recipient" gorm:"index" example:"volontario@laninna.it" description:"Email address or webhook URL the window applies to, empty for every recipient of the channel"`
+	UserID    *uint     `json:"user_id" example:"1" description:"User whose email address is the recipient; fills recipient when it is empty"`
+	Start     string    `json:"start" gorm:"not null" example:"22:00" description:"Start of the window (HH:MM)"`
+	End       string    `json:"end" gorm:"not null" example:"07:00" description:"End of the window (HH:MM); earlier than start for windows crossing midnight"`
+	Days      string    `json:"days" example:"0,6" description:"Comma separated weekdays (0 = Sunday) on which the window starts, empty for every day"`
+	Timezone  string    `json:"timezone" gorm:"default:'Europe/Rome'" example:"Europe/Rome" description:"IANA timezone of start and end"`
+	Enabled   bool      `json:"enabled" example:"true" description:"Whether the window is applied"`
+	CreatedAt time.Time `json:"created_at" example:"2024-01-15T10:30:00Z" description:"When the window was created" format:"date-time"`
+	UpdatedAt time.Time `json:"updated_at" example:"2024-01-15T10:30:00Z" description:"When the window was last updated" format:"date-time"`
+} // @QuietHours
+
+// OnCallShift model
+// @Description Day of the on-call rota assigned to a user, either a recurring weekday or a specific date
+type OnCallShift struct {
+	ID        uint      `json:"id" gorm:"primaryKey" example:"1" description:"Unique identifier"`
+	UserID    uint      `json:"user_id" gorm:"not null;index" example:"1" description:"ID of the user on call"`
+	User      *User     `json:"user,omitempty" gorm:"foreignKey:UserID" description:"User on call"`
+	Weekday   *int      `json:"weekday" example:"6" minimum:"0" maximum:"6" description:"Recurring weekday (0 = Sunday); ignored on dates that have specific shifts"`
+	Date      string    `json:"date" gorm:"index" example:"2024-12-25" description:"Specific date (YYYY-MM-DD) overriding the weekly rota"`
+	Note      string    `json:"note" example:"Turno di Natale" description:"Optional note"`
+	CreatedAt time.Time `json:"created_at" example:"2024-01-15T10:30:00Z" description:"When the shift was created" format:"date-time"`
+} // @OnCallShift
+
+// SeedMarker model
+// @Description Record that a set of default data has been created, so it is not created again after being deleted
+type SeedMarker struct {
+	Name      string    `json:"name" gorm:"primaryKey" example:"growth_curve" description:"Name of the default data set"`
+	CreatedAt time.Time `json:"created_at" example:"2024-01-15T10:30:00Z" description:"When the data set was created" format:"date-time"`
+} // @SeedMarker
@@ -0,0 +1,269 @@
+// notification_autoresolve.go - Risoluzione automatica delle notifiche quando la condizione viene meno
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/laninna/hedgehog-app/logger"
+	"gorm.io/gorm"
+)
+
+// JobKindNotificationAutoResolve rivaluta le notifiche aperte dopo una scrittura
+const JobKindNotificationAutoResolve = "notifications.auto_resolve"
+
+// autoResolvePrefix precede il motivo nella nota di risoluzione, per distinguerla da quelle degli operatori
+const autoResolvePrefix = "Risolta automaticamente: "
+
+// AutoResolvePayload indica il riccio di cui rivalutare le notifiche (tutti se zero)
+type AutoResolvePayload struct {
+	HedgehogID uint `json:"hedgehog_id,omitempty"`
+}
+
+// clearResult descrive perché una notifica non è più attuale e, se c'è, il dato che l'ha risolta
+type clearResult struct {
+	Reason     string
+	ActionType string
+	ActionID   *uint
+}
+
+// clearCondition restituisce nil se la condizione che ha generato la notifica sussiste ancora
+type clearCondition func(check *clearCheck, notification Notification) *clearResult
+
+// notificationClearConditions associa a ogni tipo la sua condizione di chiusura.
+// I tipi senza condizione (es. system_alert) si chiudono solo a mano.
+var notificationClearConditions = map[NotificationType]clearCondition{
+	NotificationTherapyExpired:    therapyExpiredCleared,
+	NotificationTherapyExpiring:   therapyExpiringCleared,
+	NotificationNoWeighing:        ruleCleared(RuleMissingWeighing, "weight_record", "pesatura registrata"),
+	NotificationWeightDrop:        ruleCleared(RuleWeightAlert, "weight_record", "il peso non è più in calo"),
+	NotificationWeightStagnation:  ruleCleared(RuleWeightAlert, "weight_record", "il peso non è più stagnante"),
+	NotificationGrowthBehind:      ruleCleared(RuleWeightAlert, "weight_record", "la crescita è rientrata nella curva attesa"),
+	NotificationLowFoodIntake:     ruleCleared(RuleLowFoodIntake, "food_intake", "l'alimentazione è tornata nella norma"),
+	NotificationHibernationLoss:   ruleCleared(RuleHibernationWeightLoss, "weight_record", "la perdita di peso in letargo è rientrata"),
+	NotificationHibernationWaking: ruleCleared(RuleHibernationWaking, "", "i risvegli sono rientrati nella soglia"),
+	NotificationHedgehogRecovered: ruleCleared(RuleReleaseReadiness, "", "il riccio non soddisfa più i criteri di rilascio"),
+	NotificationLabTestDue:        ruleCleared(RuleLabTestFollowUp, "lab_test", "esame di controllo registrato"),
+}
+
+func init() {
+	registerJobHandler(JobKindNotificationAutoResolve, runAutoResolveJob)
+}
+
+// clearCheck conserva, per un passaggio, i candidati delle regole già valutate per ciascun riccio
+type clearCheck struct {
+	ns      *NotificationService
+	now     time.Time
+	configs map[string]NotificationRuleConfig
+	raised  map[string]map[string]bool // Per regola e riccio
+}
+
+// candidateKey identifica una condizione segnalata: tipo, riccio e terapia
+func candidateKey(notification Notification) string {
+	key := fmt.Sprintf("%s/", notification.Type)
+	if notification.HedgehogID != nil {
+		key += fmt.Sprint(*notification.HedgehogID)
+	}
+	key += "/"
+	if notification.TherapyID != nil {
+		key += fmt.Sprint(*notification.TherapyID)
+	}
+	return key
+}
+
+// stillRaised indica se la regola, valutata solo per il riccio della notifica, propone ancora
+// la stessa notifica; ok è false se la regola non è valutabile
+func (check *clearCheck) stillRaised(ruleName string, notification Notification) (raised bool, ok bool) {
+	if notification.HedgehogID == nil {
+		return false, false
+	}
+	key := fmt.Sprintf("%s/%d", ruleName, *notification.HedgehogID)
+	candidates, evaluated := check.raised[key]
+	if !evaluated {
+		rule := findNotificationRule(ruleName)
+		if rule == nil {
+			return false, false
+		}
+		evaluation := check.ns.forHedgehogs(*notification.HedgehogID).evaluateRule(rule, ruleConfig(check.configs, ruleName))
+		if evaluation.Error != "" {
+			check.raised[key] = nil
+			return false, false
+		}
+		candidates = make(map[string]bool, len(evaluation.Candidates))
+		for _, candidate := range evaluation.Candidates {
+			candidates[candidateKey(candidate.Notification)] = true
+		}
+		check.raised[key] = candidates
+	}
+	if candidates == nil {
+		return false, false
+	}
+	return candidates[candidateKey(notification)], true
+}
+
+// latestAction restituisce l'ultimo dato del riccio registrato dopo la notifica
+func (check *clearCheck) latestAction(actionType string, notification Notification) *uint {
+	model, known := resolutionActions[actionType]
+	if !known || notification.HedgehogID == nil {
+		return nil
+	}
+	var ids []uint
+	check.ns.db.Model(model).
+		Where("hedgehog_id = ? AND created_at >= ?", *notification.HedgehogID, notification.CreatedAt).
+		Order("id DESC").Limit(1).
+		Pluck("id", &ids)
+	if len(ids) == 0 {
+		return nil
+	}
+	return &ids[0]
+}
+
+// ruleCleared chiude la notifica quando la regola che l'ha generata non la propone più
+func ruleCleared(ruleName, actionType, reason string) clearCondition {
+	return func(check *clearCheck, notification Notification) *clearResult {
+		raised, ok := check.stillRaised(ruleName, notification)
+		if !ok || raised {
+			return nil
+		}
+		result := &clearResult{Reason: reason}
+		if id := check.latestAction(actionType, notification); id != nil {
+			result.ActionType = actionType
+			result.ActionID = id
+		}
+		return result
+	}
+}
+
+// therapyCleared verifica le condizioni comuni alle notifiche di scadenza terapia
+func therapyCleared(check *clearCheck, notification Notification) (*clearResult, *Therapy) {
+	if notification.TherapyID == nil {
+		return nil, nil
+	}
+	var therapy Therapy
+	if err := check.ns.db.First(&therapy, *notification.TherapyID).Error; err != nil {
+		return &clearResult{Reason: "terapia eliminata"}, nil
+	}
+	if therapy.Status != "active" {
+		reason := "terapia completata"
+		if therapy.Status == "suspended" {
+			reason = "terapia sospesa"
+		}
+		return &clearResult{Reason: reason, ActionType: "therapy", ActionID: &therapy.ID}, &therapy
+	}
+	if therapy.EndDate == nil {
+		return &clearResult{Reason: "terapia senza data di fine", ActionType: "therapy", ActionID: &therapy.ID}, &therapy
+	}
+	return nil, &therapy
+}
+
+func therapyExpiredCleared(check *clearCheck, notification Notification) *clearResult {
+	result, therapy := therapyCleared(check, notification)
+	if result != nil || therapy == nil {
+		return result
+	}
+	if !therapy.EndDate.Before(check.now) {
+		return &clearResult{Reason: "terapia prorogata", ActionType: "therapy", ActionID: &therapy.ID}
+	}
+	return nil
+}
+
+func therapyExpiringCleared(check *clearCheck, notification Notification) *clearResult {
+	result, therapy := therapyCleared(check, notification)
+	if result != nil || therapy == nil {
+		return result
+	}
+	if therapy.EndDate.Before(check.now) {
+		// Sostituita dalla notifica di terapia scaduta
+		return &clearResult{Reason: "terapia scaduta"}
+	}
+	if !therapy.EndDate.Before(check.now.AddDate(0, 0, check.ns.settings.TherapyExpiringDays)) {
+		return &clearResult{Reason: "terapia prorogata", ActionType: "therapy", ActionID: &therapy.ID}
+	}
+	return nil
+}
+
+// AutoResolveNotifications rivaluta le notifiche non risolte (anche posticipate) dei ricci
+// indicati, tutti se nessuno, e risolve quelle la cui condizione è venuta meno
+func (ns *NotificationService) AutoResolveNotifications(hedgehogIDs ...uint) int {
+	query := ns.db.Where("resolved_at IS NULL AND hedgehog_id IS NOT NULL")
+	if len(hedgehogIDs) > 0 {
+		query = query.Where("hedgehog_id IN ?", hedgehogIDs)
+	}
+	var notifications []Notification
+	query.Order("id ASC").Find(&notifications)
+
+	// I ricci coinvolti si caricano una volta sola
+	ids := make([]uint, 0, len(notifications))
+	for _, notification := range notifications {
+		ids = append(ids, *notification.HedgehogID)
+	}
+	var hedgehogs []Hedgehog
+	if len(ids) > 0 {
+		ns.db.Where("id IN ?", ids).Find(&hedgehogs)
+	}
+	inCare := make(map[uint]bool, len(hedgehogs))
+	for _, hedgehog := range hedgehogs {
+		inCare[hedgehog.ID] = hedgehog.Status == "in_care"
+	}
+
+	check := &clearCheck{ns: ns, now: time.Now(), configs: loadRuleConfigs(ns.db), raised: map[string]map[string]bool{}}
+	resolved := 0
+	for i := range notifications {
+		notification := &notifications[i]
+		condition, ok := notificationClearConditions[notification.Type]
+		if !ok {
+			continue
+		}
+
+		var result *clearResult
+		if !inCare[*notification.HedgehogID] {
+			result = &clearResult{Reason: "il riccio non è più in cura"}
+		} else {
+			result = condition(check, *notification)
+		}
+		if result == nil {
+			continue
+		}
+
+		if err := resolveNotification(ns.db, notification, nil, autoResolvePrefix+result.Reason, result.ActionType, result.ActionID); err != nil {
+			logger.Error("Errore risoluzione automatica notifica", err,
+				logger.Str("component", "notifications"),
+				logger.Uint("notification_id", notification.ID))
+			continue
+		}
+		resolved++
+		logger.Info("✅ Notifica risolta automaticamente",
+			logger.Str("component", "notifications"),
+			logger.Uint("notification_id", notification.ID),
+			logger.Str("type", string(notification.Type)),
+			logger.Str("reason", result.Reason))
+	}
+
+	return resolved
+}
+
+// enqueueAutoResolve accoda la rivalutazione delle notifiche del riccio dopo una scrittura.
+// Dentro una transazione va passata la tx, così il lavoro parte solo se il dato viene salvato.
+func enqueueAutoResolve(db *gorm.DB, hedgehogID uint) {
+	if _, err := enqueueJob(db, JobKindNotificationAutoResolve, AutoResolvePayload{HedgehogID: hedgehogID}); err != nil {
+		logger.Error("Errore accodamento risoluzione automatica", err,
+			logger.Str("component", "notifications"),
+			logger.Uint("hedgehog_id", hedgehogID))
+	}
+}
+
+func runAutoResolveJob(db *gorm.DB, payload []byte) error {
+	var request AutoResolvePayload
+	if err := json.Unmarshal(payload, &request); err != nil {
+		return err
+	}
+
+	ns := NewNotificationService(db)
+	if request.HedgehogID == 0 {
+		ns.AutoResolveNotifications()
+	} else {
+		ns.AutoResolveNotifications(request.HedgehogID)
+	}
+	return nil
+}
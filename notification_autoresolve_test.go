@@ -0,0 +1,179 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestWeighingAutoResolvesNoWeighingNotification(t *testing.T) {
+	db := newTestDB(t)
+	hedgehog := Hedgehog{Name: "Spillo", Status: "in_care", ArrivalDate: time.Now().AddDate(0, 0, -20)}
+	db.Create(&hedgehog)
+
+	ns := NewNotificationService(db)
+	ns.RunRules(false, RuleMissingWeighing)
+	var notification Notification
+	if err := db.Where("type = ?", NotificationNoWeighing).First(&notification).Error; err != nil {
+		t.Fatal("missing weighing notification not created")
+	}
+
+	// Finché manca la pesatura la notifica resta aperta
+	if resolved := ns.AutoResolveNotifications(); resolved != 0 {
+		t.Fatalf("resolved %d notifications without a weighing", resolved)
+	}
+
+	record := WeightRecord{HedgehogID: hedgehog.ID, Weight: 620, Date: time.Now()}
+	db.Create(&record)
+	enqueueAutoResolve(db, hedgehog.ID)
+	queue := NewJobQueue(db)
+	for queue.ProcessNext() {
+	}
+
+	db.First(&notification, notification.ID)
+	if notification.ResolvedAt == nil || notification.ResolvedBy != nil {
+		t.Fatalf("notification = %+v, want resolved by the system", notification)
+	}
+	if !strings.HasPrefix(notification.ResolutionNote, autoResolvePrefix) ||
+		notification.ResolutionAction != "weight_record" || *notification.ResolutionActionID != record.ID {
+		t.Errorf("resolution = %q %q %v", notification.ResolutionNote, notification.ResolutionAction, notification.ResolutionActionID)
+	}
+
+	var events int64
+	db.Model(&NotificationEvent{}).Where("notification_id = ? AND type = ?", notification.ID, NotificationEventResolved).Count(&events)
+	if events != 1 {
+		t.Errorf("resolved events = %d, want 1", events)
+	}
+}
+
+func TestCompletedTherapyAutoResolvesExpiredNotification(t *testing.T) {
+	db := newTestDB(t)
+	hedgehog := Hedgehog{Name: "Spillo", Status: "in_care", ArrivalDate: time.Now().AddDate(0, -1, 0)}
+	db.Create(&hedgehog)
+	end := time.Now().AddDate(0, 0, -2)
+	therapy := Therapy{HedgehogID: hedgehog.ID, Name: "Antibiotico", StartDate: end.AddDate(0, 0, -7), EndDate: &end, Status: "active"}
+	db.Create(&therapy)
+	db.Create(&Notification{Type: NotificationSystemAlert, Priority: PriorityLow, Title: "Sistema", Message: "x", HedgehogID: &hedgehog.ID})
+
+	ns := NewNotificationService(db)
+	ns.RunRules(false, RuleTherapyExpiry)
+	if resolved := ns.AutoResolveNotifications(hedgehog.ID); resolved != 0 {
+		t.Fatalf("resolved %d notifications while the therapy is still active", resolved)
+	}
+
+	db.Model(&therapy).Update("status", "completed")
+	if resolved := ns.AutoResolveNotifications(hedgehog.ID); resolved != 1 {
+		t.Fatalf("resolved = %d, want only the expired therapy", resolved)
+	}
+
+	var notification Notification
+	db.Where("type = ?", NotificationTherapyExpired).First(&notification)
+	if notification.ResolutionNote != autoResolvePrefix+"terapia completata" || notification.ResolutionAction != "therapy" {
+		t.Errorf("resolution = %q %q", notification.ResolutionNote, notification.ResolutionAction)
+	}
+
+	// Le notifiche senza condizione di chiusura restano aperte
+	var open int64
+	db.Model(&Notification{}).Where("type = ? AND resolved_at IS NULL", NotificationSystemAlert).Count(&open)
+	if open != 1 {
+		t.Error("system alert should not be auto-resolved")
+	}
+}
+
+func TestAutoResolveEvaluatesOnlyTheNotificationHedgehog(t *testing.T) {
+	db := newTestDB(t)
+	first := Hedgehog{Name: "Spillo", Status: "in_care", ArrivalDate: time.Now().AddDate(0, 0, -20)}
+	second := Hedgehog{Name: "Riccio", Status: "in_care", ArrivalDate: time.Now().AddDate(0, 0, -20)}
+	db.Create(&first)
+	db.Create(&second)
+
+	ns := NewNotificationService(db)
+	evaluations := ns.forHedgehogs(first.ID).RunRules(true, RuleMissingWeighing)
+	if len(evaluations) != 1 || len(evaluations[0].Candidates) != 1 || *evaluations[0].Candidates[0].Notification.HedgehogID != first.ID {
+		t.Fatalf("scoped evaluation = %+v", evaluations)
+	}
+
+	ns.RunRules(false, RuleMissingWeighing)
+	db.Create(&WeightRecord{HedgehogID: first.ID, Weight: 620, Date: time.Now()})
+	if resolved := ns.AutoResolveNotifications(); resolved != 1 {
+		t.Fatalf("resolved = %d, want only the weighed hedgehog", resolved)
+	}
+	var open []Notification
+	db.Where("resolved_at IS NULL").Find(&open)
+	if len(open) != 1 || *open[0].HedgehogID != second.ID {
+		t.Errorf("open = %+v", open)
+	}
+}
+
+func TestDeletingTherapyAutoResolvesItsNotification(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := newTestDB(t)
+	hedgehog := Hedgehog{Name: "Spillo", Status: "in_care", ArrivalDate: time.Now().AddDate(0, -1, 0)}
+	db.Create(&hedgehog)
+	end := time.Now().AddDate(0, 0, -2)
+	therapy := Therapy{HedgehogID: hedgehog.ID, Name: "Antibiotico", StartDate: end.AddDate(0, 0, -7), EndDate: &end, Status: "active"}
+	db.Create(&therapy)
+	NewNotificationService(db).RunRules(false, RuleTherapyExpiry)
+
+	router := gin.New()
+	router.DELETE("/therapies/:id", deleteTherapy(db))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/therapies/"+strconv.Itoa(int(therapy.ID)), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("delete status = %d: %s", w.Code, w.Body.String())
+	}
+	for NewJobQueue(db).ProcessNext() {
+	}
+
+	var notification Notification
+	db.Where("type = ?", NotificationTherapyExpired).First(&notification)
+	if notification.ResolvedAt == nil || notification.ResolutionNote != autoResolvePrefix+"terapia eliminata" {
+		t.Errorf("notification = %+v", notification)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/therapies/"+strconv.Itoa(int(therapy.ID)), nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("second delete: status = %d", w.Code)
+	}
+}
+
+func TestExcludingTypoWeightAutoResolvesWeightDrop(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := newTestDB(t)
+	hedgehog := Hedgehog{Name: "Spillo", Status: "in_care", ArrivalDate: time.Now().AddDate(0, -1, 0)}
+	db.Create(&hedgehog)
+	for i, weight := range []float64{600, 605, 610} {
+		db.Create(&WeightRecord{HedgehogID: hedgehog.ID, Weight: weight, Date: time.Now().AddDate(0, 0, -6+i)})
+	}
+	typo := WeightRecord{HedgehogID: hedgehog.ID, Weight: 4500, Date: time.Now().AddDate(0, 0, -3)}
+	db.Create(&typo)
+	db.Create(&WeightRecord{HedgehogID: hedgehog.ID, Weight: 612, Date: time.Now().AddDate(0, 0, -1)})
+
+	NewNotificationService(db).RunRules(false, RuleWeightAlert)
+	var notification Notification
+	if err := db.Where("type = ?", NotificationWeightDrop).First(&notification).Error; err != nil {
+		t.Fatal("weight drop notification not created for the typo")
+	}
+
+	router := gin.New()
+	router.PUT("/weight-records/:id/exclusion", setWeightRecordExclusion(db))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/weight-records/"+strconv.Itoa(int(typo.ID))+"/exclusion",
+		strings.NewReader(`{"excluded":true,"reason":"Errore di battitura"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("exclusion status = %d: %s", w.Code, w.Body.String())
+	}
+	for NewJobQueue(db).ProcessNext() {
+	}
+
+	db.First(&notification, notification.ID)
+	if notification.ResolvedAt == nil {
+		t.Errorf("weight drop still open after excluding the typo: %+v", notification)
+	}
+}
@@ -0,0 +1,213 @@
+// notification_inbox.go - Caselle di notifica personali per utente
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var priorityRank = map[NotificationPriority]int{
+	PriorityLow:      0,
+	PriorityMedium:   1,
+	PriorityHigh:     2,
+	PriorityCritical: 3,
+}
+
+// prioritiesAtLeast restituisce le priorità uguali o superiori a quella indicata
+func prioritiesAtLeast(min NotificationPriority) []NotificationPriority {
+	var priorities []NotificationPriority
+	for priority, rank := range priorityRank {
+		if rank >= priorityRank[min] {
+			priorities = append(priorities, priority)
+		}
+	}
+	return priorities
+}
+
+// splitList divide una lista separata da virgole ignorando gli elementi vuoti
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// loadSubscription restituisce le preferenze dell'utente, o quelle predefinite (tutto)
+func loadSubscription(db *gorm.DB, userID uint) NotificationSubscription {
+	subscription := NotificationSubscription{UserID: userID, MinPriority: PriorityLow}
+	db.Where("user_id = ?", userID).First(&subscription)
+	return subscription
+}
+
+// inboxQuery restituisce le notifiche visibili all'utente: non archiviate da lui
+// e conformi alle sue preferenze di tipo, priorità e stanza
+func inboxQuery(db *gorm.DB, userID uint, subscription NotificationSubscription) *gorm.DB {
+	query := db.Model(&Notification{}).
+		Joins("LEFT JOIN notification_receipts ON notification_receipts.notification_id = notifications.id AND notification_receipts.user_id = ?", userID).
+		Where("COALESCE(notification_receipts.dismissed, false) = false")
+
+	if types := splitList(subscription.Types); len(types) > 0 {
+		query = query.Where("notifications.type IN ?", types)
+	}
+
+	if subscription.MinPriority != "" && subscription.MinPriority != PriorityLow {
+		query = query.Where("notifications.priority IN ?", prioritiesAtLeast(subscription.MinPriority))
+	}
+
+	if rooms := splitList(subscription.RoomIDs); len(rooms) > 0 {
+		// Le notifiche non legate a un riccio restano visibili
+		query = query.Where("notifications.hedgehog_id IS NULL OR notifications.hedgehog_id IN (?)",
+			db.Model(&Hedgehog{}).
+				Select("hedgehogs.id").
+				Joins("JOIN areas ON areas.id = hedgehogs.area_id").
+				Where("areas.room_id IN ?", rooms))
+	}
+
+	return query
+}
+
+// applyReceipts imposta Read e Dismissed secondo lo stato personale dell'utente
+func applyReceipts(db *gorm.DB, userID uint, notifications []Notification) {
+	if len(notifications) == 0 {
+		return
+	}
+
+	ids := make([]uint, len(notifications))
+	for i, notification := range notifications {
+		ids[i] = notification.ID
+	}
+
+	var receipts []NotificationReceipt
+	db.Where("user_id = ? AND notification_id IN ?", userID, ids).Find(&receipts)
+
+	byNotification := make(map[uint]NotificationReceipt, len(receipts))
+	for _, receipt := range receipts {
+		byNotification[receipt.NotificationID] = receipt
+	}
+
+	for i := range notifications {
+		receipt := byNotification[notifications[i].ID]
+		notifications[i].Read = receipt.Read
+		notifications[i].Dismissed = receipt.Dismissed
+	}
+}
+
+// updateReceipt registra la lettura o l'archiviazione di una notifica da parte dell'utente
+func updateReceipt(db *gorm.DB, notificationID, userID uint, read, dismissed bool) (NotificationReceipt, error) {
+	receipt := NotificationReceipt{NotificationID: notificationID, UserID: userID}
+	db.Where("notification_id = ? AND user_id = ?", notificationID, userID).First(&receipt)
+
+	now := time.Now()
+	if read && !receipt.Read {
+		receipt.Read = true
+		receipt.ReadAt = &now
+	}
+	if dismissed && !receipt.Dismissed {
+		receipt.Dismissed = true
+		receipt.DismissedAt = &now
+	}
+
+	err := db.Save(&receipt).Error
+	return receipt, err
+}
+
+// requireUserID restituisce l'utente autenticato o risponde 401
+func requireUserID(c *gin.Context) (uint, bool) {
+	userID := currentUserID(c)
+	if userID == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return 0, false
+	}
+	return *userID, true
+}
+
+// validateSubscription controlla priorità e stanze delle preferenze
+func validateSubscription(subscription NotificationSubscription) error {
+	if subscription.MinPriority != "" && !validNotificationPriority(subscription.MinPriority) {
+		return errors.New("min_priority must be one of low, medium, high, critical")
+	}
+	if subscription.Locale != "" && !containsString(templateLocales, subscription.Locale) {
+		return fmt.Errorf("locale must be one of %s", strings.Join(templateLocales, ", "))
+	}
+	for _, room := range splitList(subscription.RoomIDs) {
+		if _, err := strconv.ParseUint(room, 10, 64); err != nil {
+			return errors.New("room_ids must be a comma separated list of room IDs")
+		}
+	}
+	return nil
+}
+
+// @Summary Get notification preferences
+// @Description Get the notification preferences of the authenticated user
+// @Tags Notifications
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} NotificationSubscription
+// @Failure 401 {object} map[string]string
+// @Router /notification-preferences [get]
+func getNotificationPreferencesHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := requireUserID(c)
+		if !ok {
+			return
+		}
+
+		c.JSON(http.StatusOK, loadSubscription(db, userID))
+	}
+}
+
+// @Summary Update notification preferences
+// @Description Choose which notification types, priorities and rooms appear in the authenticated user's inbox
+// @Tags Notifications
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param preferences body NotificationSubscription true "Notification preferences"
+// @Success 200 {object} NotificationSubscription
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /notification-preferences [put]
+func updateNotificationPreferencesHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := requireUserID(c)
+		if !ok {
+			return
+		}
+
+		subscription := loadSubscription(db, userID)
+		existingID := subscription.ID
+		if err := c.ShouldBindJSON(&subscription); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		subscription.ID = existingID
+		subscription.UserID = userID
+		if subscription.MinPriority == "" {
+			subscription.MinPriority = PriorityLow
+		}
+
+		if err := validateSubscription(subscription); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := db.Save(&subscription).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, subscription)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInboxReadStateIsPerUser(t *testing.T) {
+	db := newTestDB(t)
+	hedgehog := Hedgehog{Name: "Spillo"}
+	db.Create(&hedgehog)
+	read := Notification{Type: NotificationWeightDrop, Priority: PriorityHigh, Title: "Calo", Message: "Calo", HedgehogID: &hedgehog.ID}
+	dismissed := Notification{Type: NotificationNoWeighing, Priority: PriorityMedium, Title: "Pesa", Message: "Pesa", HedgehogID: &hedgehog.ID}
+	db.Create(&read)
+	db.Create(&dismissed)
+
+	updateReceipt(db, read.ID, 1, true, false)
+	updateReceipt(db, dismissed.ID, 1, false, true)
+
+	var first []Notification
+	inboxQuery(db, 1, loadSubscription(db, 1)).Find(&first)
+	applyReceipts(db, 1, first)
+	if len(first) != 1 || first[0].ID != read.ID || !first[0].Read {
+		t.Fatalf("user 1 inbox = %+v, want only the read notification", first)
+	}
+
+	var unread int64
+	inboxQuery(db, 2, loadSubscription(db, 2)).Where("COALESCE(notification_receipts.read, false) = false").Count(&unread)
+	if unread != 2 {
+		t.Errorf("user 2 unread = %d, want 2", unread)
+	}
+}
+
+func TestInboxSubscriptionFilters(t *testing.T) {
+	db := newTestDB(t)
+	kitchen := Room{Name: "Cucina"}
+	garden := Room{Name: "Giardino"}
+	db.Create(&kitchen)
+	db.Create(&garden)
+	kitchenArea := Area{Name: "Gabbia 1", RoomID: kitchen.ID}
+	gardenArea := Area{Name: "Recinto", RoomID: garden.ID}
+	db.Create(&kitchenArea)
+	db.Create(&gardenArea)
+	inKitchen := Hedgehog{Name: "Uno", AreaID: &kitchenArea.ID}
+	inGarden := Hedgehog{Name: "Due", AreaID: &gardenArea.ID}
+	db.Create(&inKitchen)
+	db.Create(&inGarden)
+
+	db.Create(&Notification{Type: NotificationWeightDrop, Priority: PriorityCritical, Title: "a", Message: "a", HedgehogID: &inKitchen.ID})
+	db.Create(&Notification{Type: NotificationNoWeighing, Priority: PriorityLow, Title: "b", Message: "b", HedgehogID: &inKitchen.ID})
+	db.Create(&Notification{Type: NotificationWeightDrop, Priority: PriorityCritical, Title: "c", Message: "c", HedgehogID: &inGarden.ID})
+	db.Create(&Notification{Type: NotificationSystemAlert, Priority: PriorityHigh, Title: "d", Message: "d"})
+
+	db.Create(&NotificationSubscription{UserID: 1, MinPriority: PriorityHigh, RoomIDs: "1"})
+
+	var count int64
+	inboxQuery(db, 1, loadSubscription(db, 1)).Count(&count)
+	if count != 2 {
+		t.Errorf("inbox = %d, want critical kitchen alert and system alert", count)
+	}
+}
+
+func TestCleanupDeletesExpiredAndOldNotifications(t *testing.T) {
+	db := newTestDB(t)
+	db.Create(&User{Username: "uno", Password: "x"})
+	db.Create(&User{Username: "due", Password: "x"})
+	old := time.Now().AddDate(0, 0, -40)
+	expired := time.Now().AddDate(0, 0, -1)
+	oldUnread := Notification{Type: NotificationSystemAlert, Priority: PriorityLow, Title: "a", Message: "a", CreatedAt: old}
+	expiredUnread := Notification{Type: NotificationSystemAlert, Priority: PriorityLow, Title: "b", Message: "b", ExpiresAt: &expired}
+	recent := Notification{Type: NotificationSystemAlert, Priority: PriorityLow, Title: "c", Message: "c"}
+	db.Create(&oldUnread)
+	db.Create(&expiredUnread)
+	db.Create(&recent)
+
+	// Letta da un solo utente: l'altro non l'ha mai gestita, ma va eliminata comunque
+	updateReceipt(db, oldUnread.ID, 1, true, false)
+
+	NewNotificationService(db).cleanOldNotifications()
+
+	var remaining []uint
+	db.Model(&Notification{}).Pluck("id", &remaining)
+	if len(remaining) != 1 || remaining[0] != recent.ID {
+		t.Errorf("remaining = %v, want only the recent notification", remaining)
+	}
+	var receipts int64
+	db.Model(&NotificationReceipt{}).Count(&receipts)
+	if receipts != 0 {
+		t.Errorf("receipts = %d, want those of deleted notifications removed", receipts)
+	}
+}
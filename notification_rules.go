@@ -0,0 +1,441 @@
+// notification_rules.go - Motore regole per le notifiche
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/laninna/hedgehog-app/logger"
+	"gorm.io/gorm"
+)
+
+// Nomi delle regole predefinite
+const (
+	RuleTherapyExpiry         = "therapy_expiry"
+	RuleWeightAlert           = "weight_alert"
+	RuleMissingWeighing       = "missing_weighing"
+	RuleLowFoodIntake         = "low_food_intake"
+	RuleHibernationWeightLoss = "hibernation_weight_loss"
+	RuleHibernationWaking     = "hibernation_waking"
+	RuleReleaseReadiness      = "release_readiness"
+	RuleLabTestFollowUp       = "lab_test_follow_up"
+)
+
+// NotificationRule è una regola che, valutata sui dati correnti, propone notifiche
+type NotificationRule interface {
+	Name() string
+	Description() string
+	// ThresholdUnit restituisce l'unità della soglia, vuota se la regola non ha soglia
+	ThresholdUnit() string
+	// DefaultThreshold legge la soglia predefinita dalle impostazioni
+	DefaultThreshold(settings *NotificationSettings) *float64
+	// ApplyThreshold sovrascrive la soglia nelle impostazioni usate per la valutazione
+	ApplyThreshold(settings *NotificationSettings, value float64)
+	Evaluate(ns *NotificationService) ([]RuleCandidate, error)
+}
+
+// RuleCandidate è una notifica proposta da una regola
+type RuleCandidate struct {
+	Notification Notification  `json:"notification"`
+	RepeatAfter  time.Duration `json:"-"` // Intervallo minimo prima di ripetere la stessa notifica
+	Suppressed   bool          `json:"suppressed"`
+	Reason       string        `json:"reason,omitempty"`
+}
+
+// RuleEvaluation è il risultato della valutazione di una regola
+type RuleEvaluation struct {
+	Rule       string               `json:"rule"`
+	Enabled    bool                 `json:"enabled"`
+	Threshold  *float64             `json:"threshold,omitempty"`
+	Priority   NotificationPriority `json:"priority,omitempty"`
+	Candidates []RuleCandidate      `json:"candidates"`
+	Created    int                  `json:"created"`
+	Error      string               `json:"error,omitempty"`
+}
+
+// NotificationRuleInfo descrive una regola registrata e la sua configurazione
+type NotificationRuleInfo struct {
+	Name             string               `json:"name"`
+	Description      string               `json:"description"`
+	ThresholdUnit    string               `json:"threshold_unit,omitempty"`
+	DefaultThreshold *float64             `json:"default_threshold,omitempty"`
+	Enabled          bool                 `json:"enabled"`
+	Threshold        *float64             `json:"threshold,omitempty"`
+	Priority         NotificationPriority `json:"priority,omitempty"`
+}
+
+// NotificationRuleUpdate è il corpo della richiesta di modifica di una regola
+type NotificationRuleUpdate struct {
+	Enabled   *bool                `json:"enabled" example:"true"`
+	Threshold *float64             `json:"threshold" example:"40"`
+	Priority  NotificationPriority `json:"priority" example:"high" enums:"low,medium,high,critical"`
+}
+
+// settingsRule è una regola la cui soglia è un campo di NotificationSettings
+type settingsRule struct {
+	name        string
+	description string
+	unit        string
+	get         func(s *NotificationSettings) float64
+	set         func(s *NotificationSettings, v float64)
+	evaluate    func(ns *NotificationService) ([]RuleCandidate, error)
+}
+
+func (r settingsRule) Name() string          { return r.name }
+func (r settingsRule) Description() string   { return r.description }
+func (r settingsRule) ThresholdUnit() string { return r.unit }
+
+func (r settingsRule) DefaultThreshold(settings *NotificationSettings) *float64 {
+	if r.get == nil {
+		return nil
+	}
+	value := r.get(settings)
+	return &value
+}
+
+func (r settingsRule) ApplyThreshold(settings *NotificationSettings, value float64) {
+	if r.set != nil {
+		r.set(settings, value)
+	}
+}
+
+func (r settingsRule) Evaluate(ns *NotificationService) ([]RuleCandidate, error) {
+	return r.evaluate(ns)
+}
+
+var notificationRules []NotificationRule
+
+// registerNotificationRule aggiunge una regola al motore, nell'ordine di valutazione
+func registerNotificationRule(rule NotificationRule) {
+	for i, existing := range notificationRules {
+		if existing.Name() == rule.Name() {
+			notificationRules[i] = rule
+			return
+		}
+	}
+	notificationRules = append(notificationRules, rule)
+}
+
+func findNotificationRule(name string) NotificationRule {
+	for _, rule := range notificationRules {
+		if rule.Name() == name {
+			return rule
+		}
+	}
+	return nil
+}
+
+func init() {
+	registerNotificationRule(settingsRule{
+		name:        RuleTherapyExpiry,
+		description: "Terapie scadute o in scadenza",
+		unit:        "days",
+		get:         func(s *NotificationSettings) float64 { return float64(s.TherapyExpiringDays) },
+		set:         func(s *NotificationSettings, v float64) { s.TherapyExpiringDays = int(v) },
+		evaluate:    (*NotificationService).therapyExpiryCandidates,
+	})
+	registerNotificationRule(settingsRule{
+		name:        RuleWeightAlert,
+		description: "Cali di peso, trend negativi, stagnazione e crescita insufficiente",
+		unit:        "grams",
+		get:         func(s *NotificationSettings) float64 { return s.WeightDropThreshold },
+		set:         func(s *NotificationSettings, v float64) { s.WeightDropThreshold = v },
+		evaluate:    (*NotificationService).weightAlertCandidates,
+	})
+	registerNotificationRule(settingsRule{
+		name:        RuleMissingWeighing,
+		description: "Ricci non pesati da troppo tempo",
+		unit:        "days",
+		get:         func(s *NotificationSettings) float64 { return float64(s.NoWeighingDays) },
+		set:         func(s *NotificationSettings, v float64) { s.NoWeighingDays = int(v) },
+		evaluate:    (*NotificationService).missingWeighingCandidates,
+	})
+	registerNotificationRule(settingsRule{
+		name:        RuleLowFoodIntake,
+		description: "Ricci che mangiano meno del piano alimentare",
+		unit:        "percent",
+		get:         func(s *NotificationSettings) float64 { return s.LowIntakeThreshold },
+		set:         func(s *NotificationSettings, v float64) { s.LowIntakeThreshold = v },
+		evaluate:    (*NotificationService).foodIntakeCandidates,
+	})
+	registerNotificationRule(settingsRule{
+		name:        RuleHibernationWeightLoss,
+		description: "Perdita di peso eccessiva durante il letargo",
+		unit:        "percent",
+		get:         func(s *NotificationSettings) float64 { return s.HibernationMaxLossPercent },
+		set:         func(s *NotificationSettings, v float64) { s.HibernationMaxLossPercent = v },
+		evaluate:    (*NotificationService).hibernationLossCandidates,
+	})
+	registerNotificationRule(settingsRule{
+		name:        RuleHibernationWaking,
+		description: "Risvegli troppo frequenti durante il letargo",
+		unit:        "wakes",
+		get:         func(s *NotificationSettings) float64 { return float64(s.HibernationMaxWakes) },
+		set:         func(s *NotificationSettings, v float64) { s.HibernationMaxWakes = int(v) },
+		evaluate:    (*NotificationService).hibernationWakingCandidates,
+	})
+	registerNotificationRule(settingsRule{
+		name:        RuleReleaseReadiness,
+		description: "Ricci che soddisfano i criteri di rilascio",
+		evaluate:    (*NotificationService).releaseReadinessCandidates,
+	})
+	registerNotificationRule(settingsRule{
+		name:        RuleLabTestFollowUp,
+		description: "Esami di controllo dopo una sverminazione",
+		unit:        "days",
+		get:         func(s *NotificationSettings) float64 { return float64(s.LabTestFollowUpDays) },
+		set:         func(s *NotificationSettings, v float64) { s.LabTestFollowUpDays = int(v) },
+		evaluate:    (*NotificationService).labTestFollowUpCandidates,
+	})
+}
+
+// loadRuleConfigs restituisce le configurazioni salvate indicizzate per nome della regola
+func loadRuleConfigs(db *gorm.DB) map[string]NotificationRuleConfig {
+	var configs []NotificationRuleConfig
+	db.Find(&configs)
+
+	byRule := make(map[string]NotificationRuleConfig, len(configs))
+	for _, config := range configs {
+		byRule[config.Rule] = config
+	}
+	return byRule
+}
+
+// ruleConfig restituisce la configurazione di una regola, con i default se non salvata
+func ruleConfig(configs map[string]NotificationRuleConfig, name string) NotificationRuleConfig {
+	if config, ok := configs[name]; ok {
+		return config
+	}
+	return NotificationRuleConfig{Rule: name, Enabled: true}
+}
+
+// RunRules valuta le regole indicate (tutte se nessuna) e crea le notifiche proposte.
+// In modalità dryRun valuta anche le regole disabilitate ma non crea nulla.
+func (ns *NotificationService) RunRules(dryRun bool, names ...string) []RuleEvaluation {
+	configs := loadRuleConfigs(ns.db)
+
+	var evaluations []RuleEvaluation
+	for _, rule := range notificationRules {
+		if len(names) > 0 && !containsString(names, rule.Name()) {
+			continue
+		}
+
+		config := ruleConfig(configs, rule.Name())
+		if !config.Enabled && !dryRun {
+			continue
+		}
+
+		evaluation := ns.evaluateRule(rule, config)
+		if evaluation.Error != "" {
+			logger.Warn("Errore valutazione regola",
+				logger.Str("component", "notifications"),
+				logger.Str("rule", rule.Name()),
+				logger.Str("error", evaluation.Error))
+		}
+
+		if !dryRun && config.Enabled {
+			for _, candidate := range evaluation.Candidates {
+				if !candidate.Suppressed && ns.createNotification(candidate.Notification) {
+					evaluation.Created++
+				}
+			}
+		}
+
+		evaluations = append(evaluations, evaluation)
+	}
+
+	return evaluations
+}
+
+// evaluateRule valuta una regola con la soglia e la priorità configurate
+func (ns *NotificationService) evaluateRule(rule NotificationRule, config NotificationRuleConfig) RuleEvaluation {
+	settings := *ns.settings
+	if config.Threshold != nil {
+		rule.ApplyThreshold(&settings, *config.Threshold)
+	}
+	scoped := &NotificationService{db: ns.db, settings: &settings, scope: ns.scope}
+
+	evaluation := RuleEvaluation{
+		Rule:       rule.Name(),
+		Enabled:    config.Enabled,
+		Threshold:  rule.DefaultThreshold(&settings),
+		Priority:   config.Priority,
+		Candidates: []RuleCandidate{},
+	}
+
+	candidates, err := rule.Evaluate(scoped)
+	if err != nil {
+		evaluation.Error = err.Error()
+	}
+
+	for _, candidate := range candidates {
+		if config.Priority != "" {
+			candidate.Notification.Priority = config.Priority
+		}
+		localizeNotification(ns.db, &candidate.Notification, defaultLocale())
+
+		repeat := candidate.RepeatAfter
+		if repeat <= 0 {
+			repeat = 24 * time.Hour
+		}
+		if candidate.Notification.HedgehogID != nil &&
+			ns.hasRecentNotification(*candidate.Notification.HedgehogID, candidate.Notification.Type, repeat) {
+			candidate.Suppressed = true
+			candidate.Reason = fmt.Sprintf("notifica analoga ancora aperta o risolta nelle ultime %.0f ore", repeat.Hours())
+		}
+
+		evaluation.Candidates = append(evaluation.Candidates, candidate)
+	}
+
+	return evaluation
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func validNotificationPriority(priority NotificationPriority) bool {
+	switch priority {
+	case PriorityLow, PriorityMedium, PriorityHigh, PriorityCritical:
+		return true
+	}
+	return false
+}
+
+// @Summary List notification rules
+// @Description List the registered notification rules with their current configuration
+// @Tags Notifications
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} NotificationRuleInfo
+// @Failure 401 {object} map[string]string
+// @Router /notification-rules [get]
+func getNotificationRulesHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ns := NewNotificationService(db)
+		configs := loadRuleConfigs(db)
+
+		rules := make([]NotificationRuleInfo, 0, len(notificationRules))
+		for _, rule := range notificationRules {
+			config := ruleConfig(configs, rule.Name())
+			rules = append(rules, NotificationRuleInfo{
+				Name:             rule.Name(),
+				Description:      rule.Description(),
+				ThresholdUnit:    rule.ThresholdUnit(),
+				DefaultThreshold: rule.DefaultThreshold(ns.settings),
+				Enabled:          config.Enabled,
+				Threshold:        config.Threshold,
+				Priority:         config.Priority,
+			})
+		}
+
+		c.JSON(http.StatusOK, rules)
+	}
+}
+
+// @Summary Update notification rule
+// @Description Enable or disable a notification rule and override its threshold and priority
+// @Tags Notifications
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param name path string true "Rule name"
+// @Param rule body NotificationRuleUpdate true "Rule configuration"
+// @Success 200 {object} NotificationRuleConfig
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /notification-rules/{name} [put]
+func updateNotificationRuleHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rule := findNotificationRule(c.Param("name"))
+		if rule == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
+			return
+		}
+
+		var request NotificationRuleUpdate
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if request.Priority != "" && !validNotificationPriority(request.Priority) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "priority must be one of low, medium, high, critical"})
+			return
+		}
+		if request.Threshold != nil {
+			if rule.ThresholdUnit() == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "rule has no threshold"})
+				return
+			}
+			if *request.Threshold <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "threshold must be positive"})
+				return
+			}
+		}
+
+		config := NotificationRuleConfig{Rule: rule.Name(), Enabled: true}
+		db.Where("rule = ?", rule.Name()).First(&config)
+
+		if request.Enabled != nil {
+			config.Enabled = *request.Enabled
+		}
+		config.Threshold = request.Threshold
+		config.Priority = request.Priority
+
+		if err := db.Save(&config).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		// In creazione GORM sostituisce false con il default del database
+		if !config.Enabled {
+			if err := db.Model(&config).Update("enabled", false).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, config)
+	}
+}
+
+// @Summary Dry-run notification rules
+// @Description Evaluate the notification rules against the current data without creating notifications
+// @Tags Notifications
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param rule query string false "Comma separated rule names (default all)"
+// @Success 200 {array} RuleEvaluation
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /notification-rules/dry-run [post]
+func dryRunNotificationRulesHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var names []string
+		if param := c.Query("rule"); param != "" {
+			for _, name := range strings.Split(param, ",") {
+				name = strings.TrimSpace(name)
+				if findNotificationRule(name) == nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown rule %q", name)})
+					return
+				}
+				names = append(names, name)
+			}
+		}
+
+		ns := NewNotificationService(db)
+		c.JSON(http.StatusOK, ns.RunRules(true, names...))
+	}
+}
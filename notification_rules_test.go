@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// hibernatingHedgehog crea un riccio in letargo che ha perso il 15% del peso
+func hibernatingHedgehog(t *testing.T, db *gorm.DB) Hedgehog {
+	t.Helper()
+	start := time.Now().AddDate(0, 0, -30)
+	hedgehog := Hedgehog{Name: "Dormiglione", Status: "in_care", ArrivalDate: start.AddDate(0, 0, -30), HibernationStart: &start}
+	db.Create(&hedgehog)
+	db.Create(&WeightRecord{HedgehogID: hedgehog.ID, Weight: 700, Date: start.AddDate(0, 0, -1)})
+	db.Create(&WeightRecord{HedgehogID: hedgehog.ID, Weight: 595, Date: time.Now().AddDate(0, 0, -5)})
+	return hedgehog
+}
+
+func TestRunRulesDryRunCreatesNothing(t *testing.T) {
+	db := newTestDB(t)
+	hibernatingHedgehog(t, db)
+
+	// La soglia predefinita è 20%: con il 15% non scatta
+	threshold := 10.0
+	db.Create(&NotificationRuleConfig{Rule: RuleHibernationWeightLoss, Enabled: true, Threshold: &threshold, Priority: PriorityHigh})
+
+	ns := NewNotificationService(db)
+	evaluations := ns.RunRules(true, RuleHibernationWeightLoss)
+	if len(evaluations) != 1 || len(evaluations[0].Candidates) != 1 {
+		t.Fatalf("evaluations = %+v, want one candidate", evaluations)
+	}
+	if got := evaluations[0].Candidates[0].Notification.Priority; got != PriorityHigh {
+		t.Errorf("priority = %q, want configured %q", got, PriorityHigh)
+	}
+
+	var count int64
+	db.Model(&Notification{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("dry run created %d notifications", count)
+	}
+
+	ns.RunRules(false, RuleHibernationWeightLoss)
+	db.Model(&Notification{}).Count(&count)
+	if count != 1 {
+		t.Fatalf("notifications = %d, want 1", count)
+	}
+
+	evaluations = ns.RunRules(true, RuleHibernationWeightLoss)
+	if !evaluations[0].Candidates[0].Suppressed {
+		t.Error("repeated candidate should be suppressed")
+	}
+}
+
+func TestRunRulesSkipsDisabledRules(t *testing.T) {
+	db := newTestDB(t)
+	hibernatingHedgehog(t, db)
+
+	threshold := 10.0
+	config := NotificationRuleConfig{Rule: RuleHibernationWeightLoss, Threshold: &threshold}
+	db.Create(&config)
+	db.Model(&config).Update("enabled", false)
+
+	ns := NewNotificationService(db)
+	if evaluations := ns.RunRules(false, RuleHibernationWeightLoss); len(evaluations) != 0 {
+		t.Errorf("disabled rule evaluated: %+v", evaluations)
+	}
+
+	evaluations := ns.RunRules(true, RuleHibernationWeightLoss)
+	if len(evaluations) != 1 || evaluations[0].Enabled {
+		t.Errorf("dry run should report the disabled rule: %+v", evaluations)
+	}
+}
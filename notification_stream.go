@@ -0,0 +1,345 @@
+// notification_stream.go - Notifiche in tempo reale via Server-Sent Events
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/laninna/hedgehog-app/logger"
+	"gorm.io/gorm"
+)
+
+// Tipi di evento inviati sullo stream
+const (
+	NotificationEventCreated      = "notification.created"
+	NotificationEventRead         = "notification.read"
+	NotificationEventDismissed    = "notification.dismissed"
+	NotificationEventAcknowledged = "notification.acknowledged"
+	NotificationEventAssigned     = "notification.assigned"
+	NotificationEventSnoozed      = "notification.snoozed"
+	NotificationEventResolved     = "notification.resolved"
+	NotificationEventReopened     = "notification.reopened"
+
+	// NotificationEventReset non viene salvato: chiede al client di ricaricare la lista
+	// perché gli eventi persi sono troppi per il replay
+	NotificationEventReset = "notification.reset"
+)
+
+// Numero massimo di eventi reinviati alla riconnessione
+const maxReplayEvents = 500
+
+// notificationHub distribuisce gli eventi ai client collegati a questa istanza
+type notificationHub struct {
+	mu      sync.Mutex
+	clients map[chan NotificationEvent]struct{}
+}
+
+var notificationStream = &notificationHub{clients: make(map[chan NotificationEvent]struct{})}
+
+func (h *notificationHub) subscribe() chan NotificationEvent {
+	ch := make(chan NotificationEvent, 32)
+	h.mu.Lock()
+	h.clients[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *notificationHub) unsubscribe(ch chan NotificationEvent) {
+	h.mu.Lock()
+	delete(h.clients, ch)
+	h.mu.Unlock()
+}
+
+// publish non blocca: un client lento perde l'evento e lo recupera alla riconnessione
+func (h *notificationHub) publish(event NotificationEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.clients {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// publishNotificationEvent salva l'evento (per il replay) e lo invia ai client collegati;
+// le modifiche condivise finiscono anche nella cronologia, che non scade con il replay
+func publishNotificationEvent(db *gorm.DB, eventType string, notificationID uint, userID *uint, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+
+	event := NotificationEvent{
+		Type:           eventType,
+		NotificationID: notificationID,
+		UserID:         userID,
+		Payload:        string(data),
+	}
+	if err := db.Create(&event).Error; err != nil {
+		logger.Error("Errore salvataggio evento notifica", err,
+			logger.Str("component", "notifications"),
+			logger.Str("event", eventType))
+		return
+	}
+
+	if userID == nil {
+		history := NotificationHistory{Type: eventType, NotificationID: notificationID, Payload: event.Payload}
+		if err := db.Create(&history).Error; err != nil {
+			logger.Error("Errore salvataggio cronologia notifica", err,
+				logger.Str("component", "notifications"),
+				logger.Str("event", eventType))
+		}
+	}
+
+	notificationStream.publish(event)
+}
+
+// notificationCreatedPayload è il payload di notification.created: la notifica con la stanza
+// del riccio, risolta una volta alla pubblicazione così i client sono filtrati in memoria
+type notificationCreatedPayload struct {
+	Notification
+	RoomID *uint `json:"room_id,omitempty"`
+}
+
+// newNotificationCreatedPayload risolve la stanza in cui è ospitato il riccio della notifica
+func newNotificationCreatedPayload(db *gorm.DB, notification Notification) notificationCreatedPayload {
+	payload := notificationCreatedPayload{Notification: notification}
+	if notification.HedgehogID == nil {
+		return payload
+	}
+
+	var roomIDs []uint
+	db.Model(&Hedgehog{}).
+		Joins("JOIN areas ON areas.id = hedgehogs.area_id").
+		Where("hedgehogs.id = ?", *notification.HedgehogID).
+		Pluck("areas.room_id", &roomIDs)
+	if len(roomIDs) > 0 {
+		payload.RoomID = &roomIDs[0]
+	}
+	return payload
+}
+
+// subscriptionMatches indica se la notifica, nella stanza indicata, rientra nelle preferenze dell'utente
+func subscriptionMatches(subscription NotificationSubscription, notification Notification, roomID *uint) bool {
+	if types := splitList(subscription.Types); len(types) > 0 && !containsString(types, string(notification.Type)) {
+		return false
+	}
+	if priorityRank[notification.Priority] < priorityRank[subscription.MinPriority] {
+		return false
+	}
+
+	rooms := splitList(subscription.RoomIDs)
+	if len(rooms) == 0 || notification.HedgehogID == nil {
+		return true
+	}
+	return roomID != nil && containsString(rooms, strconv.FormatUint(uint64(*roomID), 10))
+}
+
+// eventVisibleTo indica se l'evento va inviato all'utente
+func eventVisibleTo(event NotificationEvent, userID uint, subscription NotificationSubscription) bool {
+	if event.UserID != nil && *event.UserID != userID {
+		return false
+	}
+	if event.Type != NotificationEventCreated {
+		return true
+	}
+
+	var payload notificationCreatedPayload
+	if err := json.Unmarshal([]byte(event.Payload), &payload); err != nil {
+		return false
+	}
+	return subscriptionMatches(subscription, payload.Notification, payload.RoomID)
+}
+
+func writeSSEEvent(w gin.ResponseWriter, event NotificationEvent) {
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Payload)
+	w.Flush()
+}
+
+// lastEventID legge l'ultimo evento ricevuto dal client (header standard o query per la prima connessione)
+func lastEventID(c *gin.Context) uint {
+	value := c.GetHeader("Last-Event-ID")
+	if value == "" {
+		value = c.Query("last_event_id")
+	}
+	id, _ := strconv.ParseUint(value, 10, 64)
+	return uint(id)
+}
+
+// Validità di un biglietto per lo stream
+const streamTicketTTL = time.Minute
+
+// StreamTicketResponse è la risposta con il biglietto per aprire lo stream
+type StreamTicketResponse struct {
+	Ticket    string    `json:"ticket" example:"3f2a9c0d8e7b6a5f4e3d2c1b0a998877"`
+	ExpiresAt time.Time `json:"expires_at" example:"2024-01-15T10:31:00Z" format:"date-time"`
+} // @StreamTicketResponse
+
+// issueStreamTicket crea un biglietto monouso e scarta quelli scaduti. I biglietti stanno nel
+// database perché la connessione può arrivare a un'istanza diversa da quella che li ha emessi
+func issueStreamTicket(db *gorm.DB, userID uint, now time.Time) (StreamTicketResponse, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return StreamTicketResponse{}, err
+	}
+	ticket := StreamTicket{Ticket: hex.EncodeToString(buf), UserID: userID, ExpiresAt: now.Add(streamTicketTTL)}
+
+	db.Where("expires_at <= ?", now).Delete(&StreamTicket{})
+	if err := db.Create(&ticket).Error; err != nil {
+		return StreamTicketResponse{}, err
+	}
+	return StreamTicketResponse{Ticket: ticket.Ticket, ExpiresAt: ticket.ExpiresAt}, nil
+}
+
+// redeemStreamTicket consuma il biglietto e restituisce l'utente, se ancora valido.
+// L'eliminazione condizionata fa sì che una sola richiesta, su qualunque istanza, lo usi.
+func redeemStreamTicket(db *gorm.DB, ticket string, now time.Time) (uint, bool) {
+	if ticket == "" {
+		return 0, false
+	}
+
+	var issued StreamTicket
+	if err := db.Where("ticket = ?", ticket).First(&issued).Error; err != nil {
+		return 0, false
+	}
+	result := db.Where("ticket = ?", ticket).Delete(&StreamTicket{})
+	if result.Error != nil || result.RowsAffected != 1 || !issued.ExpiresAt.After(now) {
+		return 0, false
+	}
+	return issued.UserID, true
+}
+
+// streamAuthMiddleware accetta l'header Authorization o, per EventSource che non invia header,
+// un biglietto monouso: il JWT non finisce così nell'URL e nei log
+func streamAuthMiddleware(db *gorm.DB) gin.HandlerFunc {
+	jwtAuth := authMiddleware()
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") != "" {
+			jwtAuth(c)
+			return
+		}
+
+		userID, ok := redeemStreamTicket(db, c.Query("ticket"), time.Now())
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Valid Authorization header or stream ticket required"})
+			c.Abort()
+			return
+		}
+		c.Set("userID", userID)
+		c.Next()
+	}
+}
+
+// @Summary Create notification stream ticket
+// @Description Create a single-use ticket, valid for one minute, to open the notification stream from clients that cannot set headers (EventSource)
+// @Tags Notifications
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} StreamTicketResponse
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /notifications/stream-ticket [post]
+func createStreamTicketHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := requireUserID(c)
+		if !ok {
+			return
+		}
+
+		ticket, err := issueStreamTicket(db, userID, time.Now())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, ticket)
+	}
+}
+
+// @Summary Stream notifications
+// @Description Server-Sent Events stream of new notifications and read/dismiss/acknowledge/resolve changes for the authenticated user. Reconnect with Last-Event-ID to replay missed events; when more events were missed than can be replayed a notification.reset event asks the client to reload
+// @Tags Notifications
+// @Produce text/event-stream
+// @Security BearerAuth
+// @Param ticket query string false "Single-use ticket from /notifications/stream-ticket, for clients that cannot set headers"
+// @Param Last-Event-ID header string false "ID of the last event received"
+// @Param last_event_id query int false "ID of the last event received, alternative to the header"
+// @Success 200 {string} string "text/event-stream"
+// @Failure 401 {object} map[string]string
+// @Router /notifications/stream [get]
+func streamNotificationsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := requireUserID(c)
+		if !ok {
+			return
+		}
+
+		subscription := loadSubscription(db, userID)
+		lastID := lastEventID(c)
+
+		// Iscrizione prima del replay per non perdere eventi nel frattempo
+		events := notificationStream.subscribe()
+		defer notificationStream.unsubscribe(events)
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+
+		send := func(event NotificationEvent) {
+			if event.ID <= lastID {
+				return
+			}
+			lastID = event.ID
+			if eventVisibleTo(event, userID, subscription) {
+				writeSSEEvent(c.Writer, event)
+			}
+		}
+
+		if lastID > 0 {
+			var missed []NotificationEvent
+			db.Where("id > ? AND (user_id IS NULL OR user_id = ?)", lastID, userID).
+				Order("id ASC").
+				Limit(maxReplayEvents + 1).
+				Find(&missed)
+			if len(missed) > maxReplayEvents {
+				// Troppi eventi persi: si riparte dall'ultimo e il client ricarica la lista
+				var latest NotificationEvent
+				db.Order("id DESC").First(&latest)
+				lastID = latest.ID
+				writeSSEEvent(c.Writer, NotificationEvent{ID: latest.ID, Type: NotificationEventReset, Payload: "{}"})
+				missed = nil
+			}
+			for _, event := range missed {
+				send(event)
+			}
+		}
+
+		fmt.Fprint(c.Writer, "retry: 5000\n\n")
+		c.Writer.Flush()
+
+		heartbeat := time.NewTicker(25 * time.Second)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-c.Request.Context().Done():
+				return
+			case event := <-events:
+				send(event)
+			case <-heartbeat.C:
+				fmt.Fprint(c.Writer, ": ping\n\n")
+				c.Writer.Flush()
+			}
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNotificationStreamReplaysMissedEvents(t *testing.T) {
+	db := newTestDB(t)
+	hedgehog := Hedgehog{Name: "Spillo"}
+	db.Create(&hedgehog)
+
+	ns := NewNotificationService(db)
+	ns.createNotification(Notification{Type: NotificationWeightDrop, Priority: PriorityHigh, Title: "Primo", Message: "m", HedgehogID: &hedgehog.ID})
+	ns.createNotification(Notification{Type: NotificationNoWeighing, Priority: PriorityLow, Title: "Secondo", Message: "m", HedgehogID: &hedgehog.ID})
+	otherUser := uint(2)
+	publishNotificationEvent(db, NotificationEventRead, 1, &otherUser, gin.H{"notification_id": 1})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/stream", func(c *gin.Context) { c.Set("userID", uint(1)) }, streamNotificationsHandler(db))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/stream", nil).WithContext(ctx)
+	req.Header.Set("Last-Event-ID", "1")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	body := w.Body.String()
+	if strings.Contains(body, "Primo") {
+		t.Error("event before Last-Event-ID was replayed")
+	}
+	if !strings.Contains(body, "id: 2\nevent: notification.created\n") || !strings.Contains(body, "Secondo") {
+		t.Errorf("missed event not replayed:\n%s", body)
+	}
+	if strings.Contains(body, "id: 3") {
+		t.Error("event of another user was sent")
+	}
+}
+
+func TestStreamTicketIsSingleUse(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := newTestDB(t)
+	// Due router come due istanze che condividono il database
+	instances := make([]*gin.Engine, 2)
+	for i := range instances {
+		instances[i] = gin.New()
+		instances[i].GET("/stream", streamAuthMiddleware(db), func(c *gin.Context) {
+			c.String(http.StatusOK, "%d", *currentUserID(c))
+		})
+	}
+	getFrom := func(router *gin.Engine, query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stream"+query, nil))
+		return w
+	}
+	get := func(query string) *httptest.ResponseRecorder { return getFrom(instances[0], query) }
+
+	ticket, err := issueStreamTicket(db, 7, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := getFrom(instances[1], "?ticket="+ticket.Ticket); w.Code != http.StatusOK || w.Body.String() != "7" {
+		t.Fatalf("first use on another instance: %d %s", w.Code, w.Body.String())
+	}
+	if w := get("?ticket=" + ticket.Ticket); w.Code != http.StatusUnauthorized {
+		t.Errorf("second use: status = %d", w.Code)
+	}
+
+	expired, _ := issueStreamTicket(db, 7, time.Now().Add(-2*streamTicketTTL))
+	if w := get("?ticket=" + expired.Ticket); w.Code != http.StatusUnauthorized {
+		t.Errorf("expired ticket: status = %d", w.Code)
+	}
+	if w := get("?token=anything"); w.Code != http.StatusUnauthorized {
+		t.Errorf("token in the URL: status = %d", w.Code)
+	}
+}
+
+func TestNotificationStreamResetsWhenGapExceedsReplay(t *testing.T) {
+	db := newTestDB(t)
+	events := make([]NotificationEvent, maxReplayEvents+2)
+	for i := range events {
+		events[i] = NotificationEvent{Type: NotificationEventCreated, NotificationID: uint(i + 1), Payload: "{}"}
+	}
+	db.CreateInBatches(events, 100)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/stream", func(c *gin.Context) { c.Set("userID", uint(1)) }, streamNotificationsHandler(db))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/stream?last_event_id=1", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	body := w.Body.String()
+	want := "id: " + strconv.Itoa(maxReplayEvents+2) + "\nevent: notification.reset\n"
+	if !strings.HasPrefix(body, want) {
+		t.Errorf("stream should start with a reset event, got:\n%.200s", body)
+	}
+	if strings.Contains(body, "event: notification.created") {
+		t.Error("events were replayed despite the reset")
+	}
+}
+
+func TestCreatedEventCarriesHedgehogRoom(t *testing.T) {
+	db := newTestDB(t)
+	room := Room{Name: "Cucciolaia"}
+	db.Create(&room)
+	area := Area{Name: "Box 1", RoomID: room.ID}
+	db.Create(&area)
+	hedgehog := Hedgehog{Name: "Spillo", AreaID: &area.ID}
+	db.Create(&hedgehog)
+
+	NewNotificationService(db).createNotification(Notification{Type: NotificationWeightDrop, Priority: PriorityHigh, Title: "Calo", Message: "m", HedgehogID: &hedgehog.ID})
+	var event NotificationEvent
+	if err := db.Where("type = ?", NotificationEventCreated).First(&event).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(event.Payload, `"room_id":`+strconv.Itoa(int(room.ID))) {
+		t.Errorf("payload without the room: %s", event.Payload)
+	}
+
+	// Il filtro per stanza usa solo il payload
+	if !eventVisibleTo(event, 1, NotificationSubscription{RoomIDs: strconv.Itoa(int(room.ID))}) {
+		t.Error("event hidden from a subscriber of its room")
+	}
+	if eventVisibleTo(event, 1, NotificationSubscription{RoomIDs: "99"}) {
+		t.Error("event shown to a subscriber of another room")
+	}
+}
@@ -0,0 +1,615 @@
+// notification_templates.go - Modelli localizzati di notifiche ed email
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/laninna/hedgehog-app/logger"
+	"gorm.io/gorm"
+)
+
+// Canali dei modelli: titolo e messaggio in-app, oggetto e corpo HTML delle email
+const (
+	TemplateChannelInApp = "in_app"
+	TemplateChannelEmail = "email"
+)
+
+// Modello generico del canale, usato quando manca quello del tipo
+const templateAnyType = "*"
+
+var templateChannels = []string{TemplateChannelInApp, TemplateChannelEmail}
+var templateLocales = []string{"it", "en"}
+
+// defaultLocale è la lingua con cui vengono salvate le notifiche
+func defaultLocale() string {
+	locale := getEnv("NOTIFICATION_LOCALE", "it")
+	if !containsString(templateLocales, locale) {
+		return "it"
+	}
+	return locale
+}
+
+type builtinTemplate struct {
+	Title string
+	Body  string
+}
+
+func templateKey(notifType, channel, locale string) string {
+	return notifType + "/" + channel + "/" + locale
+}
+
+// builtinTemplates sono i modelli predefiniti, sovrascrivibili dall'API
+var builtinTemplates = map[string]builtinTemplate{
+	templateKey(string(NotificationTherapyExpired), TemplateChannelInApp, "it"): {
+		Title: `Terapia Scaduta: {{.Therapy}}`,
+		Body:  `La terapia '{{.Therapy}}' per {{.Hedgehog}} è scaduta il {{date .EndDate}}`,
+	},
+	templateKey(string(NotificationTherapyExpired), TemplateChannelInApp, "en"): {
+		Title: `Therapy Expired: {{.Therapy}}`,
+		Body:  `The therapy '{{.Therapy}}' for {{.Hedgehog}} expired on {{date .EndDate}}`,
+	},
+	templateKey(string(NotificationTherapyExpiring), TemplateChannelInApp, "it"): {
+		Title: `Terapia in Scadenza: {{.Therapy}}`,
+		Body:  `La terapia '{{.Therapy}}' per {{.Hedgehog}} scadrà tra {{.DaysLeft}} giorni`,
+	},
+	templateKey(string(NotificationTherapyExpiring), TemplateChannelInApp, "en"): {
+		Title: `Therapy Expiring: {{.Therapy}}`,
+		Body:  `The therapy '{{.Therapy}}' for {{.Hedgehog}} expires in {{.DaysLeft}} days`,
+	},
+	templateKey(string(NotificationWeightDrop), TemplateChannelInApp, "it"): {
+		Title: `Allarme Peso: {{.Hedgehog}}`,
+		Body:  `{{.Reason}}`,
+	},
+	templateKey(string(NotificationWeightDrop), TemplateChannelInApp, "en"): {
+		Title: `Weight Alert: {{.Hedgehog}}`,
+		Body:  `{{.Hedgehog}} changed by {{printf "%.1f" .WeightChange}}g and now weighs {{printf "%.0f" .CurrentWeight}}g (trend: {{.Trend}})`,
+	},
+	templateKey(string(NotificationWeightStagnation), TemplateChannelInApp, "it"): {
+		Title: `Allarme Peso: {{.Hedgehog}}`,
+		Body:  `{{.Reason}}`,
+	},
+	templateKey(string(NotificationWeightStagnation), TemplateChannelInApp, "en"): {
+		Title: `Weight Alert: {{.Hedgehog}}`,
+		Body:  `{{.Hedgehog}}'s weight is stagnating ({{printf "%.1f" .WeightChange}}g), now {{printf "%.0f" .CurrentWeight}}g`,
+	},
+	templateKey(string(NotificationGrowthBehind), TemplateChannelInApp, "it"): {
+		Title: `Allarme Peso: {{.Hedgehog}}`,
+		Body:  `{{.Reason}}`,
+	},
+	templateKey(string(NotificationGrowthBehind), TemplateChannelInApp, "en"): {
+		Title: `Growth Alert: {{.Hedgehog}}`,
+		Body:  `{{.Hedgehog}} is behind the expected growth curve, now {{printf "%.0f" .CurrentWeight}}g`,
+	},
+	templateKey(string(NotificationNoWeighing), TemplateChannelInApp, "it"): {
+		Title: `Pesatura Mancante: {{.Hedgehog}}`,
+		Body:  `{{.Hedgehog}} non viene pesato da {{.DaysSince}} giorni`,
+	},
+	templateKey(string(NotificationNoWeighing), TemplateChannelInApp, "en"): {
+		Title: `Missing Weighing: {{.Hedgehog}}`,
+		Body:  `{{.Hedgehog}} has not been weighed for {{.DaysSince}} days`,
+	},
+	templateKey(string(NotificationLowFoodIntake), TemplateChannelInApp, "it"): {
+		Title: `Non Mangia: {{.Hedgehog}}`,
+		Body:  `{{.Hedgehog}} ha mangiato solo il {{printf "%.0f" .IntakeRatio}}% del previsto negli ultimi {{.Days}} giorni`,
+	},
+	templateKey(string(NotificationLowFoodIntake), TemplateChannelInApp, "en"): {
+		Title: `Not Eating: {{.Hedgehog}}`,
+		Body:  `{{.Hedgehog}} ate only {{printf "%.0f" .IntakeRatio}}% of the planned food over the last {{.Days}} days`,
+	},
+	templateKey(string(NotificationHibernationLoss), TemplateChannelInApp, "it"): {
+		Title: `Letargo - Calo Peso: {{.Hedgehog}}`,
+		Body:  `{{.Hedgehog}} ha perso il {{printf "%.1f" .LossPercent}}% del peso dall'inizio del letargo ({{printf "%.0f" .StartWeight}}g → {{printf "%.0f" .CurrentWeight}}g)`,
+	},
+	templateKey(string(NotificationHibernationLoss), TemplateChannelInApp, "en"): {
+		Title: `Hibernation - Weight Loss: {{.Hedgehog}}`,
+		Body:  `{{.Hedgehog}} lost {{printf "%.1f" .LossPercent}}% of its weight since hibernation started ({{printf "%.0f" .StartWeight}}g → {{printf "%.0f" .CurrentWeight}}g)`,
+	},
+	templateKey(string(NotificationHibernationWaking), TemplateChannelInApp, "it"): {
+		Title: `Letargo - Risvegli Frequenti: {{.Hedgehog}}`,
+		Body:  `{{.Hedgehog}} si è svegliato {{.Wakes}} volte negli ultimi {{.Days}} giorni`,
+	},
+	templateKey(string(NotificationHibernationWaking), TemplateChannelInApp, "en"): {
+		Title: `Hibernation - Frequent Waking: {{.Hedgehog}}`,
+		Body:  `{{.Hedgehog}} woke up {{.Wakes}} times in the last {{.Days}} days`,
+	},
+	templateKey(string(NotificationHedgehogRecovered), TemplateChannelInApp, "it"): {
+		Title: `Pronto per il Rilascio: {{.Hedgehog}}`,
+		Body:  `{{.Hedgehog}} soddisfa tutti i criteri di rilascio (peso {{printf "%.0f" .Weight}}g, trend {{.Trend}})`,
+	},
+	templateKey(string(NotificationHedgehogRecovered), TemplateChannelInApp, "en"): {
+		Title: `Ready for Release: {{.Hedgehog}}`,
+		Body:  `{{.Hedgehog}} meets all release criteria (weight {{printf "%.0f" .Weight}}g, trend {{.Trend}})`,
+	},
+	templateKey(string(NotificationLabTestDue), TemplateChannelInApp, "it"): {
+		Title: `Esame di Controllo: {{.Hedgehog}}`,
+		Body:  `{{.Hedgehog}} ha terminato la sverminazione '{{.Therapy}}' il {{date .EndDate}}: è necessario un esame feci di controllo`,
+	},
+	templateKey(string(NotificationLabTestDue), TemplateChannelInApp, "en"): {
+		Title: `Follow-up Test: {{.Hedgehog}}`,
+		Body:  `{{.Hedgehog}} finished the deworming '{{.Therapy}}' on {{date .EndDate}}: a follow-up faecal test is needed`,
+	},
+	templateKey(templateAnyType, TemplateChannelEmail, "it"): {
+		Title: `[La Ninna] {{.Title}}`,
+		Body:  emailTemplate,
+	},
+	templateKey(templateAnyType, TemplateChannelEmail, "en"): {
+		Title: `[La Ninna] {{.Title}}`,
+		Body:  emailTemplateEN,
+	},
+}
+
+// emailTemplateEN è la versione inglese del modello email predefinito
+var emailTemplateEN = strings.NewReplacer(
+	`Centro Recupero Ricci "La Ninna"`, `"La Ninna" Hedgehog Rescue Centre`,
+	"Sistema di Notifiche", "Notification System",
+	"<strong>Riccio:</strong>", "<strong>Hedgehog:</strong>",
+	"<strong>Terapia:</strong>", "<strong>Therapy:</strong>",
+	"<strong>Data:</strong>", "<strong>Date:</strong>",
+	"Dettagli aggiuntivi:", "Additional details:",
+	`Questo è un messaggio automatico dal sistema di gestione del Centro Ricci "La Ninna".`, `This is an automatic message from the "La Ninna" hedgehog rescue centre.`,
+	"Gestisci le notifiche", "Manage notifications",
+	"Impostazioni", "Settings",
+).Replace(emailTemplate)
+
+// templateSamples sono i dati usati dall'anteprima quando non ne vengono forniti
+var templateSamples = map[string]map[string]interface{}{
+	string(NotificationTherapyExpired):    {"Therapy": "Antibiotico", "Hedgehog": "Spillo", "EndDate": "2024-01-15T00:00:00Z", "DaysOverdue": 2},
+	string(NotificationTherapyExpiring):   {"Therapy": "Antibiotico", "Hedgehog": "Spillo", "EndDate": "2024-01-18T00:00:00Z", "DaysLeft": 3},
+	string(NotificationWeightDrop):        {"Hedgehog": "Spillo", "Reason": "Perdita di peso significativa: -60.0g in 7 giorni", "WeightChange": -60.0, "CurrentWeight": 540.0, "Trend": "critical"},
+	string(NotificationWeightStagnation):  {"Hedgehog": "Spillo", "Reason": "Peso stagnante da 14 giorni (variazione: 2.0g)", "WeightChange": 2.0, "CurrentWeight": 540.0, "Trend": "stable"},
+	string(NotificationGrowthBehind):      {"Hedgehog": "Spillo", "Reason": "Crescita insufficiente", "WeightChange": 5.0, "CurrentWeight": 250.0, "Trend": "stable"},
+	string(NotificationNoWeighing):        {"Hedgehog": "Spillo", "DaysSince": 9},
+	string(NotificationLowFoodIntake):     {"Hedgehog": "Spillo", "IntakeRatio": 35.0, "Days": 2},
+	string(NotificationHibernationLoss):   {"Hedgehog": "Spillo", "LossPercent": 21.5, "StartWeight": 700.0, "CurrentWeight": 549.0},
+	string(NotificationHibernationWaking): {"Hedgehog": "Spillo", "Wakes": 4, "Days": 7},
+	string(NotificationHedgehogRecovered): {"Hedgehog": "Spillo", "Weight": 720.0, "Trend": "improving"},
+	string(NotificationLabTestDue):        {"Hedgehog": "Spillo", "Therapy": "Panacur", "EndDate": "2024-01-10T00:00:00Z"},
+}
+
+// sampleEmailData sono i dati di esempio per l'anteprima dei modelli email
+func sampleEmailData(locale string) EmailData {
+	return EmailData{
+		Subject:       "[La Ninna] Allarme Peso: Spillo",
+		Title:         "Allarme Peso: Spillo",
+		Message:       "Perdita di peso significativa: -60.0g in 7 giorni",
+		PriorityClass: "critical",
+		HedgehogName:  "Spillo",
+		CreatedAt:     formatLocaleDateTime(time.Date(2024, 1, 15, 10, 30, 0, 0, time.Local), locale),
+		ActionURL:     "/hedgehogs/1",
+		ActionLabel:   "Controlla Peso",
+		BaseURL:       getEnv("BASE_URL", "http://localhost:8080"),
+		Locale:        locale,
+	}
+}
+
+// templateVars serializza le variabili di una notifica
+func templateVars(vars map[string]interface{}) string {
+	data, _ := json.Marshal(vars)
+	return string(data)
+}
+
+func formatLocaleDate(t time.Time, locale string) string {
+	if locale == "en" {
+		return t.Format("Jan 2, 2006")
+	}
+	return t.Format("02/01/2006")
+}
+
+func formatLocaleDateTime(t time.Time, locale string) string {
+	if locale == "en" {
+		return t.Format("Jan 2, 2006 15:04")
+	}
+	return t.Format("02/01/2006 15:04")
+}
+
+// templateFuncs espone ai modelli le funzioni dipendenti dalla lingua
+func templateFuncs(locale string) map[string]interface{} {
+	return map[string]interface{}{
+		"date": func(value interface{}) string {
+			switch v := value.(type) {
+			case time.Time:
+				return formatLocaleDate(v, locale)
+			case *time.Time:
+				if v != nil {
+					return formatLocaleDate(*v, locale)
+				}
+			case string:
+				if t, err := time.Parse(time.RFC3339, v); err == nil {
+					return formatLocaleDate(t, locale)
+				}
+				return v
+			}
+			return ""
+		},
+	}
+}
+
+// renderText esegue un modello testuale (titoli, messaggi, oggetti)
+func renderText(text, locale string, data interface{}, strict bool) (string, error) {
+	tmpl := template.New("text").Funcs(templateFuncs(locale))
+	if strict {
+		tmpl = tmpl.Option("missingkey=error")
+	}
+	tmpl, err := tmpl.Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// renderHTML esegue un modello HTML con escaping automatico (corpo delle email)
+func renderHTML(text, locale string, data interface{}, strict bool) (string, error) {
+	tmpl := htmltemplate.New("html").Funcs(templateFuncs(locale))
+	if strict {
+		tmpl = tmpl.Option("missingkey=error")
+	}
+	tmpl, err := tmpl.Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// resolveTemplate cerca il modello personalizzato, poi il predefinito, poi il generico del canale,
+// infine ripete la ricerca nella lingua predefinita
+func resolveTemplate(db *gorm.DB, notifType, channel, locale string) (NotificationTemplate, bool) {
+	locales := []string{locale}
+	if fallback := defaultLocale(); fallback != locale {
+		locales = append(locales, fallback)
+	}
+
+	for _, loc := range locales {
+		for _, t := range []string{notifType, templateAnyType} {
+			if db != nil {
+				var custom NotificationTemplate
+				if db.Where("type = ? AND channel = ? AND locale = ?", t, channel, loc).First(&custom).Error == nil {
+					custom.Custom = true
+					return custom, true
+				}
+			}
+			if builtin, ok := builtinTemplates[templateKey(t, channel, loc)]; ok {
+				return NotificationTemplate{Type: t, Channel: channel, Locale: loc, Title: builtin.Title, Body: builtin.Body}, true
+			}
+		}
+	}
+	return NotificationTemplate{}, false
+}
+
+// builtinFor restituisce il modello predefinito equivalente, usato se quello personalizzato fallisce
+func builtinFor(tmpl NotificationTemplate) (builtinTemplate, bool) {
+	if builtin, ok := builtinTemplates[templateKey(tmpl.Type, tmpl.Channel, tmpl.Locale)]; ok {
+		return builtin, true
+	}
+	builtin, ok := builtinTemplates[templateKey(templateAnyType, tmpl.Channel, tmpl.Locale)]
+	return builtin, ok
+}
+
+// localizeNotification ricompone titolo e messaggio nella lingua indicata a partire dalle variabili salvate
+func localizeNotification(db *gorm.DB, notification *Notification, locale string) {
+	if notification.TemplateData == "" {
+		return
+	}
+
+	var vars map[string]interface{}
+	if err := json.Unmarshal([]byte(notification.TemplateData), &vars); err != nil {
+		return
+	}
+
+	tmpl, ok := resolveTemplate(db, string(notification.Type), TemplateChannelInApp, locale)
+	if !ok {
+		return
+	}
+
+	title, err := renderText(tmpl.Title, tmpl.Locale, vars, false)
+	var message string
+	if err == nil {
+		message, err = renderText(tmpl.Body, tmpl.Locale, vars, false)
+	}
+	if err != nil && tmpl.Custom {
+		logger.Warn("Modello notifica non valido, uso il predefinito",
+			logger.Str("component", "notifications"),
+			logger.Str("template", templateKey(tmpl.Type, tmpl.Channel, tmpl.Locale)),
+			logger.Err(err))
+		if builtin, found := builtinFor(tmpl); found {
+			title, err = renderText(builtin.Title, tmpl.Locale, vars, false)
+			if err == nil {
+				message, err = renderText(builtin.Body, tmpl.Locale, vars, false)
+			}
+		}
+	}
+	if err != nil {
+		return
+	}
+
+	notification.Title = title
+	notification.Message = message
+	notification.Locale = tmpl.Locale
+}
+
+// localizeNotifications adatta un elenco alla lingua dell'utente
+func localizeNotifications(db *gorm.DB, notifications []Notification, locale string) {
+	for i := range notifications {
+		if notifications[i].Locale != locale {
+			localizeNotification(db, &notifications[i], locale)
+		}
+	}
+}
+
+// userLocale restituisce la lingua scelta dall'utente nelle preferenze
+func userLocale(db *gorm.DB, userID uint) string {
+	if locale := loadSubscription(db, userID).Locale; locale != "" {
+		return locale
+	}
+	return defaultLocale()
+}
+
+// recipientLocale restituisce la lingua del destinatario email, se è un utente registrato
+func recipientLocale(db *gorm.DB, email string) string {
+	if db == nil || email == "" {
+		return defaultLocale()
+	}
+	var user User
+	if err := db.Where("email = ?", email).First(&user).Error; err != nil {
+		return defaultLocale()
+	}
+	return userLocale(db, user.ID)
+}
+
+// validateNotificationTemplate controlla sintassi e variabili del modello sui dati di esempio
+func validateNotificationTemplate(tmpl NotificationTemplate) error {
+	if !containsString(templateChannels, tmpl.Channel) {
+		return fmt.Errorf("channel must be one of %s", strings.Join(templateChannels, ", "))
+	}
+	if !containsString(templateLocales, tmpl.Locale) {
+		return fmt.Errorf("locale must be one of %s", strings.Join(templateLocales, ", "))
+	}
+	if tmpl.Type == templateAnyType && tmpl.Channel == TemplateChannelInApp {
+		return errors.New("in_app templates must target a notification type")
+	}
+	if _, known := templateSamples[tmpl.Type]; !known && tmpl.Type != templateAnyType {
+		return fmt.Errorf("unknown notification type: %s", tmpl.Type)
+	}
+	if strings.TrimSpace(tmpl.Title) == "" || strings.TrimSpace(tmpl.Body) == "" {
+		return errors.New("title and body are required")
+	}
+
+	_, _, err := renderTemplatePreview(tmpl, nil, true)
+	return err
+}
+
+// renderTemplatePreview esegue titolo e corpo su dati forniti o di esempio
+func renderTemplatePreview(tmpl NotificationTemplate, data map[string]interface{}, strict bool) (string, string, error) {
+	if tmpl.Channel == TemplateChannelEmail {
+		email := sampleEmailData(tmpl.Locale)
+		if data != nil {
+			email.Vars = data
+		} else {
+			email.Vars = templateSamples[tmpl.Type]
+		}
+		subject, err := renderText(tmpl.Title, tmpl.Locale, email, strict)
+		if err != nil {
+			return "", "", fmt.Errorf("title: %v", err)
+		}
+		body, err := renderHTML(tmpl.Body, tmpl.Locale, email, strict)
+		if err != nil {
+			return "", "", fmt.Errorf("body: %v", err)
+		}
+		return subject, body, nil
+	}
+
+	if data == nil {
+		data = templateSamples[tmpl.Type]
+	}
+	title, err := renderText(tmpl.Title, tmpl.Locale, data, strict)
+	if err != nil {
+		return "", "", fmt.Errorf("title: %v", err)
+	}
+	body, err := renderText(tmpl.Body, tmpl.Locale, data, strict)
+	if err != nil {
+		return "", "", fmt.Errorf("body: %v", err)
+	}
+	return title, body, nil
+}
+
+// @Summary List notification templates
+// @Description List the effective templates per notification type, channel and locale; custom ones override the built-in defaults
+// @Tags Notification Templates
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param type query string false "Filter by notification type (* for the generic email template)"
+// @Param channel query string false "Filter by channel" Enums(in_app, email)
+// @Param locale query string false "Filter by locale" Enums(it, en)
+// @Success 200 {array} NotificationTemplate
+// @Failure 401 {object} map[string]string
+// @Router /notification-templates [get]
+func getNotificationTemplatesHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var customs []NotificationTemplate
+		db.Find(&customs)
+		custom := make(map[string]NotificationTemplate, len(customs))
+		for _, tmpl := range customs {
+			tmpl.Custom = true
+			custom[templateKey(tmpl.Type, tmpl.Channel, tmpl.Locale)] = tmpl
+		}
+
+		keys := make(map[string]bool)
+		for key := range builtinTemplates {
+			keys[key] = true
+		}
+		for key := range custom {
+			keys[key] = true
+		}
+
+		templates := []NotificationTemplate{}
+		for key := range keys {
+			tmpl, ok := custom[key]
+			if !ok {
+				parts := strings.SplitN(key, "/", 3)
+				builtin := builtinTemplates[key]
+				tmpl = NotificationTemplate{Type: parts[0], Channel: parts[1], Locale: parts[2], Title: builtin.Title, Body: builtin.Body}
+			}
+			if (c.Query("type") != "" && tmpl.Type != c.Query("type")) ||
+				(c.Query("channel") != "" && tmpl.Channel != c.Query("channel")) ||
+				(c.Query("locale") != "" && tmpl.Locale != c.Query("locale")) {
+				continue
+			}
+			templates = append(templates, tmpl)
+		}
+
+		sort.Slice(templates, func(i, j int) bool {
+			return templateKey(templates[i].Type, templates[i].Channel, templates[i].Locale) <
+				templateKey(templates[j].Type, templates[j].Channel, templates[j].Locale)
+		})
+		c.JSON(http.StatusOK, templates)
+	}
+}
+
+// @Summary Update notification template
+// @Description Save a custom template for a notification type, channel and locale. Templates use Go template syntax and are validated against sample data
+// @Tags Notification Templates
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param type path string true "Notification type, or * for the generic email template"
+// @Param channel path string true "Channel" Enums(in_app, email)
+// @Param locale path string true "Locale" Enums(it, en)
+// @Param template body NotificationTemplate true "Title and body"
+// @Success 200 {object} NotificationTemplate
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /notification-templates/{type}/{channel}/{locale} [put]
+func updateNotificationTemplateHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request struct {
+			Title string `json:"title"`
+			Body  string `json:"body"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var tmpl NotificationTemplate
+		db.Where("type = ? AND channel = ? AND locale = ?", c.Param("type"), c.Param("channel"), c.Param("locale")).First(&tmpl)
+		tmpl.Type, tmpl.Channel, tmpl.Locale = c.Param("type"), c.Param("channel"), c.Param("locale")
+		tmpl.Title, tmpl.Body = request.Title, request.Body
+		tmpl.UpdatedBy = currentUserID(c)
+
+		if err := validateNotificationTemplate(tmpl); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := db.Save(&tmpl).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		tmpl.Custom = true
+		c.JSON(http.StatusOK, tmpl)
+	}
+}
+
+// @Summary Reset notification template
+// @Description Delete the custom template, restoring the built-in default
+// @Tags Notification Templates
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param type path string true "Notification type, or * for the generic email template"
+// @Param channel path string true "Channel" Enums(in_app, email)
+// @Param locale path string true "Locale" Enums(it, en)
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /notification-templates/{type}/{channel}/{locale} [delete]
+func deleteNotificationTemplateHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result := db.Where("type = ? AND channel = ? AND locale = ?", c.Param("type"), c.Param("channel"), c.Param("locale")).
+			Delete(&NotificationTemplate{})
+		if result.RowsAffected == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Custom template not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Template reset to default"})
+	}
+}
+
+// TemplatePreviewRequest è la richiesta di anteprima di un modello
+type TemplatePreviewRequest struct {
+	Type    string                 `json:"type" binding:"required" example:"weight_drop"`
+	Channel string                 `json:"channel" example:"in_app" enums:"in_app,email"`
+	Locale  string                 `json:"locale" example:"en" enums:"it,en"`
+	Title   string                 `json:"title" example:"Weight Alert: {{.Hedgehog}}"`
+	Body    string                 `json:"body" example:"{{.Hedgehog}} now weighs {{printf \"%.0f\" .CurrentWeight}}g"`
+	Data    map[string]interface{} `json:"data"`
+} // @TemplatePreviewRequest
+
+// @Summary Preview notification template
+// @Description Render a template against sample data (or the given data). Without title and body the effective template is rendered
+// @Tags Notification Templates
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param preview body TemplatePreviewRequest true "Template and data"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /notification-templates/preview [post]
+func previewNotificationTemplateHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request TemplatePreviewRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if request.Channel == "" {
+			request.Channel = TemplateChannelInApp
+		}
+		if request.Locale == "" {
+			request.Locale = defaultLocale()
+		}
+
+		tmpl := NotificationTemplate{Type: request.Type, Channel: request.Channel, Locale: request.Locale, Title: request.Title, Body: request.Body}
+		if tmpl.Title == "" || tmpl.Body == "" {
+			effective, ok := resolveTemplate(db, request.Type, request.Channel, request.Locale)
+			if !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "No template for this type, channel and locale"})
+				return
+			}
+			if tmpl.Title == "" {
+				tmpl.Title = effective.Title
+			}
+			if tmpl.Body == "" {
+				tmpl.Body = effective.Body
+			}
+		}
+
+		title, body, err := renderTemplatePreview(tmpl, request.Data, false)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"title": title, "body": body})
+	}
+}
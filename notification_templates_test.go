@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLocalizeNotificationBuiltin(t *testing.T) {
+	db := newTestDB(t)
+	notification := Notification{
+		Type:         NotificationNoWeighing,
+		TemplateData: templateVars(map[string]interface{}{"Hedgehog": "Spillo", "DaysSince": 9}),
+	}
+
+	localizeNotification(db, &notification, "it")
+	if notification.Title != "Pesatura Mancante: Spillo" || notification.Locale != "it" {
+		t.Errorf("it = %q (%s)", notification.Title, notification.Locale)
+	}
+
+	localizeNotification(db, &notification, "en")
+	if notification.Message != "Spillo has not been weighed for 9 days" || notification.Locale != "en" {
+		t.Errorf("en = %q (%s)", notification.Message, notification.Locale)
+	}
+}
+
+func TestCustomTemplateOverridesBuiltin(t *testing.T) {
+	db := newTestDB(t)
+	db.Create(&NotificationTemplate{Type: string(NotificationNoWeighing), Channel: TemplateChannelInApp, Locale: "en", Title: "Scale please: {{.Hedgehog}}", Body: "{{.DaysSince}} days without weighing"})
+
+	notification := Notification{Type: NotificationNoWeighing, TemplateData: templateVars(map[string]interface{}{"Hedgehog": "Spillo", "DaysSince": 9})}
+	localizeNotification(db, &notification, "en")
+	if notification.Title != "Scale please: Spillo" || notification.Message != "9 days without weighing" {
+		t.Errorf("custom = %q / %q", notification.Title, notification.Message)
+	}
+
+	localizeNotification(db, &notification, "it")
+	if notification.Title != "Pesatura Mancante: Spillo" {
+		t.Errorf("it should keep the builtin, got %q", notification.Title)
+	}
+}
+
+func TestValidateNotificationTemplate(t *testing.T) {
+	valid := NotificationTemplate{Type: string(NotificationNoWeighing), Channel: TemplateChannelInApp, Locale: "en", Title: "{{.Hedgehog}}", Body: "{{.DaysSince}} days"}
+	if err := validateNotificationTemplate(valid); err != nil {
+		t.Errorf("valid template rejected: %v", err)
+	}
+
+	cases := map[string]NotificationTemplate{
+		"unknown variable": {Type: string(NotificationNoWeighing), Channel: TemplateChannelInApp, Locale: "en", Title: "{{.Riccio}}", Body: "x"},
+		"bad syntax":       {Type: string(NotificationNoWeighing), Channel: TemplateChannelInApp, Locale: "en", Title: "{{.Hedgehog", Body: "x"},
+		"bad locale":       {Type: string(NotificationNoWeighing), Channel: TemplateChannelInApp, Locale: "de", Title: "x", Body: "x"},
+		"generic in_app":   {Type: templateAnyType, Channel: TemplateChannelInApp, Locale: "en", Title: "x", Body: "x"},
+	}
+	for name, tmpl := range cases {
+		if err := validateNotificationTemplate(tmpl); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestPreviewNotificationTemplateHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := newTestDB(t)
+	router := gin.New()
+	router.POST("/notification-templates/preview", previewNotificationTemplateHandler(db))
+
+	payload, _ := json.Marshal(TemplatePreviewRequest{Type: string(NotificationLowFoodIntake), Locale: "en"})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/notification-templates/preview", bytes.NewReader(payload)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	var preview map[string]string
+	json.Unmarshal(w.Body.Bytes(), &preview)
+	if preview["title"] != "Not Eating: Spillo" || !strings.Contains(preview["body"], "35%") {
+		t.Errorf("preview = %+v", preview)
+	}
+}
+
+func TestNotificationEmailUsesRecipientLocale(t *testing.T) {
+	db := newTestDB(t)
+	memoryMailbox.Reset()
+	db.Create(&EmailTransportSettings{Transport: EmailTransportMemory, From: "notifiche@laninna.it", Enabled: true})
+	user := User{Username: "jane", Email: "jane@laninna.it", Password: "x"}
+	db.Create(&user)
+	db.Create(&NotificationSubscription{UserID: user.ID, Locale: "en"})
+
+	notification := Notification{Type: NotificationNoWeighing, Priority: PriorityMedium, Title: "Pesatura Mancante: Spillo",
+		TemplateData: templateVars(map[string]interface{}{"Hedgehog": "Spillo", "DaysSince": 9})}
+	if err := NewEmailService(db).SendNotificationEmail(notification, user.Email); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := memoryMailbox.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("memory mailbox = %+v", messages)
+	}
+	if messages[0].Subject != "[La Ninna] Missing Weighing: Spillo" || !strings.Contains(messages[0].HTMLBody, "Manage notifications") {
+		t.Errorf("email = %s\n%s", messages[0].Subject, messages[0].HTMLBody)
+	}
+}
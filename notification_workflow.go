@@ -0,0 +1,390 @@
+// notification_workflow.go - Assegnazione, posticipo e risoluzione delle notifiche
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Stati di una notifica nella casella, per il filtro state
+const (
+	NotificationStateOpen     = "open"
+	NotificationStateSnoozed  = "snoozed"
+	NotificationStateResolved = "resolved"
+	NotificationStateAll      = "all"
+)
+
+// resolutionActions associa i tipi di azione risolutiva al record collegato
+var resolutionActions = map[string]interface{}{
+	"weight_record": &WeightRecord{},
+	"therapy":       &Therapy{},
+	"therapy_dose":  &TherapyDose{},
+	"lab_test":      &LabTest{},
+	"food_intake":   &FoodIntake{},
+	"daily_task":    &DailyTask{},
+}
+
+// NotificationAssignRequest è il corpo della richiesta di assegnazione
+type NotificationAssignRequest struct {
+	UserID *uint `json:"user_id" example:"2"`
+} // @NotificationAssignRequest
+
+// NotificationSnoozeRequest è il corpo della richiesta di posticipo
+type NotificationSnoozeRequest struct {
+	Until   *time.Time `json:"until" example:"2024-01-16T08:00:00Z" format:"date-time"`
+	Minutes int        `json:"minutes" example:"120" minimum:"1"`
+} // @NotificationSnoozeRequest
+
+// NotificationResolveRequest è il corpo della richiesta di risoluzione
+type NotificationResolveRequest struct {
+	Note       string `json:"note" example:"Pesato dopo il pasto serale"`
+	ActionType string `json:"action_type" example:"weight_record" enums:"weight_record,therapy,therapy_dose,lab_test,food_intake,daily_task"`
+	ActionID   *uint  `json:"action_id" example:"42"`
+} // @NotificationResolveRequest
+
+// openNotifications limita la query alle notifiche non risolte e non posticipate
+func openNotifications(query *gorm.DB, now time.Time) *gorm.DB {
+	return query.Where("notifications.resolved_at IS NULL").
+		Where("notifications.snoozed_until IS NULL OR notifications.snoozed_until <= ?", now)
+}
+
+// filterNotificationState applica il filtro per stato della casella
+func filterNotificationState(query *gorm.DB, state string, now time.Time) (*gorm.DB, error) {
+	switch state {
+	case "", NotificationStateOpen:
+		return openNotifications(query, now), nil
+	case NotificationStateSnoozed:
+		return query.Where("notifications.resolved_at IS NULL AND notifications.snoozed_until > ?", now), nil
+	case NotificationStateResolved:
+		return query.Where("notifications.resolved_at IS NOT NULL"), nil
+	case NotificationStateAll:
+		return query, nil
+	}
+	return nil, errors.New("state must be one of open, snoozed, resolved, all")
+}
+
+// resolveNotification chiude la notifica, la considera presa in carico e registra l'evento
+func resolveNotification(db *gorm.DB, notification *Notification, userID *uint, note, actionType string, actionID *uint) error {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"resolved_at":          now,
+		"resolved_by":          userID,
+		"resolution_note":      note,
+		"resolution_action":    actionType,
+		"resolution_action_id": actionID,
+		"snoozed_until":        nil,
+	}
+	if notification.AcknowledgedAt == nil {
+		updates["acknowledged_at"] = now
+		updates["acknowledged_by"] = userID
+	}
+	if err := db.Model(notification).Updates(updates).Error; err != nil {
+		return err
+	}
+
+	notification.ResolvedAt = &now
+	notification.ResolvedBy = userID
+	notification.ResolutionNote = note
+	notification.ResolutionAction = actionType
+	notification.ResolutionActionID = actionID
+	notification.SnoozedUntil = nil
+	if notification.AcknowledgedAt == nil {
+		notification.AcknowledgedAt = &now
+		notification.AcknowledgedBy = userID
+	}
+
+	publishNotificationEvent(db, NotificationEventResolved, notification.ID, nil, gin.H{
+		"notification_id":      notification.ID,
+		"resolved_at":          now,
+		"resolved_by":          userID,
+		"resolution_note":      note,
+		"resolution_action":    actionType,
+		"resolution_action_id": actionID,
+	})
+	return nil
+}
+
+// loadWorkflowNotification legge la notifica indicata nel percorso o risponde 404
+func loadWorkflowNotification(c *gin.Context, db *gorm.DB) (Notification, bool) {
+	var notification Notification
+	if err := db.First(&notification, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+		return notification, false
+	}
+	return notification, true
+}
+
+// @Summary Assign notification
+// @Description Assign a notification to a user, or unassign it with a null user_id
+// @Tags Notifications
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Notification ID"
+// @Param assignment body NotificationAssignRequest true "User to assign"
+// @Success 200 {object} Notification
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /notifications/{id}/assign [put]
+func assignNotificationHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actorID, ok := requireUserID(c)
+		if !ok {
+			return
+		}
+		notification, ok := loadWorkflowNotification(c, db)
+		if !ok {
+			return
+		}
+
+		var request NotificationAssignRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if request.UserID != nil {
+			var user User
+			if err := db.First(&user, *request.UserID).Error; err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "User not found"})
+				return
+			}
+		}
+
+		var assignedAt *time.Time
+		if request.UserID != nil {
+			now := time.Now()
+			assignedAt = &now
+		}
+		if err := db.Model(&notification).Updates(map[string]interface{}{
+			"assigned_to": request.UserID,
+			"assigned_at": assignedAt,
+		}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		notification.AssignedTo = request.UserID
+		notification.AssignedAt = assignedAt
+
+		publishNotificationEvent(db, NotificationEventAssigned, notification.ID, nil, gin.H{
+			"notification_id": notification.ID,
+			"assigned_to":     request.UserID,
+			"assigned_by":     actorID,
+		})
+
+		c.JSON(http.StatusOK, notification)
+	}
+}
+
+// @Summary Snooze notification
+// @Description Hide a notification until the given time (or for the given minutes). While snoozed it is not escalated and the rule that raised it does not repeat it
+// @Tags Notifications
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Notification ID"
+// @Param snooze body NotificationSnoozeRequest true "Until when to snooze"
+// @Success 200 {object} Notification
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /notifications/{id}/snooze [put]
+func snoozeNotificationHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := requireUserID(c)
+		if !ok {
+			return
+		}
+		notification, ok := loadWorkflowNotification(c, db)
+		if !ok {
+			return
+		}
+		if notification.ResolvedAt != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "Notification is already resolved"})
+			return
+		}
+
+		var request NotificationSnoozeRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		now := time.Now()
+		until := request.Until
+		if until == nil && request.Minutes > 0 {
+			t := now.Add(time.Duration(request.Minutes) * time.Minute)
+			until = &t
+		}
+		if until == nil || !until.After(now) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "until must be in the future, or minutes must be positive"})
+			return
+		}
+
+		if err := db.Model(&notification).Updates(map[string]interface{}{
+			"snoozed_until": *until,
+			"snoozed_by":    userID,
+		}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		notification.SnoozedUntil = until
+		notification.SnoozedBy = &userID
+
+		publishNotificationEvent(db, NotificationEventSnoozed, notification.ID, nil, gin.H{
+			"notification_id": notification.ID,
+			"snoozed_until":   *until,
+			"snoozed_by":      userID,
+		})
+
+		c.JSON(http.StatusOK, notification)
+	}
+}
+
+// @Summary Resolve notification
+// @Description Resolve a notification with an optional note and the record of the action that resolved it (e.g. the weight record added)
+// @Tags Notifications
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Notification ID"
+// @Param resolution body NotificationResolveRequest true "Resolution"
+// @Success 200 {object} Notification
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /notifications/{id}/resolve [put]
+func resolveNotificationHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := requireUserID(c)
+		if !ok {
+			return
+		}
+		notification, ok := loadWorkflowNotification(c, db)
+		if !ok {
+			return
+		}
+		if notification.ResolvedAt != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "Notification is already resolved"})
+			return
+		}
+
+		var request NotificationResolveRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		request.Note = strings.TrimSpace(request.Note)
+
+		if (request.ActionType == "") != (request.ActionID == nil) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "action_type and action_id must be given together"})
+			return
+		}
+		if request.ActionType != "" {
+			model, known := resolutionActions[request.ActionType]
+			if !known {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "action_type must be one of weight_record, therapy, therapy_dose, lab_test, food_intake, daily_task"})
+				return
+			}
+			var count int64
+			db.Model(model).Where("id = ?", *request.ActionID).Count(&count)
+			if count == 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Linked action not found"})
+				return
+			}
+		}
+
+		if err := resolveNotification(db, &notification, &userID, request.Note, request.ActionType, request.ActionID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, notification)
+	}
+}
+
+// @Summary Reopen notification
+// @Description Reopen a resolved or snoozed notification
+// @Tags Notifications
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Notification ID"
+// @Success 200 {object} Notification
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /notifications/{id}/reopen [put]
+func reopenNotificationHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := requireUserID(c)
+		if !ok {
+			return
+		}
+		notification, ok := loadWorkflowNotification(c, db)
+		if !ok {
+			return
+		}
+
+		// La notifica riaperta torna da prendere in carico: l'escalation riparte
+		// dal primo passo, contando il tempo di presa in carico da adesso
+		if err := db.Model(&notification).Updates(map[string]interface{}{
+			"resolved_at":          nil,
+			"resolved_by":          nil,
+			"resolution_note":      "",
+			"resolution_action":    "",
+			"resolution_action_id": nil,
+			"snoozed_until":        nil,
+			"snoozed_by":           nil,
+			"acknowledged_at":      nil,
+			"acknowledged_by":      nil,
+			"escalation_level":     0,
+			"escalated_at":         time.Now(),
+		}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		db.First(&notification, notification.ID)
+
+		publishNotificationEvent(db, NotificationEventReopened, notification.ID, nil, gin.H{
+			"notification_id": notification.ID,
+			"reopened_by":     userID,
+		})
+
+		c.JSON(http.StatusOK, notification)
+	}
+}
+
+// @Summary Get notification history
+// @Description Get the audit trail of a notification: creation, assignment, snooze, resolution and reopening
+// @Tags Notifications
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Notification ID"
+// @Success 200 {array} NotificationHistory
+// @Failure 404 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /notifications/{id}/history [get]
+func getNotificationHistoryHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		notification, ok := loadWorkflowNotification(c, db)
+		if !ok {
+			return
+		}
+
+		var history []NotificationHistory
+		db.Where("notification_id = ?", notification.ID).Order("id ASC").Find(&history)
+		c.JSON(http.StatusOK, history)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHasRecentNotificationRespectsWorkflowState(t *testing.T) {
+	db := newTestDB(t)
+	hedgehog := Hedgehog{Name: "Spillo", Status: "in_care", ArrivalDate: time.Now().AddDate(0, -1, 0)}
+	db.Create(&hedgehog)
+	ns := NewNotificationService(db)
+
+	// Una notifica vecchia ma ancora aperta blocca i duplicati, anche se posticipata
+	snoozed := time.Now().Add(time.Hour)
+	notification := Notification{Type: NotificationNoWeighing, Priority: PriorityMedium, Title: "Pesatura", Message: "x", HedgehogID: &hedgehog.ID, SnoozedUntil: &snoozed}
+	db.Create(&notification)
+	db.Model(&notification).Update("created_at", time.Now().AddDate(0, 0, -5))
+	if !ns.hasRecentNotification(hedgehog.ID, NotificationNoWeighing, 24*time.Hour) {
+		t.Error("open snoozed notification should suppress duplicates")
+	}
+
+	// Appena risolta vale la finestra di ripetizione
+	if err := resolveNotification(db, &notification, nil, "", "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if !ns.hasRecentNotification(hedgehog.ID, NotificationNoWeighing, 24*time.Hour) {
+		t.Error("recently resolved notification should suppress duplicates")
+	}
+
+	// Risolta da più tempo della finestra: la condizione può essere segnalata di nuovo
+	db.Model(&notification).Update("resolved_at", time.Now().Add(-25*time.Hour))
+	if ns.hasRecentNotification(hedgehog.ID, NotificationNoWeighing, 24*time.Hour) {
+		t.Error("notification resolved before the window should not suppress")
+	}
+}
+
+func TestResolveAndSnoozeNotificationHandlers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := newTestDB(t)
+	user := User{Username: "mario", Email: "mario@laninna.it", Password: "x"}
+	db.Create(&user)
+	hedgehog := Hedgehog{Name: "Spillo", Status: "in_care", ArrivalDate: time.Now().AddDate(0, -1, 0)}
+	db.Create(&hedgehog)
+	first := Notification{Type: NotificationNoWeighing, Priority: PriorityMedium, Title: "Pesatura", Message: "x", HedgehogID: &hedgehog.ID}
+	db.Create(&first)
+	second := Notification{Type: NotificationWeightDrop, Priority: PriorityHigh, Title: "Calo", Message: "x", HedgehogID: &hedgehog.ID}
+	db.Create(&second)
+	record := WeightRecord{HedgehogID: hedgehog.ID, Weight: 640, Date: time.Now()}
+	db.Create(&record)
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("userID", user.ID) })
+	router.GET("/notifications", getNotificationsHandler(db))
+	router.PUT("/notifications/:id/snooze", snoozeNotificationHandler(db))
+	router.PUT("/notifications/:id/resolve", resolveNotificationHandler(db))
+	router.GET("/notifications/:id/history", getNotificationHistoryHandler(db))
+	router.PUT("/notifications/:id/reopen", reopenNotificationHandler(db))
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	if w := request(http.MethodPut, "/notifications/1/resolve", `{"action_type":"weight_record","action_id":99}`); w.Code != http.StatusBadRequest {
+		t.Errorf("missing linked record: status = %d", w.Code)
+	}
+	w := request(http.MethodPut, "/notifications/1/resolve", `{"note":"Pesato","action_type":"weight_record","action_id":1}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("resolve status = %d: %s", w.Code, w.Body.String())
+	}
+	var resolved Notification
+	db.First(&resolved, first.ID)
+	if resolved.ResolvedAt == nil || *resolved.ResolvedBy != user.ID || resolved.ResolutionAction != "weight_record" || *resolved.ResolutionActionID != record.ID || resolved.AcknowledgedAt == nil {
+		t.Errorf("resolved = %+v", resolved)
+	}
+	if w := request(http.MethodPut, "/notifications/1/resolve", `{}`); w.Code != http.StatusConflict {
+		t.Errorf("second resolve: status = %d", w.Code)
+	}
+
+	if w := request(http.MethodPut, "/notifications/2/snooze", `{"minutes":60}`); w.Code != http.StatusOK {
+		t.Fatalf("snooze status = %d: %s", w.Code, w.Body.String())
+	}
+
+	// Nella casella non resta nulla di aperto
+	var inbox []Notification
+	json.Unmarshal(request(http.MethodGet, "/notifications", "").Body.Bytes(), &inbox)
+	if len(inbox) != 0 {
+		t.Errorf("open inbox = %+v", inbox)
+	}
+	json.Unmarshal(request(http.MethodGet, "/notifications?state=snoozed", "").Body.Bytes(), &inbox)
+	if len(inbox) != 1 || inbox[0].ID != second.ID {
+		t.Errorf("snoozed inbox = %+v", inbox)
+	}
+
+	// La cronologia resta anche quando gli eventi del replay sono eliminati
+	db.Where("1 = 1").Delete(&NotificationEvent{})
+	var history []NotificationHistory
+	json.Unmarshal(request(http.MethodGet, "/notifications/1/history", "").Body.Bytes(), &history)
+	if len(history) != 1 || history[0].Type != NotificationEventResolved {
+		t.Errorf("history = %+v", history)
+	}
+
+	// Riaperta, la notifica torna da prendere in carico e l'escalation riparte
+	db.Model(&resolved).Updates(map[string]interface{}{"escalation_level": 2, "escalated_at": time.Now().Add(-time.Hour)})
+	if w := request(http.MethodPut, "/notifications/1/reopen", ""); w.Code != http.StatusOK {
+		t.Fatalf("reopen status = %d: %s", w.Code, w.Body.String())
+	}
+	var reopened Notification
+	db.First(&reopened, first.ID)
+	if reopened.ResolvedAt != nil || reopened.AcknowledgedAt != nil || reopened.AcknowledgedBy != nil ||
+		reopened.EscalationLevel != 0 || reopened.EscalatedAt == nil || time.Since(*reopened.EscalatedAt) > time.Minute {
+		t.Errorf("reopened = %+v", reopened)
+	}
+}
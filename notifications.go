@@ -16,16 +16,30 @@ import (
 
 // Strutture per analisi dati
 type WeightAnalysis struct {
-	HedgehogID     uint      `json:"hedgehog_id"`
-	HedgehogName   string    `json:"hedgehog_name"`
-	CurrentWeight  float64   `json:"current_weight"`
-	PreviousWeight float64   `json:"previous_weight"`
-	WeightChange   float64   `json:"weight_change"`
-	DaysSinceWeigh int       `json:"days_since_weigh"`
-	LastWeighDate  time.Time `json:"last_weigh_date"`
-	Trend          string    `json:"trend"` // "improving", "stable", "declining", "critical"
-	Alert          bool      `json:"alert"`
-	AlertReason    string    `json:"alert_reason"`
+	HedgehogID     uint        `json:"hedgehog_id"`
+	HedgehogName   string      `json:"hedgehog_name"`
+	CurrentWeight  float64     `json:"current_weight"`
+	PreviousWeight float64     `json:"previous_weight"`
+	WeightChange   float64     `json:"weight_change"`
+	DaysSinceWeigh int         `json:"days_since_weigh"`
+	LastWeighDate  time.Time   `json:"last_weigh_date"`
+	Trend          string      `json:"trend"` // "improving", "stable", "declining", "critical"
+	TrendDetails   WeightTrend `json:"trend_details"`
+	Alert          bool        `json:"alert"`
+	AlertReason    string      `json:"alert_reason"`
+	Hibernating    bool        `json:"hibernating"`
+	DropThreshold  float64     `json:"drop_threshold"` // Soglia di calo applicata (g)
+
+	// Curva di crescita (solo con data di nascita stimata)
+	Growth *GrowthComparison `json:"growth,omitempty"`
+
+	// Alimentazione
+	PlannedDailyIntake float64  `json:"planned_daily_intake"`
+	AvgDailyIntake     float64  `json:"avg_daily_intake"`
+	IntakeRatio        float64  `json:"intake_ratio"`                 // Percentuale mangiata rispetto al piano
+	IntakeCorrelation  *float64 `json:"intake_correlation,omitempty"` // Correlazione tra cibo mangiato e variazione peso
+	IntakeAlert        bool     `json:"intake_alert"`
+	IntakeAlertReason  string   `json:"intake_alert_reason,omitempty"`
 }
 
 type TherapyAnalysis struct {
@@ -46,6 +60,7 @@ type TherapyAnalysis struct {
 type NotificationService struct {
 	db       *gorm.DB
 	settings *NotificationSettings
+	scope    []uint // Ricci a cui limitare le regole, tutti se vuoto
 }
 
 func NewNotificationService(db *gorm.DB) *NotificationService {
@@ -54,6 +69,19 @@ func NewNotificationService(db *gorm.DB) *NotificationService {
 	return service
 }
 
+// forHedgehogs restituisce una copia del servizio che valuta le regole solo per i ricci indicati
+func (ns *NotificationService) forHedgehogs(ids ...uint) *NotificationService {
+	return &NotificationService{db: ns.db, settings: ns.settings, scope: ids}
+}
+
+// scoped limita la query ai ricci del servizio; column è la colonna con l'ID del riccio
+func (ns *NotificationService) scoped(query *gorm.DB, column string) *gorm.DB {
+	if len(ns.scope) == 0 {
+		return query
+	}
+	return query.Where(column+" IN ?", ns.scope)
+}
+
 func (ns *NotificationService) loadSettings() {
 	var settings NotificationSettings
 	if err := ns.db.First(&settings).Error; err != nil {
@@ -65,6 +93,25 @@ func (ns *NotificationService) loadSettings() {
 			WeightDropDays:            7,
 			WeightStagnationDays:      14,
 			NoWeighingDays:            7,
+			LabTestFollowUpDays:       14,
+			LowIntakeThreshold:        50,
+			LowIntakeDays:             2,
+			HibernationNoWeighingDays: 21,
+			HibernationDropThreshold:  100,
+			HibernationMaxLossPercent: 20,
+			HibernationMaxWakes:       3,
+			HibernationWakeWindowDays: 7,
+			TrendWindowDays:           14,
+			TrendMethod:               TrendMethodLeastSquares,
+			TrendImprovingPercent:     0.3,
+			TrendDecliningPercent:     0.3,
+			TrendCriticalPercent:      1,
+			TrendMinSamples:           3,
+			WeightOutlierPercent:      20,
+			WeightOutlierDailyPercent: 3,
+			GrowthDropPercent:         8,
+			GrowthMinZScore:           -2,
+			GrowthMinGainRatio:        0.5,
 			EmailNotificationsEnabled: false,
 		}
 		ns.db.Create(&settings)
@@ -79,32 +126,25 @@ func (ns *NotificationService) CheckAllNotifications() error {
 	// Pulisci notifiche vecchie
 	ns.cleanOldNotifications()
 
-	// Controlla terapie
-	if err := ns.checkTherapyNotifications(); err != nil {
-		logger.Error("Errore controllo terapie", err, logger.Str("component", "notifications"))
-	}
+	// Chiudi le notifiche la cui condizione è venuta meno
+	ns.AutoResolveNotifications()
 
-	// Controlla peso
-	if err := ns.checkWeightNotifications(); err != nil {
-		logger.Error("Errore controllo peso", err, logger.Str("component", "notifications"))
-	}
-
-	// Controlla pesature mancanti
-	if err := ns.checkMissingWeighings(); err != nil {
-		logger.Error("Errore controllo pesature", err, logger.Str("component", "notifications"))
-	}
+	// Valuta tutte le regole abilitate (terapie, peso, pesature, alimentazione, letargo, rilascio, esami)
+	ns.RunRules(false)
 
 	logger.Info("✅ Notification checks completed", logger.Str("component", "notifications"))
 	return nil
 }
 
-func (ns *NotificationService) checkTherapyNotifications() error {
+func (ns *NotificationService) therapyExpiryCandidates() ([]RuleCandidate, error) {
 	if !ns.settings.TherapyExpiredEnabled {
-		return nil
+		return nil, nil
 	}
 
+	var candidates []RuleCandidate
+
 	var therapies []Therapy
-	ns.db.Where("status = 'active'").Find(&therapies)
+	ns.scoped(ns.db.Where("status = 'active'"), "hedgehog_id").Find(&therapies)
 
 	now := time.Now()
 	expiringThreshold := now.AddDate(0, 0, ns.settings.TherapyExpiringDays)
@@ -125,40 +165,44 @@ func (ns *NotificationService) checkTherapyNotifications() error {
 
 		// Terapia scaduta
 		if endDate.Before(now) {
-			ns.createNotification(Notification{
-				Type:        NotificationTherapyExpired,
-				Priority:    PriorityHigh,
-				Title:       fmt.Sprintf("Terapia Scaduta: %s", therapy.Name),
-				Message:     fmt.Sprintf("La terapia '%s' per %s è scaduta il %s", therapy.Name, hedgehogName, endDate.Format("02/01/2006")),
+			candidates = append(candidates, RuleCandidate{RepeatAfter: 24 * time.Hour, Notification: Notification{
+				Type:     NotificationTherapyExpired,
+				Priority: PriorityHigh,
+				TemplateData: templateVars(map[string]interface{}{
+					"Therapy": therapy.Name, "Hedgehog": hedgehogName, "EndDate": endDate,
+					"DaysOverdue": int(now.Sub(endDate).Hours() / 24),
+				}),
 				HedgehogID:  &therapy.HedgehogID,
 				TherapyID:   &therapy.ID,
 				ActionURL:   fmt.Sprintf("/hedgehogs/%d", therapy.HedgehogID),
 				ActionLabel: "Gestisci Terapia",
 				Data:        fmt.Sprintf(`{"days_overdue": %d}`, int(now.Sub(endDate).Hours()/24)),
-			})
+			}})
 		} else if endDate.Before(expiringThreshold) {
 			// Terapia in scadenza
 			daysLeft := int(endDate.Sub(now).Hours() / 24)
-			ns.createNotification(Notification{
-				Type:        NotificationTherapyExpiring,
-				Priority:    PriorityMedium,
-				Title:       fmt.Sprintf("Terapia in Scadenza: %s", therapy.Name),
-				Message:     fmt.Sprintf("La terapia '%s' per %s scadrà tra %d giorni", therapy.Name, hedgehogName, daysLeft),
+			candidates = append(candidates, RuleCandidate{RepeatAfter: 24 * time.Hour, Notification: Notification{
+				Type:     NotificationTherapyExpiring,
+				Priority: PriorityMedium,
+				TemplateData: templateVars(map[string]interface{}{
+					"Therapy": therapy.Name, "Hedgehog": hedgehogName, "EndDate": endDate, "DaysLeft": daysLeft,
+				}),
 				HedgehogID:  &therapy.HedgehogID,
 				TherapyID:   &therapy.ID,
 				ActionURL:   fmt.Sprintf("/hedgehogs/%d", therapy.HedgehogID),
 				ActionLabel: "Rinnova Terapia",
 				Data:        fmt.Sprintf(`{"days_left": %d}`, daysLeft),
-			})
+			}})
 		}
 	}
 
-	return nil
+	return candidates, nil
 }
 
-func (ns *NotificationService) checkWeightNotifications() error {
+func (ns *NotificationService) weightAlertCandidates() ([]RuleCandidate, error) {
 	analyses := ns.analyzeWeightTrends()
 
+	var candidates []RuleCandidate
 	for _, analysis := range analyses {
 		if !analysis.Alert {
 			continue
@@ -167,42 +211,55 @@ func (ns *NotificationService) checkWeightNotifications() error {
 		var priority NotificationPriority
 		var notifType NotificationType
 
-		if analysis.WeightChange <= -ns.settings.WeightDropThreshold {
+		switch {
+		case analysis.WeightChange <= -analysis.DropThreshold, analysis.Trend == "critical":
 			priority = PriorityCritical
 			notifType = NotificationWeightDrop
-		} else {
+		case analysis.Trend == "declining":
+			priority = PriorityHigh
+			notifType = NotificationWeightDrop
+		case analysis.Growth != nil && analysis.Growth.BehindCurve:
+			priority = PriorityHigh
+			notifType = NotificationGrowthBehind
+		default:
 			priority = PriorityMedium
 			notifType = NotificationWeightStagnation
 		}
 
-		// Evita notifiche duplicate recenti
-		if ns.hasRecentNotification(analysis.HedgehogID, notifType, 24*time.Hour) {
-			continue
-		}
-
 		data, _ := json.Marshal(analysis)
-		ns.createNotification(Notification{
-			Type:        notifType,
-			Priority:    priority,
-			Title:       fmt.Sprintf("Allarme Peso: %s", analysis.HedgehogName),
-			Message:     analysis.AlertReason,
+		candidates = append(candidates, RuleCandidate{RepeatAfter: 24 * time.Hour, Notification: Notification{
+			Type:     notifType,
+			Priority: priority,
+			TemplateData: templateVars(map[string]interface{}{
+				"Hedgehog": analysis.HedgehogName, "Reason": analysis.AlertReason, "WeightChange": analysis.WeightChange,
+				"CurrentWeight": analysis.CurrentWeight, "Trend": analysis.Trend,
+			}),
 			HedgehogID:  &analysis.HedgehogID,
 			ActionURL:   fmt.Sprintf("/hedgehogs/%d", analysis.HedgehogID),
 			ActionLabel: "Controlla Peso",
 			Data:        string(data),
-		})
+		}})
 	}
 
-	return nil
+	return candidates, nil
 }
 
-func (ns *NotificationService) checkMissingWeighings() error {
-	var hedgehogs []Hedgehog
-	ns.db.Where("status = 'in_care'").Find(&hedgehogs)
+// Riccio che non viene pesato da troppo tempo
+type MissingWeighing struct {
+	Hedgehog  Hedgehog
+	DaysSince int
+}
 
-	threshold := time.Now().AddDate(0, 0, -ns.settings.NoWeighingDays)
+// findMissingWeighings restituisce i ricci in cura non pesati negli ultimi NoWeighingDays giorni
+func (ns *NotificationService) findMissingWeighings() []MissingWeighing {
+	var hedgehogs []Hedgehog
+	ns.scoped(ns.db.Where("status = 'in_care'"), "id").Find(&hedgehogs)
 
+	var missing []MissingWeighing
 	for _, hedgehog := range hedgehogs {
+		// Durante il letargo le pesature sono più rade
+		threshold := time.Now().AddDate(0, 0, -ns.noWeighingDays(isHibernating(hedgehog)))
+
 		var lastWeight WeightRecord
 		err := ns.db.Where("hedgehog_id = ?", hedgehog.ID).
 			Order("date DESC").
@@ -213,37 +270,42 @@ func (ns *NotificationService) checkMissingWeighings() error {
 			if err != nil {
 				daysSince = int(time.Since(hedgehog.ArrivalDate).Hours() / 24)
 			}
+			missing = append(missing, MissingWeighing{Hedgehog: hedgehog, DaysSince: daysSince})
+		}
+	}
 
-			// Evita spam di notifiche
-			if ns.hasRecentNotification(hedgehog.ID, NotificationNoWeighing, 48*time.Hour) {
-				continue
-			}
+	return missing
+}
 
-			ns.createNotification(Notification{
-				Type:        NotificationNoWeighing,
-				Priority:    PriorityMedium,
-				Title:       fmt.Sprintf("Pesatura Mancante: %s", hedgehog.Name),
-				Message:     fmt.Sprintf("%s non viene pesato da %d giorni", hedgehog.Name, daysSince),
-				HedgehogID:  &hedgehog.ID,
-				ActionURL:   fmt.Sprintf("/hedgehogs/%d", hedgehog.ID),
-				ActionLabel: "Aggiungi Pesatura",
-				Data:        fmt.Sprintf(`{"days_since": %d}`, daysSince),
-			})
-		}
+func (ns *NotificationService) missingWeighingCandidates() ([]RuleCandidate, error) {
+	var candidates []RuleCandidate
+	for _, m := range ns.findMissingWeighings() {
+		hedgehog, daysSince := m.Hedgehog, m.DaysSince
+
+		// Evita spam di notifiche: al massimo una ogni 48 ore
+		candidates = append(candidates, RuleCandidate{RepeatAfter: 48 * time.Hour, Notification: Notification{
+			Type:         NotificationNoWeighing,
+			Priority:     PriorityMedium,
+			TemplateData: templateVars(map[string]interface{}{"Hedgehog": hedgehog.Name, "DaysSince": daysSince}),
+			HedgehogID:   &hedgehog.ID,
+			ActionURL:    fmt.Sprintf("/hedgehogs/%d", hedgehog.ID),
+			ActionLabel:  "Aggiungi Pesatura",
+			Data:         fmt.Sprintf(`{"days_since": %d}`, daysSince),
+		}})
 	}
 
-	return nil
+	return candidates, nil
 }
 
 func (ns *NotificationService) analyzeWeightTrends() []WeightAnalysis {
 	var hedgehogs []Hedgehog
-	ns.db.Where("status = 'in_care'").Find(&hedgehogs)
+	ns.scoped(ns.db.Where("status = 'in_care'"), "id").Find(&hedgehogs)
 
 	var analyses []WeightAnalysis
 
 	for _, hedgehog := range hedgehogs {
 		var weights []WeightRecord
-		ns.db.Where("hedgehog_id = ?", hedgehog.ID).
+		ns.db.Where("hedgehog_id = ? AND excluded = ?", hedgehog.ID, false).
 			Order("date DESC").
 			Limit(10).
 			Find(&weights)
@@ -260,18 +322,34 @@ func (ns *NotificationService) analyzeWeightTrends() []WeightAnalysis {
 			WeightChange:   weights[0].Weight - weights[1].Weight,
 			DaysSinceWeigh: int(time.Since(weights[0].Date).Hours() / 24),
 			LastWeighDate:  weights[0].Date,
+			Hibernating:    isHibernating(hedgehog),
 		}
 
 		// Analisi trend
-		analysis.Trend = ns.calculateWeightTrend(weights)
+		analysis.TrendDetails = computeWeightTrend(ns.loadTrendWeights(hedgehog.ID), ns.trendConfig())
+		analysis.Trend = analysis.TrendDetails.Direction
+
+		// Confronto con la curva di crescita
+		analysis.Growth = ns.analyzeGrowth(hedgehog, weights[0], analysis.TrendDetails)
+		analysis.DropThreshold = ns.weightDropThreshold(analysis.Hibernating)
+		if !analysis.Hibernating {
+			analysis.DropThreshold = ns.growthDropThreshold(analysis.DropThreshold, analysis.Growth)
+		}
+
+		// Analisi alimentazione
+		ns.applyIntakeAnalysis(&analysis, weights)
 
 		// Controllo allarmi
-		if analysis.WeightChange <= -ns.settings.WeightDropThreshold {
+		if analysis.WeightChange <= -analysis.DropThreshold {
 			analysis.Alert = true
 			analysis.AlertReason = fmt.Sprintf("Perdita di peso significativa: %.1fg in %d giorni",
 				math.Abs(analysis.WeightChange),
 				int(weights[0].Date.Sub(weights[1].Date).Hours()/24))
-		} else if analysis.Trend == "declining" && len(weights) >= 3 {
+		} else if analysis.Trend == "critical" && !analysis.Hibernating {
+			analysis.Alert = true
+			analysis.AlertReason = fmt.Sprintf("Calo di peso rapido: %.1f%% al giorno negli ultimi %d giorni",
+				math.Abs(analysis.TrendDetails.SlopePercentPerDay), analysis.TrendDetails.WindowDays)
+		} else if analysis.Trend == "declining" && len(weights) >= 3 && !analysis.Hibernating {
 			// Controllo trend negativo su più pesature
 			recentWeights := weights[:3]
 			declining := true
@@ -287,8 +365,8 @@ func (ns *NotificationService) analyzeWeightTrends() []WeightAnalysis {
 			}
 		}
 
-		// Controllo stagnazione
-		if analysis.Trend == "stable" && len(weights) >= 4 {
+		// Controllo stagnazione (non significativa durante il letargo)
+		if analysis.Trend == "stable" && len(weights) >= 4 && !analysis.Hibernating {
 			weekSpan := weights[0].Date.Sub(weights[3].Date)
 			if weekSpan.Hours() > float64(ns.settings.WeightStagnationDays*24) {
 				variation := ns.calculateWeightVariation(weights[:4])
@@ -300,52 +378,27 @@ func (ns *NotificationService) analyzeWeightTrends() []WeightAnalysis {
 			}
 		}
 
+		// Controllo crescita rispetto alla curva attesa
+		if !analysis.Alert && !analysis.Hibernating && analysis.Growth != nil && analysis.Growth.BehindCurve {
+			analysis.Alert = true
+			if analysis.Growth.GainRatio != nil && *analysis.Growth.GainRatio < ns.settings.GrowthMinGainRatio {
+				analysis.AlertReason = fmt.Sprintf("Crescita insufficiente: %.1fg/giorno contro %.1fg/giorno attesi (%s, %d giorni)",
+					analysis.Growth.ActualGainPerDay, analysis.Growth.ExpectedGainPerDay, analysis.Growth.AgeClass, analysis.Growth.AgeDays)
+			} else {
+				analysis.AlertReason = fmt.Sprintf("Sottopeso rispetto alla curva di crescita: %.0fg contro %.0fg attesi (%.0f° percentile)",
+					analysis.CurrentWeight, analysis.Growth.ExpectedWeight, analysis.Growth.Percentile)
+			}
+		}
+
 		analyses = append(analyses, analysis)
 	}
 
 	return analyses
 }
 
+// calculateWeightTrend restituisce la direzione del trend stimata dalla regressione
 func (ns *NotificationService) calculateWeightTrend(weights []WeightRecord) string {
-	if len(weights) < 3 {
-		return "insufficient_data"
-	}
-
-	// Calcola trend su ultimi 5 punti
-	recentWeights := weights
-	if len(weights) > 5 {
-		recentWeights = weights[:5]
-	}
-
-	// Calcola pendenza media
-	var slopes []float64
-	for i := 0; i < len(recentWeights)-1; i++ {
-		daysDiff := recentWeights[i].Date.Sub(recentWeights[i+1].Date).Hours() / 24
-		if daysDiff > 0 {
-			slope := (recentWeights[i].Weight - recentWeights[i+1].Weight) / daysDiff
-			slopes = append(slopes, slope)
-		}
-	}
-
-	if len(slopes) == 0 {
-		return "stable"
-	}
-
-	// Media delle pendenze
-	avgSlope := 0.0
-	for _, slope := range slopes {
-		avgSlope += slope
-	}
-	avgSlope /= float64(len(slopes))
-
-	if avgSlope > 2 {
-		return "improving"
-	} else if avgSlope < -2 {
-		return "declining"
-	} else if avgSlope < -5 {
-		return "critical"
-	}
-	return "stable"
+	return computeWeightTrend(weights, ns.trendConfig()).Direction
 }
 
 func (ns *NotificationService) calculateWeightVariation(weights []WeightRecord) float64 {
@@ -368,11 +421,11 @@ func (ns *NotificationService) calculateWeightVariation(weights []WeightRecord)
 	return max - min
 }
 
-func (ns *NotificationService) createNotification(notification Notification) {
-	// Controlla duplicati recenti
-	if ns.hasRecentNotification(*notification.HedgehogID, notification.Type, 24*time.Hour) {
-		return
-	}
+// createNotification salva la notifica e la pubblica; restituisce false se il salvataggio fallisce.
+// I duplicati sono già scartati da evaluateRule con la finestra RepeatAfter del candidato.
+func (ns *NotificationService) createNotification(notification Notification) bool {
+	// Titolo e messaggio dal modello, nella lingua predefinita
+	localizeNotification(ns.db, &notification, defaultLocale())
 
 	// Imposta scadenza automatica
 	if notification.ExpiresAt == nil {
@@ -384,36 +437,51 @@ func (ns *NotificationService) createNotification(notification Notification) {
 		logger.Error("Errore creazione notifica", err, 
 			logger.Str("component", "notifications"),
 			logger.Str("notification_type", string(notification.Type)))
-		return
+		return false
 	}
 
+	publishNotificationEvent(ns.db, NotificationEventCreated, notification.ID, nil, newNotificationCreatedPayload(ns.db, notification))
+
 	logger.Info("📢 Notifica creata", 
 		logger.Str("component", "notifications"),
 		logger.Str("type", string(notification.Type)), 
 		logger.Str("title", notification.Title))
 
-	// Invia notifiche esterne se abilitate
-	go ns.sendExternalNotifications(notification)
+	// Accoda le notifiche esterne se abilitate
+	ns.sendExternalNotifications(notification)
+	return true
 }
 
+// hasRecentNotification indica se esiste già una notifica analoga ancora in
+// carico (aperta o posticipata, non scaduta) oppure risolta da meno di duration.
+// Lettura e dismissione sono personali e non contano come gestione: una notifica
+// ignorata da tutti resta aperta e continua a impedire i duplicati finché non è risolta.
 func (ns *NotificationService) hasRecentNotification(hedgehogID uint, notifType NotificationType, duration time.Duration) bool {
 	var count int64
-	since := time.Now().Add(-duration)
+	now := time.Now()
+	since := now.Add(-duration)
 
 	ns.db.Model(&Notification{}).
-		Where("hedgehog_id = ? AND type = ? AND created_at > ?", hedgehogID, notifType, since).
+		Where("hedgehog_id = ? AND type = ?", hedgehogID, notifType).
+		Where("(resolved_at IS NULL AND (expires_at IS NULL OR expires_at > ?)) OR resolved_at > ?", now, since).
 		Count(&count)
 
 	return count > 0
 }
 
 func (ns *NotificationService) cleanOldNotifications() {
-	// Elimina notifiche scadute
-	ns.db.Where("expires_at < ?", time.Now()).Delete(&Notification{})
+	// Elimina le notifiche scadute o vecchie di 30 giorni, lette o no: chi non le ha
+	// gestite non le vede o non le vedrà più, e i controlli dei duplicati non le usano
+	now := time.Now()
+	ns.db.Where("expires_at < ? OR created_at < ?", now, now.AddDate(0, 0, -30)).Delete(&Notification{})
+
+	// Elimina gli eventi dello stream troppo vecchi per il replay
+	ns.db.Where("created_at < ?", now.AddDate(0, 0, -7)).Delete(&NotificationEvent{})
 
-	// Elimina notifiche lette vecchie di 30 giorni
-	thirtyDaysAgo := time.Now().AddDate(0, 0, -30)
-	ns.db.Where("read = true AND created_at < ?", thirtyDaysAgo).Delete(&Notification{})
+	// Elimina lo stato personale e la cronologia delle notifiche non più esistenti
+	existing := ns.db.Model(&Notification{}).Select("id")
+	ns.db.Where("notification_id NOT IN (?)", existing).Delete(&NotificationReceipt{})
+	ns.db.Where("notification_id NOT IN (?)", existing).Delete(&NotificationHistory{})
 }
 
 func (ns *NotificationService) sendEmailNotification(notification Notification) {
@@ -442,26 +510,44 @@ func (ns *NotificationService) sendWebhookNotification(notification Notification
 // @Param unread query boolean false "Filter by unread status"
 // @Param priority query string false "Filter by priority"
 // @Param type query string false "Filter by notification type"
+// @Param state query string false "Filter by workflow state" Enums(open, snoozed, resolved, all) default(open)
+// @Param assigned_to query string false "Filter by assignee: a user ID or 'me'"
 // @Param limit query int false "Limit results" default(50)
 // @Success 200 {array} Notification
+// @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
 // @Router /notifications [get]
 func getNotificationsHandler(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		userID, ok := requireUserID(c)
+		if !ok {
+			return
+		}
+
 		var notifications []Notification
-		query := db
+		query, err := filterNotificationState(inboxQuery(db, userID, loadSubscription(db, userID)), c.Query("state"), time.Now())
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
 		// Filtri query
 		if unreadOnly := c.Query("unread"); unreadOnly == "true" {
-			query = query.Where("read = false")
+			query = query.Where("COALESCE(notification_receipts.read, false) = false")
 		}
 
 		if priority := c.Query("priority"); priority != "" {
-			query = query.Where("priority = ?", priority)
+			query = query.Where("notifications.priority = ?", priority)
 		}
 
 		if notifType := c.Query("type"); notifType != "" {
-			query = query.Where("type = ?", notifType)
+			query = query.Where("notifications.type = ?", notifType)
+		}
+
+		if assignee := c.Query("assigned_to"); assignee == "me" {
+			query = query.Where("notifications.assigned_to = ?", userID)
+		} else if assignee != "" {
+			query = query.Where("notifications.assigned_to = ?", assignee)
 		}
 
 		limit := 50 // Default limit
@@ -469,17 +555,18 @@ func getNotificationsHandler(db *gorm.DB) gin.HandlerFunc {
 			fmt.Sscanf(l, "%d", &limit)
 		}
 
-		query.Where("dismissed = false").
-			Order("priority DESC, created_at DESC").
+		query.Order("notifications.priority DESC, notifications.created_at DESC").
 			Limit(limit).
 			Find(&notifications)
+		applyReceipts(db, userID, notifications)
+		localizeNotifications(db, notifications, userLocale(db, userID))
 
 		c.JSON(http.StatusOK, notifications)
 	}
 }
 
 // @Summary Mark notification as read
-// @Description Mark a notification as read by its ID
+// @Description Mark a notification as read by its ID for the authenticated user
 // @Tags Notifications
 // @Accept json
 // @Produce json
@@ -492,6 +579,11 @@ func getNotificationsHandler(db *gorm.DB) gin.HandlerFunc {
 // @Router /notifications/{id}/read [put]
 func markNotificationReadHandler(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		userID, ok := requireUserID(c)
+		if !ok {
+			return
+		}
+
 		id := c.Param("id")
 
 		var notification Notification
@@ -500,18 +592,22 @@ func markNotificationReadHandler(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		notification.Read = true
-		if err := db.Save(&notification).Error; err != nil {
+		receipt, err := updateReceipt(db, notification.ID, userID, true, false)
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
+		notification.Read = receipt.Read
+		notification.Dismissed = receipt.Dismissed
+		publishNotificationEvent(db, NotificationEventRead, notification.ID, &userID, receipt)
+
 		c.JSON(http.StatusOK, notification)
 	}
 }
 
 // @Summary Dismiss notification
-// @Description Dismiss a notification by its ID
+// @Description Dismiss a notification by its ID for the authenticated user
 // @Tags Notifications
 // @Accept json
 // @Produce json
@@ -524,6 +620,11 @@ func markNotificationReadHandler(db *gorm.DB) gin.HandlerFunc {
 // @Router /notifications/{id} [delete]
 func dismissNotificationHandler(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		userID, ok := requireUserID(c)
+		if !ok {
+			return
+		}
+
 		id := c.Param("id")
 
 		var notification Notification
@@ -532,18 +633,19 @@ func dismissNotificationHandler(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 
-		notification.Dismissed = true
-		if err := db.Save(&notification).Error; err != nil {
+		receipt, err := updateReceipt(db, notification.ID, userID, false, true)
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		publishNotificationEvent(db, NotificationEventDismissed, notification.ID, &userID, receipt)
 
 		c.JSON(http.StatusOK, gin.H{"message": "Notification dismissed"})
 	}
 }
 
 // @Summary Get notification statistics
-// @Description Get statistics about notifications (counts by status, priority, and type)
+// @Description Get statistics about the authenticated user's open notifications (counts by status, priority, and type); snoozed and resolved ones are excluded
 // @Tags Notifications
 // @Accept json
 // @Produce json
@@ -553,6 +655,15 @@ func dismissNotificationHandler(db *gorm.DB) gin.HandlerFunc {
 // @Router /notifications/stats [get]
 func getNotificationStatsHandler(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		userID, ok := requireUserID(c)
+		if !ok {
+			return
+		}
+
+		subscription := loadSubscription(db, userID)
+		now := time.Now()
+		inbox := func() *gorm.DB { return openNotifications(inboxQuery(db, userID, subscription), now) }
+
 		var stats struct {
 			Total    int64            `json:"total"`
 			Unread   int64            `json:"unread"`
@@ -561,20 +672,19 @@ func getNotificationStatsHandler(db *gorm.DB) gin.HandlerFunc {
 			ByType   map[string]int64 `json:"by_type"`
 		}
 
-		db.Model(&Notification{}).Where("dismissed = false").Count(&stats.Total)
-		db.Model(&Notification{}).Where("dismissed = false AND read = false").Count(&stats.Unread)
-		db.Model(&Notification{}).Where("dismissed = false AND priority = 'critical'").Count(&stats.Critical)
-		db.Model(&Notification{}).Where("dismissed = false AND priority = 'high'").Count(&stats.High)
+		inbox().Count(&stats.Total)
+		inbox().Where("COALESCE(notification_receipts.read, false) = false").Count(&stats.Unread)
+		inbox().Where("notifications.priority = 'critical'").Count(&stats.Critical)
+		inbox().Where("notifications.priority = 'high'").Count(&stats.High)
 
 		// Conta per tipo
 		var typeCounts []struct {
 			Type  string `json:"type"`
 			Count int64  `json:"count"`
 		}
-		db.Model(&Notification{}).
-			Select("type, count(*) as count").
-			Where("dismissed = false").
-			Group("type").
+		inbox().
+			Select("notifications.type, count(*) as count").
+			Group("notifications.type").
 			Find(&typeCounts)
 
 		stats.ByType = make(map[string]int64)
@@ -737,30 +847,6 @@ func getNotificationSettingsHandler(db *gorm.DB) gin.HandlerFunc {
 	}
 }
 
-// Background job per controlli periodici
-func StartNotificationScheduler(db *gorm.DB) {
-	ns := NewNotificationService(db)
-
-	// Controlli ogni 30 minuti
-	ticker := time.NewTicker(30 * time.Minute)
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				ns.CheckAllNotifications()
-			}
-		}
-	}()
-
-	// Controllo iniziale
-	go func() {
-		time.Sleep(10 * time.Second) // Aspetta che il server sia pronto
-		ns.CheckAllNotifications()
-	}()
-
-	logger.Info("📅 Notification scheduler started", logger.Str("component", "notifications"))
-}
-
 // Aggiungi route al router principale
 func addNotificationRoutes(r *gin.Engine, db *gorm.DB) {
 	api := r.Group("/api")
@@ -793,8 +879,10 @@ func addNotificationRoutes(r *gin.Engine, db *gorm.DB) {
 		// @Failure 401 {object} map[string]string
 		// @Router /notifications/check [post]
 		protected.POST("/notifications/check", func(c *gin.Context) {
-			ns := NewNotificationService(db)
-			go ns.CheckAllNotifications()
+			if _, err := enqueueJob(db, JobKindNotificationCheck, NotificationCheckPayload{}); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
 			c.JSON(http.StatusOK, gin.H{"message": "Notification check triggered"})
 		})
 	}
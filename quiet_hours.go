@@ -0,0 +1,463 @@
+// quiet_hours.go - Ore di silenzio e turni di reperibilità per le notifiche esterne
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/laninna/hedgehog-app/logger"
+	"gorm.io/gorm"
+)
+
+// Canali a cui si applicano le ore di silenzio
+const (
+	QuietChannelEmail   = "email"
+	QuietChannelWebhook = "webhook"
+)
+
+// onCallTimezone è il fuso orario in cui cambia il giorno del turno
+func onCallTimezone() *time.Location {
+	loc, err := time.LoadLocation(getEnv("ON_CALL_TIMEZONE", digestDefaultTimezone))
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// parseClock converte un orario HH:MM in minuti dalla mezzanotte
+func parseClock(value string) (int, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	hours, err1 := strconv.Atoi(parts[0])
+	minutes, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || hours < 0 || hours > 23 || minutes < 0 || minutes > 59 {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return hours*60 + minutes, nil
+}
+
+// activeUntil restituisce la fine della finestra se now vi ricade. Una finestra
+// che attraversa la mezzanotte appartiene al giorno in cui inizia.
+func (q QuietHours) activeUntil(now time.Time) (time.Time, bool) {
+	start, err1 := parseClock(q.Start)
+	end, err2 := parseClock(q.End)
+	loc, err3 := time.LoadLocation(q.Timezone)
+	if err1 != nil || err2 != nil || err3 != nil || start == end {
+		return time.Time{}, false
+	}
+
+	local := now.In(loc)
+	days := splitList(q.Days)
+	for offset := -1; offset <= 0; offset++ {
+		day := time.Date(local.Year(), local.Month(), local.Day()+offset, 0, 0, 0, 0, loc)
+		if len(days) > 0 && !containsString(days, strconv.Itoa(int(day.Weekday()))) {
+			continue
+		}
+		from := day.Add(time.Duration(start) * time.Minute)
+		until := day.Add(time.Duration(end) * time.Minute)
+		if end < start {
+			until = until.AddDate(0, 0, 1)
+		}
+		if !local.Before(from) && local.Before(until) {
+			return until, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// quietHoursUntil restituisce la fine delle ore di silenzio attive per canale e destinatario
+func quietHoursUntil(db *gorm.DB, channel, recipient string, now time.Time) (time.Time, bool) {
+	var windows []QuietHours
+	db.Where("enabled = ?", true).
+		Where("channel = ? OR channel = ''", channel).
+		Where("recipient = ? OR recipient = ''", recipient).
+		Find(&windows)
+
+	var latest time.Time
+	for _, window := range windows {
+		if until, active := window.activeUntil(now); active && until.After(latest) {
+			latest = until
+		}
+	}
+	return latest, !latest.IsZero()
+}
+
+// holdUntil restituisce quando può partire la notifica: subito se critica,
+// altrimenti alla fine delle eventuali ore di silenzio del destinatario
+func holdUntil(db *gorm.DB, notification Notification, channel, recipient string, now time.Time) time.Time {
+	if notification.Priority == PriorityCritical {
+		return now
+	}
+	until, held := quietHoursUntil(db, channel, recipient, now)
+	if !held {
+		return now
+	}
+
+	logger.Info("🔕 Notifica trattenuta per ore di silenzio",
+		logger.Str("component", "notifications"),
+		logger.Uint("notification_id", notification.ID),
+		logger.Str("channel", channel),
+		logger.Str("until", until.Format(time.RFC3339)))
+	return until
+}
+
+// sendStillNeeded indica se un invio accodato (magari trattenuto dalle ore di silenzio) va ancora
+// fatto: una notifica nel frattempo risolta, presa in carico o posticipata non si invia più
+func sendStillNeeded(notification Notification, channel string, now time.Time) bool {
+	reason := ""
+	switch {
+	case notification.ResolvedAt != nil:
+		reason = "resolved"
+	case notification.AcknowledgedAt != nil:
+		reason = "acknowledged"
+	case notification.SnoozedUntil != nil && notification.SnoozedUntil.After(now):
+		reason = "snoozed"
+	default:
+		return true
+	}
+
+	logger.Info("🔕 Invio annullato, notifica già gestita",
+		logger.Str("component", "notifications"),
+		logger.Uint("notification_id", notification.ID),
+		logger.Str("channel", channel),
+		logger.Str("reason", reason))
+	return false
+}
+
+// onCallShiftsAt restituisce i turni del giorno: quelli con data specifica
+// sostituiscono quelli settimanali
+func onCallShiftsAt(db *gorm.DB, now time.Time) []OnCallShift {
+	local := now.In(onCallTimezone())
+
+	var shifts []OnCallShift
+	db.Preload("User").Where("date = ?", local.Format("2006-01-02")).Order("id ASC").Find(&shifts)
+	if len(shifts) == 0 {
+		db.Preload("User").Where("(date = '' OR date IS NULL) AND weekday = ?", int(local.Weekday())).Order("id ASC").Find(&shifts)
+	}
+	return shifts
+}
+
+// onCallToday restituisce gli utenti di turno nel giorno di now
+func onCallToday(db *gorm.DB, now time.Time) []User {
+	var users []User
+	for _, shift := range onCallShiftsAt(db, now) {
+		if shift.User != nil {
+			users = append(users, *shift.User)
+		}
+	}
+	return users
+}
+
+// onCallEmails restituisce gli indirizzi dei reperibili del giorno
+func onCallEmails(db *gorm.DB, now time.Time) []string {
+	var emails []string
+	for _, user := range onCallToday(db, now) {
+		if user.Email != "" && !containsString(emails, user.Email) {
+			emails = append(emails, user.Email)
+		}
+	}
+	return emails
+}
+
+func validateQuietHours(db *gorm.DB, window *QuietHours) error {
+	if window.Channel != "" && window.Channel != QuietChannelEmail && window.Channel != QuietChannelWebhook {
+		return errors.New("channel must be email, webhook or empty for all")
+	}
+	start, err := parseClock(window.Start)
+	if err != nil {
+		return fmt.Errorf("start: %v", err)
+	}
+	end, err := parseClock(window.End)
+	if err != nil {
+		return fmt.Errorf("end: %v", err)
+	}
+	if start == end {
+		return errors.New("start and end must differ")
+	}
+	for _, day := range splitList(window.Days) {
+		if n, err := strconv.Atoi(day); err != nil || n < 0 || n > 6 {
+			return errors.New("days must be a comma separated list of weekdays from 0 (Sunday) to 6")
+		}
+	}
+	if window.Timezone == "" {
+		window.Timezone = digestDefaultTimezone
+	}
+	if _, err := time.LoadLocation(window.Timezone); err != nil {
+		return fmt.Errorf("unknown timezone %q", window.Timezone)
+	}
+	if window.Recipient == "" && window.UserID != nil {
+		var user User
+		if err := db.First(&user, *window.UserID).Error; err != nil {
+			return errors.New("user not found")
+		}
+		if user.Email == "" {
+			return errors.New("the user has no email address")
+		}
+		window.Recipient = user.Email
+	}
+	return nil
+}
+
+func validateOnCallShift(db *gorm.DB, shift *OnCallShift) error {
+	var user User
+	if err := db.First(&user, shift.UserID).Error; err != nil {
+		return errors.New("user not found")
+	}
+	if (shift.Weekday == nil) == (shift.Date == "") {
+		return errors.New("exactly one of weekday and date is required")
+	}
+	if shift.Weekday != nil && (*shift.Weekday < 0 || *shift.Weekday > 6) {
+		return errors.New("weekday must be between 0 (Sunday) and 6")
+	}
+	if shift.Date != "" {
+		if _, err := time.Parse("2006-01-02", shift.Date); err != nil {
+			return errors.New("date must be in YYYY-MM-DD format")
+		}
+	}
+	return nil
+}
+
+// @Summary List quiet hours
+// @Description List the windows in which non-critical external notifications are held
+// @Tags Quiet Hours
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} QuietHours
+// @Failure 401 {object} map[string]string
+// @Router /quiet-hours [get]
+func getQuietHoursHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var windows []QuietHours
+		db.Order("id ASC").Find(&windows)
+		c.JSON(http.StatusOK, windows)
+	}
+}
+
+// @Summary Create quiet hours
+// @Description Create a quiet hours window for a channel and recipient. Critical notifications are never held
+// @Tags Quiet Hours
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param window body QuietHours true "Quiet hours"
+// @Success 201 {object} QuietHours
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /quiet-hours [post]
+func createQuietHoursHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		window := QuietHours{Enabled: true}
+		if err := c.ShouldBindJSON(&window); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		window.ID = 0
+
+		if err := validateQuietHours(db, &window); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := db.Create(&window).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, window)
+	}
+}
+
+// @Summary Update quiet hours
+// @Description Update a quiet hours window
+// @Tags Quiet Hours
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Quiet hours ID"
+// @Param window body QuietHours true "Quiet hours"
+// @Success 200 {object} QuietHours
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /quiet-hours/{id} [put]
+func updateQuietHoursHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var window QuietHours
+		if err := db.First(&window, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Quiet hours not found"})
+			return
+		}
+
+		id := window.ID
+		if err := c.ShouldBindJSON(&window); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		window.ID = id
+
+		if err := validateQuietHours(db, &window); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := db.Save(&window).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, window)
+	}
+}
+
+// @Summary Delete quiet hours
+// @Description Delete a quiet hours window
+// @Tags Quiet Hours
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Quiet hours ID"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /quiet-hours/{id} [delete]
+func deleteQuietHoursHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var window QuietHours
+		if err := db.First(&window, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Quiet hours not found"})
+			return
+		}
+
+		if err := db.Delete(&window).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Quiet hours deleted"})
+	}
+}
+
+// @Summary List on-call rota
+// @Description List the on-call shifts, weekly ones first
+// @Tags Quiet Hours
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} OnCallShift
+// @Failure 401 {object} map[string]string
+// @Router /on-call-rota [get]
+func getOnCallRotaHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var shifts []OnCallShift
+		db.Preload("User").Order("date ASC, weekday ASC, id ASC").Find(&shifts)
+		c.JSON(http.StatusOK, shifts)
+	}
+}
+
+// @Summary Get current on-call users
+// @Description Get the users on call on the given date (default today); critical notifications are sent to them
+// @Tags Quiet Hours
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param date query string false "Date (YYYY-MM-DD)"
+// @Success 200 {array} User
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /on-call-rota/current [get]
+func getCurrentOnCallHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		at := time.Now()
+		if date := c.Query("date"); date != "" {
+			day, err := time.ParseInLocation("2006-01-02", date, onCallTimezone())
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "date must be in YYYY-MM-DD format"})
+				return
+			}
+			at = day.Add(12 * time.Hour)
+		}
+
+		users := onCallToday(db, at)
+		if users == nil {
+			users = []User{}
+		}
+		c.JSON(http.StatusOK, users)
+	}
+}
+
+// @Summary Create on-call shift
+// @Description Assign a user to a recurring weekday or to a specific date of the on-call rota
+// @Tags Quiet Hours
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param shift body OnCallShift true "On-call shift"
+// @Success 201 {object} OnCallShift
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /on-call-rota [post]
+func createOnCallShiftHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var shift OnCallShift
+		if err := c.ShouldBindJSON(&shift); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		shift.ID = 0
+		shift.User = nil
+
+		if err := validateOnCallShift(db, &shift); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := db.Create(&shift).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		db.Preload("User").First(&shift, shift.ID)
+		c.JSON(http.StatusCreated, shift)
+	}
+}
+
+// @Summary Delete on-call shift
+// @Description Remove a shift from the on-call rota
+// @Tags Quiet Hours
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Shift ID"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /on-call-rota/{id} [delete]
+func deleteOnCallShiftHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var shift OnCallShift
+		if err := db.First(&shift, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "On-call shift not found"})
+			return
+		}
+
+		if err := db.Delete(&shift).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "On-call shift deleted"})
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestQuietHoursWindowAcrossMidnight(t *testing.T) {
+	rome, _ := time.LoadLocation("Europe/Rome")
+	window := QuietHours{Start: "22:00", End: "07:00", Days: "5", Timezone: "Europe/Rome"}
+
+	// Venerdì 19 gennaio 2024: la finestra inizia venerdì sera e finisce sabato mattina
+	if until, active := window.activeUntil(time.Date(2024, 1, 20, 3, 0, 0, 0, rome)); !active || !until.Equal(time.Date(2024, 1, 20, 7, 0, 0, 0, rome)) {
+		t.Errorf("saturday 03:00 = %v, %v", until, active)
+	}
+	if _, active := window.activeUntil(time.Date(2024, 1, 19, 12, 0, 0, 0, rome)); active {
+		t.Error("friday noon should not be quiet")
+	}
+	if _, active := window.activeUntil(time.Date(2024, 1, 21, 3, 0, 0, 0, rome)); active {
+		t.Error("sunday 03:00 belongs to a saturday window, not configured")
+	}
+}
+
+func TestExternalNotificationsRespectQuietHoursAndRota(t *testing.T) {
+	db := newTestDB(t)
+	db.Create(&NotificationSettings{EmailNotificationsEnabled: true, EmailAddress: "volontari@laninna.it"})
+
+	now := time.Now().UTC()
+	db.Create(&QuietHours{Channel: QuietChannelEmail, Start: now.Add(-time.Hour).Format("15:04"), End: now.Add(time.Hour).Format("15:04"), Timezone: "UTC", Enabled: true})
+
+	oncall := User{Username: "mario", Email: "mario@laninna.it", Password: "x"}
+	db.Create(&oncall)
+	weekday := int(now.In(onCallTimezone()).Weekday())
+	db.Create(&OnCallShift{UserID: oncall.ID, Weekday: &weekday})
+
+	ns := NewNotificationService(db)
+	medium := Notification{Type: NotificationNoWeighing, Priority: PriorityMedium, Title: "Pesatura", Message: "x"}
+	db.Create(&medium)
+	ns.sendExternalNotifications(medium)
+	critical := Notification{Type: NotificationWeightDrop, Priority: PriorityCritical, Title: "Calo", Message: "x"}
+	db.Create(&critical)
+	ns.sendExternalNotifications(critical)
+
+	var jobs []Job
+	db.Where("kind = ?", JobKindNotificationEmail).Order("id ASC").Find(&jobs)
+	if len(jobs) != 2 {
+		t.Fatalf("jobs = %+v", jobs)
+	}
+
+	// La notifica media attende la fine delle ore di silenzio
+	if held := jobs[0].RunAt.Sub(now); held < 58*time.Minute || held > 61*time.Minute {
+		t.Errorf("medium held for %v", held)
+	}
+	// Quella critica parte subito verso il reperibile di turno
+	if jobs[1].RunAt.After(time.Now()) || jobs[1].Payload != `{"notification_id":2,"recipient":"mario@laninna.it"}` {
+		t.Errorf("critical job = %+v", jobs[1])
+	}
+}
+
+func TestHeldSendDroppedWhenNotificationHandled(t *testing.T) {
+	db := newTestDB(t)
+	memoryMailbox.Reset()
+	db.Create(&EmailTransportSettings{Transport: EmailTransportMemory, From: "notifiche@laninna.it", Enabled: true})
+
+	now := time.Now()
+	notification := Notification{Type: NotificationNoWeighing, Priority: PriorityMedium, Title: "Pesatura", Message: "x", AcknowledgedAt: &now}
+	db.Create(&notification)
+	payload := []byte(`{"notification_id":` + strconv.Itoa(int(notification.ID)) + `,"recipient":"volontari@laninna.it"}`)
+
+	if err := runNotificationEmailJob(db, payload); err != nil {
+		t.Fatal(err)
+	}
+	if messages := memoryMailbox.Messages(); len(messages) != 0 {
+		t.Fatalf("acknowledged notification was sent: %+v", messages)
+	}
+
+	later := now.Add(time.Hour)
+	db.Model(&notification).Updates(map[string]interface{}{"acknowledged_at": nil, "snoozed_until": later})
+	if err := runNotificationEmailJob(db, payload); err != nil {
+		t.Fatal(err)
+	}
+	if messages := memoryMailbox.Messages(); len(messages) != 0 {
+		t.Fatalf("snoozed notification was sent: %+v", messages)
+	}
+
+	db.Model(&notification).Update("snoozed_until", nil)
+	if err := runNotificationEmailJob(db, payload); err != nil {
+		t.Fatal(err)
+	}
+	if messages := memoryMailbox.Messages(); len(messages) != 1 {
+		t.Errorf("open notification sent %d times, want 1", len(messages))
+	}
+}
+
+func TestEscalationEmailRespectsQuietHours(t *testing.T) {
+	db := newTestDB(t)
+	db.Create(&EmailTransportSettings{Transport: EmailTransportMemory, From: "notifiche@laninna.it", Enabled: true})
+	db.Create(&User{Username: "primo", Password: "x", Email: "primo@laninna.it", OnCallOrder: 1})
+
+	notification := Notification{Type: NotificationWeightDrop, Priority: PriorityHigh, Title: "Calo", Message: "x"}
+	db.Create(&notification)
+
+	at := notification.CreatedAt.UTC().Add(121 * time.Minute)
+	db.Create(&QuietHours{Channel: QuietChannelEmail, Start: at.Add(-time.Hour).Format("15:04"), End: at.Add(time.Hour).Format("15:04"), Timezone: "UTC", Enabled: true})
+
+	if got := NewNotificationService(db).EscalatePending(at); got != 1 {
+		t.Fatalf("escalated %d, want 1", got)
+	}
+
+	// L'email di escalation di una notifica non critica attende la fine delle ore di silenzio
+	var job Job
+	if err := db.Where("kind = ?", JobKindNotificationEmail).First(&job).Error; err != nil {
+		t.Fatal(err)
+	}
+	if held := job.RunAt.Sub(at); held < 58*time.Minute || held > 61*time.Minute {
+		t.Errorf("escalation email held for %v", held)
+	}
+}
+
+func TestCreateDisabledQuietHours(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := newTestDB(t)
+	router := gin.New()
+	router.POST("/quiet-hours", createQuietHoursHandler(db))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/quiet-hours",
+		strings.NewReader(`{"start":"22:00","end":"07:00","enabled":false}`)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+
+	var window QuietHours
+	db.First(&window)
+	if window.Enabled {
+		t.Error("window created with enabled=false was stored as enabled")
+	}
+}
@@ -0,0 +1,326 @@
+// release.go - Valutazione idoneità al rilascio
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Esito di un singolo criterio di rilascio
+type ReleaseCriterionResult struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail"`
+}
+
+// Valutazione complessiva di idoneità al rilascio
+type ReleaseEvaluation struct {
+	HedgehogID    uint                     `json:"hedgehog_id"`
+	HedgehogName  string                   `json:"hedgehog_name"`
+	CurrentWeight float64                  `json:"current_weight"`
+	Trend         string                   `json:"trend"`
+	Score         float64                  `json:"score"` // Percentuale di criteri soddisfatti
+	Ready         bool                     `json:"ready"`
+	Criteria      []ReleaseCriterionResult `json:"criteria"`
+}
+
+func defaultReleaseCriteria() ReleaseCriteria {
+	return ReleaseCriteria{
+		Enabled:                true,
+		MinWeight:              600,
+		AllowedTrends:          "improving,stable",
+		RequireNoTherapies:     true,
+		SeasonStartMonth:       4,
+		SeasonEndMonth:         10,
+		NotificationRepeatDays: 7,
+	}
+}
+
+// loadReleaseCriteria legge i criteri salvati o crea quelli di default
+func loadReleaseCriteria(db *gorm.DB) ReleaseCriteria {
+	var criteria ReleaseCriteria
+	if err := db.First(&criteria).Error; err != nil {
+		criteria = defaultReleaseCriteria()
+		db.Create(&criteria)
+	}
+	return criteria
+}
+
+// inReleaseSeason verifica se il mese cade nella finestra stagionale (anche a cavallo d'anno)
+func inReleaseSeason(month time.Month, startMonth, endMonth int) bool {
+	m := int(month)
+	if startMonth <= endMonth {
+		return m >= startMonth && m <= endMonth
+	}
+	return m >= startMonth || m <= endMonth
+}
+
+// EvaluateRelease valuta un riccio rispetto ai criteri di rilascio
+func (ns *NotificationService) EvaluateRelease(hedgehog Hedgehog, criteria ReleaseCriteria, now time.Time) ReleaseEvaluation {
+	eval := ReleaseEvaluation{HedgehogID: hedgehog.ID, HedgehogName: hedgehog.Name}
+
+	var weights []WeightRecord
+	ns.db.Where("hedgehog_id = ? AND excluded = ?", hedgehog.ID, false).
+		Order("date DESC").
+		Limit(10).
+		Find(&weights)
+
+	// Peso minimo
+	if len(weights) > 0 {
+		eval.CurrentWeight = weights[0].Weight
+	}
+	eval.Criteria = append(eval.Criteria, ReleaseCriterionResult{
+		Name:   "min_weight",
+		Passed: eval.CurrentWeight >= criteria.MinWeight,
+		Detail: fmt.Sprintf("Peso attuale %.0fg (minimo %.0fg)", eval.CurrentWeight, criteria.MinWeight),
+	})
+
+	// Andamento del peso
+	eval.Trend = ns.calculateWeightTrend(ns.loadTrendWeights(hedgehog.ID))
+	trendOK := false
+	for _, allowed := range strings.Split(criteria.AllowedTrends, ",") {
+		if strings.TrimSpace(allowed) == eval.Trend {
+			trendOK = true
+		}
+	}
+	eval.Criteria = append(eval.Criteria, ReleaseCriterionResult{
+		Name:   "weight_trend",
+		Passed: trendOK,
+		Detail: fmt.Sprintf("Trend %s (ammessi: %s)", eval.Trend, criteria.AllowedTrends),
+	})
+
+	// Terapie in corso
+	if criteria.RequireNoTherapies {
+		var active int64
+		ns.db.Model(&Therapy{}).Where("hedgehog_id = ? AND status = 'active'", hedgehog.ID).Count(&active)
+		eval.Criteria = append(eval.Criteria, ReleaseCriterionResult{
+			Name:   "no_active_therapies",
+			Passed: active == 0,
+			Detail: fmt.Sprintf("%d terapie attive", active),
+		})
+	}
+
+	// Letargo
+	eval.Criteria = append(eval.Criteria, ReleaseCriterionResult{
+		Name:   "not_hibernating",
+		Passed: !isHibernating(hedgehog),
+		Detail: "Il riccio non deve essere in letargo",
+	})
+
+	// Stagione
+	eval.Criteria = append(eval.Criteria, ReleaseCriterionResult{
+		Name:   "season",
+		Passed: inReleaseSeason(now.Month(), criteria.SeasonStartMonth, criteria.SeasonEndMonth),
+		Detail: fmt.Sprintf("Finestra di rilascio: mesi %d-%d", criteria.SeasonStartMonth, criteria.SeasonEndMonth),
+	})
+
+	// Temperatura
+	if criteria.MinTemperature != nil {
+		result := ReleaseCriterionResult{Name: "temperature"}
+		if criteria.LastTemperature == nil || criteria.LastTemperatureAt == nil || now.Sub(*criteria.LastTemperatureAt) > 48*time.Hour {
+			result.Detail = "Temperatura non disponibile o non aggiornata"
+		} else {
+			result.Passed = *criteria.LastTemperature >= *criteria.MinTemperature
+			result.Detail = fmt.Sprintf("Temperatura %.1f°C (minimo %.1f°C)", *criteria.LastTemperature, *criteria.MinTemperature)
+		}
+		eval.Criteria = append(eval.Criteria, result)
+	}
+
+	passed := 0
+	for _, c := range eval.Criteria {
+		if c.Passed {
+			passed++
+		}
+	}
+	eval.Score = float64(passed) / float64(len(eval.Criteria)) * 100
+	eval.Ready = passed == len(eval.Criteria)
+
+	return eval
+}
+
+// evaluateReleaseCandidates valuta i ricci in cura, solo quelli del servizio se limitato
+func (ns *NotificationService) evaluateReleaseCandidates(criteria ReleaseCriteria) []ReleaseEvaluation {
+	var hedgehogs []Hedgehog
+	ns.scoped(ns.db.Where("status = 'in_care'"), "id").Find(&hedgehogs)
+
+	now := time.Now()
+	evaluations := make([]ReleaseEvaluation, 0, len(hedgehogs))
+	for _, hedgehog := range hedgehogs {
+		evaluations = append(evaluations, ns.EvaluateRelease(hedgehog, criteria, now))
+	}
+
+	sort.Slice(evaluations, func(i, j int) bool {
+		if evaluations[i].Score != evaluations[j].Score {
+			return evaluations[i].Score > evaluations[j].Score
+		}
+		return evaluations[i].CurrentWeight > evaluations[j].CurrentWeight
+	})
+
+	return evaluations
+}
+
+func (ns *NotificationService) releaseReadinessCandidates() ([]RuleCandidate, error) {
+	criteria := loadReleaseCriteria(ns.db)
+	if !criteria.Enabled {
+		return nil, nil
+	}
+
+	repeat := time.Duration(criteria.NotificationRepeatDays) * 24 * time.Hour
+	if repeat <= 0 {
+		repeat = 7 * 24 * time.Hour
+	}
+
+	var candidates []RuleCandidate
+	for _, eval := range ns.evaluateReleaseCandidates(criteria) {
+		if !eval.Ready {
+			continue
+		}
+
+		hedgehogID := eval.HedgehogID
+		data, _ := json.Marshal(eval)
+		candidates = append(candidates, RuleCandidate{RepeatAfter: repeat, Notification: Notification{
+			Type:         NotificationHedgehogRecovered,
+			Priority:     PriorityLow,
+			TemplateData: templateVars(map[string]interface{}{"Hedgehog": eval.HedgehogName, "Weight": eval.CurrentWeight, "Trend": eval.Trend}),
+			HedgehogID:   &hedgehogID,
+			ActionURL:    fmt.Sprintf("/hedgehogs/%d", hedgehogID),
+			ActionLabel:  "Pianifica Rilascio",
+			Data:         string(data),
+		}})
+	}
+
+	return candidates, nil
+}
+
+// @Summary Get release candidates
+// @Description Evaluate hedgehogs in care against the release criteria
+// @Tags Release
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param all query boolean false "Include hedgehogs that are not ready"
+// @Param min_score query number false "Minimum readiness score (0-100)"
+// @Success 200 {array} ReleaseEvaluation
+// @Failure 401 {object} map[string]string
+// @Router /hedgehogs/release-candidates [get]
+func getReleaseCandidatesHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ns := NewNotificationService(db)
+		evaluations := ns.evaluateReleaseCandidates(loadReleaseCriteria(db))
+
+		includeAll := c.Query("all") == "true"
+		minScore := 0.0
+		if s := c.Query("min_score"); s != "" {
+			if parsed, err := strconv.ParseFloat(s, 64); err == nil {
+				minScore = parsed
+			}
+		}
+
+		result := make([]ReleaseEvaluation, 0, len(evaluations))
+		for _, eval := range evaluations {
+			if !includeAll && !eval.Ready && minScore == 0 {
+				continue
+			}
+			if eval.Score < minScore {
+				continue
+			}
+			result = append(result, eval)
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// @Summary Get release criteria
+// @Description Get the criteria used to evaluate release readiness
+// @Tags Release
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} ReleaseCriteria
+// @Failure 401 {object} map[string]string
+// @Router /release-criteria [get]
+func getReleaseCriteriaHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, loadReleaseCriteria(db))
+	}
+}
+
+// @Summary Update release criteria
+// @Description Update the criteria used to evaluate release readiness
+// @Tags Release
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param criteria body ReleaseCriteria true "Release criteria"
+// @Success 200 {object} ReleaseCriteria
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /release-criteria [put]
+func updateReleaseCriteriaHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		criteria := loadReleaseCriteria(db)
+		if err := c.ShouldBindJSON(&criteria); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if criteria.SeasonStartMonth < 1 || criteria.SeasonStartMonth > 12 ||
+			criteria.SeasonEndMonth < 1 || criteria.SeasonEndMonth > 12 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "season months must be between 1 and 12"})
+			return
+		}
+
+		if err := db.Save(&criteria).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, criteria)
+	}
+}
+
+// @Summary Report night temperature
+// @Description Report the current night temperature used by the release temperature window
+// @Tags Release
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param temperature body object true "Temperature, e.g. {\"temperature\": 9.5}"
+// @Success 200 {object} ReleaseCriteria
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /release-criteria/temperature [put]
+func reportTemperatureHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request struct {
+			Temperature *float64 `json:"temperature" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		criteria := loadReleaseCriteria(db)
+		now := time.Now()
+		criteria.LastTemperature = request.Temperature
+		criteria.LastTemperatureAt = &now
+
+		if err := db.Save(&criteria).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, criteria)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInReleaseSeason(t *testing.T) {
+	tests := []struct {
+		month      time.Month
+		start, end int
+		want       bool
+	}{
+		{time.April, 4, 10, true},
+		{time.October, 4, 10, true},
+		{time.November, 4, 10, false},
+		{time.March, 4, 10, false},
+		// Finestra a cavallo d'anno, da novembre a febbraio
+		{time.December, 11, 2, true},
+		{time.January, 11, 2, true},
+		{time.February, 11, 2, true},
+		{time.March, 11, 2, false},
+		{time.October, 11, 2, false},
+	}
+
+	for _, tt := range tests {
+		if got := inReleaseSeason(tt.month, tt.start, tt.end); got != tt.want {
+			t.Errorf("inReleaseSeason(%s, %d, %d) = %v, want %v", tt.month, tt.start, tt.end, got, tt.want)
+		}
+	}
+}
+
+func TestEvaluateReleaseScore(t *testing.T) {
+	db := newTestDB(t)
+	hedgehog := Hedgehog{Name: "Pronto", Status: "in_care"}
+	db.Create(&hedgehog)
+	for i, weight := range []float64{640, 644, 648, 652, 656} {
+		db.Create(&WeightRecord{HedgehogID: hedgehog.ID, Weight: weight, Date: time.Now().AddDate(0, 0, -8+2*i)})
+	}
+
+	ns := NewNotificationService(db)
+	criteria := defaultReleaseCriteria()
+	june := time.Date(time.Now().Year(), time.June, 15, 12, 0, 0, 0, time.UTC)
+
+	eval := ns.EvaluateRelease(hedgehog, criteria, june)
+	if !eval.Ready || eval.Score != 100 || eval.CurrentWeight != 656 {
+		t.Fatalf("evaluation = %+v, want ready with every criterion passed", eval)
+	}
+
+	// Terapia in corso e fuori stagione: 3 criteri su 5
+	db.Create(&Therapy{HedgehogID: hedgehog.ID, Name: "Antibiotico", Status: "active"})
+	december := time.Date(time.Now().Year(), time.December, 15, 12, 0, 0, 0, time.UTC)
+	eval = ns.EvaluateRelease(hedgehog, criteria, december)
+	if eval.Ready || eval.Score != 60 {
+		t.Errorf("score = %.0f, ready = %v, want 60 and not ready", eval.Score, eval.Ready)
+	}
+
+	// Una temperatura non aggiornata non soddisfa il criterio
+	minTemperature, lastTemperature := 8.0, 12.0
+	stale := june.Add(-72 * time.Hour)
+	criteria.MinTemperature, criteria.LastTemperature, criteria.LastTemperatureAt = &minTemperature, &lastTemperature, &stale
+	db.Model(&Therapy{}).Where("hedgehog_id = ?", hedgehog.ID).Update("status", "completed")
+	eval = ns.EvaluateRelease(hedgehog, criteria, june)
+	if eval.Ready || len(eval.Criteria) != 6 || eval.Criteria[5].Name != "temperature" || eval.Criteria[5].Passed {
+		t.Errorf("criteria = %+v, want the stale temperature to fail", eval.Criteria)
+	}
+}
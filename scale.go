@@ -0,0 +1,578 @@
+// scale.go - Acquisizione pesate da bilance collegate (bridge HTTP o cartella CSV)
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/laninna/hedgehog-app/logger"
+	"gorm.io/gorm"
+)
+
+const (
+	ScaleStatusRecorded  = "recorded"
+	ScaleStatusMerged    = "merged"
+	ScaleStatusUnmatched = "unmatched"
+	ScaleStatusAmbiguous = "ambiguous"
+	ScaleStatusRejected  = "rejected"
+
+	ScaleSourceBridge = "bridge"
+	ScaleSourceCSV    = "csv"
+)
+
+// Lettura inviata da una bilancia o da un bridge
+type ScaleReadingInput struct {
+	DeviceID   string     `json:"device_id" example:"scale-01"`
+	Tag        string     `json:"tag" example:"380260000123456"` // Microchip/RFID o QR della gabbia
+	Weight     float64    `json:"weight" example:"452.5"`
+	MeasuredAt *time.Time `json:"measured_at,omitempty" example:"2024-01-15T10:30:00Z"`
+}
+
+// Richiesta di assegnazione manuale di una lettura non abbinata
+type ScaleAssignRequest struct {
+	HedgehogID uint `json:"hedgehog_id" binding:"required" example:"1"`
+}
+
+// ScaleFeeder è una sorgente di letture (cartella CSV, bilancia simulata nei test, ...)
+type ScaleFeeder interface {
+	Source() string
+	Fetch(ctx context.Context) ([]ScaleReadingInput, error)
+}
+
+// ScaleFeedAcknowledger è implementata dalle sorgenti che devono conoscere l'esito delle
+// letture restituite da Fetch: failed[i] indica se la lettura i non è stata registrata
+type ScaleFeedAcknowledger interface {
+	Acknowledge(failed []bool)
+}
+
+type ScaleIngestService struct {
+	db          *gorm.DB
+	burstWindow time.Duration
+}
+
+func NewScaleIngestService(db *gorm.DB) *ScaleIngestService {
+	window := 120
+	if seconds, err := strconv.Atoi(getEnv("SCALE_BURST_WINDOW_SECONDS", "120")); err == nil && seconds > 0 {
+		window = seconds
+	}
+	return &ScaleIngestService{db: db, burstWindow: time.Duration(window) * time.Second}
+}
+
+// matchHedgehog abbina il codice letto al riccio: prima il microchip, poi il QR della gabbia
+func (s *ScaleIngestService) matchHedgehog(tag string) (*Hedgehog, string, string) {
+	var hedgehogs []Hedgehog
+	s.db.Where("microchip = ? AND status = 'in_care'", tag).Find(&hedgehogs)
+	if len(hedgehogs) == 1 {
+		return &hedgehogs[0], "", ""
+	}
+	if len(hedgehogs) > 1 {
+		return nil, ScaleStatusAmbiguous, fmt.Sprintf("%d ricci con lo stesso microchip", len(hedgehogs))
+	}
+
+	var area Area
+	if err := s.db.Where("qr_code = ?", tag).First(&area).Error; err != nil {
+		return nil, ScaleStatusUnmatched, "Nessun riccio o gabbia con questo codice"
+	}
+
+	s.db.Where("area_id = ? AND status = 'in_care'", area.ID).Find(&hedgehogs)
+	switch len(hedgehogs) {
+	case 0:
+		return nil, ScaleStatusUnmatched, fmt.Sprintf("Nessun riccio nella gabbia %s", area.Name)
+	case 1:
+		return &hedgehogs[0], "", ""
+	default:
+		return nil, ScaleStatusAmbiguous, fmt.Sprintf("%d ricci nella gabbia %s", len(hedgehogs), area.Name)
+	}
+}
+
+// Ingest registra una lettura, la abbina al riccio e crea (o aggiorna) la pesata
+func (s *ScaleIngestService) Ingest(input ScaleReadingInput, source string) (ScaleReading, error) {
+	reading := ScaleReading{
+		DeviceID:   strings.TrimSpace(input.DeviceID),
+		Tag:        strings.TrimSpace(input.Tag),
+		Weight:     input.Weight,
+		MeasuredAt: time.Now(),
+		Source:     source,
+	}
+	if input.MeasuredAt != nil && !input.MeasuredAt.IsZero() {
+		reading.MeasuredAt = *input.MeasuredAt
+	}
+
+	switch {
+	case reading.Tag == "":
+		reading.Status, reading.Message = ScaleStatusRejected, "Codice mancante"
+	case reading.Weight <= 0:
+		reading.Status, reading.Message = ScaleStatusRejected, "Peso non valido"
+	}
+	if reading.Status != "" {
+		return reading, s.db.Create(&reading).Error
+	}
+
+	hedgehog, status, message := s.matchHedgehog(reading.Tag)
+	if hedgehog == nil {
+		reading.Status, reading.Message = status, message
+		return reading, s.db.Create(&reading).Error
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		return s.recordWeight(tx, &reading, hedgehog.ID)
+	})
+	return reading, err
+}
+
+// recordWeight crea la pesata o la unisce a quella della raffica in corso
+func (s *ScaleIngestService) recordWeight(tx *gorm.DB, reading *ScaleReading, hedgehogID uint) error {
+	reading.HedgehogID = &hedgehogID
+
+	// Le bilance inviano più letture mentre l'animale si assesta: si uniscono alla prima della raffica
+	var first ScaleReading
+	err := tx.Where("hedgehog_id = ? AND status = ? AND weight_record_id IS NOT NULL AND measured_at >= ? AND measured_at <= ?",
+		hedgehogID, ScaleStatusRecorded, reading.MeasuredAt.Add(-s.burstWindow), reading.MeasuredAt).
+		Order("measured_at DESC").
+		First(&first).Error
+
+	if err == nil {
+		var burst []ScaleReading
+		tx.Where("weight_record_id = ?", *first.WeightRecordID).Find(&burst)
+		weights := []float64{reading.Weight}
+		for _, r := range burst {
+			weights = append(weights, r.Weight)
+		}
+
+		var record WeightRecord
+		if err := tx.First(&record, *first.WeightRecordID).Error; err != nil {
+			return err
+		}
+		record.Weight = median(weights)
+		s.checkRecord(tx, &record)
+		if err := tx.Save(&record).Error; err != nil {
+			return err
+		}
+		enqueueAutoResolve(tx, hedgehogID)
+
+		reading.Status = ScaleStatusMerged
+		reading.Message = fmt.Sprintf("Unita alla raffica (%d letture, mediana %.1fg)", len(weights), record.Weight)
+		reading.WeightRecordID = &record.ID
+		return tx.Save(reading).Error
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	record := WeightRecord{HedgehogID: hedgehogID, Weight: reading.Weight, Date: reading.MeasuredAt, Notes: "Bilancia " + reading.DeviceID}
+	s.checkRecord(tx, &record)
+	if err := tx.Create(&record).Error; err != nil {
+		return err
+	}
+	publishDomainEvent(tx, DomainEventWeightRecorded, newWeightEvent(record))
+	enqueueAutoResolve(tx, hedgehogID)
+
+	reading.Status = ScaleStatusRecorded
+	if record.Flagged {
+		reading.Message = record.FlagReason
+	}
+	reading.WeightRecordID = &record.ID
+	return tx.Save(reading).Error
+}
+
+// checkRecord applica controllo di plausibilità e curva di crescita; senza operatore le pesate sospette restano escluse
+func (s *ScaleIngestService) checkRecord(tx *gorm.DB, record *WeightRecord) {
+	check := NewNotificationService(tx).detectWeightOutlier(*record)
+	applyOutlierCheck(record, check)
+	record.Excluded = check.Flagged
+	annotateWeightRecord(tx, record)
+}
+
+// Assign abbina manualmente una lettura non abbinata a un riccio
+func (s *ScaleIngestService) Assign(reading *ScaleReading, hedgehogID uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&Hedgehog{}, hedgehogID).Error; err != nil {
+			return err
+		}
+		reading.Message = ""
+		return s.recordWeight(tx, reading, hedgehogID)
+	})
+}
+
+// parseScaleCSV legge righe "measured_at,tag,weight[,device_id]" con intestazione opzionale
+func parseScaleCSV(r io.Reader) ([]ScaleReadingInput, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var readings []ScaleReadingInput
+	for i, row := range rows {
+		if len(row) < 3 {
+			return nil, fmt.Errorf("line %d: expected at least 3 columns", i+1)
+		}
+
+		weight, err := strconv.ParseFloat(strings.TrimSpace(row[2]), 64)
+		if err != nil {
+			if i == 0 {
+				continue // Intestazione
+			}
+			return nil, fmt.Errorf("line %d: invalid weight %q", i+1, row[2])
+		}
+
+		input := ScaleReadingInput{Tag: strings.TrimSpace(row[1]), Weight: weight}
+		if ts := strings.TrimSpace(row[0]); ts != "" {
+			measuredAt, err := parseScaleTime(ts)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid timestamp %q", i+1, ts)
+			}
+			input.MeasuredAt = &measuredAt
+		}
+		if len(row) > 3 {
+			input.DeviceID = strings.TrimSpace(row[3])
+		}
+		readings = append(readings, input)
+	}
+
+	return readings, nil
+}
+
+func parseScaleTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unsupported time format")
+}
+
+// CSVDropFolder legge i file CSV depositati dal bridge. I file illeggibili vanno subito in failed/,
+// gli altri solo dopo la registrazione delle letture: in processed/ o, se una riga fallisce, in failed/
+type CSVDropFolder struct {
+	Dir string
+
+	pending []csvDropFile // File letti dall'ultima Fetch, in attesa dell'esito
+}
+
+// csvDropFile è un file letto e il numero di letture che ha prodotto
+type csvDropFile struct {
+	path     string
+	readings int
+}
+
+func (f *CSVDropFolder) Source() string {
+	return ScaleSourceCSV
+}
+
+func (f *CSVDropFolder) Fetch(ctx context.Context) ([]ScaleReadingInput, error) {
+	files, err := filepath.Glob(filepath.Join(f.Dir, "*.csv"))
+	if err != nil {
+		return nil, err
+	}
+
+	f.pending = nil
+	var readings []ScaleReadingInput
+	for _, path := range files {
+		if ctx.Err() != nil {
+			break
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			logger.Error("Failed to open scale CSV", err, logger.Str("file", path))
+			continue
+		}
+		parsed, parseErr := parseScaleCSV(file)
+		file.Close()
+
+		if parseErr != nil {
+			logger.Error("Failed to parse scale CSV", parseErr, logger.Str("file", path))
+			f.move(path, "failed")
+			continue
+		}
+		readings = append(readings, parsed...)
+		f.pending = append(f.pending, csvDropFile{path: path, readings: len(parsed)})
+	}
+
+	return readings, nil
+}
+
+// Acknowledge archivia i file dell'ultima Fetch in base all'esito delle loro letture
+func (f *CSVDropFolder) Acknowledge(failed []bool) {
+	offset := 0
+	for _, file := range f.pending {
+		target := "processed"
+		for i := offset; i < offset+file.readings && i < len(failed); i++ {
+			if failed[i] {
+				target = "failed"
+				break
+			}
+		}
+		offset += file.readings
+
+		if target == "failed" {
+			logger.Warn("Scale CSV moved to failed/, some readings were not ingested", logger.Str("file", file.path))
+		}
+		f.move(file.path, target)
+	}
+	f.pending = nil
+}
+
+func (f *CSVDropFolder) move(path, target string) {
+	if err := os.MkdirAll(filepath.Join(f.Dir, target), 0o755); err == nil {
+		os.Rename(path, filepath.Join(f.Dir, target, filepath.Base(path)))
+	}
+}
+
+func ingestFromFeeder(ctx context.Context, feeder ScaleFeeder, service *ScaleIngestService) []ScaleReading {
+	inputs, err := feeder.Fetch(ctx)
+	if err != nil {
+		logger.Error("Failed to fetch scale readings", err, logger.Str("source", feeder.Source()))
+		return nil
+	}
+
+	results := make([]ScaleReading, 0, len(inputs))
+	failed := make([]bool, len(inputs))
+	for i, input := range inputs {
+		reading, err := service.Ingest(input, feeder.Source())
+		if err != nil {
+			logger.Error("Failed to ingest scale reading", err, logger.Str("tag", input.Tag))
+			failed[i] = true
+			continue
+		}
+		results = append(results, reading)
+	}
+	if acknowledger, ok := feeder.(ScaleFeedAcknowledger); ok {
+		acknowledger.Acknowledge(failed)
+	}
+
+	if len(results) > 0 {
+		logger.Info("Scale readings ingested", logger.Str("source", feeder.Source()), logger.Int("count", len(results)))
+	}
+	return results
+}
+
+// StartScaleDropFolder registra la lettura della cartella CSV tra le attività
+// pianificate se SCALE_DROP_DIR è configurata
+func StartScaleDropFolder(db *gorm.DB) {
+	dir := os.Getenv("SCALE_DROP_DIR")
+	if dir == "" {
+		return
+	}
+
+	registered := tryRegisterScheduledTask(ScheduledTask{
+		Name:        "scale-drop-folder",
+		Description: "Ingest scale CSV files from " + dir,
+		Cron:        getEnv("SCALE_POLL_CRON", "* * * * *"),
+		Run: func(db *gorm.DB, now time.Time) error {
+			ingestFromFeeder(context.Background(), &CSVDropFolder{Dir: dir}, NewScaleIngestService(db))
+			return nil
+		},
+	})
+	if !registered {
+		// SCALE_POLL_CRON non valida: l'applicazione parte comunque, senza lettura della cartella
+		return
+	}
+	logger.Info("Scale drop folder enabled", logger.Str("dir", dir))
+}
+
+// scaleAuthMiddleware accetta il token del bridge (X-Scale-Token) oppure un normale JWT
+func scaleAuthMiddleware() gin.HandlerFunc {
+	jwtAuth := authMiddleware()
+	return func(c *gin.Context) {
+		expected := os.Getenv("SCALE_INGEST_TOKEN")
+		token := c.GetHeader("X-Scale-Token")
+		if expected != "" && token != "" {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid scale token"})
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+		jwtAuth(c)
+	}
+}
+
+// @Summary Ingest scale readings
+// @Description Ingest one reading or a batch ({"readings": [...]}) from a scale bridge. Authenticate with X-Scale-Token or a JWT
+// @Tags Scales
+// @Accept json
+// @Produce json
+// @Param X-Scale-Token header string false "Scale bridge token"
+// @Param readings body ScaleReadingInput true "Reading or batch of readings"
+// @Success 200 {array} ScaleReading
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /scale/readings [post]
+func ingestScaleReadingsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := c.GetRawData()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var batch struct {
+			Readings []ScaleReadingInput `json:"readings"`
+		}
+		if err := json.Unmarshal(body, &batch); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(batch.Readings) == 0 {
+			var single ScaleReadingInput
+			if err := json.Unmarshal(body, &single); err != nil || single.Tag == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "tag and weight are required"})
+				return
+			}
+			batch.Readings = append(batch.Readings, single)
+		}
+
+		ingestScaleInputs(c, NewScaleIngestService(db), batch.Readings, ScaleSourceBridge)
+	}
+}
+
+// @Summary Ingest scale CSV
+// @Description Ingest a CSV export (measured_at,tag,weight[,device_id]) sent as request body or as multipart "file"
+// @Tags Scales
+// @Accept text/csv
+// @Produce json
+// @Param X-Scale-Token header string false "Scale bridge token"
+// @Param file formData file false "CSV file"
+// @Success 200 {array} ScaleReading
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /scale/readings/csv [post]
+func ingestScaleCSVHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var source io.Reader = c.Request.Body
+		if file, err := c.FormFile("file"); err == nil {
+			opened, err := file.Open()
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			defer opened.Close()
+			source = opened
+		}
+
+		inputs, err := parseScaleCSV(source)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ingestScaleInputs(c, NewScaleIngestService(db), inputs, ScaleSourceCSV)
+	}
+}
+
+func ingestScaleInputs(c *gin.Context, service *ScaleIngestService, inputs []ScaleReadingInput, source string) {
+	log := logger.GetLoggerFromContext(c)
+
+	results := make([]ScaleReading, 0, len(inputs))
+	for _, input := range inputs {
+		reading, err := service.Ingest(input, source)
+		if err != nil {
+			log.Error().Err(err).Str("tag", input.Tag).Msg("Failed to ingest scale reading")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		results = append(results, reading)
+	}
+
+	log.Info().Int("count", len(results)).Str("source", source).Msg("Scale readings ingested")
+	c.JSON(http.StatusOK, results)
+}
+
+// @Summary Get scale readings
+// @Description Get raw scale readings, e.g. unmatched ones to assign manually
+// @Tags Scales
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param status query string false "Filter by status (recorded, merged, unmatched, ambiguous, rejected)"
+// @Param limit query int false "Limit results" default(100)
+// @Success 200 {array} ScaleReading
+// @Failure 401 {object} map[string]string
+// @Router /scale/readings [get]
+func getScaleReadingsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := db.Order("measured_at DESC")
+		if status := c.Query("status"); status != "" {
+			query = query.Where("status = ?", status)
+		}
+
+		limit := 100
+		if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 {
+			limit = l
+		}
+
+		var readings []ScaleReading
+		query.Limit(limit).Find(&readings)
+		c.JSON(http.StatusOK, readings)
+	}
+}
+
+// @Summary Assign scale reading
+// @Description Assign an unmatched or ambiguous scale reading to a hedgehog and create the weight record
+// @Tags Scales
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Scale reading ID"
+// @Param assignment body ScaleAssignRequest true "Hedgehog to assign"
+// @Success 200 {object} ScaleReading
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /scale/readings/{id}/assign [put]
+func assignScaleReadingHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var reading ScaleReading
+		if err := db.First(&reading, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Scale reading not found"})
+			return
+		}
+
+		if reading.Status != ScaleStatusUnmatched && reading.Status != ScaleStatusAmbiguous {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Only unmatched or ambiguous readings can be assigned"})
+			return
+		}
+
+		var req ScaleAssignRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := NewScaleIngestService(db).Assign(&reading, req.HedgehogID); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Hedgehog not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, reading)
+	}
+}
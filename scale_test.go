@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// fakeScale simula una bilancia che invia raffiche di letture
+type fakeScale struct {
+	batches [][]ScaleReadingInput
+}
+
+func (f *fakeScale) Source() string {
+	return ScaleSourceBridge
+}
+
+func (f *fakeScale) Fetch(ctx context.Context) ([]ScaleReadingInput, error) {
+	if len(f.batches) == 0 {
+		return nil, nil
+	}
+	batch := f.batches[0]
+	f.batches = f.batches[1:]
+	return batch, nil
+}
+
+// burst genera letture ravvicinate di pochi secondi, come una bilancia che si assesta
+func (f *fakeScale) burst(tag string, start time.Time, weights ...float64) {
+	var batch []ScaleReadingInput
+	for i, w := range weights {
+		measuredAt := start.Add(time.Duration(i*3) * time.Second)
+		batch = append(batch, ScaleReadingInput{DeviceID: "fake-scale", Tag: tag, Weight: w, MeasuredAt: &measuredAt})
+	}
+	f.batches = append(f.batches, batch)
+}
+
+func newScaleTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "scale.db")), &gorm.Config{
+		Logger: gormlogger.Default.LogMode(gormlogger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&Hedgehog{}, &Area{}, &WeightRecord{}, &GrowthCurvePoint{}, &ScaleReading{}, &NotificationSettings{}, &SeedMarker{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestScaleIngestDeduplicatesBursts(t *testing.T) {
+	db := newScaleTestDB(t)
+	db.Create(&Hedgehog{Name: "Spillo", Microchip: "380260000123456", ArrivalDate: time.Now()})
+
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	scale := &fakeScale{}
+	scale.burst("380260000123456", start, 448, 452, 451, 450)
+	scale.burst("380260000123456", start.Add(24*time.Hour), 455)
+
+	service := &ScaleIngestService{db: db, burstWindow: 2 * time.Minute}
+	ingestFromFeeder(context.Background(), scale, service)
+	ingestFromFeeder(context.Background(), scale, service)
+
+	var records []WeightRecord
+	db.Order("date ASC").Find(&records)
+	if len(records) != 2 {
+		t.Fatalf("weight records = %d, want 2", len(records))
+	}
+	if records[0].Weight != 450.5 {
+		t.Errorf("burst weight = %.1f, want median 450.5", records[0].Weight)
+	}
+
+	var merged int64
+	db.Model(&ScaleReading{}).Where("status = ?", ScaleStatusMerged).Count(&merged)
+	if merged != 3 {
+		t.Errorf("merged readings = %d, want 3", merged)
+	}
+}
+
+func TestScaleIngestMatchesCageQRCode(t *testing.T) {
+	db := newScaleTestDB(t)
+	single := Area{Name: "Gabbia 1", QRCode: "CAGE-001"}
+	shared := Area{Name: "Recinto", QRCode: "CAGE-002"}
+	db.Create(&single)
+	db.Create(&shared)
+	db.Create(&Hedgehog{Name: "Riccio", AreaID: &single.ID, ArrivalDate: time.Now()})
+	db.Create(&Hedgehog{Name: "Uno", AreaID: &shared.ID, ArrivalDate: time.Now()})
+	db.Create(&Hedgehog{Name: "Due", AreaID: &shared.ID, ArrivalDate: time.Now()})
+
+	service := &ScaleIngestService{db: db, burstWindow: 2 * time.Minute}
+	tests := map[string]string{
+		"CAGE-001": ScaleStatusRecorded,
+		"CAGE-002": ScaleStatusAmbiguous,
+		"UNKNOWN":  ScaleStatusUnmatched,
+	}
+	for tag, want := range tests {
+		reading, err := service.Ingest(ScaleReadingInput{Tag: tag, Weight: 500}, ScaleSourceBridge)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reading.Status != want {
+			t.Errorf("%s: status = %q, want %q", tag, reading.Status, want)
+		}
+	}
+}
+
+func TestParseScaleCSV(t *testing.T) {
+	data := "measured_at,tag,weight,device_id\n" +
+		"2024-03-01T09:00:00Z,380260000123456,450.5,scale-01\n" +
+		"2024-03-01 09:05:00,CAGE-001,380\n"
+
+	readings, err := parseScaleCSV(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readings) != 2 {
+		t.Fatalf("readings = %d, want 2", len(readings))
+	}
+	if readings[0].Weight != 450.5 || readings[0].DeviceID != "scale-01" || readings[0].MeasuredAt == nil {
+		t.Errorf("unexpected first reading: %+v", readings[0])
+	}
+	if readings[1].Tag != "CAGE-001" {
+		t.Errorf("tag = %q, want CAGE-001", readings[1].Tag)
+	}
+
+	if _, err := parseScaleCSV(strings.NewReader("2024-03-01T09:00:00Z,TAG,abc\n2024-03-01T09:00:00Z,TAG,xyz\n")); err == nil {
+		t.Error("expected error for invalid weight")
+	}
+}
+
+func TestCSVDropFolderArchivesFilesAfterIngestion(t *testing.T) {
+	db := newScaleTestDB(t)
+	db.Create(&Hedgehog{Name: "Spillo", Microchip: "380260000123456", ArrivalDate: time.Now()})
+
+	// Il salvataggio delle letture di BROKEN fallisce, come un errore del database
+	db.Callback().Create().Before("gorm:create").Register("test:fail_broken", func(tx *gorm.DB) {
+		if reading, ok := tx.Statement.Dest.(*ScaleReading); ok && reading.Tag == "BROKEN" {
+			tx.AddError(errors.New("database unavailable"))
+		}
+	})
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"ok.csv":      "2024-03-01T09:00:00Z,380260000123456,450\n",
+		"partial.csv": "2024-03-02T09:00:00Z,380260000123456,455\n2024-03-02T09:10:00Z,BROKEN,300\n",
+		"bad.csv":     "not,a,weight\nstill,not,one\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	folder := &CSVDropFolder{Dir: dir}
+	if _, err := folder.Fetch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// Prima della registrazione restano nella cartella, tranne quelli illeggibili
+	for name, want := range map[string]string{"ok.csv": "", "partial.csv": "", "bad.csv": "failed"} {
+		if _, err := os.Stat(filepath.Join(dir, want, name)); err != nil {
+			t.Errorf("%s not in %q after fetch", name, want)
+		}
+	}
+
+	ingestFromFeeder(context.Background(), &CSVDropFolder{Dir: dir}, &ScaleIngestService{db: db, burstWindow: 2 * time.Minute})
+
+	for name, want := range map[string]string{"ok.csv": "processed", "partial.csv": "failed", "bad.csv": "failed"} {
+		if _, err := os.Stat(filepath.Join(dir, want, name)); err != nil {
+			t.Errorf("%s not moved to %s/", name, want)
+		}
+	}
+}
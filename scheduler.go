@@ -0,0 +1,565 @@
+// scheduler.go - Scheduler unico per le attività periodiche, con lock condiviso e storico esecuzioni
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/laninna/hedgehog-app/logger"
+	"gorm.io/gorm"
+)
+
+// Stati di un'attività pianificata
+const (
+	ScheduledJobIdle      = "idle"
+	ScheduledJobRunning   = "running"
+	ScheduledJobSucceeded = "succeeded"
+	ScheduledJobFailed    = "failed"
+)
+
+// Origine di un'esecuzione
+const (
+	ScheduledTriggerSchedule = "schedule"
+	ScheduledTriggerManual   = "manual"
+)
+
+const (
+	scheduledJobTimeout     = 15 * time.Minute
+	scheduledRunRetention   = 14 * 24 * time.Hour
+	schedulerDefaultTick    = 15 * time.Second
+	notificationCheckMinute = 30
+)
+
+var (
+	errScheduledJobUnknown = errors.New("scheduled job not registered on this instance")
+	errScheduledJobBusy    = errors.New("scheduled job is already running")
+)
+
+// ScheduledTask è un'attività periodica registrata nel codice
+type ScheduledTask struct {
+	Name        string
+	Description string
+	Cron        string
+	// RunAtStartup anticipa la prima esecuzione all'avvio dell'istanza
+	RunAtStartup bool
+	// Timeout è la durata del lock, rinnovato durante l'esecuzione: se l'istanza si ferma,
+	// scaduto il lock un'altra istanza può riprendere l'attività
+	Timeout time.Duration
+	Run     func(db *gorm.DB, now time.Time) error
+}
+
+var (
+	scheduledTasksMu sync.RWMutex
+	scheduledTasks   = map[string]ScheduledTask{}
+)
+
+// registerScheduledTask aggiunge o sostituisce un'attività periodica.
+// Un'espressione cron non valida (spesso da una variabile d'ambiente) non registra nulla.
+func registerScheduledTask(task ScheduledTask) error {
+	if _, err := parseCron(task.Cron); err != nil {
+		return fmt.Errorf("scheduled task %s: %v", task.Name, err)
+	}
+	if task.Timeout == 0 {
+		task.Timeout = scheduledJobTimeout
+	}
+	scheduledTasksMu.Lock()
+	scheduledTasks[task.Name] = task
+	scheduledTasksMu.Unlock()
+	return nil
+}
+
+// tryRegisterScheduledTask registra l'attività o segnala nel log che è stata saltata
+func tryRegisterScheduledTask(task ScheduledTask) bool {
+	if err := registerScheduledTask(task); err != nil {
+		logger.Error("Attività pianificata non registrata", err,
+			logger.Str("component", "scheduler"),
+			logger.Str("job", task.Name),
+			logger.Str("cron", task.Cron))
+		return false
+	}
+	return true
+}
+
+func lookupScheduledTask(name string) (ScheduledTask, bool) {
+	scheduledTasksMu.RLock()
+	defer scheduledTasksMu.RUnlock()
+	task, ok := scheduledTasks[name]
+	return task, ok
+}
+
+// registeredScheduledTasks restituisce le attività ordinate per nome
+func registeredScheduledTasks() []ScheduledTask {
+	scheduledTasksMu.RLock()
+	defer scheduledTasksMu.RUnlock()
+	tasks := make([]ScheduledTask, 0, len(scheduledTasks))
+	for _, task := range scheduledTasks {
+		tasks = append(tasks, task)
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].Name < tasks[j].Name })
+	return tasks
+}
+
+// intervalCron converte un intervallo in minuti nell'espressione cron equivalente.
+// Con cron un passo è un intervallo regolare solo se divide l'ora (o il giorno, per le ore):
+// */45 scatterebbe ai minuti 0 e 45, quindi gli altri valori non sono ammessi.
+func intervalCron(minutes int) (string, error) {
+	switch {
+	case minutes <= 0:
+		return "", fmt.Errorf("interval must be positive, got %d", minutes)
+	case minutes < 60 && 60%minutes == 0:
+		return fmt.Sprintf("*/%d * * * *", minutes), nil
+	case minutes%60 == 0 && minutes <= 24*60 && (24*60)%minutes == 0:
+		return fmt.Sprintf("0 */%d * * *", minutes/60), nil
+	}
+	return "", fmt.Errorf("interval of %d minutes does not divide an hour or a day evenly", minutes)
+}
+
+// notificationCheckCron deriva l'espressione del controllo notifiche da NOTIFICATION_INTERVAL_MINUTES
+func notificationCheckCron() string {
+	value := os.Getenv("NOTIFICATION_INTERVAL_MINUTES")
+	if value == "" {
+		cron, _ := intervalCron(notificationCheckMinute)
+		return cron
+	}
+
+	n, err := strconv.Atoi(value)
+	if err == nil {
+		var cron string
+		if cron, err = intervalCron(n); err == nil {
+			return cron
+		}
+	}
+	logger.Warn("NOTIFICATION_INTERVAL_MINUTES non valido, uso il valore predefinito",
+		logger.Str("component", "scheduler"),
+		logger.Str("value", value),
+		logger.Int("default_minutes", notificationCheckMinute))
+	cron, _ := intervalCron(notificationCheckMinute)
+	return cron
+}
+
+// registerDefaultScheduledTasks registra le attività periodiche dell'applicazione
+func registerDefaultScheduledTasks() {
+	tryRegisterScheduledTask(ScheduledTask{
+		Name:         "notification-check",
+		Description:  "Queue the evaluation of all notification rules",
+		Cron:         notificationCheckCron(),
+		RunAtStartup: true,
+		Run: func(db *gorm.DB, now time.Time) error {
+			// Come /notifications/check passa dalla coda, con tentativi e dead letter
+			_, err := enqueueJob(db, JobKindNotificationCheck, NotificationCheckPayload{})
+			return err
+		},
+	})
+	tryRegisterScheduledTask(ScheduledTask{
+		Name:        "notification-escalation",
+		Description: "Escalate unacknowledged notifications to the next channel",
+		Cron:        "* * * * *",
+		Run: func(db *gorm.DB, now time.Time) error {
+			NewNotificationService(db).EscalatePending(now)
+			return nil
+		},
+	})
+	tryRegisterScheduledTask(ScheduledTask{
+		Name:        "notification-digests",
+		Description: "Queue the digest schedules that are due",
+		Cron:        "* * * * *",
+		Run: func(db *gorm.DB, now time.Time) error {
+			RunDueDigests(db, now)
+			return nil
+		},
+	})
+	tryRegisterScheduledTask(ScheduledTask{
+		Name:         "daily-tasks",
+		Description:  "Generate today's care task rota",
+		Cron:         "0 * * * *",
+		RunAtStartup: true,
+		Run: func(db *gorm.DB, now time.Time) error {
+			_, err := NewTaskService(db).GenerateTasks(now)
+			return err
+		},
+	})
+	tryRegisterScheduledTask(ScheduledTask{
+		Name:         "job-queue-maintenance",
+		Description:  "Requeue jobs of dead workers and purge completed jobs",
+		Cron:         "* * * * *",
+		RunAtStartup: true,
+		Run: func(db *gorm.DB, now time.Time) error {
+			NewJobQueue(db).maintain(now)
+			return nil
+		},
+	})
+}
+
+// Scheduler esegue le attività registrate quando sono dovute. Più istanze possono
+// condividere il database: ogni esecuzione è prenotata con un aggiornamento condizionato.
+type Scheduler struct {
+	db         *gorm.DB
+	instanceID string
+	tick       time.Duration
+}
+
+func NewScheduler(db *gorm.DB) *Scheduler {
+	tick := schedulerDefaultTick
+	if seconds, err := strconv.Atoi(os.Getenv("SCHEDULER_TICK_SECONDS")); err == nil && seconds > 0 {
+		tick = time.Duration(seconds) * time.Second
+	}
+
+	hostname, _ := os.Hostname()
+	return &Scheduler{
+		db:         db,
+		instanceID: fmt.Sprintf("%s:%d", hostname, os.Getpid()),
+		tick:       tick,
+	}
+}
+
+// Sync allinea le righe delle attività alle definizioni nel codice
+func (s *Scheduler) Sync(now time.Time) {
+	for _, task := range registeredScheduledTasks() {
+		next, _ := nextCronRun(task.Cron, time.Local.String(), now)
+		if task.RunAtStartup {
+			next = now
+		}
+
+		var job ScheduledJob
+		if err := s.db.Where("name = ?", task.Name).First(&job).Error; err != nil {
+			job = ScheduledJob{Name: task.Name, Description: task.Description, Cron: task.Cron, Status: ScheduledJobIdle, NextRunAt: &next}
+			if err := s.db.Create(&job).Error; err != nil {
+				logger.Error("Errore registrazione attività pianificata", err,
+					logger.Str("component", "scheduler"),
+					logger.Str("job", task.Name))
+			}
+			continue
+		}
+
+		updates := map[string]interface{}{"description": task.Description, "cron": task.Cron}
+		if job.NextRunAt == nil || job.Cron != task.Cron || next.Before(*job.NextRunAt) {
+			updates["next_run_at"] = next
+		}
+		s.db.Model(&job).Updates(updates)
+	}
+}
+
+// claim prenota l'attività per questa istanza. Le esecuzioni pianificate richiedono
+// che l'attività sia dovuta e non sospesa e spostano subito la prossima esecuzione.
+func (s *Scheduler) claim(task ScheduledTask, now time.Time, trigger string) bool {
+	query := s.db.Model(&ScheduledJob{}).
+		Where("name = ? AND (locked_until IS NULL OR locked_until < ?)", task.Name, now)
+
+	updates := map[string]interface{}{
+		"status":       ScheduledJobRunning,
+		"locked_by":    s.instanceID,
+		"locked_until": now.Add(task.Timeout),
+	}
+	if trigger == ScheduledTriggerSchedule {
+		query = query.Where("paused = ? AND next_run_at <= ?", false, now)
+		next, err := nextCronRun(task.Cron, time.Local.String(), now)
+		if err != nil {
+			return false
+		}
+		updates["next_run_at"] = next
+	}
+
+	result := query.Updates(updates)
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+// renewLock prolunga il lock dell'attività; restituisce false se non è più di questa istanza
+func (s *Scheduler) renewLock(task ScheduledTask, now time.Time) bool {
+	result := s.db.Model(&ScheduledJob{}).
+		Where("name = ? AND locked_by = ?", task.Name, s.instanceID).
+		Update("locked_until", now.Add(task.Timeout))
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+// holdLock rinnova il lock finché l'attività è in esecuzione, così un'esecuzione
+// più lunga del Timeout non parte anche su un'altra istanza
+func (s *Scheduler) holdLock(task ScheduledTask) (release func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(task.Timeout / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if !s.renewLock(task, now) {
+					logger.Warn("Lock dell'attività pianificata perso durante l'esecuzione",
+						logger.Str("component", "scheduler"),
+						logger.Str("job", task.Name))
+					return
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// execute esegue l'attività già prenotata e ne registra l'esito
+func (s *Scheduler) execute(task ScheduledTask, trigger string) error {
+	started := time.Now()
+	release := s.holdLock(task)
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		return task.Run(s.db, started)
+	}()
+	release()
+	duration := time.Since(started).Milliseconds()
+
+	run := ScheduledJobRun{
+		JobName:    task.Name,
+		Trigger:    trigger,
+		Instance:   s.instanceID,
+		Status:     ScheduledJobSucceeded,
+		StartedAt:  started,
+		DurationMs: duration,
+	}
+	if err != nil {
+		run.Status = ScheduledJobFailed
+		run.Error = err.Error()
+		logger.Error("Attività pianificata fallita", err,
+			logger.Str("component", "scheduler"),
+			logger.Str("job", task.Name),
+			logger.Str("trigger", trigger))
+	}
+
+	s.db.Model(&ScheduledJob{}).
+		Where("name = ? AND locked_by = ?", task.Name, s.instanceID).
+		Updates(map[string]interface{}{
+			"status":           run.Status,
+			"last_run_at":      started,
+			"last_duration_ms": duration,
+			"last_error":       run.Error,
+			"run_count":        gorm.Expr("run_count + 1"),
+			"locked_by":        "",
+			"locked_until":     nil,
+		})
+	s.db.Create(&run)
+	s.db.Where("job_name = ? AND started_at < ?", task.Name, started.Add(-scheduledRunRetention)).
+		Delete(&ScheduledJobRun{})
+	return err
+}
+
+// RunDue esegue in parallelo le attività dovute e attende che terminino.
+// Restituisce il numero di attività avviate da questa istanza.
+func (s *Scheduler) RunDue(now time.Time) int {
+	var wg sync.WaitGroup
+	started := 0
+	for _, task := range registeredScheduledTasks() {
+		if !s.claim(task, now, ScheduledTriggerSchedule) {
+			continue
+		}
+		started++
+		wg.Add(1)
+		go func(task ScheduledTask) {
+			defer wg.Done()
+			s.execute(task, ScheduledTriggerSchedule)
+		}(task)
+	}
+	wg.Wait()
+	return started
+}
+
+// Trigger prenota un'esecuzione manuale, anche se l'attività è sospesa
+func (s *Scheduler) Trigger(name string) (ScheduledTask, error) {
+	task, ok := lookupScheduledTask(name)
+	if !ok {
+		return task, errScheduledJobUnknown
+	}
+	if !s.claim(task, time.Now(), ScheduledTriggerManual) {
+		return task, errScheduledJobBusy
+	}
+	return task, nil
+}
+
+// Start allinea le attività e controlla periodicamente quelle dovute
+func (s *Scheduler) Start(ctx context.Context) {
+	s.Sync(time.Now())
+
+	go func() {
+		ticker := time.NewTicker(s.tick)
+		defer ticker.Stop()
+		for {
+			go s.RunDue(time.Now())
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	logger.Info("📅 Scheduler started",
+		logger.Str("component", "scheduler"),
+		logger.Int("jobs", len(registeredScheduledTasks())))
+}
+
+// StartScheduler registra le attività predefinite e avvia lo scheduler fino alla cancellazione di ctx
+func StartScheduler(ctx context.Context, db *gorm.DB) {
+	registerDefaultScheduledTasks()
+	NewScheduler(db).Start(ctx)
+}
+
+// @Summary List scheduled jobs
+// @Description List the periodic jobs with their cron expression, status, last and next run
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} ScheduledJob
+// @Failure 401 {object} map[string]string
+// @Router /admin/jobs [get]
+func getScheduledJobsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var jobs []ScheduledJob
+		db.Order("name ASC").Find(&jobs)
+		c.JSON(http.StatusOK, jobs)
+	}
+}
+
+// @Summary List scheduled job runs
+// @Description List the recent runs of a scheduled job, most recent first
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param name path string true "Job name"
+// @Param limit query int false "Limit results" default(50)
+// @Success 200 {array} ScheduledJobRun
+// @Failure 404 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /admin/jobs/{name}/runs [get]
+func getScheduledJobRunsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var job ScheduledJob
+		if err := db.Where("name = ?", c.Param("name")).First(&job).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Scheduled job not found"})
+			return
+		}
+
+		limit := 50
+		if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 {
+			limit = l
+		}
+
+		var runs []ScheduledJobRun
+		db.Where("job_name = ?", job.Name).Order("started_at DESC").Limit(limit).Find(&runs)
+		c.JSON(http.StatusOK, runs)
+	}
+}
+
+// setScheduledJobPaused sospende o riprende un'attività; alla ripresa la prossima
+// esecuzione riparte da adesso, senza recuperare quelle saltate
+func setScheduledJobPaused(db *gorm.DB, name string, paused bool) (ScheduledJob, error) {
+	var job ScheduledJob
+	if err := db.Where("name = ?", name).First(&job).Error; err != nil {
+		return job, err
+	}
+
+	updates := map[string]interface{}{"paused": paused}
+	if !paused {
+		if next, err := nextCronRun(job.Cron, time.Local.String(), time.Now()); err == nil {
+			updates["next_run_at"] = next
+		}
+	}
+	if err := db.Model(&job).Updates(updates).Error; err != nil {
+		return job, err
+	}
+	db.First(&job, job.ID)
+	return job, nil
+}
+
+// respondScheduledJobPaused applica la sospensione e risponde con l'attività aggiornata
+func respondScheduledJobPaused(c *gin.Context, db *gorm.DB, paused bool) {
+	job, err := setScheduledJobPaused(db, c.Param("name"), paused)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scheduled job not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// @Summary Pause scheduled job
+// @Description Suspend the scheduled runs of a job; manual triggers still work
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param name path string true "Job name"
+// @Success 200 {object} ScheduledJob
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /admin/jobs/{name}/pause [post]
+func pauseScheduledJobHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		respondScheduledJobPaused(c, db, true)
+	}
+}
+
+// @Summary Resume scheduled job
+// @Description Resume the scheduled runs of a paused job from its next cron occurrence
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param name path string true "Job name"
+// @Success 200 {object} ScheduledJob
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /admin/jobs/{name}/resume [post]
+func resumeScheduledJobHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		respondScheduledJobPaused(c, db, false)
+	}
+}
+
+// @Summary Trigger scheduled job
+// @Description Run a job now in the background, outside its schedule. Fails if the job is already running on any instance
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param name path string true "Job name"
+// @Success 202 {object} ScheduledJob
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /admin/jobs/{name}/trigger [post]
+func triggerScheduledJobHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scheduler := NewScheduler(db)
+		task, err := scheduler.Trigger(c.Param("name"))
+		switch {
+		case errors.Is(err, errScheduledJobUnknown):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Scheduled job not found"})
+			return
+		case errors.Is(err, errScheduledJobBusy):
+			c.JSON(http.StatusConflict, gin.H{"error": "Scheduled job is already running"})
+			return
+		}
+
+		go scheduler.execute(task, ScheduledTriggerManual)
+
+		var job ScheduledJob
+		db.Where("name = ?", task.Name).First(&job)
+		c.JSON(http.StatusAccepted, job)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// registerTestTask registra un'attività per la durata del test
+func registerTestTask(t *testing.T, task ScheduledTask) {
+	if err := registerScheduledTask(task); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		scheduledTasksMu.Lock()
+		delete(scheduledTasks, task.Name)
+		scheduledTasksMu.Unlock()
+	})
+}
+
+func TestSchedulerRunsDueJobsOnce(t *testing.T) {
+	db := newTestDB(t)
+	var runs int32
+	running := make(chan struct{})
+	release := make(chan struct{})
+	registerTestTask(t, ScheduledTask{Name: "test-single-flight", Cron: "*/5 * * * *", RunAtStartup: true, Run: func(db *gorm.DB, now time.Time) error {
+		atomic.AddInt32(&runs, 1)
+		close(running)
+		<-release
+		return nil
+	}})
+
+	first := NewScheduler(db)
+	second := NewScheduler(db)
+	second.instanceID = "other-instance:1"
+
+	now := time.Now()
+	first.Sync(now)
+
+	done := make(chan int)
+	go func() { done <- first.RunDue(now.Add(time.Second)) }()
+	<-running
+
+	// L'altra istanza non può prenotare un'attività già in esecuzione, nemmeno manualmente
+	if started := second.RunDue(now.Add(time.Second)); started != 0 {
+		t.Errorf("second instance started %d jobs", started)
+	}
+	if _, err := second.Trigger("test-single-flight"); !errors.Is(err, errScheduledJobBusy) {
+		t.Errorf("trigger while running: %v", err)
+	}
+
+	close(release)
+	if started := <-done; started != 1 {
+		t.Fatalf("started = %d, want 1", started)
+	}
+
+	var job ScheduledJob
+	db.Where("name = ?", "test-single-flight").First(&job)
+	if job.Status != ScheduledJobSucceeded || job.LastRunAt == nil || job.RunCount != 1 || job.LockedBy != "" || !job.NextRunAt.After(now) {
+		t.Errorf("job = %+v", job)
+	}
+	if atomic.LoadInt32(&runs) != 1 {
+		t.Errorf("runs = %d", runs)
+	}
+}
+
+func TestSchedulerRenewsLockWhileRunning(t *testing.T) {
+	db := newTestDB(t)
+	running := make(chan struct{})
+	release := make(chan struct{})
+	registerTestTask(t, ScheduledTask{Name: "test-long-run", Cron: "* * * * *", RunAtStartup: true, Timeout: 200 * time.Millisecond,
+		Run: func(db *gorm.DB, now time.Time) error {
+			close(running)
+			<-release
+			return nil
+		}})
+
+	first := NewScheduler(db)
+	second := NewScheduler(db)
+	second.instanceID = "other-instance:1"
+	first.Sync(time.Now())
+
+	done := make(chan int)
+	go func() { done <- first.RunDue(time.Now()) }()
+	<-running
+
+	// Ben oltre il Timeout l'attività è ancora in corso: il lock rinnovato la tiene prenotata
+	time.Sleep(600 * time.Millisecond)
+	if _, err := second.Trigger("test-long-run"); !errors.Is(err, errScheduledJobBusy) {
+		t.Errorf("trigger after the timeout while still running: %v", err)
+	}
+
+	close(release)
+	if started := <-done; started != 1 {
+		t.Fatalf("started = %d, want 1", started)
+	}
+	var job ScheduledJob
+	db.Where("name = ?", "test-long-run").First(&job)
+	if job.LockedBy != "" || job.LockedUntil != nil {
+		t.Errorf("lock not released: %+v", job)
+	}
+}
+
+func TestSchedulerPauseTriggerAndHistory(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := newTestDB(t)
+	registerTestTask(t, ScheduledTask{Name: "test-failing", Cron: "* * * * *", RunAtStartup: true, Run: func(db *gorm.DB, now time.Time) error {
+		return errors.New("database is locked")
+	}})
+
+	scheduler := NewScheduler(db)
+	now := time.Now()
+	scheduler.Sync(now)
+
+	router := gin.New()
+	router.POST("/admin/jobs/:name/pause", pauseScheduledJobHandler(db))
+	router.GET("/admin/jobs/:name/runs", getScheduledJobRunsHandler(db))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/jobs/test-failing/pause", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("pause status = %d: %s", w.Code, w.Body.String())
+	}
+	if started := scheduler.RunDue(now.Add(time.Minute)); started != 0 {
+		t.Fatalf("paused job started")
+	}
+
+	// L'esecuzione manuale è ammessa anche se l'attività è sospesa
+	task, err := scheduler.Trigger("test-failing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheduler.execute(task, ScheduledTriggerManual)
+
+	var job ScheduledJob
+	db.Where("name = ?", "test-failing").First(&job)
+	if job.Status != ScheduledJobFailed || job.LastError != "database is locked" || !job.Paused {
+		t.Errorf("job = %+v", job)
+	}
+
+	var runs []ScheduledJobRun
+	db.Where("job_name = ?", "test-failing").Find(&runs)
+	if len(runs) != 1 || runs[0].Trigger != ScheduledTriggerManual || runs[0].Status != ScheduledJobFailed {
+		t.Errorf("runs = %+v", runs)
+	}
+
+	if _, err := scheduler.Trigger("unknown-job"); !errors.Is(err, errScheduledJobUnknown) {
+		t.Errorf("unknown job: %v", err)
+	}
+}
+
+func TestInvalidCronIsNotRegistered(t *testing.T) {
+	if err := registerScheduledTask(ScheduledTask{Name: "test-invalid", Cron: "*/5 * *"}); err == nil {
+		t.Fatal("invalid cron accepted")
+	}
+	if _, ok := lookupScheduledTask("test-invalid"); ok {
+		t.Error("task with invalid cron was registered")
+	}
+
+	t.Setenv("SCALE_DROP_DIR", t.TempDir())
+	t.Setenv("SCALE_POLL_CRON", "every minute")
+	StartScaleDropFolder(nil)
+	if _, ok := lookupScheduledTask("scale-drop-folder"); ok {
+		t.Error("scale drop folder registered with an invalid SCALE_POLL_CRON")
+	}
+}
+
+func TestNotificationCheckCronInterval(t *testing.T) {
+	cases := map[string]string{
+		"":    "*/30 * * * *",
+		"15":  "*/15 * * * *",
+		"120": "0 */2 * * *",
+		"45":  "*/30 * * * *", // */45 non è un intervallo regolare: si usa il predefinito
+		"90":  "*/30 * * * *",
+		"abc": "*/30 * * * *",
+	}
+	for value, want := range cases {
+		t.Setenv("NOTIFICATION_INTERVAL_MINUTES", value)
+		if got := notificationCheckCron(); got != want {
+			t.Errorf("NOTIFICATION_INTERVAL_MINUTES=%q: cron = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestNotificationCheckTaskQueuesJob(t *testing.T) {
+	db := newTestDB(t)
+	scheduledTasksMu.Lock()
+	previous := scheduledTasks
+	scheduledTasks = make(map[string]ScheduledTask)
+	scheduledTasksMu.Unlock()
+	t.Cleanup(func() {
+		scheduledTasksMu.Lock()
+		scheduledTasks = previous
+		scheduledTasksMu.Unlock()
+	})
+
+	registerDefaultScheduledTasks()
+	task, ok := lookupScheduledTask("notification-check")
+	if !ok {
+		t.Fatal("notification-check not registered")
+	}
+	if err := task.Run(db, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	var jobs []Job
+	db.Find(&jobs)
+	if len(jobs) != 1 || jobs[0].Kind != JobKindNotificationCheck || jobs[0].Status != JobStatusPending {
+		t.Errorf("jobs = %+v, want one queued notification check", jobs)
+	}
+}
@@ -0,0 +1,32 @@
+// seed.go - Dati predefiniti creati una sola volta
+package main
+
+import (
+	"github.com/laninna/hedgehog-app/logger"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// seedOnce crea i dati predefiniti la prima volta che servono. Il marcatore impedisce di
+// ricrearli quando l'utente li elimina tutti; se la tabella ha già dati (installazioni
+// precedenti al marcatore) si registra solo il marcatore.
+func seedOnce(db *gorm.DB, name string, model interface{}, seed func(tx *gorm.DB) error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&SeedMarker{Name: name})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		var count int64
+		if err := tx.Model(model).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+		return seed(tx)
+	})
+	if err != nil {
+		logger.Error("Errore creazione dati predefiniti", err, logger.Str("seed", name))
+	}
+}
@@ -305,6 +305,10 @@ class MobileNavigation {
                         <i class="fas fa-bell text-xl"></i>
                     </a>
                     <div class="border-t border-gray-200 mx-2 lg:mx-4"></div>
+                    <a href="/tasks" onclick="this.href='/tasks'; mobileNav.closeSidebar();" class="sidebar-icon-mobile lg:nav-item-vertical ${currentPath === '/tasks' ? 'active' : ''}" title="Compiti del Giorno">
+                        <i class="fas fa-clipboard-check text-xl"></i>
+                    </a>
+                    <div class="border-t border-gray-200 mx-2 lg:mx-4"></div>
                     <a href="/tutorial" onclick="window.location.href='/docs'; mobileNav.closeSidebar();" class="sidebar-icon-mobile lg:nav-item-vertical ${currentPath === '/docs' ? 'active' : ''}" title="Tutorial">
                         <i class="fas fa-book text-xl"></i>
                     </a>
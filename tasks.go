@@ -0,0 +1,550 @@
+// tasks.go - Turni giornalieri generati dalle necessità di cura
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/laninna/hedgehog-app/logger"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	TaskTypeWeighing   = "weighing"
+	TaskTypeMedication = "medication"
+	TaskTypeFeeding    = "feeding"
+
+	TaskStatusPending = "pending"
+	TaskStatusDone    = "done"
+	TaskStatusSkipped = "skipped"
+)
+
+// errTaskNotPending indica un compito già chiuso, anche da una richiesta concorrente
+var errTaskNotPending = errors.New("task already completed")
+
+// Elenco dei compiti di una stanza
+type RoomTasks struct {
+	RoomID   *uint       `json:"room_id"`
+	RoomName string      `json:"room_name"`
+	Total    int         `json:"total"`
+	Pending  int         `json:"pending"`
+	Tasks    []DailyTask `json:"tasks"`
+}
+
+// Dati inviati al completamento di un compito
+type CompleteTaskRequest struct {
+	Status       string   `json:"status" example:"done" enums:"done,skipped"`
+	Weight       float64  `json:"weight" example:"450.5"`
+	OfferedGrams *float64 `json:"offered_grams" example:"30"`
+	EatenGrams   *float64 `json:"eaten_grams" example:"25"`
+	Notes        string   `json:"notes" example:"Tutto ok"`
+}
+
+// TaskService - Generazione e completamento dei compiti giornalieri
+type TaskService struct {
+	db *gorm.DB
+}
+
+func NewTaskService(db *gorm.DB) *TaskService {
+	return &TaskService{db: db}
+}
+
+// GenerateTasks crea i compiti del giorno indicato; l'operazione è idempotente
+func (ts *TaskService) GenerateTasks(day time.Time) (int, error) {
+	day = startOfDay(day)
+	dayEnd := day.AddDate(0, 0, 1)
+	dateKey := day.Format("2006-01-02")
+
+	var hedgehogs []Hedgehog
+	if err := ts.db.Preload("Area").Where("status = 'in_care'").Find(&hedgehogs).Error; err != nil {
+		return 0, err
+	}
+
+	byID := make(map[uint]Hedgehog, len(hedgehogs))
+	for _, h := range hedgehogs {
+		byID[h.ID] = h
+	}
+
+	newTask := func(h Hedgehog, taskType string, seq int) DailyTask {
+		task := DailyTask{
+			Key:          fmt.Sprintf("%s:%s:%d:%d", dateKey, taskType, h.ID, seq),
+			Date:         day,
+			Type:         taskType,
+			HedgehogID:   h.ID,
+			HedgehogName: h.Name,
+			AreaID:       h.AreaID,
+			Sequence:     seq,
+			Status:       TaskStatusPending,
+		}
+		if h.Area != nil {
+			task.RoomID = &h.Area.RoomID
+		}
+		return task
+	}
+
+	var tasks []DailyTask
+
+	// Pesature mancanti
+	ns := NewNotificationService(ts.db)
+	for _, m := range ns.findMissingWeighings() {
+		h, ok := byID[m.Hedgehog.ID]
+		if !ok {
+			continue
+		}
+		task := newTask(h, TaskTypeWeighing, 1)
+		task.Title = "Pesatura"
+		task.Details = fmt.Sprintf("Non pesato da %d giorni", m.DaysSince)
+		tasks = append(tasks, task)
+	}
+
+	// Dosi delle terapie attive
+	var therapies []Therapy
+	ts.db.Where("status = 'active' AND start_date < ? AND (end_date IS NULL OR end_date >= ?)", dayEnd, day).
+		Find(&therapies)
+	for _, therapy := range therapies {
+		h, ok := byID[therapy.HedgehogID]
+		if !ok {
+			continue
+		}
+		for dose := 1; dose <= therapy.DosesPerDay; dose++ {
+			task := newTask(h, TaskTypeMedication, dose)
+			task.Key = fmt.Sprintf("%s:%s:%d:%d:%d", dateKey, TaskTypeMedication, h.ID, therapy.ID, dose)
+			task.TherapyID = &therapy.ID
+			task.Title = fmt.Sprintf("%s (dose %d/%d)", therapy.Name, dose, therapy.DosesPerDay)
+			task.Details = therapy.Dosage
+			tasks = append(tasks, task)
+		}
+	}
+
+	// Pasti dei piani alimentari
+	var plans []FeedingPlan
+	ts.db.Where("active = ? AND start_date < ? AND (end_date IS NULL OR end_date >= ?)", true, dayEnd, day).
+		Find(&plans)
+	for _, plan := range plans {
+		h, ok := byID[plan.HedgehogID]
+		if !ok {
+			continue
+		}
+		for meal := 1; meal <= plan.TimesPerDay; meal++ {
+			task := newTask(h, TaskTypeFeeding, meal)
+			task.Key = fmt.Sprintf("%s:%s:%d:%d:%d", dateKey, TaskTypeFeeding, h.ID, plan.ID, meal)
+			task.FeedingPlanID = &plan.ID
+			task.Title = fmt.Sprintf("%s (pasto %d/%d)", plan.FoodType, meal, plan.TimesPerDay)
+			task.Details = fmt.Sprintf("%.0fg", plan.Grams)
+			tasks = append(tasks, task)
+		}
+	}
+
+	if len(tasks) == 0 {
+		return 0, nil
+	}
+
+	result := ts.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoNothing: true,
+	}).Create(&tasks)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	return int(result.RowsAffected), nil
+}
+
+// CompleteTask chiude un compito e registra il dato corrispondente; il compito è prenotato
+// nella transazione, così una doppia richiesta non crea due volte il dato
+func (ts *TaskService) CompleteTask(task *DailyTask, req CompleteTaskRequest, userID *uint) error {
+	status := req.Status
+	if status == "" {
+		status = TaskStatusDone
+	}
+	if status != TaskStatusDone && status != TaskStatusSkipped {
+		return fmt.Errorf("invalid status: %s", status)
+	}
+
+	now := time.Now()
+
+	return ts.db.Transaction(func(tx *gorm.DB) error {
+		claim := tx.Model(&DailyTask{}).
+			Where("id = ? AND status = ?", task.ID, TaskStatusPending).
+			Update("status", status)
+		if claim.Error != nil {
+			return claim.Error
+		}
+		if claim.RowsAffected == 0 {
+			return errTaskNotPending
+		}
+
+		if status == TaskStatusDone {
+			recordID, err := ts.createTaskRecord(tx, task, req, userID, now)
+			if err != nil {
+				return err
+			}
+			task.RecordID = recordID
+			enqueueAutoResolve(tx, task.HedgehogID)
+		}
+
+		task.Status = status
+		task.CompletedAt = &now
+		task.CompletedByID = userID
+		task.Notes = req.Notes
+		return tx.Save(task).Error
+	})
+}
+
+func (ts *TaskService) createTaskRecord(tx *gorm.DB, task *DailyTask, req CompleteTaskRequest, userID *uint, now time.Time) (*uint, error) {
+	switch task.Type {
+	case TaskTypeWeighing:
+		if req.Weight <= 0 {
+			return nil, fmt.Errorf("weight is required to complete a weighing task")
+		}
+		record := WeightRecord{HedgehogID: task.HedgehogID, Weight: req.Weight, Date: now, Notes: req.Notes}
+		// Senza conferma interattiva una pesata implausibile resta esclusa fino alla revisione
+		check := NewNotificationService(tx).detectWeightOutlier(record)
+		applyOutlierCheck(&record, check)
+		record.Excluded = check.Flagged
+		annotateWeightRecord(tx, &record)
+		if err := tx.Create(&record).Error; err != nil {
+			return nil, err
+		}
+		publishDomainEvent(tx, DomainEventWeightRecorded, newWeightEvent(record))
+		return &record.ID, nil
+
+	case TaskTypeMedication:
+		if task.TherapyID == nil {
+			return nil, nil
+		}
+		dose := TherapyDose{TherapyID: *task.TherapyID, HedgehogID: task.HedgehogID, AdministeredAt: now, UserID: userID, Notes: req.Notes}
+		if err := tx.Create(&dose).Error; err != nil {
+			return nil, err
+		}
+		return &dose.ID, nil
+
+	case TaskTypeFeeding:
+		intake := FoodIntake{HedgehogID: task.HedgehogID, FeedingPlanID: task.FeedingPlanID, Date: now, Notes: req.Notes}
+		if task.FeedingPlanID != nil {
+			var plan FeedingPlan
+			if err := tx.First(&plan, *task.FeedingPlanID).Error; err == nil {
+				intake.FoodType = plan.FoodType
+				intake.OfferedGrams = plan.Grams
+			}
+		}
+		if req.OfferedGrams != nil {
+			intake.OfferedGrams = *req.OfferedGrams
+		}
+		// Senza indicazione si assume che abbia mangiato tutto
+		intake.EatenGrams = intake.OfferedGrams
+		if req.EatenGrams != nil {
+			intake.EatenGrams = *req.EatenGrams
+		}
+		if err := tx.Create(&intake).Error; err != nil {
+			return nil, err
+		}
+		return &intake.ID, nil
+	}
+
+	return nil, nil
+}
+
+// currentUserID restituisce l'ID dell'utente autenticato, se presente
+func currentUserID(c *gin.Context) *uint {
+	if value, exists := c.Get("userID"); exists {
+		if id, ok := value.(uint); ok {
+			return &id
+		}
+	}
+	return nil
+}
+
+// parseTaskDate legge il parametro date (YYYY-MM-DD), di default oggi
+func parseTaskDate(c *gin.Context) (time.Time, error) {
+	if date := c.Query("date"); date != "" {
+		return time.ParseInLocation("2006-01-02", date, time.Local)
+	}
+	return startOfDay(time.Now()), nil
+}
+
+// @Summary Get daily tasks
+// @Description Get the daily task rota grouped by room. Today's tasks are generated hourly by the scheduler or on demand with /tasks/generate
+// @Tags Tasks
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param date query string false "Day (YYYY-MM-DD), defaults to today"
+// @Param room_id query int false "Filter by room ID"
+// @Param assigned_user_id query int false "Filter by assigned user ID"
+// @Param mine query boolean false "Only tasks assigned to the current user"
+// @Param status query string false "Filter by status"
+// @Success 200 {array} RoomTasks
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /tasks [get]
+func getDailyTasksHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		day, err := parseTaskDate(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date, expected YYYY-MM-DD"})
+			return
+		}
+
+		query := db.Where("date = ?", day)
+		if roomID := c.Query("room_id"); roomID != "" {
+			query = query.Where("room_id = ?", roomID)
+		}
+		if assigned := c.Query("assigned_user_id"); assigned != "" {
+			query = query.Where("assigned_user_id = ?", assigned)
+		}
+		if c.Query("mine") == "true" {
+			if userID := currentUserID(c); userID != nil {
+				query = query.Where("assigned_user_id = ?", *userID)
+			}
+		}
+		if status := c.Query("status"); status != "" {
+			query = query.Where("status = ?", status)
+		}
+
+		var tasks []DailyTask
+		query.Order("type, hedgehog_name, sequence").Find(&tasks)
+
+		c.JSON(http.StatusOK, groupTasksByRoom(db, tasks))
+	}
+}
+
+func groupTasksByRoom(db *gorm.DB, tasks []DailyTask) []RoomTasks {
+	var rooms []Room
+	db.Find(&rooms)
+	roomNames := make(map[uint]string, len(rooms))
+	for _, r := range rooms {
+		roomNames[r.ID] = r.Name
+	}
+
+	groups := make(map[uint]*RoomTasks)
+	var order []uint
+	for _, task := range tasks {
+		var key uint
+		if task.RoomID != nil {
+			key = *task.RoomID
+		}
+		group, ok := groups[key]
+		if !ok {
+			group = &RoomTasks{RoomID: task.RoomID, RoomName: "Senza stanza", Tasks: []DailyTask{}}
+			if name, found := roomNames[key]; found {
+				group.RoomName = name
+			}
+			groups[key] = group
+			order = append(order, key)
+		}
+		group.Tasks = append(group.Tasks, task)
+		group.Total++
+		if task.Status == TaskStatusPending {
+			group.Pending++
+		}
+	}
+
+	sort.Slice(order, func(i, j int) bool { return groups[order[i]].RoomName < groups[order[j]].RoomName })
+
+	result := make([]RoomTasks, 0, len(order))
+	for _, key := range order {
+		result = append(result, *groups[key])
+	}
+	return result
+}
+
+// @Summary Generate daily tasks
+// @Description Generate today's task rota from missing weighings, therapy doses and feeding plans. Other days cannot be generated: missing weighings are only known for today
+// @Tags Tasks
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{}
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /tasks/generate [post]
+func generateDailyTasksHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		day := startOfDay(time.Now())
+		created, err := NewTaskService(db).GenerateTasks(day)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"date": day.Format("2006-01-02"), "created": created})
+	}
+}
+
+// @Summary Assign task
+// @Description Assign a daily task to a user (null user_id removes the assignment)
+// @Tags Tasks
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Task ID"
+// @Param assignment body object true "Assignment, e.g. {\"user_id\": 1}"
+// @Success 200 {object} DailyTask
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /tasks/{id}/assign [put]
+func assignDailyTaskHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request struct {
+			UserID *uint `json:"user_id"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var task DailyTask
+		if err := db.First(&task, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+			return
+		}
+
+		if request.UserID != nil {
+			var user User
+			if err := db.First(&user, *request.UserID).Error; err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "User not found"})
+				return
+			}
+		}
+
+		task.AssignedUserID = request.UserID
+		if err := db.Save(&task).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, task)
+	}
+}
+
+// @Summary Complete task
+// @Description Mark a daily task as done or skipped; done tasks create the related weight record, therapy dose or food intake
+// @Tags Tasks
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Task ID"
+// @Param completion body CompleteTaskRequest true "Completion data"
+// @Success 200 {object} DailyTask
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /tasks/{id}/complete [post]
+func completeDailyTaskHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		log := logger.GetLoggerFromContext(c)
+
+		var request CompleteTaskRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var task DailyTask
+		if err := db.First(&task, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+			return
+		}
+
+		if err := NewTaskService(db).CompleteTask(&task, request, currentUserID(c)); err != nil {
+			if errors.Is(err, errTaskNotPending) {
+				c.JSON(http.StatusConflict, gin.H{"error": "Task already completed"})
+				return
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		log.Info().
+			Uint("task_id", task.ID).
+			Str("type", task.Type).
+			Str("status", task.Status).
+			Uint("hedgehog_id", task.HedgehogID).
+			Msg("Daily task completed")
+
+		c.JSON(http.StatusOK, task)
+	}
+}
+
+// @Summary Reopen task
+// @Description Reset a skipped task, or a completed one that created no record, to pending. Tasks linked to a weight record, therapy dose or food intake cannot be reopened: correct or delete the record instead
+// @Tags Tasks
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Task ID"
+// @Success 200 {object} DailyTask
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /tasks/{id}/reopen [post]
+func reopenDailyTaskHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var task DailyTask
+		if err := db.First(&task, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Task not found"})
+			return
+		}
+
+		// Completandolo di nuovo si creerebbe un secondo dato per lo stesso compito
+		if task.RecordID != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "Task has a linked record: correct or delete the record instead"})
+			return
+		}
+
+		task.Status = TaskStatusPending
+		task.CompletedAt = nil
+		task.CompletedByID = nil
+		if err := db.Save(&task).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, task)
+	}
+}
+
+// @Summary Get therapy doses
+// @Description Get administered therapy doses with optional filters
+// @Tags Therapies
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param therapy_id query int false "Filter by therapy ID"
+// @Param hedgehog_id query int false "Filter by hedgehog ID"
+// @Success 200 {array} TherapyDose
+// @Failure 401 {object} map[string]string
+// @Router /therapy-doses [get]
+func getTherapyDoses(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var doses []TherapyDose
+		query := db.Order("administered_at DESC")
+
+		if therapyID := c.Query("therapy_id"); therapyID != "" {
+			query = query.Where("therapy_id = ?", therapyID)
+		}
+		if hedgehogID := c.Query("hedgehog_id"); hedgehogID != "" {
+			query = query.Where("hedgehog_id = ?", hedgehogID)
+		}
+
+		query.Find(&doses)
+		c.JSON(http.StatusOK, doses)
+	}
+}
+
+// Handler per pagina compiti giornalieri
+func tasksPageHandler(c *gin.Context) {
+	c.HTML(http.StatusOK, "tasks.html", gin.H{
+		"title": "Compiti del Giorno - La Ninna",
+	})
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGenerateTasksIsIdempotent(t *testing.T) {
+	db := newTestDB(t)
+	room := Room{Name: "Cucciolaia"}
+	db.Create(&room)
+	area := Area{Name: "Box 1", RoomID: room.ID}
+	db.Create(&area)
+	hedgehog := Hedgehog{Name: "Spillo", Status: "in_care", ArrivalDate: time.Now().AddDate(0, 0, -10), AreaID: &area.ID}
+	db.Create(&hedgehog)
+	db.Create(&Therapy{HedgehogID: hedgehog.ID, Name: "Antibiotico", StartDate: time.Now().AddDate(0, 0, -1), DosesPerDay: 2, Status: "active"})
+	db.Create(&FeedingPlan{HedgehogID: hedgehog.ID, FoodType: "Crocchette", Grams: 30, TimesPerDay: 2, StartDate: time.Now().AddDate(0, 0, -10), Active: true})
+
+	ts := NewTaskService(db)
+	created, err := ts.GenerateTasks(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Pesatura mancante, due dosi e due pasti
+	if created != 5 {
+		t.Fatalf("created = %d, want 5", created)
+	}
+
+	// Una seconda generazione nello stesso giorno non duplica i compiti
+	if created, err := ts.GenerateTasks(time.Now().Add(time.Minute)); err != nil || created != 0 {
+		t.Fatalf("second run created %d (%v), want 0", created, err)
+	}
+	var tasks []DailyTask
+	db.Where("date = ?", startOfDay(time.Now())).Find(&tasks)
+	if len(tasks) != 5 {
+		t.Fatalf("tasks = %d, want 5", len(tasks))
+	}
+	for _, task := range tasks {
+		if task.RoomID == nil || *task.RoomID != room.ID {
+			t.Errorf("task %q without the hedgehog room", task.Key)
+		}
+	}
+}
+
+func TestCompleteTaskCreatesLinkedRecord(t *testing.T) {
+	db := newTestDB(t)
+	hedgehog := Hedgehog{Name: "Spillo", Status: "in_care"}
+	db.Create(&hedgehog)
+	therapy := Therapy{HedgehogID: hedgehog.ID, Name: "Antibiotico", DosesPerDay: 1, Status: "active"}
+	db.Create(&therapy)
+	plan := FeedingPlan{HedgehogID: hedgehog.ID, FoodType: "Crocchette", Grams: 30, TimesPerDay: 1, Active: true}
+	db.Create(&plan)
+
+	ts := NewTaskService(db)
+	eaten := 20.0
+	tests := []struct {
+		task  DailyTask
+		req   CompleteTaskRequest
+		model interface{}
+	}{
+		{DailyTask{Key: "w", Type: TaskTypeWeighing, HedgehogID: hedgehog.ID}, CompleteTaskRequest{Weight: 450}, &WeightRecord{}},
+		{DailyTask{Key: "m", Type: TaskTypeMedication, HedgehogID: hedgehog.ID, TherapyID: &therapy.ID}, CompleteTaskRequest{}, &TherapyDose{}},
+		{DailyTask{Key: "f", Type: TaskTypeFeeding, HedgehogID: hedgehog.ID, FeedingPlanID: &plan.ID}, CompleteTaskRequest{EatenGrams: &eaten}, &FoodIntake{}},
+	}
+
+	for _, tt := range tests {
+		task := tt.task
+		db.Create(&task)
+		if err := ts.CompleteTask(&task, tt.req, nil); err != nil {
+			t.Fatalf("%s: %v", task.Type, err)
+		}
+		if task.Status != TaskStatusDone || task.RecordID == nil {
+			t.Fatalf("%s: task = %+v, want done with a linked record", task.Type, task)
+		}
+		if err := db.First(tt.model, *task.RecordID).Error; err != nil {
+			t.Errorf("%s: linked record not found: %v", task.Type, err)
+		}
+	}
+
+	var intake FoodIntake
+	db.First(&intake)
+	if intake.OfferedGrams != 30 || intake.EatenGrams != 20 || intake.FoodType != "Crocchette" {
+		t.Errorf("intake = %+v, want offered from the plan and eaten from the request", intake)
+	}
+
+	// Un compito saltato non crea dati, una pesata senza peso non chiude il compito
+	skipped := DailyTask{Key: "s", Type: TaskTypeWeighing, HedgehogID: hedgehog.ID}
+	db.Create(&skipped)
+	if err := ts.CompleteTask(&skipped, CompleteTaskRequest{Status: TaskStatusSkipped}, nil); err != nil || skipped.RecordID != nil {
+		t.Errorf("skipped task = %+v (%v)", skipped, err)
+	}
+	missing := DailyTask{Key: "x", Type: TaskTypeWeighing, HedgehogID: hedgehog.ID}
+	db.Create(&missing)
+	if err := ts.CompleteTask(&missing, CompleteTaskRequest{}, nil); err == nil {
+		t.Error("weighing task completed without a weight")
+	}
+}
+
+func TestCompleteTaskTwiceCreatesOneRecord(t *testing.T) {
+	db := newTestDB(t)
+	hedgehog := Hedgehog{Name: "Spillo", Status: "in_care"}
+	db.Create(&hedgehog)
+	task := DailyTask{Key: "w", Type: TaskTypeWeighing, HedgehogID: hedgehog.ID}
+	db.Create(&task)
+
+	// Due richieste lette prima che una delle due chiuda il compito
+	first, second := task, task
+	ts := NewTaskService(db)
+	if err := ts.CompleteTask(&first, CompleteTaskRequest{Weight: 450}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.CompleteTask(&second, CompleteTaskRequest{Weight: 450}, nil); !errors.Is(err, errTaskNotPending) {
+		t.Errorf("second completion: %v, want errTaskNotPending", err)
+	}
+
+	var count int64
+	db.Model(&WeightRecord{}).Count(&count)
+	if count != 1 {
+		t.Errorf("weight records = %d, want 1", count)
+	}
+}
+
+func TestReopenTaskRefusesLinkedRecords(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := newTestDB(t)
+	hedgehog := Hedgehog{Name: "Spillo", Status: "in_care"}
+	db.Create(&hedgehog)
+	ts := NewTaskService(db)
+	done := DailyTask{Key: "w", Type: TaskTypeWeighing, HedgehogID: hedgehog.ID}
+	db.Create(&done)
+	ts.CompleteTask(&done, CompleteTaskRequest{Weight: 450}, nil)
+	skipped := DailyTask{Key: "s", Type: TaskTypeWeighing, HedgehogID: hedgehog.ID}
+	db.Create(&skipped)
+	ts.CompleteTask(&skipped, CompleteTaskRequest{Status: TaskStatusSkipped}, nil)
+
+	router := gin.New()
+	router.POST("/tasks/:id/reopen", reopenDailyTaskHandler(db))
+	reopen := func(id uint) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/tasks/%d/reopen", id), nil))
+		return w.Code
+	}
+
+	if code := reopen(done.ID); code != http.StatusConflict {
+		t.Errorf("task with a weight record: status = %d, want 409", code)
+	}
+	if code := reopen(skipped.ID); code != http.StatusOK {
+		t.Errorf("skipped task: status = %d, want 200", code)
+	}
+	var reopened DailyTask
+	db.First(&reopened, skipped.ID)
+	if reopened.Status != TaskStatusPending || reopened.CompletedAt != nil {
+		t.Errorf("reopened task = %+v", reopened)
+	}
+}
+
+func TestGetDailyTasksDoesNotGenerate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := newTestDB(t)
+	db.Create(&Hedgehog{Name: "Spillo", Status: "in_care", ArrivalDate: time.Now().AddDate(0, 0, -10)})
+
+	router := gin.New()
+	router.GET("/tasks", getDailyTasksHandler(db))
+	for _, query := range []string{"", "?date=2099-01-01"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/tasks"+query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /tasks%s: status = %d", query, w.Code)
+		}
+	}
+
+	var count int64
+	db.Model(&DailyTask{}).Count(&count)
+	if count != 0 {
+		t.Errorf("tasks = %d, want none created by GET", count)
+	}
+}
@@ -0,0 +1,548 @@
+// webhooks.go - Sottoscrizioni webhook firmate e registro consegne
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Header con firma HMAC-SHA256 delle consegne: "t=<unix>,v1=<hex>"
+const WebhookSignatureHeader = "X-La-Ninna-Signature"
+
+// Tolleranza consigliata ai destinatari per rifiutare consegne ripetute
+const WebhookSignatureTolerance = 5 * time.Minute
+
+// Evento inviato per le nuove notifiche
+const WebhookEventNotificationCreated = "notification.created"
+
+const JobKindWebhookDelivery = "webhooks.delivery"
+
+// Formati di payload supportati: JSON nativo e piattaforme di chat
+var webhookFormats = []string{"json", "slack", "discord", "telegram", "matrix"}
+
+// Byte della risposta conservati nel registro consegne
+const webhookResponseLimit = 1024
+
+// WebhookDeliveryPayload è il payload del lavoro di consegna
+type WebhookDeliveryPayload struct {
+	SubscriptionID uint            `json:"subscription_id"`
+	NotificationID uint            `json:"notification_id,omitempty"`
+	Event          string          `json:"event,omitempty"`
+	Body           json.RawMessage `json:"body,omitempty"`
+}
+
+func init() {
+	registerJobHandler(JobKindWebhookDelivery, runWebhookDeliveryJob)
+}
+
+// generateWebhookSecret crea un segreto casuale per una nuova sottoscrizione
+func generateWebhookSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(buf), nil
+}
+
+// signWebhookPayload firma "<timestamp>.<body>" con il segreto della sottoscrizione
+func signWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// verifyWebhookSignature controlla firma e timestamp di una consegna, come deve fare il destinatario
+func verifyWebhookSignature(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var timestamp int64
+	var signature string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp, _ = strconv.ParseInt(value, 10, 64)
+		case "v1":
+			signature = value
+		}
+	}
+	if timestamp == 0 || signature == "" {
+		return errors.New("invalid signature header")
+	}
+
+	if age := now.Sub(time.Unix(timestamp, 0)); age > tolerance || age < -tolerance {
+		return errors.New("signature timestamp outside tolerance")
+	}
+
+	expected := signWebhookPayload(secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(fmt.Sprintf("t=%d,v1=%s", timestamp, signature))) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// subscribedToEvent indica se la sottoscrizione riceve l'evento; senza eventi indicati riceve solo le notifiche
+func subscribedToEvent(subscription WebhookSubscription, eventType string) bool {
+	events := splitList(subscription.Events)
+	if len(events) == 0 {
+		return eventType == WebhookEventNotificationCreated
+	}
+	return containsString(events, eventType)
+}
+
+// webhookMatches indica se la notifica rientra nei filtri della sottoscrizione
+func webhookMatches(subscription WebhookSubscription, notification Notification) bool {
+	if !subscribedToEvent(subscription, WebhookEventNotificationCreated) {
+		return false
+	}
+	if types := splitList(subscription.Types); len(types) > 0 && !containsString(types, string(notification.Type)) {
+		return false
+	}
+	if priorities := splitList(subscription.Priorities); len(priorities) > 0 && !containsString(priorities, string(notification.Priority)) {
+		return false
+	}
+	return true
+}
+
+// enqueueWebhookDeliveries accoda una consegna per ogni sottoscrizione interessata
+// e restituisce il numero di consegne accodate
+func (ns *NotificationService) enqueueWebhookDeliveries(notification Notification) int {
+	var subscriptions []WebhookSubscription
+	ns.db.Where("enabled = ?", true).Find(&subscriptions)
+
+	now := time.Now()
+	queued := 0
+	for _, subscription := range subscriptions {
+		if !webhookMatches(subscription, notification) {
+			continue
+		}
+		if _, err := enqueueJobAt(ns.db, JobKindWebhookDelivery, WebhookDeliveryPayload{
+			SubscriptionID: subscription.ID,
+			NotificationID: notification.ID,
+		}, holdUntil(ns.db, notification, QuietChannelWebhook, subscription.URL, now)); err == nil {
+			queued++
+		}
+	}
+	return queued
+}
+
+// buildWebhookBody prepara il corpo della consegna nel formato della sottoscrizione
+func buildWebhookBody(subscription WebhookSubscription, notification Notification) ([]byte, error) {
+	if subscription.Format == "" || subscription.Format == "json" {
+		return json.Marshal(newWebhookPayload(notification))
+	}
+	if formatter, ok := chatFormatters[subscription.Format]; ok {
+		return renderChatMessage(formatter, subscription, notification)
+	}
+	return nil, fmt.Errorf("formato webhook non supportato: %s", subscription.Format)
+}
+
+// maskWebhookURL nasconde percorso e query dell'URL: per Slack, Discord e simili contengono il token di accesso
+func maskWebhookURL(raw string) string {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Host == "" {
+		return "***"
+	}
+	masked := parsed.Scheme + "://" + parsed.Host
+	if strings.Trim(parsed.Path, "/") != "" || parsed.RawQuery != "" {
+		masked += "/***"
+	}
+	return masked
+}
+
+// redactWebhookSubscription prepara la sottoscrizione per le risposte: senza segreti e con l'URL mascherato
+func redactWebhookSubscription(subscription *WebhookSubscription) {
+	subscription.Secret = ""
+	subscription.AccessToken = ""
+	subscription.URL = maskWebhookURL(subscription.URL)
+}
+
+// Deliver invia alla sottoscrizione il corpo della consegna, firmato, e la registra
+func (ws *WebhookService) Deliver(db *gorm.DB, subscription WebhookSubscription, delivery WebhookDelivery) (WebhookDelivery, error) {
+	delivery.SubscriptionID = subscription.ID
+	delivery.URL = maskWebhookURL(subscription.URL)
+
+	start := time.Now()
+	err := ws.postSigned(subscription, delivery.Event, []byte(delivery.RequestBody), &delivery)
+	delivery.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+	delivery.Success = err == nil
+
+	db.Create(&delivery)
+	return delivery, err
+}
+
+func (ws *WebhookService) postSigned(subscription WebhookSubscription, event string, body []byte, delivery *WebhookDelivery) error {
+	var req *http.Request
+	var err error
+	if formatter, ok := chatFormatters[subscription.Format]; ok && formatter.newRequest != nil {
+		req, err = formatter.newRequest(subscription, *delivery, body)
+	} else {
+		req, err = http.NewRequest("POST", subscription.URL, bytes.NewReader(body))
+	}
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "La-Ninna-Hedgehog-App/1.0")
+	req.Header.Set("X-La-Ninna-Source", "notification-system")
+	req.Header.Set("X-La-Ninna-Event", event)
+	if subscription.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, signWebhookPayload(subscription.Secret, time.Now().Unix(), body))
+	}
+
+	resp, err := ws.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	response, _ := io.ReadAll(io.LimitReader(resp.Body, webhookResponseLimit))
+	delivery.StatusCode = resp.StatusCode
+	delivery.ResponseBody = string(response)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func runWebhookDeliveryJob(db *gorm.DB, payload []byte) error {
+	var request WebhookDeliveryPayload
+	if err := json.Unmarshal(payload, &request); err != nil {
+		return err
+	}
+
+	var subscription WebhookSubscription
+	err := db.First(&subscription, request.SubscriptionID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Sottoscrizione eliminata nel frattempo
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !subscription.Enabled {
+		return nil
+	}
+
+	// Evento di dominio: il corpo è già pronto
+	if len(request.Body) > 0 {
+		_, err := NewWebhookService().Deliver(db, subscription, WebhookDelivery{
+			Event:       request.Event,
+			RequestBody: string(request.Body),
+		})
+		return err
+	}
+
+	var notification Notification
+	err = db.Preload("Hedgehog").First(&notification, request.NotificationID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Notifica eliminata nel frattempo
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !sendStillNeeded(notification, QuietChannelWebhook, time.Now()) {
+		return nil
+	}
+
+	body, err := buildWebhookBody(subscription, notification)
+	if err != nil {
+		return err
+	}
+
+	_, err = NewWebhookService().Deliver(db, subscription, WebhookDelivery{
+		NotificationID: &notification.ID,
+		Event:          WebhookEventNotificationCreated,
+		RequestBody:    string(body),
+	})
+	return err
+}
+
+func validateWebhookSubscription(subscription *WebhookSubscription) error {
+	if subscription.Name == "" {
+		return errors.New("name is required")
+	}
+	parsed, err := url.Parse(subscription.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.New("url must be an absolute http(s) URL")
+	}
+	if subscription.Format == "" {
+		subscription.Format = "json"
+	}
+	if !containsString(webhookFormats, subscription.Format) {
+		return fmt.Errorf("format must be one of %s", strings.Join(webhookFormats, ", "))
+	}
+	if formatter, ok := chatFormatters[subscription.Format]; ok && formatter.validate != nil {
+		if err := formatter.validate(*subscription); err != nil {
+			return err
+		}
+	}
+	for _, event := range splitList(subscription.Events) {
+		if domainEventVersion(event) == 0 {
+			return fmt.Errorf("unknown event: %s", event)
+		}
+		// I formati chat sanno rappresentare solo le notifiche
+		if event != WebhookEventNotificationCreated && subscription.Format != "json" {
+			return errors.New("domain events require the json format")
+		}
+	}
+	for _, priority := range splitList(subscription.Priorities) {
+		if !validNotificationPriority(NotificationPriority(priority)) {
+			return errors.New("priorities must be low, medium, high or critical")
+		}
+	}
+	return nil
+}
+
+// @Summary List webhook subscriptions
+// @Description List the webhook subscriptions (secrets are not returned, URL path and query are masked)
+// @Tags Webhooks
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} WebhookSubscription
+// @Failure 401 {object} map[string]string
+// @Router /webhooks [get]
+func getWebhookSubscriptionsHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var subscriptions []WebhookSubscription
+		db.Order("id ASC").Find(&subscriptions)
+		for i := range subscriptions {
+			redactWebhookSubscription(&subscriptions[i])
+		}
+		c.JSON(http.StatusOK, subscriptions)
+	}
+}
+
+// @Summary Create webhook subscription
+// @Description Create a webhook subscription. A signing secret is generated if not provided and returned only in this response
+// @Tags Webhooks
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param subscription body WebhookSubscription true "Webhook subscription"
+// @Success 201 {object} WebhookSubscription
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /webhooks [post]
+func createWebhookSubscriptionHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		subscription := WebhookSubscription{Enabled: true}
+		if err := c.ShouldBindJSON(&subscription); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		subscription.ID = 0
+
+		if err := validateWebhookSubscription(&subscription); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if subscription.Secret == "" {
+			secret, err := generateWebhookSecret()
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			subscription.Secret = secret
+		}
+
+		if err := db.Create(&subscription).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Il segreto si restituisce solo qui, l'URL resta mascherato
+		subscription.AccessToken = ""
+		subscription.URL = maskWebhookURL(subscription.URL)
+		c.JSON(http.StatusCreated, subscription)
+	}
+}
+
+// @Summary Update webhook subscription
+// @Description Update a webhook subscription. Leave secret and access_token empty, and url as returned (masked), to keep the current ones
+// @Tags Webhooks
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Subscription ID"
+// @Param subscription body WebhookSubscription true "Webhook subscription"
+// @Success 200 {object} WebhookSubscription
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /webhooks/{id} [put]
+func updateWebhookSubscriptionHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var subscription WebhookSubscription
+		if err := db.First(&subscription, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook subscription not found"})
+			return
+		}
+
+		id, secret, token, endpoint := subscription.ID, subscription.Secret, subscription.AccessToken, subscription.URL
+		subscription.Secret = ""
+		subscription.AccessToken = ""
+		if err := c.ShouldBindJSON(&subscription); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		subscription.ID = id
+		// L'URL mascherato ricevuto dalle letture indica quello già salvato
+		if subscription.URL == maskWebhookURL(endpoint) {
+			subscription.URL = endpoint
+		}
+		if subscription.Secret == "" {
+			subscription.Secret = secret
+		}
+		if subscription.AccessToken == "" {
+			subscription.AccessToken = token
+		}
+
+		if err := validateWebhookSubscription(&subscription); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := db.Save(&subscription).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		redactWebhookSubscription(&subscription)
+		c.JSON(http.StatusOK, subscription)
+	}
+}
+
+// @Summary Delete webhook subscription
+// @Description Delete a webhook subscription
+// @Tags Webhooks
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Subscription ID"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /webhooks/{id} [delete]
+func deleteWebhookSubscriptionHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var subscription WebhookSubscription
+		if err := db.First(&subscription, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook subscription not found"})
+			return
+		}
+
+		if err := db.Delete(&subscription).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Webhook subscription deleted"})
+	}
+}
+
+// @Summary List webhook deliveries
+// @Description List the delivery log of a webhook subscription, most recent first
+// @Tags Webhooks
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Subscription ID"
+// @Param success query boolean false "Filter by outcome"
+// @Param limit query int false "Limit results" default(50)
+// @Success 200 {array} WebhookDelivery
+// @Failure 404 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /webhooks/{id}/deliveries [get]
+func getWebhookDeliveriesHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var subscription WebhookSubscription
+		if err := db.First(&subscription, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook subscription not found"})
+			return
+		}
+
+		query := db.Where("subscription_id = ?", subscription.ID)
+		if success := c.Query("success"); success != "" {
+			query = query.Where("success = ?", success == "true")
+		}
+
+		limit := 50
+		if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 {
+			limit = l
+		}
+
+		var deliveries []WebhookDelivery
+		query.Order("id DESC").Limit(limit).Find(&deliveries)
+		for i := range deliveries {
+			deliveries[i].URL = maskWebhookURL(deliveries[i].URL)
+		}
+		c.JSON(http.StatusOK, deliveries)
+	}
+}
+
+// @Summary Redeliver webhook
+// @Description Send the body of a previous delivery again, with a fresh signature, and log the new attempt
+// @Tags Webhooks
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Delivery ID"
+// @Success 200 {object} WebhookDelivery
+// @Failure 404 {object} map[string]string
+// @Failure 502 {object} WebhookDelivery
+// @Failure 401 {object} map[string]string
+// @Router /webhook-deliveries/{id}/redeliver [post]
+func redeliverWebhookHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var original WebhookDelivery
+		if err := db.First(&original, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook delivery not found"})
+			return
+		}
+
+		var subscription WebhookSubscription
+		if err := db.First(&subscription, original.SubscriptionID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook subscription not found"})
+			return
+		}
+
+		delivery, err := NewWebhookService().Deliver(db, subscription, WebhookDelivery{
+			NotificationID: original.NotificationID,
+			Event:          original.Event,
+			RequestBody:    original.RequestBody,
+			RedeliveryOf:   &original.ID,
+		})
+
+		if err != nil {
+			c.JSON(http.StatusBadGateway, delivery)
+			return
+		}
+		c.JSON(http.StatusOK, delivery)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestWebhookSignatureRoundTrip(t *testing.T) {
+	body := []byte(`{"id":1}`)
+	now := time.Now()
+	header := signWebhookPayload("whsec_test", now.Unix(), body)
+
+	if err := verifyWebhookSignature("whsec_test", header, body, WebhookSignatureTolerance, now); err != nil {
+		t.Fatalf("valid signature rejected: %v", err)
+	}
+	if err := verifyWebhookSignature("whsec_other", header, body, WebhookSignatureTolerance, now); err == nil {
+		t.Error("signature with wrong secret accepted")
+	}
+	if err := verifyWebhookSignature("whsec_test", header, []byte(`{"id":2}`), WebhookSignatureTolerance, now); err == nil {
+		t.Error("signature of a different body accepted")
+	}
+	if err := verifyWebhookSignature("whsec_test", header, body, WebhookSignatureTolerance, now.Add(10*time.Minute)); err == nil {
+		t.Error("replayed signature outside tolerance accepted")
+	}
+}
+
+func TestWebhookDeliveryLogAndRedelivery(t *testing.T) {
+	db := newTestDB(t)
+
+	status := http.StatusInternalServerError
+	var received []byte
+	var signature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get(WebhookSignatureHeader)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	subscription := WebhookSubscription{Name: "Volontari", URL: server.URL, Secret: "whsec_test", Format: "json", Enabled: true}
+	db.Create(&subscription)
+	notification := Notification{Type: NotificationWeightDrop, Priority: PriorityHigh, Title: "Calo peso", Message: "Calo del 12%"}
+	db.Create(&notification)
+
+	if err := runWebhookDeliveryJob(db, []byte(`{"subscription_id":1,"notification_id":1}`)); err == nil {
+		t.Fatal("failed delivery should return an error for retry")
+	}
+	if err := verifyWebhookSignature("whsec_test", signature, received, WebhookSignatureTolerance, time.Now()); err != nil {
+		t.Errorf("receiver could not verify signature: %v", err)
+	}
+
+	var failed WebhookDelivery
+	db.First(&failed)
+	if failed.Success || failed.StatusCode != http.StatusInternalServerError || failed.RequestBody != string(received) {
+		t.Fatalf("delivery log = %+v", failed)
+	}
+
+	status = http.StatusOK
+	redelivered, err := NewWebhookService().Deliver(db, subscription, WebhookDelivery{
+		NotificationID: failed.NotificationID,
+		Event:          failed.Event,
+		RequestBody:    failed.RequestBody,
+		RedeliveryOf:   &failed.ID,
+	})
+	if err != nil || !redelivered.Success || redelivered.RedeliveryOf == nil || *redelivered.RedeliveryOf != failed.ID {
+		t.Fatalf("redelivery = %+v, err %v", redelivered, err)
+	}
+}
+
+func TestWebhookURLIsMaskedInResponses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := newTestDB(t)
+	secretURL := "https://hooks.slack.com/services/T000/B000/XXXXSECRET"
+	subscription := WebhookSubscription{Name: "Slack", URL: secretURL, Format: "slack", Enabled: true}
+	db.Create(&subscription)
+
+	router := gin.New()
+	router.GET("/webhooks", getWebhookSubscriptionsHandler(db))
+	router.PUT("/webhooks/:id", updateWebhookSubscriptionHandler(db))
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	w := request(http.MethodGet, "/webhooks", "")
+	if strings.Contains(w.Body.String(), "XXXXSECRET") || !strings.Contains(w.Body.String(), `"url":"https://hooks.slack.com/***"`) {
+		t.Errorf("list = %s", w.Body.String())
+	}
+
+	// Rimandare l'URL mascherato mantiene quello salvato
+	w = request(http.MethodPut, "/webhooks/1", `{"name":"Slack volontari","url":"https://hooks.slack.com/***","format":"slack"}`)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "XXXXSECRET") {
+		t.Fatalf("update = %d %s", w.Code, w.Body.String())
+	}
+	db.First(&subscription, subscription.ID)
+	if subscription.URL != secretURL || subscription.Name != "Slack volontari" {
+		t.Errorf("subscription = %+v", subscription)
+	}
+
+	if masked := maskWebhookURL("https://example.org"); masked != "https://example.org" {
+		t.Errorf("masked bare host = %s", masked)
+	}
+}
+
+func TestCreateDisabledWebhookSubscription(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := newTestDB(t)
+	router := gin.New()
+	router.POST("/webhooks", createWebhookSubscriptionHandler(db))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/webhooks",
+		strings.NewReader(`{"name":"Spento","url":"https://example.org/hook","enabled":false}`)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+
+	var subscription WebhookSubscription
+	db.First(&subscription)
+	if subscription.Enabled {
+		t.Error("subscription created with enabled=false was stored as enabled")
+	}
+}
@@ -0,0 +1,131 @@
+// weight_outliers.go - Rilevamento pesate implausibili e flusso di correzione
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/laninna/hedgehog-app/logger"
+	"gorm.io/gorm"
+)
+
+// Esito del controllo di plausibilità di una pesata
+type WeightOutlierCheck struct {
+	Flagged         bool    `json:"flagged"`
+	Reason          string  `json:"reason,omitempty"`
+	ReferenceWeight float64 `json:"reference_weight"` // Mediana delle pesate recenti
+	ChangePercent   float64 `json:"change_percent"`
+	AllowedPercent  float64 `json:"allowed_percent"`
+	MinPlausible    float64 `json:"min_plausible"`
+	MaxPlausible    float64 `json:"max_plausible"`
+}
+
+// Richiesta di esclusione/reinclusione di una pesata
+type WeightExclusionRequest struct {
+	Excluded bool   `json:"excluded" example:"true"`
+	Reason   string `json:"reason" example:"Errore di battitura"`
+}
+
+// detectWeightOutlier confronta la pesata con la mediana delle ultime pesate valide
+func (ns *NotificationService) detectWeightOutlier(record WeightRecord) WeightOutlierCheck {
+	check := WeightOutlierCheck{}
+
+	var recent []WeightRecord
+	query := ns.db.Where("hedgehog_id = ? AND excluded = ? AND date <= ?", record.HedgehogID, false, record.Date)
+	if record.ID != 0 {
+		query = query.Where("id <> ?", record.ID)
+	}
+	query.Order("date DESC").Limit(5).Find(&recent)
+	if len(recent) == 0 || record.Weight <= 0 {
+		return check
+	}
+
+	values := make([]float64, len(recent))
+	for i, r := range recent {
+		values[i] = r.Weight
+	}
+	check.ReferenceWeight = median(values)
+	if check.ReferenceWeight <= 0 {
+		return check
+	}
+
+	// La variazione ammessa cresce con i giorni trascorsi dall'ultima pesata
+	days := math.Max(1, record.Date.Sub(recent[0].Date).Hours()/24)
+	check.AllowedPercent = math.Round((ns.settings.WeightOutlierPercent+ns.settings.WeightOutlierDailyPercent*days)*10) / 10
+	check.ChangePercent = math.Round((record.Weight-check.ReferenceWeight)/check.ReferenceWeight*1000) / 10
+	check.MinPlausible = math.Round(check.ReferenceWeight * math.Max(0, 1-check.AllowedPercent/100))
+	check.MaxPlausible = math.Round(check.ReferenceWeight * (1 + check.AllowedPercent/100))
+
+	if ns.settings.WeightOutlierPercent > 0 && math.Abs(check.ChangePercent) > check.AllowedPercent {
+		check.Flagged = true
+		check.Reason = fmt.Sprintf("Peso %.0fg molto diverso dal riferimento %.0fg (%+.1f%%, ammesso ±%.0f%% in %d giorni)",
+			record.Weight, check.ReferenceWeight, check.ChangePercent, check.AllowedPercent, int(days))
+	}
+
+	return check
+}
+
+// applyOutlierCheck riporta l'esito del controllo sulla pesata
+func applyOutlierCheck(record *WeightRecord, check WeightOutlierCheck) {
+	record.Flagged = check.Flagged
+	record.FlagReason = check.Reason
+}
+
+// @Summary Exclude or include weight record
+// @Description Exclude a flagged weight record from trend analysis, or include it again
+// @Tags Weight Records
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Weight Record ID"
+// @Param exclusion body WeightExclusionRequest true "Exclusion data"
+// @Success 200 {object} WeightRecord
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /weight-records/{id}/exclusion [put]
+func setWeightRecordExclusion(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var record WeightRecord
+		if err := db.First(&record, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Weight record not found"})
+			return
+		}
+
+		var req WeightExclusionRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		record.Excluded = req.Excluded
+		if req.Excluded && req.Reason != "" {
+			record.Flagged = true
+			record.FlagReason = req.Reason
+		}
+
+		if err := db.Save(&record).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		enqueueAutoResolve(db, record.HedgehogID)
+
+		log := logger.GetLoggerFromContext(c)
+		log.Info().
+			Uint("weight_record_id", record.ID).
+			Bool("excluded", record.Excluded).
+			Msg("Weight record exclusion updated")
+
+		c.JSON(http.StatusOK, record)
+	}
+}
+
+// confirmRequested indica se il client ha confermato una pesata segnalata
+func confirmRequested(c *gin.Context) bool {
+	confirm, _ := strconv.ParseBool(c.Query("confirm"))
+	return confirm
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDetectWeightOutlier(t *testing.T) {
+	now := time.Date(2024, 3, 20, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		history   map[int]float64 // giorni prima della nuova pesata -> peso
+		weight    float64
+		flagged   bool
+		reference float64
+	}{
+		{"typo", map[int]float64{3: 440, 2: 450, 1: 455}, 4500, true, 450},
+		{"normal daily change", map[int]float64{3: 440, 2: 450, 1: 455}, 460, false, 450},
+		{"gain over many days", map[int]float64{60: 400}, 600, false, 400},
+		{"same gain overnight", map[int]float64{1: 400}, 600, true, 400},
+		{"first record", nil, 450, false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			hedgehog := Hedgehog{Name: "Spillo"}
+			db.Create(&hedgehog)
+			for days, weight := range tt.history {
+				db.Create(&WeightRecord{HedgehogID: hedgehog.ID, Weight: weight, Date: now.AddDate(0, 0, -days)})
+			}
+
+			check := NewNotificationService(db).detectWeightOutlier(WeightRecord{HedgehogID: hedgehog.ID, Weight: tt.weight, Date: now})
+			if check.Flagged != tt.flagged {
+				t.Errorf("flagged = %v (%+.1f%%, allowed ±%.1f%%), want %v", check.Flagged, check.ChangePercent, check.AllowedPercent, tt.flagged)
+			}
+			if check.ReferenceWeight != tt.reference {
+				t.Errorf("reference = %.0f, want the median %.0f", check.ReferenceWeight, tt.reference)
+			}
+			if tt.flagged && check.Reason == "" {
+				t.Error("flagged weight without a reason")
+			}
+		})
+	}
+}
+
+func TestDetectWeightOutlierIgnoresExcludedRecords(t *testing.T) {
+	db := newTestDB(t)
+	hedgehog := Hedgehog{Name: "Spillo"}
+	db.Create(&hedgehog)
+	now := time.Now()
+	db.Create(&WeightRecord{HedgehogID: hedgehog.ID, Weight: 450, Date: now.AddDate(0, 0, -2)})
+	db.Create(&WeightRecord{HedgehogID: hedgehog.ID, Weight: 4500, Date: now.AddDate(0, 0, -1), Excluded: true})
+
+	check := NewNotificationService(db).detectWeightOutlier(WeightRecord{HedgehogID: hedgehog.ID, Weight: 455, Date: now})
+	if check.Flagged || check.ReferenceWeight != 450 {
+		t.Errorf("check = %+v, want the excluded typo ignored", check)
+	}
+}
+
+func TestCreateWeightRecordRequiresConfirmationForOutliers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := newTestDB(t)
+	hedgehog := Hedgehog{Name: "Spillo"}
+	db.Create(&hedgehog)
+	db.Create(&WeightRecord{HedgehogID: hedgehog.ID, Weight: 450, Date: time.Now().AddDate(0, 0, -1)})
+
+	router := gin.New()
+	router.POST("/weight-records", createWeightRecord(db))
+	post := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, url, strings.NewReader(`{"hedgehog_id":1,"weight":4500}`)))
+		return w
+	}
+
+	w := post("/weight-records")
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want 409: %s", w.Code, w.Body.String())
+	}
+	var conflict struct {
+		RequiresConfirmation bool               `json:"requires_confirmation"`
+		Outlier              WeightOutlierCheck `json:"outlier"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &conflict)
+	if !conflict.RequiresConfirmation || !conflict.Outlier.Flagged || conflict.Outlier.ReferenceWeight != 450 {
+		t.Errorf("conflict = %+v", conflict)
+	}
+
+	var count int64
+	db.Model(&WeightRecord{}).Count(&count)
+	if count != 1 {
+		t.Fatalf("unconfirmed outlier was saved: %d records", count)
+	}
+
+	// Confermata, la pesata si salva ma resta segnalata
+	w = post("/weight-records?confirm=true")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("confirmed status = %d: %s", w.Code, w.Body.String())
+	}
+	var record WeightRecord
+	db.Order("id DESC").First(&record)
+	if record.Weight != 4500 || !record.Flagged || record.FlagReason == "" || record.Excluded {
+		t.Errorf("record = %+v, want saved, flagged and still included", record)
+	}
+}
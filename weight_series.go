@@ -0,0 +1,347 @@
+// weight_series.go - Serie temporale del peso pronta per i grafici
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/laninna/hedgehog-app/logger"
+	"gorm.io/gorm"
+)
+
+// Punto giornaliero della serie
+type WeightSeriesPoint struct {
+	Date           time.Time `json:"date" format:"date-time"`
+	Weight         *float64  `json:"weight"`       // Media delle pesate del giorno (o valore interpolato)
+	Measurements   int       `json:"measurements"` // Pesate valide registrate nel giorno
+	Interpolated   bool      `json:"interpolated"`
+	MovingAverage  *float64  `json:"moving_average"`
+	Trend          *float64  `json:"trend"`
+	ExpectedWeight *float64  `json:"expected_weight,omitempty"` // Dalla curva di crescita
+}
+
+// Evento da annotare sul grafico
+type WeightSeriesEvent struct {
+	Date    time.Time `json:"date" format:"date-time"`
+	Type    string    `json:"type"` // "arrival", "therapy_start", "therapy_end", "area_move", "hibernation_start", "hibernation_end"
+	Label   string    `json:"label"`
+	RefID   *uint     `json:"ref_id,omitempty"`
+	Details string    `json:"details,omitempty"`
+}
+
+// Serie completa per un riccio
+type WeightSeries struct {
+	HedgehogID        uint                `json:"hedgehog_id"`
+	HedgehogName      string              `json:"hedgehog_name"`
+	From              time.Time           `json:"from" format:"date-time"`
+	To                time.Time           `json:"to" format:"date-time"`
+	MovingAverageDays int                 `json:"moving_average_days"`
+	TargetWeight      float64             `json:"target_weight"` // Peso minimo per il rilascio
+	TrendMethod       string              `json:"trend_method"`
+	TrendSlopePerDay  float64             `json:"trend_slope_per_day"`
+	Trend             WeightTrend         `json:"trend"` // Trend attuale (finestra configurata)
+	Points            []WeightSeriesPoint `json:"points"`
+	Excluded          []WeightRecord      `json:"excluded"` // Pesate escluse dall'analisi
+	Events            []WeightSeriesEvent `json:"events"`
+}
+
+func sameAreaID(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// recordAreaMove registra lo spostamento di un riccio tra due aree
+func recordAreaMove(db *gorm.DB, hedgehogID uint, from, to *uint, userID *uint) {
+	move := AreaMove{HedgehogID: hedgehogID, FromAreaID: from, ToAreaID: to, MovedAt: time.Now(), UserID: userID}
+
+	var area Area
+	if from != nil && db.First(&area, *from).Error == nil {
+		move.FromAreaName = area.Name
+	}
+	area = Area{}
+	if to != nil && db.First(&area, *to).Error == nil {
+		move.ToAreaName = area.Name
+	}
+
+	if err := db.Create(&move).Error; err != nil {
+		logger.Error("Failed to record area move", err, logger.Uint("hedgehog_id", hedgehogID))
+	}
+}
+
+func roundGrams(v float64) *float64 {
+	r := math.Round(v*10) / 10
+	return &r
+}
+
+// resampleDaily aggrega le pesate per giorno e interpola i giorni mancanti
+func resampleDaily(records []WeightRecord, from, to time.Time, interpolate bool) []WeightSeriesPoint {
+	// Chiave per giorno nel fuso dell'intervallo (le date lette dal DB possono avere un fuso diverso)
+	dayKey := func(t time.Time) string { return t.In(from.Location()).Format("2006-01-02") }
+
+	sums := make(map[string]float64)
+	counts := make(map[string]int)
+	for _, r := range records {
+		sums[dayKey(r.Date)] += r.Weight
+		counts[dayKey(r.Date)]++
+	}
+
+	var points []WeightSeriesPoint
+	for day := startOfDay(from); !day.After(to); day = day.AddDate(0, 0, 1) {
+		key := dayKey(day)
+		point := WeightSeriesPoint{Date: day, Measurements: counts[key]}
+		if counts[key] > 0 {
+			point.Weight = roundGrams(sums[key] / float64(counts[key]))
+		}
+		points = append(points, point)
+	}
+
+	if !interpolate {
+		return points
+	}
+
+	// Interpolazione lineare tra i giorni misurati (non oltre gli estremi)
+	last := -1
+	for i := range points {
+		if points[i].Weight == nil {
+			continue
+		}
+		if last >= 0 && i-last > 1 {
+			start, end := *points[last].Weight, *points[i].Weight
+			for j := last + 1; j < i; j++ {
+				f := float64(j-last) / float64(i-last)
+				points[j].Weight = roundGrams(start + f*(end-start))
+				points[j].Interpolated = true
+			}
+		}
+		last = i
+	}
+
+	return points
+}
+
+// applyMovingAverage calcola la media mobile trascinata sui valori disponibili
+func applyMovingAverage(points []WeightSeriesPoint, days int) {
+	if days < 1 {
+		days = 1
+	}
+	for i := range points {
+		sum, n := 0.0, 0
+		for j := i; j >= 0 && j > i-days; j-- {
+			if points[j].Weight != nil {
+				sum += *points[j].Weight
+				n++
+			}
+		}
+		if n > 0 && points[i].Weight != nil {
+			points[i].MovingAverage = roundGrams(sum / float64(n))
+		}
+	}
+}
+
+// weightSeriesEvents raccoglie gli eventi da annotare nell'intervallo
+func weightSeriesEvents(db *gorm.DB, hedgehog Hedgehog, from, to time.Time) []WeightSeriesEvent {
+	events := []WeightSeriesEvent{}
+	inRange := func(t time.Time) bool { return !t.Before(from) && !t.After(to) }
+
+	if inRange(hedgehog.ArrivalDate) {
+		events = append(events, WeightSeriesEvent{Date: hedgehog.ArrivalDate, Type: "arrival", Label: "Arrivo al centro"})
+	}
+
+	var therapies []Therapy
+	db.Where("hedgehog_id = ?", hedgehog.ID).Find(&therapies)
+	for _, t := range therapies {
+		id := t.ID
+		if inRange(t.StartDate) {
+			events = append(events, WeightSeriesEvent{Date: t.StartDate, Type: "therapy_start", Label: "Inizio " + t.Name, RefID: &id, Details: t.Dosage})
+		}
+		if t.EndDate != nil && inRange(*t.EndDate) && !t.EndDate.After(time.Now()) {
+			events = append(events, WeightSeriesEvent{Date: *t.EndDate, Type: "therapy_end", Label: "Fine " + t.Name, RefID: &id, Details: t.Status})
+		}
+	}
+
+	var moves []AreaMove
+	db.Where("hedgehog_id = ? AND moved_at >= ? AND moved_at <= ?", hedgehog.ID, from, to).Find(&moves)
+	for _, m := range moves {
+		id := m.ID
+		label := "Spostato"
+		if m.ToAreaName != "" {
+			label = "Spostato in " + m.ToAreaName
+		}
+		details := ""
+		if m.FromAreaName != "" {
+			details = "Da " + m.FromAreaName
+		}
+		events = append(events, WeightSeriesEvent{Date: m.MovedAt, Type: "area_move", Label: label, RefID: &id, Details: details})
+	}
+
+	if hedgehog.HibernationStart != nil && inRange(*hedgehog.HibernationStart) {
+		events = append(events, WeightSeriesEvent{Date: *hedgehog.HibernationStart, Type: "hibernation_start", Label: "Inizio letargo"})
+	}
+	if hedgehog.HibernationEnd != nil && inRange(*hedgehog.HibernationEnd) {
+		events = append(events, WeightSeriesEvent{Date: *hedgehog.HibernationEnd, Type: "hibernation_end", Label: "Fine letargo"})
+	}
+
+	sort.Slice(events, func(i, j int) bool { return events[i].Date.Before(events[j].Date) })
+	return events
+}
+
+// BuildWeightSeries costruisce la serie giornaliera del peso per un riccio
+func (ns *NotificationService) BuildWeightSeries(hedgehog Hedgehog, from, to time.Time, movingAverageDays int, interpolate bool) WeightSeries {
+	series := WeightSeries{
+		HedgehogID:        hedgehog.ID,
+		HedgehogName:      hedgehog.Name,
+		From:              startOfDay(from),
+		To:                to,
+		MovingAverageDays: movingAverageDays,
+		TargetWeight:      loadReleaseCriteria(ns.db).MinWeight,
+		Trend:             computeWeightTrend(ns.loadTrendWeights(hedgehog.ID), ns.trendConfig()),
+		Points:            []WeightSeriesPoint{},
+		Excluded:          []WeightRecord{},
+	}
+
+	var records []WeightRecord
+	ns.db.Where("hedgehog_id = ? AND date >= ? AND date <= ?", hedgehog.ID, series.From, to).
+		Order("date ASC").
+		Find(&records)
+
+	var included []WeightRecord
+	for _, r := range records {
+		if r.Excluded {
+			series.Excluded = append(series.Excluded, r)
+		} else {
+			included = append(included, r)
+		}
+	}
+
+	series.Events = weightSeriesEvents(ns.db, hedgehog, series.From, to)
+	if len(included) == 0 {
+		return series
+	}
+
+	series.Points = resampleDaily(included, series.From, to, interpolate)
+	applyMovingAverage(series.Points, movingAverageDays)
+
+	// Retta di tendenza sull'intero intervallo
+	origin := series.Points[0].Date
+	xs := make([]float64, len(included))
+	ys := make([]float64, len(included))
+	for i, r := range included {
+		xs[i] = r.Date.Sub(origin).Hours() / 24
+		ys[i] = r.Weight
+	}
+	if len(included) >= 2 {
+		var slope, intercept float64
+		series.TrendMethod = ns.trendConfig().Method
+		if series.TrendMethod == TrendMethodTheilSen {
+			slope, intercept = theilSenRegression(xs, ys)
+		} else {
+			series.TrendMethod = TrendMethodLeastSquares
+			slope, intercept = leastSquaresRegression(xs, ys)
+		}
+		series.TrendSlopePerDay = math.Round(slope*100) / 100
+
+		firstDay := startOfDay(included[0].Date.In(origin.Location()))
+		lastDay := startOfDay(included[len(included)-1].Date.In(origin.Location()))
+		for i := range series.Points {
+			day := series.Points[i].Date
+			if day.Before(firstDay) || day.After(lastDay) {
+				continue
+			}
+			x := day.Sub(origin).Hours() / 24
+			series.Points[i].Trend = roundGrams(intercept + slope*x)
+		}
+	}
+
+	// Curva di crescita attesa, se è nota l'età
+	if hedgehog.BirthDate != nil {
+		curve := loadGrowthCurve(ns.db, hedgehog.Sex)
+		for i := range series.Points {
+			ageDays := series.Points[i].Date.Sub(*hedgehog.BirthDate).Hours() / 24
+			if ageDays < 0 {
+				continue
+			}
+			if expected, _, ok := interpolateGrowthCurve(curve, ageDays); ok {
+				series.Points[i].ExpectedWeight = roundGrams(expected)
+			}
+		}
+	}
+
+	return series
+}
+
+// @Summary Get weight series
+// @Description Get a daily resampled weight series with moving average, trend line, target release weight and annotated events
+// @Tags Weight Records
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Hedgehog ID"
+// @Param from query string false "Start day (YYYY-MM-DD), defaults to the arrival date"
+// @Param to query string false "End day (YYYY-MM-DD), defaults to today"
+// @Param window query int false "Moving average window in days" default(7)
+// @Param interpolate query boolean false "Interpolate days without weighings" default(true)
+// @Success 200 {object} WeightSeries
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Router /hedgehogs/{id}/weights/series [get]
+func getWeightSeriesHandler(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var hedgehog Hedgehog
+		if err := db.First(&hedgehog, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Hedgehog not found"})
+			return
+		}
+
+		to := time.Now()
+		if s := c.Query("to"); s != "" {
+			parsed, err := time.ParseInLocation("2006-01-02", s, time.Local)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date, expected YYYY-MM-DD"})
+				return
+			}
+			to = parsed.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+
+		from := hedgehog.ArrivalDate
+		var first WeightRecord
+		if err := db.Where("hedgehog_id = ?", hedgehog.ID).Order("date ASC").First(&first).Error; err == nil && first.Date.Before(from) {
+			from = first.Date
+		}
+		if s := c.Query("from"); s != "" {
+			parsed, err := time.ParseInLocation("2006-01-02", s, time.Local)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date, expected YYYY-MM-DD"})
+				return
+			}
+			from = parsed
+		}
+
+		if from.After(to) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+			return
+		}
+		if to.Sub(from) > 3*365*24*time.Hour {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("range too large (max %d days)", 3*365)})
+			return
+		}
+
+		window := 7
+		if s := c.Query("window"); s != "" {
+			if parsed, err := strconv.Atoi(s); err == nil && parsed > 0 {
+				window = parsed
+			}
+		}
+		interpolate := c.Query("interpolate") != "false"
+
+		ns := NewNotificationService(db)
+		c.JSON(http.StatusOK, ns.BuildWeightSeries(hedgehog, from, to, window, interpolate))
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func seriesWeights(points []WeightSeriesPoint) []float64 {
+	weights := make([]float64, len(points))
+	for i, p := range points {
+		weights[i] = -1
+		if p.Weight != nil {
+			weights[i] = *p.Weight
+		}
+	}
+	return weights
+}
+
+func TestResampleDaily(t *testing.T) {
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 4)
+	records := []WeightRecord{
+		{Weight: 500, Date: from.Add(8 * time.Hour)},
+		{Weight: 510, Date: from.Add(20 * time.Hour)}, // Due pesate nello stesso giorno
+		{Weight: 535, Date: from.AddDate(0, 0, 3).Add(9 * time.Hour)},
+	}
+
+	raw := resampleDaily(records, from, to, false)
+	if len(raw) != 5 {
+		t.Fatalf("points = %d, want one per day", len(raw))
+	}
+	if got := seriesWeights(raw); got[0] != 505 || got[1] != -1 || got[2] != -1 || got[3] != 535 || got[4] != -1 {
+		t.Errorf("raw weights = %v, want the daily mean and gaps", got)
+	}
+	if raw[0].Measurements != 2 || raw[3].Measurements != 1 {
+		t.Errorf("measurements = %d, %d", raw[0].Measurements, raw[3].Measurements)
+	}
+
+	// I giorni mancanti tra due misure si interpolano, non oltre l'ultima
+	interpolated := resampleDaily(records, from, to, true)
+	if got := seriesWeights(interpolated); got[1] != 515 || got[2] != 525 || got[4] != -1 {
+		t.Errorf("interpolated weights = %v", got)
+	}
+	if !interpolated[1].Interpolated || interpolated[3].Interpolated {
+		t.Error("only the filled days should be marked as interpolated")
+	}
+}
+
+func TestApplyMovingAverage(t *testing.T) {
+	weight := func(v float64) *float64 { return &v }
+	points := []WeightSeriesPoint{
+		{Weight: weight(500)},
+		{Weight: weight(510)},
+		{},
+		{Weight: weight(530)},
+		{Weight: weight(560)},
+	}
+
+	applyMovingAverage(points, 3)
+
+	want := []float64{500, 505, -1, 520, 545}
+	for i, p := range points {
+		got := -1.0
+		if p.MovingAverage != nil {
+			got = *p.MovingAverage
+		}
+		if got != want[i] {
+			t.Errorf("moving average[%d] = %.1f, want %.1f", i, got, want[i])
+		}
+	}
+}
@@ -0,0 +1,248 @@
+// weight_trend.go - Modello di regressione per il trend del peso
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	TrendMethodLeastSquares = "least_squares"
+	TrendMethodTheilSen     = "theil_sen"
+)
+
+// Parametri del modello di trend
+type TrendConfig struct {
+	WindowDays       int     // Finestra temporale analizzata, a ritroso dall'ultima pesata
+	Method           string  // least_squares o theil_sen
+	ImprovingPercent float64 // Crescita giornaliera (% del peso) oltre cui il trend è "improving"
+	DecliningPercent float64 // Calo giornaliero (% del peso) oltre cui il trend è "declining"
+	CriticalPercent  float64 // Calo giornaliero (% del peso) oltre cui il trend è "critical"
+	MinSamples       int     // Pesature minime per stimare un trend
+}
+
+// Risultato della regressione sul peso
+type WeightTrend struct {
+	Direction          string  `json:"direction"` // "improving", "stable", "declining", "critical", "insufficient_data"
+	Method             string  `json:"method"`
+	SlopePerDay        float64 `json:"slope_per_day"`         // Grammi al giorno
+	SlopePercentPerDay float64 `json:"slope_percent_per_day"` // Percentuale del peso medio al giorno
+	RSquared           float64 `json:"r_squared"`
+	Samples            int     `json:"samples"`
+	WindowDays         int     `json:"window_days"`
+	Confidence         float64 `json:"confidence"`       // 0-1, cresce con il numero di pesature
+	ConfidenceLevel    string  `json:"confidence_level"` // "low", "medium", "high"
+}
+
+func defaultTrendConfig() TrendConfig {
+	return TrendConfig{
+		WindowDays:       14,
+		Method:           TrendMethodLeastSquares,
+		ImprovingPercent: 0.3,
+		DecliningPercent: 0.3,
+		CriticalPercent:  1.0,
+		MinSamples:       3,
+	}
+}
+
+// trendConfig costruisce la configurazione del trend dalle impostazioni
+func (ns *NotificationService) trendConfig() TrendConfig {
+	cfg := defaultTrendConfig()
+	if ns.settings.TrendWindowDays > 0 {
+		cfg.WindowDays = ns.settings.TrendWindowDays
+	}
+	if ns.settings.TrendMethod != "" {
+		cfg.Method = ns.settings.TrendMethod
+	}
+	if ns.settings.TrendImprovingPercent > 0 {
+		cfg.ImprovingPercent = ns.settings.TrendImprovingPercent
+	}
+	if ns.settings.TrendDecliningPercent > 0 {
+		cfg.DecliningPercent = ns.settings.TrendDecliningPercent
+	}
+	if ns.settings.TrendCriticalPercent > 0 {
+		cfg.CriticalPercent = ns.settings.TrendCriticalPercent
+	}
+	if ns.settings.TrendMinSamples > 0 {
+		cfg.MinSamples = ns.settings.TrendMinSamples
+	}
+	return cfg
+}
+
+// loadTrendWeights carica le pesature nella finestra del trend (ordine decrescente)
+func (ns *NotificationService) loadTrendWeights(hedgehogID uint) []WeightRecord {
+	var latest WeightRecord
+	if err := ns.db.Where("hedgehog_id = ? AND excluded = ?", hedgehogID, false).Order("date DESC").First(&latest).Error; err != nil {
+		return nil
+	}
+
+	since := latest.Date.AddDate(0, 0, -ns.trendConfig().WindowDays)
+
+	var weights []WeightRecord
+	ns.db.Where("hedgehog_id = ? AND excluded = ? AND date >= ?", hedgehogID, false, since).
+		Order("date DESC").
+		Find(&weights)
+	return weights
+}
+
+// computeWeightTrend stima il trend tramite regressione sulle pesature nella finestra
+func computeWeightTrend(weights []WeightRecord, cfg TrendConfig) WeightTrend {
+	trend := WeightTrend{Direction: "insufficient_data", Method: cfg.Method, WindowDays: cfg.WindowDays}
+	if len(weights) == 0 {
+		return trend
+	}
+
+	// Ordina per data crescente e limita alla finestra
+	points := make([]WeightRecord, len(weights))
+	copy(points, weights)
+	sort.Slice(points, func(i, j int) bool { return points[i].Date.Before(points[j].Date) })
+
+	latest := points[len(points)-1].Date
+	if cfg.WindowDays > 0 {
+		since := latest.Add(-time.Duration(cfg.WindowDays) * 24 * time.Hour)
+		for len(points) > 0 && points[0].Date.Before(since) {
+			points = points[1:]
+		}
+	}
+
+	trend.Samples = len(points)
+	minSamples := cfg.MinSamples
+	if minSamples < 2 {
+		minSamples = 2
+	}
+	if trend.Samples < minSamples {
+		return trend
+	}
+
+	origin := points[0].Date
+	xs := make([]float64, len(points))
+	ys := make([]float64, len(points))
+	mean := 0.0
+	for i, p := range points {
+		xs[i] = p.Date.Sub(origin).Hours() / 24
+		ys[i] = p.Weight
+		mean += p.Weight
+	}
+	mean /= float64(len(points))
+
+	// Tutte le pesature nello stesso istante: nessuna pendenza stimabile
+	if xs[len(xs)-1] == 0 {
+		return trend
+	}
+
+	var slope, intercept float64
+	if cfg.Method == TrendMethodTheilSen {
+		slope, intercept = theilSenRegression(xs, ys)
+	} else {
+		trend.Method = TrendMethodLeastSquares
+		slope, intercept = leastSquaresRegression(xs, ys)
+	}
+
+	trend.SlopePerDay = slope
+	if mean > 0 {
+		trend.SlopePercentPerDay = slope / mean * 100
+	}
+	trend.RSquared = rSquared(xs, ys, slope, intercept)
+
+	// Confidenza basata sul numero di pesature
+	trend.Confidence = math.Min(1, float64(trend.Samples-1)/7)
+	switch {
+	case trend.Samples >= 8:
+		trend.ConfidenceLevel = "high"
+	case trend.Samples >= 5:
+		trend.ConfidenceLevel = "medium"
+	default:
+		trend.ConfidenceLevel = "low"
+	}
+
+	// Soglie in percentuale del peso corporeo; il caso critico va valutato per primo
+	switch pct := trend.SlopePercentPerDay; {
+	case pct <= -cfg.CriticalPercent:
+		trend.Direction = "critical"
+	case pct <= -cfg.DecliningPercent:
+		trend.Direction = "declining"
+	case pct >= cfg.ImprovingPercent:
+		trend.Direction = "improving"
+	default:
+		trend.Direction = "stable"
+	}
+
+	return trend
+}
+
+// leastSquaresRegression calcola la retta dei minimi quadrati
+func leastSquaresRegression(xs, ys []float64) (slope, intercept float64) {
+	n := float64(len(xs))
+	var sumX, sumY, sumXY, sumXX float64
+	for i := range xs {
+		sumX += xs[i]
+		sumY += ys[i]
+		sumXY += xs[i] * ys[i]
+		sumXX += xs[i] * xs[i]
+	}
+
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0, sumY / n
+	}
+
+	slope = (n*sumXY - sumX*sumY) / denominator
+	intercept = (sumY - slope*sumX) / n
+	return slope, intercept
+}
+
+// theilSenRegression calcola una retta robusta agli outlier (mediana delle pendenze)
+func theilSenRegression(xs, ys []float64) (slope, intercept float64) {
+	var slopes []float64
+	for i := 0; i < len(xs); i++ {
+		for j := i + 1; j < len(xs); j++ {
+			if dx := xs[j] - xs[i]; dx != 0 {
+				slopes = append(slopes, (ys[j]-ys[i])/dx)
+			}
+		}
+	}
+	if len(slopes) == 0 {
+		return 0, median(ys)
+	}
+
+	slope = median(slopes)
+
+	intercepts := make([]float64, len(xs))
+	for i := range xs {
+		intercepts[i] = ys[i] - slope*xs[i]
+	}
+	return slope, median(intercepts)
+}
+
+func median(values []float64) float64 {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// rSquared calcola il coefficiente di determinazione della retta stimata
+func rSquared(xs, ys []float64, slope, intercept float64) float64 {
+	mean := 0.0
+	for _, y := range ys {
+		mean += y
+	}
+	mean /= float64(len(ys))
+
+	var ssRes, ssTot float64
+	for i := range xs {
+		predicted := slope*xs[i] + intercept
+		ssRes += (ys[i] - predicted) * (ys[i] - predicted)
+		ssTot += (ys[i] - mean) * (ys[i] - mean)
+	}
+	if ssTot == 0 {
+		return 1
+	}
+	return 1 - ssRes/ssTot
+}
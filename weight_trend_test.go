@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func trendSeries(start time.Time, weights ...float64) []WeightRecord {
+	records := make([]WeightRecord, len(weights))
+	for i, w := range weights {
+		records[i] = WeightRecord{Weight: w, Date: start.AddDate(0, 0, i)}
+	}
+	return records
+}
+
+func TestComputeWeightTrendDirections(t *testing.T) {
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	cfg := defaultTrendConfig()
+
+	tests := []struct {
+		name    string
+		weights []float64
+		want    string
+	}{
+		{"improving", []float64{400, 404, 408, 412, 416}, "improving"},
+		{"stable", []float64{500, 501, 499, 500, 501}, "stable"},
+		{"declining", []float64{500, 497, 494, 491, 488}, "declining"},
+		{"critical", []float64{500, 490, 480, 470, 460}, "critical"},
+		{"insufficient data", []float64{500, 490}, "insufficient_data"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := computeWeightTrend(trendSeries(start, tt.weights...), cfg)
+			if got.Direction != tt.want {
+				t.Errorf("direction = %q (%.2f%%/day), want %q", got.Direction, got.SlopePercentPerDay, tt.want)
+			}
+		})
+	}
+}
+
+func TestComputeWeightTrendWindow(t *testing.T) {
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	cfg := defaultTrendConfig()
+	cfg.WindowDays = 3
+
+	// Il calo iniziale resta fuori dalla finestra
+	weights := trendSeries(start, 600, 550, 500, 500, 501, 500, 501)
+	got := computeWeightTrend(weights, cfg)
+	if got.Samples != 4 {
+		t.Errorf("samples = %d, want 4", got.Samples)
+	}
+	if got.Direction != "stable" {
+		t.Errorf("direction = %q, want stable", got.Direction)
+	}
+}
+
+func TestComputeWeightTrendTheilSenIgnoresOutlier(t *testing.T) {
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	weights := trendSeries(start, 500, 501, 502, 380, 504, 505, 506)
+
+	cfg := defaultTrendConfig()
+	cfg.Method = TrendMethodTheilSen
+	got := computeWeightTrend(weights, cfg)
+	if got.Direction != "stable" {
+		t.Errorf("theil-sen direction = %q (%.2f g/day), want stable", got.Direction, got.SlopePerDay)
+	}
+	if got.ConfidenceLevel != "medium" {
+		t.Errorf("confidence level = %q, want medium", got.ConfidenceLevel)
+	}
+}
+
+func TestGrowthCurveSeededOnlyOnce(t *testing.T) {
+	db := newTestDB(t)
+	if curve := loadGrowthCurve(db, "male"); len(curve) == 0 {
+		t.Fatal("default growth curve not created")
+	}
+
+	// Eliminata dall'utente, la curva predefinita non torna
+	db.Where("1 = 1").Delete(&GrowthCurvePoint{})
+	if curve := loadGrowthCurve(db, "male"); len(curve) != 0 {
+		t.Errorf("growth curve re-seeded with %d points", len(curve))
+	}
+}